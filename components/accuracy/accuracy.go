@@ -0,0 +1,299 @@
+package accuracy
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+)
+
+// Period is the length of the periods that forecasts are compared with the observed weather in, which is the interval of OpenWeatherMap's
+// forecasts
+const Period = 3 * time.Hour
+
+// observationDelay is how long after a forecast's date range ends that its observed weather is obtained, so that the provider's history
+// includes the end of the range
+const observationDelay = time.Hour
+
+// maxAttempts is how many times obtaining the observed weather of a forecast fails before the forecast is discarded
+const maxAttempts = 3
+
+// maxPending caps the forecasts awaiting their observed weather. The forecasts fetched earliest are discarded first
+const maxPending = 10000
+
+// Snapshot is a forecast as it was fetched from a provider
+type Snapshot struct {
+	// Provider is the provider that fetched the forecast, which the observed weather is obtained from
+	Provider weatherforecaster.ProviderConfig
+	Location weatherforecaster.Location
+	// Range is the date range of the forecast. The forecast is issued at the start of its range, which lead times are measured from
+	Range    util.DateRange
+	Forecast []models.Weather
+}
+
+// key identifies the forecasts of a location issued at the same time by the same provider account, of which only the last is tracked
+func (snapshot Snapshot) key() string {
+	return snapshot.Provider.Account() + "|" + strings.ToLower(snapshot.Location.City) + "," + strings.ToUpper(snapshot.Location.CountryCode) +
+		"|" + snapshot.Range.Start.UTC().Format(time.RFC3339)
+}
+
+// Counts compares the periods that rain was forecast in with the periods that it was observed in
+type Counts struct {
+	Periods int `json:"periods"`
+	// Hits are periods that rain was forecast and observed in
+	Hits int `json:"hits"`
+	// Misses are periods that rain was observed in but not forecast
+	Misses int `json:"misses"`
+	// FalseAlarms are periods that rain was forecast in but not observed
+	FalseAlarms int `json:"false_alarms"`
+	// CorrectNegatives are periods without rain that were forecast as such
+	CorrectNegatives int `json:"correct_negatives"`
+}
+
+func (counts *Counts) add(other Counts) {
+	counts.Periods += other.Periods
+	counts.Hits += other.Hits
+	counts.Misses += other.Misses
+	counts.FalseAlarms += other.FalseAlarms
+	counts.CorrectNegatives += other.CorrectNegatives
+}
+
+// Metrics are the accuracy metrics of Counts, which are 0 if there were no periods to compute them from
+type Metrics struct {
+	Counts
+	// Accuracy is the share of periods that were forecast correctly
+	Accuracy float64 `json:"accuracy"`
+	// ProbabilityOfDetection is the share of periods with rain that rain was forecast in
+	ProbabilityOfDetection float64 `json:"probability_of_detection"`
+	// FalseAlarmRatio is the share of periods that rain was forecast in that it wasn't observed in
+	FalseAlarmRatio float64 `json:"false_alarm_ratio"`
+}
+
+func newMetrics(counts Counts) Metrics {
+	return Metrics{
+		Counts:                 counts,
+		Accuracy:               ratio(counts.Hits+counts.CorrectNegatives, counts.Periods),
+		ProbabilityOfDetection: ratio(counts.Hits, counts.Hits+counts.Misses),
+		FalseAlarmRatio:        ratio(counts.FalseAlarms, counts.Hits+counts.FalseAlarms),
+	}
+}
+
+func ratio(numerator, denominator int) float64 {
+	if denominator == 0 {
+		return 0
+	}
+	return float64(numerator) / float64(denominator)
+}
+
+// LeadTimeAccuracy is a provider's accuracy for periods that were forecast the number of days in advance
+type LeadTimeAccuracy struct {
+	LeadDays int `json:"lead_days"`
+	Metrics
+}
+
+// ProviderAccuracy is the accuracy of a provider's forecasts, overall and by lead time
+type ProviderAccuracy struct {
+	Provider string `json:"provider"`
+	// Forecasts is the number of forecasts that were compared with the observed weather
+	Forecasts int `json:"forecasts"`
+	Metrics
+	LeadTimes []LeadTimeAccuracy `json:"lead_times"`
+}
+
+// pendingSnapshot is a snapshot awaiting its observed weather
+type pendingSnapshot struct {
+	Snapshot
+	provider   string
+	recordedAt time.Time
+	attempts   int
+}
+
+// providerResults are the counts of the forecasts of a provider that were compared with the observed weather, by lead days
+type providerResults struct {
+	forecasts int
+	leadDays  map[int]*Counts
+}
+
+// Tracker snapshots forecasts and compares them with the weather observed once their date range has passed. It's safe for concurrent use
+type Tracker struct {
+	mu      sync.Mutex
+	pending map[string]*pendingSnapshot
+	results map[string]*providerResults
+	now     func() time.Time
+}
+
+// Default is the tracker shared by the application's forecast calls
+var Default = New()
+
+// New returns an empty Tracker
+func New() *Tracker {
+	return &Tracker{pending: map[string]*pendingSnapshot{}, results: map[string]*providerResults{}, now: time.Now}
+}
+
+// Record snapshots a forecast, replacing the forecast of the location issued at the same time by the same provider account if it's pending
+func (tracker *Tracker) Record(snapshot Snapshot) {
+	if !snapshot.Range.End.After(snapshot.Range.Start) {
+		return
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	key := snapshot.key()
+	if _, ok := tracker.pending[key]; !ok && len(tracker.pending) >= maxPending {
+		tracker.discardEarliest()
+	}
+	tracker.pending[key] = &pendingSnapshot{Snapshot: snapshot, provider: snapshot.Provider.Name(), recordedAt: tracker.now()}
+}
+
+// discardEarliest discards the pending snapshot that was recorded earliest. The tracker must be locked
+func (tracker *Tracker) discardEarliest() {
+	var earliest string
+	for key, pending := range tracker.pending {
+		if earliest == "" || pending.recordedAt.Before(tracker.pending[earliest].recordedAt) {
+			earliest = key
+		}
+	}
+	delete(tracker.pending, earliest)
+}
+
+// Due returns the snapshots whose observed weather can be obtained at the specified time, those whose range ended first first
+func (tracker *Tracker) Due(now time.Time) []Snapshot {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	due := []Snapshot{}
+	for _, pending := range tracker.pending {
+		if !now.Before(pending.Range.End.Add(observationDelay)) {
+			due = append(due, pending.Snapshot)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].Range.End.Equal(due[j].Range.End) {
+			return due[i].Range.End.Before(due[j].Range.End)
+		}
+		return due[i].key() < due[j].key()
+	})
+	return due
+}
+
+// Pending returns the number of snapshots awaiting their observed weather
+func (tracker *Tracker) Pending() int {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	return len(tracker.pending)
+}
+
+// Observe compares the snapshot's forecast with the weather observed within its range, and stops tracking the snapshot. Snapshots that
+// were replaced or discarded since they were returned by Due are ignored
+func (tracker *Tracker) Observe(snapshot Snapshot, observed []models.Weather) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	key := snapshot.key()
+	pending, ok := tracker.pending[key]
+	if !ok {
+		return
+	}
+	delete(tracker.pending, key)
+
+	results, ok := tracker.results[pending.provider]
+	if !ok {
+		results = &providerResults{leadDays: map[int]*Counts{}}
+		tracker.results[pending.provider] = results
+	}
+	results.forecasts++
+	for leadDays, counts := range compare(pending.Snapshot, observed) {
+		if _, ok := results.leadDays[leadDays]; !ok {
+			results.leadDays[leadDays] = &Counts{}
+		}
+		results.leadDays[leadDays].add(counts)
+	}
+}
+
+// Fail records that the snapshot's observed weather couldn't be obtained. The snapshot is discarded after maxAttempts failures
+func (tracker *Tracker) Fail(snapshot Snapshot) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	key := snapshot.key()
+	if pending, ok := tracker.pending[key]; ok {
+		pending.attempts++
+		if pending.attempts >= maxAttempts {
+			delete(tracker.pending, key)
+		}
+	}
+}
+
+// compare counts the periods of the snapshot's range that rain was forecast and observed in, by the whole days between the issue of the
+// forecast and the start of each period. Only whole periods within the range are compared
+func compare(snapshot Snapshot, observed []models.Weather) map[int]Counts {
+	forecastRain := rainyPeriods(snapshot.Forecast)
+	observedRain := rainyPeriods(observed)
+
+	byLeadDays := map[int]Counts{}
+	period := snapshot.Range.Start.Truncate(Period)
+	if period.Before(snapshot.Range.Start) {
+		period = period.Add(Period)
+	}
+	for ; !period.Add(Period).After(snapshot.Range.End); period = period.Add(Period) {
+		leadDays := int(period.Sub(snapshot.Range.Start) / (24 * time.Hour))
+		counts := byLeadDays[leadDays]
+		counts.Periods++
+		forecast, observed := forecastRain[period.Unix()], observedRain[period.Unix()]
+		switch {
+		case forecast && observed:
+			counts.Hits++
+		case observed:
+			counts.Misses++
+		case forecast:
+			counts.FalseAlarms++
+		default:
+			counts.CorrectNegatives++
+		}
+		byLeadDays[leadDays] = counts
+	}
+	return byLeadDays
+}
+
+// rainyPeriods returns the start of each Period that the weather includes rain in, as unix times
+func rainyPeriods(weather []models.Weather) map[int64]bool {
+	periods := map[int64]bool{}
+	for _, w := range weather {
+		if w.Type == models.WeatherTypeRain {
+			periods[w.Date.Truncate(Period).Unix()] = true
+		}
+	}
+	return periods
+}
+
+// Report returns the accuracy of each provider whose forecasts were compared with the observed weather, most accurate first, which is the
+// order that providers should be failed over in
+func (tracker *Tracker) Report() []ProviderAccuracy {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	report := []ProviderAccuracy{}
+	for provider, results := range tracker.results {
+		accuracy := ProviderAccuracy{Provider: provider, Forecasts: results.forecasts, LeadTimes: []LeadTimeAccuracy{}}
+		var total Counts
+		for leadDays, counts := range results.leadDays {
+			total.add(*counts)
+			accuracy.LeadTimes = append(accuracy.LeadTimes, LeadTimeAccuracy{LeadDays: leadDays, Metrics: newMetrics(*counts)})
+		}
+		sort.Slice(accuracy.LeadTimes, func(i, j int) bool {
+			return accuracy.LeadTimes[i].LeadDays < accuracy.LeadTimes[j].LeadDays
+		})
+		accuracy.Metrics = newMetrics(total)
+		report = append(report, accuracy)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Accuracy != report[j].Accuracy {
+			return report[i].Accuracy > report[j].Accuracy
+		}
+		return report[i].Provider < report[j].Provider
+	})
+	return report
+}
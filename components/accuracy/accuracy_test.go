@@ -0,0 +1,114 @@
+package accuracy
+
+import (
+	"testing"
+	"time"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var issuedAt = time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+
+func rainAt(offsets ...time.Duration) []models.Weather {
+	weather := []models.Weather{}
+	for _, offset := range offsets {
+		weather = append(weather, models.Weather{Date: issuedAt.Add(offset), Type: models.WeatherTypeRain})
+	}
+	return weather
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name      string
+		dateRange util.DateRange
+		forecast  []models.Weather
+		observed  []models.Weather
+		expCounts map[int]Counts
+	}{
+		{
+			name:      "hits, misses and false alarms by lead days",
+			dateRange: util.DateRange{Start: issuedAt, End: issuedAt.AddDate(0, 0, 2)},
+			forecast:  rainAt(0, 3*time.Hour+30*time.Minute, 30*time.Hour),
+			observed:  rainAt(time.Hour, 33*time.Hour),
+			expCounts: map[int]Counts{
+				0: {Periods: 8, Hits: 1, FalseAlarms: 1, CorrectNegatives: 6},
+				1: {Periods: 8, Misses: 1, FalseAlarms: 1, CorrectNegatives: 6},
+			},
+		},
+		{
+			name:      "partial periods aren't compared",
+			dateRange: util.DateRange{Start: issuedAt.Add(time.Hour), End: issuedAt.Add(8 * time.Hour)},
+			forecast:  rainAt(time.Hour, 3*time.Hour),
+			observed:  rainAt(4 * time.Hour),
+			expCounts: map[int]Counts{0: {Periods: 1, Hits: 1}},
+		},
+		{
+			name:      "no rain",
+			dateRange: util.DateRange{Start: issuedAt, End: issuedAt.Add(6 * time.Hour)},
+			forecast:  []models.Weather{{Date: issuedAt, Type: "snow"}},
+			expCounts: map[int]Counts{0: {Periods: 2, CorrectNegatives: 2}},
+		},
+	}
+
+	for _, test := range tests {
+		counts := compare(Snapshot{Range: test.dateRange, Forecast: test.forecast}, test.observed)
+		assert.Equal(t, test.expCounts, counts, test.name)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tracker := New()
+	dateRange := util.DateRange{Start: issuedAt, End: issuedAt.AddDate(0, 0, 1)}
+	replay := weatherforecaster.ProviderConfig{Provider: weatherforecaster.ProviderReplay, Fixtures: "fixtures"}
+	toronto := Snapshot{Provider: replay, Location: weatherforecaster.Location{City: "Toronto", CountryCode: "CA"}, Range: dateRange}
+	chicago := Snapshot{Provider: weatherforecaster.ProviderConfig{}, Location: weatherforecaster.Location{City: "Chicago", CountryCode: "US"}, Range: dateRange}
+
+	tracker.Record(toronto)
+	// A later forecast of the same location, issued at the same time, replaces the earlier one
+	toronto.Forecast = rainAt(0)
+	tracker.Record(Snapshot{Provider: replay, Location: weatherforecaster.Location{City: "TORONTO", CountryCode: "ca"}, Range: dateRange, Forecast: rainAt(0)})
+	tracker.Record(chicago)
+	// Forecasts without a range aren't tracked
+	tracker.Record(Snapshot{Provider: replay, Location: chicago.Location, Range: util.DateRange{Start: issuedAt, End: issuedAt}})
+	assert.Equal(t, 2, tracker.Pending())
+
+	assert.Empty(t, tracker.Due(dateRange.End))
+	due := tracker.Due(dateRange.End.Add(observationDelay))
+	if assert.Len(t, due, 2) {
+		assert.Equal(t, chicago, due[0])
+		assert.Equal(t, rainAt(0), due[1].Forecast)
+	}
+
+	tracker.Observe(due[1], rainAt(0, 3*time.Hour))
+	for i := 0; i < maxAttempts; i++ {
+		assert.Equal(t, 1, tracker.Pending())
+		tracker.Fail(chicago)
+	}
+	assert.Equal(t, 0, tracker.Pending())
+	// Snapshots that are no longer pending are ignored
+	tracker.Observe(toronto, nil)
+
+	report := tracker.Report()
+	if assert.Len(t, report, 1) {
+		assert.Equal(t, weatherforecaster.ProviderReplay, report[0].Provider)
+		assert.Equal(t, 1, report[0].Forecasts)
+		assert.Equal(t, Counts{Periods: 8, Hits: 1, Misses: 1, CorrectNegatives: 6}, report[0].Counts)
+		assert.Equal(t, 7.0/8, report[0].Accuracy)
+		assert.Equal(t, 0.5, report[0].ProbabilityOfDetection)
+		assert.Equal(t, 0.0, report[0].FalseAlarmRatio)
+		assert.Equal(t, []LeadTimeAccuracy{{LeadDays: 0, Metrics: report[0].Metrics}}, report[0].LeadTimes)
+	}
+
+	// Providers are ranked by accuracy
+	chicago.Forecast = rainAt(0, 3*time.Hour)
+	tracker.Record(chicago)
+	tracker.Observe(chicago, rainAt(0, 3*time.Hour))
+	report = tracker.Report()
+	if assert.Len(t, report, 2) {
+		assert.Equal(t, weatherforecaster.ProviderOpenWeatherMap, report[0].Provider)
+		assert.Equal(t, weatherforecaster.ProviderReplay, report[1].Provider)
+	}
+}
@@ -0,0 +1,259 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"umbrellacorp/util"
+)
+
+// Action describes the kind of change recorded by an Entry
+type Action string
+
+// Supported Action values
+const (
+	ActionCreate  = Action("create")
+	ActionUpdate  = Action("update")
+	ActionDelete  = Action("delete")
+	ActionRestore = Action("restore")
+	ActionPurge   = Action("purge")
+)
+
+// Entry records a single change made to an entity
+type Entry struct {
+	ID string `json:"id"`
+	// Tenant is the tenant whose data was changed
+	Tenant   string    `json:"tenant"`
+	EntityID string    `json:"entity_id"`
+	Action   Action    `json:"action"`
+	Actor    string    `json:"actor"`
+	Route    string    `json:"route"`
+	Time     time.Time `json:"time"`
+	// Changes lists the fields that were modified by the change, ordered by field
+	Changes []FieldChange `json:"changes"`
+}
+
+// FieldChange is the before and after value of a modified field. Nested fields are named with dot separated paths, e.g. address.city
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Log is an append-only log of entries that is safe for concurrent use. Entries are kept in memory and, if the log has a path, appended to
+// its file as a line of json each, so that the history survives restarts without rewriting the file on every change
+type Log struct {
+	path string
+
+	mu      sync.RWMutex
+	entries []Entry
+}
+
+// NewLog returns an empty Log that is only kept in memory
+func NewLog() *Log {
+	return &Log{}
+}
+
+// New returns a Log containing the entries appended to the file at path. The log is only kept in memory if path is empty. A missing file
+// is treated as an empty log. The last line is left incomplete if the process stopped while appending it, so an unterminated last line
+// that can't be parsed is truncated rather than failing to load the log
+func New(path string) (*Log, error) {
+	log := &Log{path: path}
+	if path == "" {
+		return log, nil
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return log, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read audit log from %s: %s", path, err.Error())
+	}
+
+	lines := bytes.Split(buf, []byte("\n"))
+	// complete is the length of the lines that were read so far, including their newlines
+	complete := 0
+	for i, line := range lines {
+		last := i == len(lines)-1
+		if len(bytes.TrimSpace(line)) == 0 {
+			complete += len(line) + 1
+			continue
+		}
+
+		var entry Entry
+		if err = json.Unmarshal(line, &entry); err != nil {
+			if !last {
+				return nil, fmt.Errorf("Failed to parse audit log from %s at line %d: %s", path, i+1, err.Error())
+			}
+			if err = os.Truncate(path, int64(complete)); err != nil {
+				return nil, fmt.Errorf("Failed to truncate the incomplete last line of audit log %s: %s", path, err.Error())
+			}
+			break
+		}
+		log.entries = append(log.entries, entry)
+		complete += len(line) + 1
+
+		if last {
+			// The entry was written but not its newline, which is added so that the next entry starts on its own line
+			if err = appendFile(path, []byte("\n")); err != nil {
+				return nil, fmt.Errorf("Failed to read audit log from %s: %s", path, err.Error())
+			}
+		}
+	}
+	return log, nil
+}
+
+// Append adds an entry to the log, assigning its ID. The entry is kept in memory even if it fails to be appended to the log's file
+func (log *Log) Append(entry Entry) (Entry, error) {
+	entry.ID = util.NewID()
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.entries = append(log.entries, entry)
+	return entry, log.write(entry)
+}
+
+// write appends the entry to the log's file. The caller must hold the lock
+func (log *Log) write(entry Entry) error {
+	if log.path == "" {
+		return nil
+	}
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err = appendFile(log.path, append(buf, '\n')); err != nil {
+		return fmt.Errorf("Failed to save audit entry: %s", err.Error())
+	}
+	return nil
+}
+
+// appendFile appends buf to the file at path, creating it if it doesn't exist
+func appendFile(path string, buf []byte) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(buf); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// History returns the entries of the tenant's specified entity, oldest first
+func (log *Log) History(tenant, entityID string) []Entry {
+	log.mu.RLock()
+	defer log.mu.RUnlock()
+
+	history := []Entry{}
+	for _, entry := range log.entries {
+		if entry.Tenant == tenant && entry.EntityID == entityID {
+			history = append(history, entry)
+		}
+	}
+	return history
+}
+
+// Entries returns the tenant's entries recorded within the date range, oldest first. A zero Start or End leaves that side of the range
+// open
+func (log *Log) Entries(tenant string, dateRange util.DateRange) []Entry {
+	log.mu.RLock()
+	defer log.mu.RUnlock()
+
+	entries := []Entry{}
+	for _, entry := range log.entries {
+		if entry.Tenant != tenant {
+			continue
+		}
+		if !dateRange.Start.IsZero() && entry.Time.Before(dateRange.Start) {
+			continue
+		}
+		if !dateRange.End.IsZero() && entry.Time.After(dateRange.End) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Diff returns the fields that differ between the json representations of before and after, excluding the ignored fields and their
+// nested fields. Either may be nil, e.g. when an entity is created
+func Diff(before, after interface{}, ignored ...string) ([]FieldChange, error) {
+	beforeFields, err := flatten(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := flatten(after)
+	if err != nil {
+		return nil, err
+	}
+
+	isIgnored := func(field string) bool {
+		for _, ignoredField := range ignored {
+			if field == ignoredField || strings.HasPrefix(field, ignoredField+".") {
+				return true
+			}
+		}
+		return false
+	}
+
+	fields := map[string]bool{}
+	for field := range beforeFields {
+		fields[field] = true
+	}
+	for field := range afterFields {
+		fields[field] = true
+	}
+
+	changes := []FieldChange{}
+	for field := range fields {
+		if isIgnored(field) || reflect.DeepEqual(beforeFields[field], afterFields[field]) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: field, Before: beforeFields[field], After: afterFields[field]})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes, nil
+}
+
+// flatten converts the json representation of val into a map of dot separated field paths to values. Arrays are treated as a single
+// value
+func flatten(val interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if val == nil || (reflect.ValueOf(val).Kind() == reflect.Ptr && reflect.ValueOf(val).IsNil()) {
+		return fields, nil
+	}
+
+	buf, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	var decoded map[string]interface{}
+	if err = json.Unmarshal(buf, &decoded); err != nil {
+		return nil, err
+	}
+
+	var walk func(prefix string, obj map[string]interface{})
+	walk = func(prefix string, obj map[string]interface{}) {
+		for key, value := range obj {
+			if nested, ok := value.(map[string]interface{}); ok {
+				walk(prefix+key+".", nested)
+				continue
+			}
+			fields[prefix+key] = value
+		}
+	}
+	walk("", decoded)
+	return fields, nil
+}
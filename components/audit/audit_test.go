@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"umbrellacorp/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type address struct {
+	City    string `json:"city"`
+	Country string `json:"country"`
+}
+
+type entity struct {
+	Name    string   `json:"name"`
+	Tags    []string `json:"tags"`
+	Address address  `json:"address"`
+	Score   int      `json:"score"`
+}
+
+func TestDiff(t *testing.T) {
+	existing := &entity{Name: "Acme", Tags: []string{"a"}, Address: address{City: "Toronto", Country: "CA"}, Score: 1}
+
+	tests := []struct {
+		name       string
+		before     interface{}
+		after      interface{}
+		ignored    []string
+		expChanges []FieldChange
+	}{
+		{
+			name:       "no changes",
+			before:     existing,
+			after:      existing,
+			expChanges: []FieldChange{},
+		},
+		{
+			name:   "nested and array fields",
+			before: existing,
+			after:  &entity{Name: "Acme", Tags: []string{"a", "b"}, Address: address{City: "Ottawa", Country: "CA"}, Score: 1},
+			expChanges: []FieldChange{
+				{Field: "address.city", Before: "Toronto", After: "Ottawa"},
+				{Field: "tags", Before: []interface{}{"a"}, After: []interface{}{"a", "b"}},
+			},
+		},
+		{
+			name:       "ignored fields",
+			before:     existing,
+			after:      &entity{Name: "Acme Inc", Tags: []string{"a"}, Address: address{City: "Ottawa", Country: "CA"}, Score: 2},
+			ignored:    []string{"score", "address"},
+			expChanges: []FieldChange{{Field: "name", Before: "Acme", After: "Acme Inc"}},
+		},
+		{
+			name:   "created",
+			before: (*entity)(nil),
+			after:  &entity{Name: "Acme"},
+			expChanges: []FieldChange{
+				{Field: "address.city", After: ""},
+				{Field: "address.country", After: ""},
+				{Field: "name", After: "Acme"},
+				{Field: "score", After: float64(0)},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes, err := Diff(test.before, test.after, test.ignored...)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expChanges, changes)
+		})
+	}
+}
+
+func TestLog(t *testing.T) {
+	start := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	log := NewLog()
+	first, _ := log.Append(Entry{Tenant: "acme", EntityID: "1", Action: ActionCreate, Time: start})
+	second, _ := log.Append(Entry{Tenant: "acme", EntityID: "2", Action: ActionCreate, Time: start.Add(time.Hour)})
+	third, _ := log.Append(Entry{Tenant: "acme", EntityID: "1", Action: ActionUpdate, Time: start.Add(2 * time.Hour)})
+
+	assert.NotEmpty(t, first.ID)
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Equal(t, []Entry{first, third}, log.History("acme", "1"))
+	assert.Equal(t, []Entry{}, log.History("acme", "3"))
+	assert.Equal(t, []Entry{first, second, third}, log.Entries("acme", util.DateRange{}))
+	assert.Equal(t, []Entry{second, third}, log.Entries("acme", util.DateRange{Start: start.Add(time.Hour)}))
+	assert.Equal(t, []Entry{first, second}, log.Entries("acme", util.DateRange{End: start.Add(time.Hour)}))
+
+	// Other tenants' entries are excluded
+	assert.Equal(t, []Entry{}, log.History("globex", "1"))
+	assert.Equal(t, []Entry{}, log.Entries("globex", util.DateRange{}))
+}
+
+func TestPersistedLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	start := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	log, err := New(path)
+	assert.NoError(t, err)
+	created, err := log.Append(Entry{Tenant: "acme", EntityID: "1", Action: ActionCreate, Time: start, Changes: []FieldChange{}})
+	assert.NoError(t, err)
+	updated, err := log.Append(Entry{Tenant: "acme", EntityID: "1", Action: ActionUpdate, Time: start.Add(time.Hour), Changes: []FieldChange{
+		{Field: "name", Before: "Acme", After: "Acme Inc"},
+	}})
+	assert.NoError(t, err)
+
+	// Entries are appended to the file rather than rewriting it
+	buf, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(buf), "\n"))
+
+	reloaded, err := New(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{created, updated}, reloaded.History("acme", "1"))
+
+	// An unterminated last line is left by a crash while appending, so it's truncated and appending continues after the complete lines
+	assert.NoError(t, ioutil.WriteFile(path, append(buf, []byte(`{"id":"3","tenant":"ac`)...), 0600))
+	reloaded, err = New(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{created, updated}, reloaded.History("acme", "1"))
+	deleted, err := reloaded.Append(Entry{Tenant: "acme", EntityID: "1", Action: ActionDelete, Time: start.Add(2 * time.Hour), Changes: []FieldChange{}})
+	assert.NoError(t, err)
+	reloaded, err = New(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{created, updated, deleted}, reloaded.History("acme", "1"))
+
+	// Corruption before the last line fails to load the log
+	assert.NoError(t, ioutil.WriteFile(path, append([]byte("{not json\n"), buf...), 0600))
+	_, err = New(path)
+	assert.EqualError(t, err, fmt.Sprintf("Failed to parse audit log from %s at line 1: invalid character 'n' looking for beginning of object key string", path))
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"umbrellacorp/models"
+)
+
+// APIKeyHeader is an alternative to the Authorization header for sending API keys
+const APIKeyHeader = "X-API-Key"
+
+// Authenticator authenticates requests using either API keys or JWTs signed with a configured key. Credentials are read from the
+// Authorization header as "Bearer <API key or JWT>", or from the X-API-Key header
+type Authenticator struct {
+	keys *KeyStore
+	// jwtKey is the HS256 key JWTs are signed with. JWTs aren't accepted if it's empty
+	jwtKey []byte
+	now    func() time.Time
+}
+
+// NewAuthenticator returns an Authenticator accepting the API keys in the store and JWTs signed with jwtKey
+func NewAuthenticator(keys *KeyStore, jwtKey []byte) *Authenticator {
+	return &Authenticator{keys: keys, jwtKey: jwtKey, now: time.Now}
+}
+
+// Authenticate returns the principal identified by the credentials in the http headers
+func (authenticator *Authenticator) Authenticate(header http.Header) (models.Principal, error) {
+	credential := header.Get(APIKeyHeader)
+	if authorization := header.Get("Authorization"); authorization != "" {
+		scheme, value, ok := cutSpace(authorization)
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return models.Principal{}, fmt.Errorf("Authorization header must use the Bearer scheme")
+		}
+		credential = value
+	}
+	if credential == "" {
+		return models.Principal{}, fmt.Errorf("Authentication required")
+	}
+
+	if strings.HasPrefix(credential, apiKeyPrefix) {
+		key, ok := authenticator.keys.Lookup(credential)
+		if !ok {
+			return models.Principal{}, fmt.Errorf("Invalid API key")
+		}
+		return models.Principal{Subject: key.Name, Role: key.Role, Tenant: key.Tenant, Client: "key:" + key.ID}, nil
+	}
+
+	if len(authenticator.jwtKey) == 0 {
+		return models.Principal{}, fmt.Errorf("Invalid API key")
+	}
+	claims, err := VerifyJWT(credential, authenticator.jwtKey, authenticator.now())
+	if err != nil {
+		return models.Principal{}, err
+	}
+	return models.Principal{Subject: claims.Subject, Role: claims.Role, Tenant: claims.Tenant, Client: "jwt:" + claims.Subject}, nil
+}
+
+// cutSpace splits the value around its first space
+func cutSpace(value string) (string, string, bool) {
+	i := strings.IndexByte(value, ' ')
+	if i < 0 {
+		return value, "", false
+	}
+	return value[:i], strings.TrimSpace(value[i+1:]), true
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate(t *testing.T) {
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	jwtKey := []byte("jwt signing key")
+
+	keys, _ := NewKeyStore("")
+	repAPIKey, repKey, err := keys.Create("rep@umbrellacorp.com", models.RoleRep, "acme")
+	assert.NoError(t, err)
+
+	sign := func(claims Claims, key []byte) string {
+		token, err := SignJWT(claims, key)
+		assert.NoError(t, err)
+		return token
+	}
+	validClaims := Claims{Subject: "manager@umbrellacorp.com", Role: models.RoleAdmin, ExpiresAt: now.Add(time.Hour).Unix()}
+	validJWT := sign(validClaims, jwtKey)
+	parts := strings.Split(validJWT, ".")
+	unsignedJWT := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+
+	tests := []struct {
+		name         string
+		header       http.Header
+		expPrincipal models.Principal
+		expError     string
+	}{
+		{
+			name:         "api key bearer",
+			header:       http.Header{"Authorization": {"Bearer " + repKey}},
+			expPrincipal: models.Principal{Subject: "rep@umbrellacorp.com", Role: models.RoleRep, Tenant: "acme", Client: "key:" + repAPIKey.ID},
+		},
+		{
+			name:         "api key header",
+			header:       http.Header{"X-Api-Key": {repKey}},
+			expPrincipal: models.Principal{Subject: "rep@umbrellacorp.com", Role: models.RoleRep, Tenant: "acme", Client: "key:" + repAPIKey.ID},
+		},
+		{
+			name:     "unknown api key",
+			header:   http.Header{"X-Api-Key": {"uc_unknown"}},
+			expError: "Invalid API key",
+		},
+		{
+			name:         "jwt",
+			header:       http.Header{"Authorization": {"bearer " + validJWT}},
+			expPrincipal: models.Principal{Subject: "manager@umbrellacorp.com", Role: models.RoleAdmin, Client: "jwt:manager@umbrellacorp.com"},
+		},
+		{
+			name: "jwt with tenant",
+			header: http.Header{"Authorization": {"Bearer " + sign(Claims{
+				Subject: "manager@umbrellacorp.com", Role: models.RoleAdmin, Tenant: "acme", ExpiresAt: now.Add(time.Hour).Unix(),
+			}, jwtKey)}},
+			expPrincipal: models.Principal{Subject: "manager@umbrellacorp.com", Role: models.RoleAdmin, Tenant: "acme", Client: "jwt:manager@umbrellacorp.com"},
+		},
+		{
+			name:     "jwt signed with another key",
+			header:   http.Header{"Authorization": {"Bearer " + sign(validClaims, []byte("other key"))}},
+			expError: "Invalid token signature",
+		},
+		{
+			name:     "unsigned jwt",
+			header:   http.Header{"Authorization": {"Bearer " + unsignedJWT}},
+			expError: "Unsupported token algorithm: none",
+		},
+		{
+			name: "expired jwt",
+			header: http.Header{"Authorization": {"Bearer " + sign(Claims{
+				Subject: "manager@umbrellacorp.com", Role: models.RoleAdmin, ExpiresAt: now.Add(-time.Hour).Unix(),
+			}, jwtKey)}},
+			expError: "Token has expired",
+		},
+		{
+			name: "jwt without expiry",
+			header: http.Header{"Authorization": {"Bearer " + sign(Claims{
+				Subject: "manager@umbrellacorp.com", Role: models.RoleAdmin,
+			}, jwtKey)}},
+			expError: "Token doesn't expire",
+		},
+		{
+			name: "jwt not valid yet",
+			header: http.Header{"Authorization": {"Bearer " + sign(Claims{
+				Subject: "manager@umbrellacorp.com", Role: models.RoleAdmin, ExpiresAt: now.Add(2 * time.Hour).Unix(), NotBefore: now.Add(time.Hour).Unix(),
+			}, jwtKey)}},
+			expError: "Token isn't valid yet",
+		},
+		{
+			name: "jwt with unknown role",
+			header: http.Header{"Authorization": {"Bearer " + sign(Claims{
+				Subject: "manager@umbrellacorp.com", Role: models.Role("root"), ExpiresAt: now.Add(time.Hour).Unix(),
+			}, jwtKey)}},
+			expError: "Unknown role: root",
+		},
+		{
+			name:     "malformed jwt",
+			header:   http.Header{"Authorization": {"Bearer not.a.jwt"}},
+			expError: "Malformed token",
+		},
+		{
+			name:     "basic auth",
+			header:   http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}},
+			expError: "Authorization header must use the Bearer scheme",
+		},
+		{
+			name:     "no credentials",
+			header:   http.Header{},
+			expError: "Authentication required",
+		},
+	}
+
+	authenticator := NewAuthenticator(keys, jwtKey)
+	authenticator.now = func() time.Time { return now }
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			principal, err := authenticator.Authenticate(test.header)
+			if test.expError != "" {
+				assert.EqualError(t, err, test.expError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expPrincipal, principal)
+		})
+	}
+
+	t.Run("jwts are rejected without a key", func(t *testing.T) {
+		_, err := NewAuthenticator(keys, nil).Authenticate(http.Header{"Authorization": {"Bearer " + validJWT}})
+		assert.EqualError(t, err, "Invalid API key")
+	})
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"umbrellacorp/models"
+)
+
+// Claims are the JWT claims used to authenticate requests
+type Claims struct {
+	Subject string      `json:"sub"`
+	Role    models.Role `json:"role"`
+	// Tenant restricts the token to the data of a tenant. Tokens without a tenant can act on behalf of any tenant
+	Tenant string `json:"tenant,omitempty"`
+	// ExpiresAt and NotBefore are unix timestamps. ExpiresAt is required
+	ExpiresAt int64 `json:"exp"`
+	NotBefore int64 `json:"nbf,omitempty"`
+	IssuedAt  int64 `json:"iat,omitempty"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// clockSkew is the leeway allowed when checking a JWT's timestamps, to tolerate clock differences with the issuer
+const clockSkew = 30 * time.Second
+
+// SignJWT returns a JWT of the claims signed with HS256
+func SignJWT(claims Claims, key []byte) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(hs256(signingInput, key)), nil
+}
+
+// VerifyJWT verifies that the token is signed with the key using HS256 and is valid at the specified time, and returns its claims
+func VerifyJWT(token string, key []byte, now time.Time) (Claims, error) {
+	var claims Claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, fmt.Errorf("Malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, err
+	}
+	// Only accepting the expected algorithm prevents tokens claiming "none", or an algorithm that would misuse the key, from being accepted
+	if header.Alg != "HS256" {
+		return claims, fmt.Errorf("Unsupported token algorithm: %s", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, hs256(parts[0]+"."+parts[1], key)) {
+		return claims, fmt.Errorf("Invalid token signature")
+	}
+
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return claims, err
+	}
+	if claims.ExpiresAt == 0 {
+		return claims, fmt.Errorf("Token doesn't expire")
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)) {
+		return claims, fmt.Errorf("Token has expired")
+	}
+	if claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0)) {
+		return claims, fmt.Errorf("Token isn't valid yet")
+	}
+	if claims.Subject == "" {
+		return claims, fmt.Errorf("Token doesn't specify a subject")
+	}
+	if err = claims.Role.Validate(); err != nil {
+		return claims, err
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	buf, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("Malformed token")
+	}
+	if err = json.Unmarshal(buf, out); err != nil {
+		return fmt.Errorf("Malformed token")
+	}
+	return nil
+}
+
+func hs256(signingInput string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
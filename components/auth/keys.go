@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+)
+
+// apiKeyPrefix makes API keys recognizable, e.g. by secret scanners
+const apiKeyPrefix = "uc_"
+
+// APIKey is an API key granting a role. Only the key's hash is stored, the key itself is returned once when it's created
+type APIKey struct {
+	ID   string      `json:"id"`
+	Name string      `json:"name"`
+	Role models.Role `json:"role"`
+	// Tenant restricts the key to the data of a tenant. Keys without a tenant can act on behalf of any tenant
+	Tenant    string    `json:"tenant,omitempty"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// KeyStore stores API keys, persisting them to a file. It's safe for concurrent use
+type KeyStore struct {
+	path string
+
+	mu   sync.RWMutex
+	keys []APIKey
+}
+
+// NewKeyStore returns a KeyStore containing the keys previously persisted to path. Keys are only kept in memory if path is empty
+func NewKeyStore(path string) (*KeyStore, error) {
+	store := &KeyStore{path: path, keys: []APIKey{}}
+	if path == "" {
+		return store, nil
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to read API keys from %s: %s", path, err.Error())
+	}
+	if len(buf) > 0 {
+		if err = json.Unmarshal(buf, &store.keys); err != nil {
+			return nil, fmt.Errorf("Failed to parse API keys from %s: %s", path, err.Error())
+		}
+	}
+	return store, nil
+}
+
+// Create generates a new API key granting the role within the tenant, and returns it along with the plaintext key. The plaintext key
+// can't be recovered later
+func (store *KeyStore) Create(name string, role models.Role, tenant string) (APIKey, string, error) {
+	plaintext := apiKeyPrefix + util.NewID() + util.NewID()
+	key, err := store.Add(name, role, tenant, plaintext)
+	return key, plaintext, err
+}
+
+// Add stores a key that was generated elsewhere, such as the bootstrap key supplied by an operator, granting the role within the tenant.
+// Only the key's hash is stored
+func (store *KeyStore) Add(name string, role models.Role, tenant, plaintext string) (APIKey, error) {
+	if err := role.Validate(); err != nil {
+		return APIKey{}, err
+	}
+	key := APIKey{ID: util.NewID(), Name: name, Role: role, Tenant: tenant, Hash: hashKey(plaintext), CreatedAt: time.Now()}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.keys = append(store.keys, key)
+	return key, store.save()
+}
+
+// List returns every API key, in the order they were created
+func (store *KeyStore) List() []APIKey {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return append([]APIKey{}, store.keys...)
+}
+
+// Revoke deletes the API key with the specified id
+func (store *KeyStore) Revoke(id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for i, key := range store.keys {
+		if key.ID == id {
+			store.keys = append(store.keys[:i], store.keys[i+1:]...)
+			return store.save()
+		}
+	}
+	return ErrNotFound(id)
+}
+
+// Lookup returns the API key matching the plaintext key
+func (store *KeyStore) Lookup(plaintext string) (APIKey, bool) {
+	hash := hashKey(plaintext)
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	for _, key := range store.keys {
+		if key.Hash == hash {
+			return key, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// hashKey hashes a plaintext key. API keys are long random values rather than passwords, so a fast hash is sufficient
+func hashKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// save persists the keys to the store's path. The caller must hold the lock
+func (store *KeyStore) save() error {
+	if store.path == "" {
+		return nil
+	}
+	buf, err := json.Marshal(store.keys)
+	if err != nil {
+		return err
+	}
+	if err = util.WriteFileAtomic(store.path, buf); err != nil {
+		return fmt.Errorf("Failed to save API keys: %s", err.Error())
+	}
+	return nil
+}
+
+// ErrNotFound is returned when an API key with the specified id doesn't exist
+type ErrNotFound string
+
+func (id ErrNotFound) Error() string {
+	return fmt.Sprintf("Failed to locate API key with id: %s", string(id))
+}
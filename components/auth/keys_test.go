@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apikeys")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "api_keys.json")
+
+	store, err := NewKeyStore(path)
+	assert.NoError(t, err)
+	key, plaintext, err := store.Create("crm", models.RoleViewer, "")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(plaintext, apiKeyPrefix))
+
+	_, _, err = store.Create("crm", models.Role("root"), "")
+	assert.EqualError(t, err, "Unknown role: root")
+
+	// Only the hash is persisted
+	buf, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(buf), plaintext)
+
+	restarted, err := NewKeyStore(path)
+	assert.NoError(t, err)
+	found, ok := restarted.Lookup(plaintext)
+	assert.True(t, ok)
+	assert.Equal(t, key.ID, found.ID)
+
+	assert.NoError(t, restarted.Revoke(key.ID))
+	assert.Equal(t, ErrNotFound(key.ID), restarted.Revoke(key.ID))
+	_, ok = restarted.Lookup(plaintext)
+	assert.False(t, ok)
+}
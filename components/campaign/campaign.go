@@ -0,0 +1,561 @@
+package campaign
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"umbrellacorp/components/notify"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+)
+
+// Segment selects the customers that a campaign targets. Empty fields match every customer
+type Segment struct {
+	// Countries are matched against the customer's country code. Names are translated to codes by Normalize
+	Countries []string `json:"countries"`
+	// Cities are matched against the customer's city, case insensitive
+	Cities       []string `json:"cities"`
+	MinEmployees int      `json:"min_employees"`
+	// MaxEmployees is ignored if it's 0
+	MaxEmployees     int                     `json:"max_employees"`
+	PipelineStatuses []models.PipelineStatus `json:"pipeline_statuses"`
+	// RainWithinHours matches customers with rain forecast within the hours after the start of their forecast
+	RainWithinHours int `json:"rain_within_hours"`
+	// MinRainDays matches customers with rain forecast on at least the number of days
+	MinRainDays int `json:"min_rain_days"`
+}
+
+// Normalize validates the segment and translates its countries to codes
+func (segment Segment) Normalize() (Segment, error) {
+	if segment.MinEmployees < 0 || segment.MaxEmployees < 0 || segment.RainWithinHours < 0 || segment.MinRainDays < 0 {
+		return segment, fmt.Errorf("Segment bounds can't be negative")
+	}
+	if segment.MaxEmployees > 0 && segment.MaxEmployees < segment.MinEmployees {
+		return segment, fmt.Errorf("max_employees can't be less than min_employees")
+	}
+	for _, status := range segment.PipelineStatuses {
+		if err := status.Validate(); err != nil {
+			return segment, err
+		}
+	}
+
+	countries := []string{}
+	for _, country := range segment.Countries {
+		address, err := models.Address{Country: country}.SetCountryCode()
+		if err != nil {
+			return segment, fmt.Errorf("Unknown country: %s", country)
+		}
+		countries = append(countries, address.CountryCode)
+	}
+	segment.Countries = countries
+	return segment, nil
+}
+
+// Matches returns true if the customer is within the segment. Forecasts only cover weatherforecaster.ForecastRange, so rain is measured
+// from its start rather than from when the campaign runs
+func (segment Segment) Matches(customer models.Customer) bool {
+	if len(segment.Countries) > 0 && !containsFold(segment.Countries, customer.Address.CountryCode) {
+		return false
+	}
+	if len(segment.Cities) > 0 && !containsFold(segment.Cities, customer.Address.City) {
+		return false
+	}
+	if customer.NumEmployees < segment.MinEmployees || (segment.MaxEmployees > 0 && customer.NumEmployees > segment.MaxEmployees) {
+		return false
+	}
+	if len(segment.PipelineStatuses) > 0 {
+		status := customer.PipelineStatus
+		if status == "" {
+			status = models.PipelineStatusProspect
+		}
+		matched := false
+		for _, allowed := range segment.PipelineStatuses {
+			matched = matched || allowed == status
+		}
+		if !matched {
+			return false
+		}
+	}
+	if segment.RainWithinHours > 0 && !customer.HasRainWithin(weatherforecaster.ForecastRange().Start, time.Duration(segment.RainWithinHours)*time.Hour) {
+		return false
+	}
+	return customer.RainDays() >= segment.MinRainDays
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// TemplateData are the fields of a customer available to message templates, e.g. {{.Contact}}, rain is forecast in {{.City}} on
+// {{.FirstRain}}
+type TemplateData struct {
+	Name         string
+	Contact      string
+	City         string
+	Country      string
+	NumEmployees int
+	RainDays     int
+	// FirstRain is the day of the first rain in the customer's forecast, e.g. Friday, February 17. It's empty if no rain is forecast
+	FirstRain string
+}
+
+func newTemplateData(customer models.Customer) TemplateData {
+	data := TemplateData{
+		Name:         customer.Name,
+		Contact:      customer.Contact,
+		City:         customer.Address.City,
+		Country:      customer.Address.Country,
+		NumEmployees: customer.NumEmployees,
+		RainDays:     customer.RainDays(),
+	}
+	start := weatherforecaster.ForecastRange().Start
+	var firstRain *time.Time
+	for i, weather := range customer.WeatherDetails {
+		if weather.Type == models.WeatherTypeRain && !weather.Date.Before(start) && (firstRain == nil || weather.Date.Before(*firstRain)) {
+			firstRain = &customer.WeatherDetails[i].Date
+		}
+	}
+	if firstRain != nil {
+		data.FirstRain = firstRain.UTC().Format("Monday, January 2")
+	}
+	return data
+}
+
+// ParseTemplate parses a message template, verifying that it only refers to TemplateData fields
+func ParseTemplate(text string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("template required")
+	}
+	tmpl, err := template.New("message").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid template: %s", err.Error())
+	}
+	if err = tmpl.Execute(ioutil.Discard, TemplateData{}); err != nil {
+		return nil, fmt.Errorf("Invalid template: %s", err.Error())
+	}
+	return tmpl, nil
+}
+
+// Status is the status of a Campaign
+type Status string
+
+// Supported Status values
+const (
+	StatusScheduled = Status("scheduled")
+	StatusRunning   = Status("running")
+	StatusCompleted = Status("completed")
+	StatusCancelled = Status("cancelled")
+)
+
+// Campaign messages the customers in its segment through a channel
+type Campaign struct {
+	ID      string  `json:"id"`
+	Tenant  string  `json:"tenant"`
+	Name    string  `json:"name" api:"required"`
+	Segment Segment `json:"segment"`
+	// Channel is the notify channel that messages are sent through
+	Channel string `json:"channel" api:"required"`
+	// Template is the text/template of messages, executed with each customer's TemplateData
+	Template string `json:"template" api:"required"`
+	// ScheduledAt is when the campaign runs. Campaigns without a schedule only run when they're run manually
+	ScheduledAt *time.Time `json:"scheduled_at"`
+	Status      Status     `json:"status"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Normalize validates the campaign, normalizing its segment
+func (campaign Campaign) Normalize() (Campaign, error) {
+	if strings.TrimSpace(campaign.Name) == "" {
+		return campaign, fmt.Errorf("name required")
+	}
+	if campaign.Channel == "" {
+		return campaign, fmt.Errorf("channel required")
+	}
+	if _, err := ParseTemplate(campaign.Template); err != nil {
+		return campaign, err
+	}
+	var err error
+	campaign.Segment, err = campaign.Segment.Normalize()
+	return campaign, err
+}
+
+// OutcomeStatus is the status of the message sent to a customer
+type OutcomeStatus string
+
+// Supported OutcomeStatus values
+const (
+	OutcomeSent     = OutcomeStatus("sent")
+	OutcomeFailed   = OutcomeStatus("failed")
+	OutcomeOptedOut = OutcomeStatus("opted_out")
+)
+
+// Outcome is the outcome of messaging a customer
+type Outcome struct {
+	CustomerID   string        `json:"customer_id"`
+	CustomerName string        `json:"customer_name"`
+	To           string        `json:"to"`
+	Status       OutcomeStatus `json:"status"`
+	MessageID    string        `json:"message_id,omitempty"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// Run is an execution of a campaign
+type Run struct {
+	ID          string                `json:"id"`
+	Tenant      string                `json:"tenant"`
+	CampaignID  string                `json:"campaign_id"`
+	StartedAt   time.Time             `json:"started_at"`
+	CompletedAt time.Time             `json:"completed_at"`
+	Counts      map[OutcomeStatus]int `json:"counts"`
+	Outcomes    []Outcome             `json:"outcomes"`
+}
+
+// Execute messages each customer in the campaign's segment through the channel, skipping opted out customers
+func Execute(campaign Campaign, customers models.Customers, optedOut map[string]bool, channel notify.Channel, now time.Time) Run {
+	run := Run{
+		ID:         util.NewID(),
+		Tenant:     campaign.Tenant,
+		CampaignID: campaign.ID,
+		StartedAt:  now,
+		Counts:     map[OutcomeStatus]int{},
+		Outcomes:   []Outcome{},
+	}
+	tmpl, err := ParseTemplate(campaign.Template)
+
+	for _, customer := range customers {
+		if customer.DeletedAt != nil || !campaign.Segment.Matches(customer) {
+			continue
+		}
+		outcome := Outcome{CustomerID: customer.ID, CustomerName: customer.Name, To: customer.ContactNumber, Status: OutcomeSent}
+		switch {
+		case optedOut[customer.ID]:
+			outcome.Status = OutcomeOptedOut
+		case err != nil:
+			outcome.Status, outcome.Error = OutcomeFailed, err.Error()
+		default:
+			var body bytes.Buffer
+			if err := tmpl.Execute(&body, newTemplateData(customer)); err != nil {
+				outcome.Status, outcome.Error = OutcomeFailed, err.Error()
+				break
+			}
+			message := notify.Message{
+				ID:         util.NewID(),
+				Tenant:     campaign.Tenant,
+				Source:     "campaign " + campaign.ID,
+				CustomerID: customer.ID,
+				To:         customer.ContactNumber,
+				Body:       body.String(),
+			}
+			if err := channel.Send(message); err != nil {
+				outcome.Status, outcome.Error = OutcomeFailed, err.Error()
+				break
+			}
+			outcome.MessageID = message.ID
+		}
+		run.Outcomes = append(run.Outcomes, outcome)
+		run.Counts[outcome.Status]++
+	}
+	return run
+}
+
+// Preview is a customer that a campaign would message
+type Preview struct {
+	CustomerID   string `json:"customer_id"`
+	CustomerName string `json:"customer_name"`
+	To           string `json:"to"`
+	OptedOut     bool   `json:"opted_out"`
+	Message      string `json:"message"`
+}
+
+// PreviewMessages returns the messages that the campaign would send to the customers if it ran, including opted out customers
+// who would be skipped
+func PreviewMessages(campaign Campaign, customers models.Customers, optedOut map[string]bool) ([]Preview, error) {
+	tmpl, err := ParseTemplate(campaign.Template)
+	if err != nil {
+		return nil, err
+	}
+	previews := []Preview{}
+	for _, customer := range customers {
+		if customer.DeletedAt != nil || !campaign.Segment.Matches(customer) {
+			continue
+		}
+		var body bytes.Buffer
+		if err := tmpl.Execute(&body, newTemplateData(customer)); err != nil {
+			return nil, err
+		}
+		previews = append(previews, Preview{
+			CustomerID:   customer.ID,
+			CustomerName: customer.Name,
+			To:           customer.ContactNumber,
+			OptedOut:     optedOut[customer.ID],
+			Message:      body.String(),
+		})
+	}
+	return previews, nil
+}
+
+// OptOut records that a customer doesn't want to receive campaign messages
+type OptOut struct {
+	Tenant     string    `json:"tenant"`
+	CustomerID string    `json:"customer_id"`
+	By         string    `json:"by"`
+	At         time.Time `json:"at"`
+}
+
+// state is the persisted data of a Store
+type state struct {
+	Campaigns []Campaign `json:"campaigns"`
+	Runs      []Run      `json:"runs"`
+	OptOuts   []OptOut   `json:"opt_outs"`
+}
+
+// Store stores every tenant's campaigns, their runs and the customers that opted out of them, persisting them to a file. It's safe for
+// concurrent use
+type Store struct {
+	path string
+
+	mu    sync.RWMutex
+	state state
+}
+
+// New returns a Store containing the campaigns previously persisted to path. They're only kept in memory if path is empty
+func New(path string) (*Store, error) {
+	store := &Store{path: path, state: state{Campaigns: []Campaign{}, Runs: []Run{}, OptOuts: []OptOut{}}}
+	if path == "" {
+		return store, nil
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to read campaigns from %s: %s", path, err.Error())
+	}
+	if len(buf) > 0 {
+		if err = json.Unmarshal(buf, &store.state); err != nil {
+			return nil, fmt.Errorf("Failed to parse campaigns from %s: %s", path, err.Error())
+		}
+	}
+	return store, nil
+}
+
+// Create adds the campaign to the store with a new ID, as a scheduled campaign
+func (store *Store) Create(campaign Campaign) (Campaign, error) {
+	campaign, err := campaign.Normalize()
+	if err != nil {
+		return campaign, err
+	}
+	campaign.ID = util.NewID()
+	campaign.Status = StatusScheduled
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.state.Campaigns = append(store.state.Campaigns, campaign)
+	return campaign, store.save()
+}
+
+// Update replaces the tenant's campaign with the same ID, keeping its status and creation. Only campaigns that haven't run yet can be
+// updated
+func (store *Store) Update(campaign Campaign) (Campaign, error) {
+	campaign, err := campaign.Normalize()
+	if err != nil {
+		return campaign, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	i := store.campaignIndex(campaign.Tenant, campaign.ID)
+	if i < 0 {
+		return campaign, ErrNotFound{Kind: "campaign", ID: campaign.ID}
+	}
+	existing := store.state.Campaigns[i]
+	if existing.Status != StatusScheduled {
+		return campaign, ErrConflict(fmt.Sprintf("Campaign %s is %s", campaign.ID, existing.Status))
+	}
+	campaign.Status, campaign.CreatedBy, campaign.CreatedAt = existing.Status, existing.CreatedBy, existing.CreatedAt
+	store.state.Campaigns[i] = campaign
+	return campaign, store.save()
+}
+
+// Cancel cancels the tenant's campaign with the specified id, if it hasn't run yet
+func (store *Store) Cancel(tenant, id string) (Campaign, error) {
+	return store.transition(tenant, id, StatusScheduled, StatusCancelled)
+}
+
+// Start marks the tenant's scheduled campaign with the specified id as running, so that it isn't run again concurrently
+func (store *Store) Start(tenant, id string) (Campaign, error) {
+	return store.transition(tenant, id, StatusScheduled, StatusRunning)
+}
+
+// transition moves the tenant's campaign from the from status to the to status
+func (store *Store) transition(tenant, id string, from, to Status) (Campaign, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	i := store.campaignIndex(tenant, id)
+	if i < 0 {
+		return Campaign{}, ErrNotFound{Kind: "campaign", ID: id}
+	}
+	if store.state.Campaigns[i].Status != from {
+		return store.state.Campaigns[i], ErrConflict(fmt.Sprintf("Campaign %s is %s", id, store.state.Campaigns[i].Status))
+	}
+	store.state.Campaigns[i].Status = to
+	return store.state.Campaigns[i], store.save()
+}
+
+// Complete records the run of a running campaign, marking the campaign as completed
+func (store *Store) Complete(run Run) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	i := store.campaignIndex(run.Tenant, run.CampaignID)
+	if i < 0 {
+		return ErrNotFound{Kind: "campaign", ID: run.CampaignID}
+	}
+	store.state.Campaigns[i].Status = StatusCompleted
+	store.state.Runs = append(store.state.Runs, run)
+	return store.save()
+}
+
+// Campaigns returns the tenant's campaigns, in the order they were created
+func (store *Store) Campaigns(tenant string) []Campaign {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	campaigns := []Campaign{}
+	for _, campaign := range store.state.Campaigns {
+		if campaign.Tenant == tenant {
+			campaigns = append(campaigns, campaign)
+		}
+	}
+	return campaigns
+}
+
+// Campaign returns the tenant's campaign with the specified id
+func (store *Store) Campaign(tenant, id string) (Campaign, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if i := store.campaignIndex(tenant, id); i >= 0 {
+		return store.state.Campaigns[i], true
+	}
+	return Campaign{}, false
+}
+
+// Due returns the scheduled campaigns of every tenant whose schedule is at or before now, earliest first
+func (store *Store) Due(now time.Time) []Campaign {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	due := []Campaign{}
+	for _, campaign := range store.state.Campaigns {
+		if campaign.Status == StatusScheduled && campaign.ScheduledAt != nil && !campaign.ScheduledAt.After(now) {
+			due = append(due, campaign)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].ScheduledAt.Before(*due[j].ScheduledAt)
+	})
+	return due
+}
+
+// Runs returns the runs of the tenant's campaign with the specified id
+func (store *Store) Runs(tenant, campaignID string) []Run {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	runs := []Run{}
+	for _, run := range store.state.Runs {
+		if run.Tenant == tenant && run.CampaignID == campaignID {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+// OptOut records that the tenant's customer opted out of campaign messages
+func (store *Store) OptOut(optOut OptOut) (OptOut, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, existing := range store.state.OptOuts {
+		if existing.Tenant == optOut.Tenant && existing.CustomerID == optOut.CustomerID {
+			return existing, nil
+		}
+	}
+	store.state.OptOuts = append(store.state.OptOuts, optOut)
+	return optOut, store.save()
+}
+
+// OptIn removes the opt out of the tenant's customer, if it opted out
+func (store *Store) OptIn(tenant, customerID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for i, optOut := range store.state.OptOuts {
+		if optOut.Tenant == tenant && optOut.CustomerID == customerID {
+			store.state.OptOuts = append(store.state.OptOuts[:i], store.state.OptOuts[i+1:]...)
+			return store.save()
+		}
+	}
+	return nil
+}
+
+// OptedOut returns the ids of the tenant's customers that opted out of campaign messages
+func (store *Store) OptedOut(tenant string) map[string]bool {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	optedOut := map[string]bool{}
+	for _, optOut := range store.state.OptOuts {
+		if optOut.Tenant == tenant {
+			optedOut[optOut.CustomerID] = true
+		}
+	}
+	return optedOut
+}
+
+// save persists the store to its path. The caller must hold the lock
+func (store *Store) save() error {
+	if store.path == "" {
+		return nil
+	}
+	buf, err := json.Marshal(store.state)
+	if err != nil {
+		return err
+	}
+	if err = util.WriteFileAtomic(store.path, buf); err != nil {
+		return fmt.Errorf("Failed to save campaigns: %s", err.Error())
+	}
+	return nil
+}
+
+// campaignIndex returns the position of the tenant's campaign with the specified id, or -1. The caller must hold the lock
+func (store *Store) campaignIndex(tenant, id string) int {
+	for i, campaign := range store.state.Campaigns {
+		if campaign.ID == id && campaign.Tenant == tenant {
+			return i
+		}
+	}
+	return -1
+}
+
+// ErrNotFound is returned when a campaign with the specified id doesn't exist
+type ErrNotFound struct {
+	Kind string
+	ID   string
+}
+
+func (err ErrNotFound) Error() string {
+	return fmt.Sprintf("Failed to locate %s with id: %s", err.Kind, err.ID)
+}
+
+// ErrConflict is returned when a campaign's status doesn't allow a change
+type ErrConflict string
+
+func (err ErrConflict) Error() string {
+	return string(err)
+}
@@ -0,0 +1,198 @@
+package campaign
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"umbrellacorp/components/notify"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+
+var (
+	seattle = models.Customer{
+		ID:             "1",
+		Name:           "Seattle Company",
+		Contact:        "Jane",
+		ContactNumber:  "2065555555",
+		NumEmployees:   80,
+		PipelineStatus: models.PipelineStatusContacted,
+		Address:        models.Address{City: "Seattle", Country: "US", CountryCode: "US"},
+		WeatherDetails: []models.Weather{{Date: now.Add(30 * time.Hour), Type: models.WeatherTypeRain}, {Date: now.Add(54 * time.Hour), Type: models.WeatherTypeRain}},
+	}
+	vancouver = models.Customer{
+		ID:            "2",
+		Name:          "Vancouver Company",
+		ContactNumber: "6045555555",
+		NumEmployees:  20,
+		Address:       models.Address{City: "Vancouver", Country: "Canada", CountryCode: "CA"},
+	}
+)
+
+func TestSegment(t *testing.T) {
+	tests := []struct {
+		name     string
+		segment  Segment
+		expIDs   []string
+		expError error
+	}{
+		{
+			name:    "everyone",
+			segment: Segment{},
+			expIDs:  []string{"1", "2"},
+		},
+		{
+			name:    "countries by name",
+			segment: Segment{Countries: []string{"Canada"}},
+			expIDs:  []string{"2"},
+		},
+		{
+			name:    "cities and size",
+			segment: Segment{Cities: []string{"seattle", "vancouver"}, MinEmployees: 10, MaxEmployees: 50},
+			expIDs:  []string{"2"},
+		},
+		{
+			name:    "prospects include customers without a status",
+			segment: Segment{PipelineStatuses: []models.PipelineStatus{models.PipelineStatusProspect}},
+			expIDs:  []string{"2"},
+		},
+		{
+			name:    "rain within",
+			segment: Segment{RainWithinHours: 24},
+			expIDs:  []string{},
+		},
+		{
+			name:    "rainy week",
+			segment: Segment{RainWithinHours: 7 * 24, MinRainDays: 2},
+			expIDs:  []string{"1"},
+		},
+		{
+			name:     "unknown country",
+			segment:  Segment{Countries: []string{"Atlantis"}},
+			expError: fmt.Errorf("Unknown country: Atlantis"),
+		},
+		{
+			name:     "inverted size",
+			segment:  Segment{MinEmployees: 50, MaxEmployees: 10},
+			expError: fmt.Errorf("max_employees can't be less than min_employees"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			segment, err := test.segment.Normalize()
+			assert.Equal(t, test.expError, err)
+			if err != nil {
+				return
+			}
+			ids := []string{}
+			for _, customer := range (models.Customers{seattle, vancouver}) {
+				if segment.Matches(customer) {
+					ids = append(ids, customer.ID)
+				}
+			}
+			assert.Equal(t, test.expIDs, ids)
+		})
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	_, err := ParseTemplate("Hi {{.Contact}}, rain is forecast in {{.City}} on {{.FirstRain}}")
+	assert.NoError(t, err)
+	_, err = ParseTemplate("Hi {{.Contact")
+	assert.Error(t, err)
+	_, err = ParseTemplate("Hi {{.Email}}")
+	assert.Contains(t, err.Error(), "can't evaluate field Email")
+}
+
+// failingChannel fails to send messages to the contact number
+type failingChannel struct {
+	contactNumber string
+	sent          []notify.Message
+}
+
+func (channel *failingChannel) Send(message notify.Message) error {
+	if message.To == channel.contactNumber {
+		return fmt.Errorf("Unreachable")
+	}
+	channel.sent = append(channel.sent, message)
+	return nil
+}
+
+func TestExecute(t *testing.T) {
+	campaign := Campaign{ID: "c", Tenant: "acme", Template: "Hi {{.Contact}}, rain is forecast on {{.FirstRain}}"}
+	deleted := seattle
+	deleted.ID, deleted.DeletedAt = "3", &now
+	optedOut := vancouver
+	optedOut.ID = "4"
+	channel := &failingChannel{contactNumber: vancouver.ContactNumber}
+
+	previews, err := PreviewMessages(campaign, models.Customers{seattle, optedOut}, map[string]bool{"4": true})
+	assert.NoError(t, err)
+	assert.Equal(t, []Preview{
+		{CustomerID: "1", CustomerName: "Seattle Company", To: "2065555555", Message: "Hi Jane, rain is forecast on Friday, February 17"},
+		{CustomerID: "4", CustomerName: "Vancouver Company", To: "6045555555", OptedOut: true, Message: "Hi , rain is forecast on "},
+	}, previews)
+
+	run := Execute(campaign, models.Customers{seattle, vancouver, deleted, optedOut}, map[string]bool{"4": true}, channel, now)
+	assert.Equal(t, map[OutcomeStatus]int{OutcomeSent: 1, OutcomeFailed: 1, OutcomeOptedOut: 1}, run.Counts)
+	assert.Equal(t, OutcomeFailed, run.Outcomes[1].Status)
+	assert.Equal(t, "Unreachable", run.Outcomes[1].Error)
+	assert.Len(t, channel.sent, 1)
+	assert.Equal(t, "Hi Jane, rain is forecast on Friday, February 17", channel.sent[0].Body)
+	assert.Equal(t, channel.sent[0].ID, run.Outcomes[0].MessageID)
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "campaigns")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "campaigns.json")
+
+	store, err := New(path)
+	assert.NoError(t, err)
+	_, err = store.Create(Campaign{Name: "Rainy week", Channel: notify.ChannelSMS, Template: "{{.Missing}}"})
+	assert.Error(t, err)
+
+	later := now.Add(time.Hour)
+	first, err := store.Create(Campaign{Tenant: "acme", Name: "Rainy week", Channel: notify.ChannelSMS, Template: "Hi", ScheduledAt: &later})
+	assert.NoError(t, err)
+	second, err := store.Create(Campaign{Tenant: "acme", Name: "Rainy day", Channel: notify.ChannelSMS, Template: "Hi", ScheduledAt: &now})
+	assert.NoError(t, err)
+	_, err = store.Create(Campaign{Tenant: "acme", Name: "Manual", Channel: notify.ChannelSMS, Template: "Hi"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Campaign{second, first}, store.Due(later))
+
+	_, err = store.Start("acme", second.ID)
+	assert.NoError(t, err)
+	_, err = store.Start("acme", second.ID)
+	assert.Equal(t, ErrConflict(fmt.Sprintf("Campaign %s is running", second.ID)), err)
+	_, err = store.Update(second)
+	assert.IsType(t, ErrConflict(""), err)
+	assert.NoError(t, store.Complete(Run{ID: "r", Tenant: "acme", CampaignID: second.ID}))
+	_, err = store.Cancel("acme", first.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, store.Due(later))
+
+	_, err = store.OptOut(OptOut{Tenant: "acme", CustomerID: "1", By: "rep", At: now})
+	assert.NoError(t, err)
+	_, err = store.OptOut(OptOut{Tenant: "acme", CustomerID: "2", By: "rep", At: now})
+	assert.NoError(t, err)
+	assert.NoError(t, store.OptIn("acme", "2"))
+
+	// Campaigns survive restarts
+	restarted, err := New(path)
+	assert.NoError(t, err)
+	found, ok := restarted.Campaign("acme", second.ID)
+	assert.True(t, ok)
+	assert.Equal(t, StatusCompleted, found.Status)
+	assert.Len(t, restarted.Runs("acme", second.ID), 1)
+	assert.Equal(t, map[string]bool{"1": true}, restarted.OptedOut("acme"))
+	assert.Empty(t, restarted.Campaigns("globex"))
+}
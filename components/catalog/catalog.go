@@ -0,0 +1,566 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+)
+
+// Product is an umbrella that can be sold, identified by its SKU
+type Product struct {
+	ID          string `json:"id"`
+	SKU         string `json:"sku" api:"required"`
+	Name        string `json:"name" api:"required"`
+	Description string `json:"description"`
+	// Currency is the ISO-4217 code of the currency of the price tiers. Defaults to USD
+	Currency   string      `json:"currency"`
+	PriceTiers []PriceTier `json:"price_tiers" api:"required"`
+	// LowStockThreshold is the available stock of the product at a warehouse at or below which the warehouse is low on the product
+	LowStockThreshold int `json:"low_stock_threshold"`
+}
+
+// PriceTier is the unit price of a product when ordering at least MinQuantity units
+type PriceTier struct {
+	MinQuantity    int   `json:"min_quantity"`
+	UnitPriceCents int64 `json:"unit_price_cents"`
+}
+
+// defaultCurrency is the currency of products that don't specify one
+const defaultCurrency = "USD"
+
+// Normalize validates the product, and returns it with its SKU and currency uppercased and its price tiers sorted by quantity
+func (product Product) Normalize() (Product, error) {
+	product.SKU = strings.ToUpper(strings.TrimSpace(product.SKU))
+	if product.SKU == "" || strings.TrimSpace(product.Name) == "" {
+		return product, fmt.Errorf("Products require a sku and a name")
+	}
+	if product.Currency == "" {
+		product.Currency = defaultCurrency
+	}
+	product.Currency = strings.ToUpper(product.Currency)
+	if len(product.Currency) != 3 {
+		return product, fmt.Errorf("Currencies must be ISO-4217 codes: %s", product.Currency)
+	}
+	if product.LowStockThreshold < 0 {
+		return product, fmt.Errorf("The low stock threshold can't be negative")
+	}
+
+	if len(product.PriceTiers) == 0 {
+		return product, fmt.Errorf("Products require at least one price tier")
+	}
+	tiers := append([]PriceTier{}, product.PriceTiers...)
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].MinQuantity < tiers[j].MinQuantity
+	})
+	if tiers[0].MinQuantity != 1 {
+		return product, fmt.Errorf("The first price tier must start at a quantity of 1")
+	}
+	for i, tier := range tiers {
+		if tier.UnitPriceCents < 0 {
+			return product, fmt.Errorf("Prices can't be negative")
+		}
+		if i > 0 && tier.MinQuantity == tiers[i-1].MinQuantity {
+			return product, fmt.Errorf("Price tiers must start at distinct quantities")
+		}
+	}
+	product.PriceTiers = tiers
+	return product, nil
+}
+
+// UnitPriceCents returns the unit price of the product when ordering the quantity, which is the price of the tier with the largest minimum
+// quantity that the quantity satisfies
+func (product Product) UnitPriceCents(quantity int) int64 {
+	var price int64
+	for _, tier := range product.PriceTiers {
+		if quantity >= tier.MinQuantity {
+			price = tier.UnitPriceCents
+		}
+	}
+	return price
+}
+
+// Warehouse stocks products and serves customers within its region of countries and cities
+type Warehouse struct {
+	ID        string           `json:"id"`
+	Name      string           `json:"name" api:"required"`
+	Countries []string         `json:"countries"`
+	Cities    []territory.City `json:"cities"`
+}
+
+// Normalize validates the warehouse, and returns it with its countries translated to ISO-3166 alpha-2 codes
+func (warehouse Warehouse) Normalize() (Warehouse, error) {
+	if strings.TrimSpace(warehouse.Name) == "" {
+		return warehouse, fmt.Errorf("name required")
+	}
+	if len(warehouse.Countries) == 0 && len(warehouse.Cities) == 0 {
+		return warehouse, fmt.Errorf("A warehouse must serve at least one country or city")
+	}
+
+	region, err := warehouse.region().Normalize()
+	if err != nil {
+		return warehouse, err
+	}
+	warehouse.Countries, warehouse.Cities = region.Countries, region.Cities
+	return warehouse, nil
+}
+
+// region returns the territory that the warehouse serves
+func (warehouse Warehouse) region() territory.Territory {
+	return territory.Territory{Name: warehouse.Name, Countries: warehouse.Countries, Cities: warehouse.Cities}
+}
+
+// Serves returns true if the address is within the warehouse's region. The address's CountryCode must be set
+func (warehouse Warehouse) Serves(address models.Address) bool {
+	return warehouse.region().Contains(address)
+}
+
+// Stock is the stock of a product at a warehouse
+type Stock struct {
+	ProductID   string `json:"product_id"`
+	WarehouseID string `json:"warehouse_id"`
+	OnHand      int    `json:"on_hand"`
+	// Reserved is the part of the stock on hand that is reserved, e.g. for orders that haven't shipped
+	Reserved  int `json:"reserved"`
+	Available int `json:"available"`
+}
+
+// Reservation holds part of a product's stock at a warehouse, so that it isn't sold twice
+type Reservation struct {
+	ID          string `json:"id"`
+	ProductID   string `json:"product_id" api:"required"`
+	WarehouseID string `json:"warehouse_id" api:"required"`
+	Quantity    int    `json:"quantity" api:"required"`
+	// Reference optionally identifies what the stock is reserved for, e.g. a quote
+	Reference string    `json:"reference"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StockAlert notifies that a warehouse is low on a product while rain is forecast for many of the customers it serves
+type StockAlert struct {
+	ProductID     string `json:"product_id"`
+	SKU           string `json:"sku"`
+	WarehouseID   string `json:"warehouse_id"`
+	WarehouseName string `json:"warehouse_name"`
+	Available     int    `json:"available"`
+	Threshold     int    `json:"low_stock_threshold"`
+	// RainyCustomers is the number of customers served by the warehouse with rain in their forecast
+	RainyCustomers int `json:"rainy_customers"`
+}
+
+// stockKey identifies the stock of a product at a warehouse
+type stockKey struct {
+	product   string
+	warehouse string
+}
+
+// Catalog stores products, warehouses and their stock. It's safe for concurrent use
+type Catalog struct {
+	mu           sync.RWMutex
+	products     []Product
+	warehouses   []Warehouse
+	onHand       map[stockKey]int
+	reservations []Reservation
+}
+
+// Tenants holds a separate Catalog per tenant. It's safe for concurrent use
+type Tenants struct {
+	mu       sync.Mutex
+	catalogs map[string]*Catalog
+}
+
+// Default holds the catalogs shared by the application's handlers
+var Default = NewTenants()
+
+// NewTenants returns Tenants without any products
+func NewTenants() *Tenants {
+	return &Tenants{catalogs: map[string]*Catalog{}}
+}
+
+// Get returns the catalog of the tenant. The empty tenant is the default tenant
+func (tenants *Tenants) Get(tenant string) *Catalog {
+	if tenant == "" {
+		tenant = models.DefaultTenant
+	}
+
+	tenants.mu.Lock()
+	defer tenants.mu.Unlock()
+	catalog, ok := tenants.catalogs[tenant]
+	if !ok {
+		catalog = New()
+		tenants.catalogs[tenant] = catalog
+	}
+	return catalog
+}
+
+// New returns an empty Catalog
+func New() *Catalog {
+	return &Catalog{onHand: map[stockKey]int{}}
+}
+
+// Products returns every product, in the order they were created
+func (catalog *Catalog) Products() []Product {
+	catalog.mu.RLock()
+	defer catalog.mu.RUnlock()
+	return append([]Product{}, catalog.products...)
+}
+
+// Product returns the product with the specified id
+func (catalog *Catalog) Product(id string) (Product, bool) {
+	catalog.mu.RLock()
+	defer catalog.mu.RUnlock()
+	if i := catalog.productIndex(id); i >= 0 {
+		return catalog.products[i], true
+	}
+	return Product{}, false
+}
+
+// CreateProduct validates the product and adds it to the catalog with a new ID. SKUs are unique
+func (catalog *Catalog) CreateProduct(product Product) (Product, error) {
+	product, err := product.Normalize()
+	if err != nil {
+		return product, err
+	}
+	product.ID = util.NewID()
+
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+	if err = catalog.checkSKU(product); err != nil {
+		return product, err
+	}
+	catalog.products = append(catalog.products, product)
+	return product, nil
+}
+
+// UpdateProduct validates the product and replaces the existing product with the same ID. Its stock is kept
+func (catalog *Catalog) UpdateProduct(product Product) (Product, error) {
+	product, err := product.Normalize()
+	if err != nil {
+		return product, err
+	}
+
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+	i := catalog.productIndex(product.ID)
+	if i < 0 {
+		return product, ErrNotFound{Kind: "product", ID: product.ID}
+	}
+	if err = catalog.checkSKU(product); err != nil {
+		return product, err
+	}
+	catalog.products[i] = product
+	return product, nil
+}
+
+// DeleteProduct removes the product with the specified id along with its stock. Products with reserved stock can't be deleted
+func (catalog *Catalog) DeleteProduct(id string) error {
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+	i := catalog.productIndex(id)
+	if i < 0 {
+		return ErrNotFound{Kind: "product", ID: id}
+	}
+	for _, reservation := range catalog.reservations {
+		if reservation.ProductID == id {
+			return ErrConflict(fmt.Sprintf("Product %s has reserved stock, release its reservations first", id))
+		}
+	}
+
+	catalog.products = append(catalog.products[:i], catalog.products[i+1:]...)
+	for key := range catalog.onHand {
+		if key.product == id {
+			delete(catalog.onHand, key)
+		}
+	}
+	return nil
+}
+
+// Warehouses returns every warehouse, in the order they were created
+func (catalog *Catalog) Warehouses() []Warehouse {
+	catalog.mu.RLock()
+	defer catalog.mu.RUnlock()
+	return append([]Warehouse{}, catalog.warehouses...)
+}
+
+// Warehouse returns the warehouse with the specified id
+func (catalog *Catalog) Warehouse(id string) (Warehouse, bool) {
+	catalog.mu.RLock()
+	defer catalog.mu.RUnlock()
+	if i := catalog.warehouseIndex(id); i >= 0 {
+		return catalog.warehouses[i], true
+	}
+	return Warehouse{}, false
+}
+
+// CreateWarehouse validates the warehouse and adds it to the catalog with a new ID
+func (catalog *Catalog) CreateWarehouse(warehouse Warehouse) (Warehouse, error) {
+	warehouse, err := warehouse.Normalize()
+	if err != nil {
+		return warehouse, err
+	}
+	warehouse.ID = util.NewID()
+
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+	catalog.warehouses = append(catalog.warehouses, warehouse)
+	return warehouse, nil
+}
+
+// UpdateWarehouse validates the warehouse and replaces the existing warehouse with the same ID. Its stock is kept
+func (catalog *Catalog) UpdateWarehouse(warehouse Warehouse) (Warehouse, error) {
+	warehouse, err := warehouse.Normalize()
+	if err != nil {
+		return warehouse, err
+	}
+
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+	i := catalog.warehouseIndex(warehouse.ID)
+	if i < 0 {
+		return warehouse, ErrNotFound{Kind: "warehouse", ID: warehouse.ID}
+	}
+	catalog.warehouses[i] = warehouse
+	return warehouse, nil
+}
+
+// DeleteWarehouse removes the warehouse with the specified id along with its stock. Warehouses with reserved stock can't be deleted
+func (catalog *Catalog) DeleteWarehouse(id string) error {
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+	i := catalog.warehouseIndex(id)
+	if i < 0 {
+		return ErrNotFound{Kind: "warehouse", ID: id}
+	}
+	for _, reservation := range catalog.reservations {
+		if reservation.WarehouseID == id {
+			return ErrConflict(fmt.Sprintf("Warehouse %s has reserved stock, release its reservations first", id))
+		}
+	}
+
+	catalog.warehouses = append(catalog.warehouses[:i], catalog.warehouses[i+1:]...)
+	for key := range catalog.onHand {
+		if key.warehouse == id {
+			delete(catalog.onHand, key)
+		}
+	}
+	return nil
+}
+
+// Stock returns the stock of the product at every warehouse, in the order the warehouses were created
+func (catalog *Catalog) Stock(productID string) ([]Stock, error) {
+	catalog.mu.RLock()
+	defer catalog.mu.RUnlock()
+	if catalog.productIndex(productID) < 0 {
+		return nil, ErrNotFound{Kind: "product", ID: productID}
+	}
+
+	stock := []Stock{}
+	for _, warehouse := range catalog.warehouses {
+		stock = append(stock, catalog.stock(productID, warehouse.ID))
+	}
+	return stock, nil
+}
+
+// SetStock sets the stock of the product on hand at the warehouse, e.g. after a delivery or a stock count. The stock on hand can't be less
+// than the stock reserved
+func (catalog *Catalog) SetStock(productID, warehouseID string, onHand int) (Stock, error) {
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+	if err := catalog.checkStockKey(productID, warehouseID); err != nil {
+		return Stock{}, err
+	}
+	if onHand < 0 {
+		return Stock{}, fmt.Errorf("Stock on hand can't be negative")
+	}
+	if reserved := catalog.reserved(productID, warehouseID); onHand < reserved {
+		return Stock{}, ErrConflict(fmt.Sprintf("%d units are reserved, release reservations before reducing the stock on hand below them", reserved))
+	}
+
+	catalog.onHand[stockKey{product: productID, warehouse: warehouseID}] = onHand
+	return catalog.stock(productID, warehouseID), nil
+}
+
+// Reservations returns every reservation, in the order they were made
+func (catalog *Catalog) Reservations() []Reservation {
+	catalog.mu.RLock()
+	defer catalog.mu.RUnlock()
+	return append([]Reservation{}, catalog.reservations...)
+}
+
+// Reserve reserves the quantity of the product at the warehouse if enough of it is available. ErrInsufficientStock is returned otherwise
+func (catalog *Catalog) Reserve(reservation Reservation) (Reservation, error) {
+	if reservation.Quantity <= 0 {
+		return reservation, fmt.Errorf("Reserved quantities must be positive")
+	}
+
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+	if err := catalog.checkStockKey(reservation.ProductID, reservation.WarehouseID); err != nil {
+		return reservation, err
+	}
+	stock := catalog.stock(reservation.ProductID, reservation.WarehouseID)
+	if stock.Available < reservation.Quantity {
+		return reservation, ErrInsufficientStock{Stock: stock, Requested: reservation.Quantity}
+	}
+
+	reservation.ID = util.NewID()
+	reservation.CreatedAt = time.Now().UTC()
+	catalog.reservations = append(catalog.reservations, reservation)
+	return reservation, nil
+}
+
+// Release releases the reservation with the specified id, making its stock available again
+func (catalog *Catalog) Release(id string) (Reservation, error) {
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+	for i, reservation := range catalog.reservations {
+		if reservation.ID == id {
+			catalog.reservations = append(catalog.reservations[:i], catalog.reservations[i+1:]...)
+			return reservation, nil
+		}
+	}
+	return Reservation{}, ErrNotFound{Kind: "reservation", ID: id}
+}
+
+// Fulfill removes the reservation with the specified id along with its quantity of stock on hand, once the reserved stock has shipped
+func (catalog *Catalog) Fulfill(id string) (Reservation, error) {
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+	for i, reservation := range catalog.reservations {
+		if reservation.ID == id {
+			catalog.reservations = append(catalog.reservations[:i], catalog.reservations[i+1:]...)
+			catalog.onHand[stockKey{product: reservation.ProductID, warehouse: reservation.WarehouseID}] -= reservation.Quantity
+			return reservation, nil
+		}
+	}
+	return Reservation{}, ErrNotFound{Kind: "reservation", ID: id}
+}
+
+// LowStock returns an alert for each product that a warehouse is low on while rain is forecast for at least minRainyCustomers of the
+// customers it serves, since those customers are likely to order umbrellas soon. Alerts are sorted by warehouse and product, in the
+// order they were created
+func (catalog *Catalog) LowStock(customers models.Customers, minRainyCustomers int) []StockAlert {
+	catalog.mu.RLock()
+	defer catalog.mu.RUnlock()
+
+	alerts := []StockAlert{}
+	for _, warehouse := range catalog.warehouses {
+		rainyCustomers := 0
+		for _, customer := range customers {
+			if customer.RainDays() > 0 && warehouse.Serves(customer.Address) {
+				rainyCustomers++
+			}
+		}
+		if rainyCustomers == 0 || rainyCustomers < minRainyCustomers {
+			continue
+		}
+
+		for _, product := range catalog.products {
+			stock := catalog.stock(product.ID, warehouse.ID)
+			if stock.Available > product.LowStockThreshold {
+				continue
+			}
+			alerts = append(alerts, StockAlert{
+				ProductID:      product.ID,
+				SKU:            product.SKU,
+				WarehouseID:    warehouse.ID,
+				WarehouseName:  warehouse.Name,
+				Available:      stock.Available,
+				Threshold:      product.LowStockThreshold,
+				RainyCustomers: rainyCustomers,
+			})
+		}
+	}
+	return alerts
+}
+
+// stock returns the stock of the product at the warehouse. The caller must hold the lock
+func (catalog *Catalog) stock(productID, warehouseID string) Stock {
+	onHand := catalog.onHand[stockKey{product: productID, warehouse: warehouseID}]
+	reserved := catalog.reserved(productID, warehouseID)
+	return Stock{ProductID: productID, WarehouseID: warehouseID, OnHand: onHand, Reserved: reserved, Available: onHand - reserved}
+}
+
+// reserved returns the quantity of the product reserved at the warehouse. The caller must hold the lock
+func (catalog *Catalog) reserved(productID, warehouseID string) int {
+	reserved := 0
+	for _, reservation := range catalog.reservations {
+		if reservation.ProductID == productID && reservation.WarehouseID == warehouseID {
+			reserved += reservation.Quantity
+		}
+	}
+	return reserved
+}
+
+// checkStockKey verifies that the product and warehouse exist. The caller must hold the lock
+func (catalog *Catalog) checkStockKey(productID, warehouseID string) error {
+	if catalog.productIndex(productID) < 0 {
+		return ErrNotFound{Kind: "product", ID: productID}
+	}
+	if catalog.warehouseIndex(warehouseID) < 0 {
+		return ErrNotFound{Kind: "warehouse", ID: warehouseID}
+	}
+	return nil
+}
+
+// checkSKU verifies that no other product has the product's SKU. The caller must hold the lock
+func (catalog *Catalog) checkSKU(product Product) error {
+	for _, existing := range catalog.products {
+		if existing.SKU == product.SKU && existing.ID != product.ID {
+			return ErrConflict(fmt.Sprintf("An existing product has the sku %s", product.SKU))
+		}
+	}
+	return nil
+}
+
+// productIndex returns the position of the product with the specified id, or -1. The caller must hold the lock
+func (catalog *Catalog) productIndex(id string) int {
+	for i, product := range catalog.products {
+		if product.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// warehouseIndex returns the position of the warehouse with the specified id, or -1. The caller must hold the lock
+func (catalog *Catalog) warehouseIndex(id string) int {
+	for i, warehouse := range catalog.warehouses {
+		if warehouse.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ErrNotFound is returned when a product, warehouse or reservation with the specified id doesn't exist
+type ErrNotFound struct {
+	Kind string
+	ID   string
+}
+
+func (err ErrNotFound) Error() string {
+	return fmt.Sprintf("Failed to locate %s with id: %s", err.Kind, err.ID)
+}
+
+// ErrConflict is returned when a change conflicts with the catalog's existing products, warehouses or reservations
+type ErrConflict string
+
+func (err ErrConflict) Error() string {
+	return string(err)
+}
+
+// ErrInsufficientStock is returned when more of a product is reserved than is available at the warehouse
+type ErrInsufficientStock struct {
+	Stock     Stock
+	Requested int
+}
+
+func (err ErrInsufficientStock) Error() string {
+	return fmt.Sprintf("Only %d units of product %s are available at warehouse %s, %d were requested", err.Stock.Available,
+		err.Stock.ProductID, err.Stock.WarehouseID, err.Requested)
+}
@@ -0,0 +1,152 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeProduct(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      Product
+		expProduct Product
+		expErr     error
+	}{
+		{
+			name:   "Missing sku",
+			input:  Product{Name: "Compact", PriceTiers: []PriceTier{{MinQuantity: 1}}},
+			expErr: fmt.Errorf("Products require a sku and a name"),
+		},
+		{
+			name:   "No price tiers",
+			input:  Product{SKU: "UMB-1", Name: "Compact"},
+			expErr: fmt.Errorf("Products require at least one price tier"),
+		},
+		{
+			name:   "First tier above 1",
+			input:  Product{SKU: "UMB-1", Name: "Compact", PriceTiers: []PriceTier{{MinQuantity: 10}}},
+			expErr: fmt.Errorf("The first price tier must start at a quantity of 1"),
+		},
+		{
+			name:   "Duplicate tiers",
+			input:  Product{SKU: "UMB-1", Name: "Compact", PriceTiers: []PriceTier{{MinQuantity: 1}, {MinQuantity: 1}}},
+			expErr: fmt.Errorf("Price tiers must start at distinct quantities"),
+		},
+		{
+			name:   "Invalid currency",
+			input:  Product{SKU: "UMB-1", Name: "Compact", Currency: "dollars", PriceTiers: []PriceTier{{MinQuantity: 1}}},
+			expErr: fmt.Errorf("Currencies must be ISO-4217 codes: DOLLARS"),
+		},
+		{
+			name: "Normalized",
+			input: Product{SKU: " umb-1 ", Name: "Compact", Currency: "cad", PriceTiers: []PriceTier{
+				{MinQuantity: 100, UnitPriceCents: 900},
+				{MinQuantity: 1, UnitPriceCents: 1500},
+			}},
+			expProduct: Product{SKU: "UMB-1", Name: "Compact", Currency: "CAD", PriceTiers: []PriceTier{
+				{MinQuantity: 1, UnitPriceCents: 1500},
+				{MinQuantity: 100, UnitPriceCents: 900},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			product, err := test.input.Normalize()
+			assert.Equal(t, test.expErr, err)
+			if err == nil {
+				assert.Equal(t, test.expProduct, product)
+			}
+		})
+	}
+}
+
+func TestUnitPriceCents(t *testing.T) {
+	product := Product{PriceTiers: []PriceTier{{MinQuantity: 1, UnitPriceCents: 1500}, {MinQuantity: 10, UnitPriceCents: 1200}, {MinQuantity: 100, UnitPriceCents: 900}}}
+	assert.Equal(t, int64(1500), product.UnitPriceCents(9))
+	assert.Equal(t, int64(1200), product.UnitPriceCents(10))
+	assert.Equal(t, int64(900), product.UnitPriceCents(250))
+}
+
+func TestReservations(t *testing.T) {
+	catalog := New()
+	product, err := catalog.CreateProduct(Product{SKU: "umb-1", Name: "Compact", PriceTiers: []PriceTier{{MinQuantity: 1, UnitPriceCents: 1500}}})
+	assert.NoError(t, err)
+	_, err = catalog.CreateProduct(Product{SKU: "UMB-1", Name: "Duplicate", PriceTiers: []PriceTier{{MinQuantity: 1}}})
+	assert.Equal(t, ErrConflict("An existing product has the sku UMB-1"), err)
+	toronto, err := catalog.CreateWarehouse(Warehouse{Name: "Toronto", Countries: []string{"Canada"}})
+	assert.NoError(t, err)
+
+	_, err = catalog.Reserve(Reservation{ProductID: product.ID, WarehouseID: "missing", Quantity: 1})
+	assert.Equal(t, ErrNotFound{Kind: "warehouse", ID: "missing"}, err)
+	_, err = catalog.Reserve(Reservation{ProductID: product.ID, WarehouseID: toronto.ID, Quantity: 1})
+	assert.IsType(t, ErrInsufficientStock{}, err)
+
+	_, err = catalog.SetStock(product.ID, toronto.ID, 10)
+	assert.NoError(t, err)
+	reservation, err := catalog.Reserve(Reservation{ProductID: product.ID, WarehouseID: toronto.ID, Quantity: 7, Reference: "quote-1"})
+	assert.NoError(t, err)
+	_, err = catalog.Reserve(Reservation{ProductID: product.ID, WarehouseID: toronto.ID, Quantity: 4})
+	assert.EqualError(t, err, fmt.Sprintf("Only 3 units of product %s are available at warehouse %s, 4 were requested", product.ID, toronto.ID))
+
+	stock, err := catalog.Stock(product.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []Stock{{ProductID: product.ID, WarehouseID: toronto.ID, OnHand: 10, Reserved: 7, Available: 3}}, stock)
+
+	// Reserved stock can't be removed
+	_, err = catalog.SetStock(product.ID, toronto.ID, 5)
+	assert.IsType(t, ErrConflict(""), err)
+	assert.IsType(t, ErrConflict(""), catalog.DeleteProduct(product.ID))
+	assert.IsType(t, ErrConflict(""), catalog.DeleteWarehouse(toronto.ID))
+
+	released, err := catalog.Release(reservation.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, reservation, released)
+	_, err = catalog.Release(reservation.ID)
+	assert.Equal(t, ErrNotFound{Kind: "reservation", ID: reservation.ID}, err)
+
+	// Fulfilled reservations take their stock with them
+	reservation, err = catalog.Reserve(Reservation{ProductID: product.ID, WarehouseID: toronto.ID, Quantity: 6})
+	assert.NoError(t, err)
+	_, err = catalog.Fulfill(reservation.ID)
+	assert.NoError(t, err)
+	stock, _ = catalog.Stock(product.ID)
+	assert.Equal(t, []Stock{{ProductID: product.ID, WarehouseID: toronto.ID, OnHand: 4, Reserved: 0, Available: 4}}, stock)
+	_, err = catalog.Fulfill(reservation.ID)
+	assert.Equal(t, ErrNotFound{Kind: "reservation", ID: reservation.ID}, err)
+
+	assert.NoError(t, catalog.DeleteWarehouse(toronto.ID))
+	stock, _ = catalog.Stock(product.ID)
+	assert.Empty(t, stock)
+}
+
+func TestLowStock(t *testing.T) {
+	catalog := New()
+	compact, _ := catalog.CreateProduct(Product{SKU: "UMB-1", Name: "Compact", LowStockThreshold: 5, PriceTiers: []PriceTier{{MinQuantity: 1}}})
+	golf, _ := catalog.CreateProduct(Product{SKU: "UMB-2", Name: "Golf", LowStockThreshold: 5, PriceTiers: []PriceTier{{MinQuantity: 1}}})
+	canada, _ := catalog.CreateWarehouse(Warehouse{Name: "Canada", Countries: []string{"CA"}})
+	catalog.CreateWarehouse(Warehouse{Name: "London", Cities: []territory.City{{Name: "London", Country: "GB"}}})
+	catalog.SetStock(compact.ID, canada.ID, 5)
+	catalog.SetStock(golf.ID, canada.ID, 50)
+
+	rain := []models.Weather{{Date: time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC), Type: models.WeatherTypeRain}}
+	customers := models.Customers{
+		{ID: "1", Address: models.Address{City: "Toronto", CountryCode: "CA"}, WeatherDetails: rain},
+		{ID: "2", Address: models.Address{City: "Ottawa", CountryCode: "CA"}, WeatherDetails: rain},
+		{ID: "3", Address: models.Address{City: "Vancouver", CountryCode: "CA"}},
+		{ID: "4", Address: models.Address{City: "London", CountryCode: "GB"}, WeatherDetails: rain},
+	}
+
+	assert.Equal(t, []StockAlert{
+		{ProductID: compact.ID, SKU: "UMB-1", WarehouseID: canada.ID, WarehouseName: "Canada", Available: 5, Threshold: 5, RainyCustomers: 2},
+	}, catalog.LowStock(customers, 2))
+	// London is out of stock, but low stock is only alerted where rain is forecast for enough customers
+	assert.Len(t, catalog.LowStock(customers, 1), 3)
+	assert.Empty(t, catalog.LowStock(customers, 3))
+	assert.Empty(t, catalog.LowStock(customers[2:3], 0))
+}
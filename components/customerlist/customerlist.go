@@ -0,0 +1,261 @@
+// Package customerlist filters, sorts and paginates customer listings
+package customerlist
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"umbrellacorp/components/segment"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/models"
+)
+
+const (
+	// DefaultLimit is the page size used when the client doesn't specify a limit
+	DefaultLimit = 50
+	// MaxLimit caps the page size so that a single response stays reasonably small
+	MaxLimit = 500
+)
+
+// sortKey is the position of a customer within a sorted listing. ID breaks ties between customers with the same sort value so that
+// every customer has a unique position
+type sortKey struct {
+	Str string `json:"s,omitempty"`
+	Num int64  `json:"n,omitempty"`
+	ID  string `json:"id"`
+}
+
+func (key sortKey) compare(other sortKey) int {
+	switch {
+	case key.Num != other.Num:
+		if key.Num < other.Num {
+			return -1
+		}
+		return 1
+	case key.Str != other.Str:
+		return strings.Compare(key.Str, other.Str)
+	}
+	return strings.Compare(key.ID, other.ID)
+}
+
+// sortFields maps the supported sort query param values to fns that compute a customer's sortKey
+var sortFields = map[string]func(models.Customer) sortKey{
+	"name": func(customer models.Customer) sortKey {
+		return sortKey{Str: strings.ToLower(customer.Name), ID: customer.ID}
+	},
+	"created_at": func(customer models.Customer) sortKey {
+		return sortKey{Num: customer.CreatedAt.UnixNano(), ID: customer.ID}
+	},
+	"num_employees": func(customer models.Customer) sortKey {
+		return sortKey{Num: int64(customer.NumEmployees), ID: customer.ID}
+	},
+	"rain_days": func(customer models.Customer) sortKey {
+		return sortKey{Num: int64(customer.RainDays()), ID: customer.ID}
+	},
+}
+
+// Cursor is the position after which the next page of a listing starts. It's handed to clients as an opaque base64 string
+type Cursor struct {
+	Sort  string  `json:"sort"`
+	Desc  bool    `json:"desc"`
+	After sortKey `json:"after"`
+}
+
+func (cursor Cursor) encode() string {
+	buf, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeCursor(encoded string) (Cursor, error) {
+	var cursor Cursor
+	buf, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, fmt.Errorf("Invalid cursor")
+	}
+	if err = json.Unmarshal(buf, &cursor); err != nil {
+		return cursor, fmt.Errorf("Invalid cursor")
+	}
+	return cursor, nil
+}
+
+// Options are the filtering, sorting and pagination options of a customer listing
+type Options struct {
+	Limit          int
+	Sort           string
+	Desc           bool
+	Cursor         *Cursor
+	IncludeDeleted bool
+
+	Country       string
+	City          string
+	MinEmployees  int
+	HasRainWithin time.Duration
+	// Expressions are segment expressions that customers must match, e.g. from the where query param or a saved segment
+	Expressions []segment.Expression
+}
+
+// ParseOptions parses the query params of a customer listing. Errors describe the invalid query param. Supported query params:
+//   - limit: the page size, defaults to 50 and is capped at 500
+//   - cursor: the next_cursor value returned by the previous page
+//   - sort: one of name, created_at, num_employees or rain_days. Defaults to name
+//   - order: asc or desc, defaults to asc
+//   - country: matches the customer's country name or code, case insensitive
+//   - city: matches the customer's city, case insensitive
+//   - min_employees: minimum number of employees
+//   - has_rain_within: a duration such as 48h. Matches customers with rain forecast within the duration from the start of weatherforecaster.ForecastRange
+//   - where: a segment expression such as num_employees >= 50 and rain_hours_next(48h) > 6, see segment.Parse
+//   - include_deleted: true to include deleted customers that haven't been purged yet
+func ParseOptions(query url.Values) (Options, error) {
+	opts := Options{
+		Limit:   DefaultLimit,
+		Sort:    "name",
+		Country: query.Get("country"),
+		City:    query.Get("city"),
+	}
+
+	var err error
+	if limit := query.Get("limit"); limit != "" {
+		opts.Limit, err = strconv.Atoi(limit)
+		if err != nil || opts.Limit <= 0 {
+			return opts, fmt.Errorf("limit must be a positive integer")
+		}
+		if opts.Limit > MaxLimit {
+			opts.Limit = MaxLimit
+		}
+	}
+
+	if sortBy := query.Get("sort"); sortBy != "" {
+		if _, ok := sortFields[sortBy]; !ok {
+			return opts, fmt.Errorf("Unsupported sort: %s", sortBy)
+		}
+		opts.Sort = sortBy
+	}
+
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, fmt.Errorf("order must be asc or desc")
+	}
+
+	if encoded := query.Get("cursor"); encoded != "" {
+		cursor, err := decodeCursor(encoded)
+		if err != nil {
+			return opts, err
+		}
+		if cursor.Sort != opts.Sort || cursor.Desc != opts.Desc {
+			return opts, fmt.Errorf("cursor doesn't match the requested sort and order")
+		}
+		opts.Cursor = &cursor
+	}
+
+	if minEmployees := query.Get("min_employees"); minEmployees != "" {
+		opts.MinEmployees, err = strconv.Atoi(minEmployees)
+		if err != nil {
+			return opts, fmt.Errorf("min_employees must be an integer")
+		}
+	}
+
+	if hasRainWithin := query.Get("has_rain_within"); hasRainWithin != "" {
+		opts.HasRainWithin, err = time.ParseDuration(hasRainWithin)
+		if err != nil || opts.HasRainWithin <= 0 {
+			return opts, fmt.Errorf("has_rain_within must be a positive duration such as 48h")
+		}
+	}
+
+	if where := query.Get("where"); where != "" {
+		expression, err := segment.Parse(where)
+		if err != nil {
+			return opts, fmt.Errorf("Invalid where: %s", err.Error())
+		}
+		opts.Expressions = append(opts.Expressions, expression)
+	}
+
+	if includeDeleted := query.Get("include_deleted"); includeDeleted != "" {
+		opts.IncludeDeleted, err = strconv.ParseBool(includeDeleted)
+		if err != nil {
+			return opts, fmt.Errorf("include_deleted must be true or false")
+		}
+	}
+
+	return opts, nil
+}
+
+// matches returns true if the customer satisfies every filter in opts. Forecasts are only fetched for weatherforecaster.ForecastRange, so rain filters are
+// measured from its start rather than from now
+func (opts Options) matches(customer models.Customer, now time.Time) bool {
+	if opts.Country != "" && !strings.EqualFold(opts.Country, customer.Address.Country) && !strings.EqualFold(opts.Country, customer.Address.CountryCode) {
+		return false
+	}
+	if opts.City != "" && !strings.EqualFold(opts.City, customer.Address.City) {
+		return false
+	}
+	if customer.NumEmployees < opts.MinEmployees {
+		return false
+	}
+	if opts.HasRainWithin > 0 && !customer.HasRainWithin(weatherforecaster.ForecastRange().Start, opts.HasRainWithin) {
+		return false
+	}
+	for _, expression := range opts.Expressions {
+		if !expression.Matches(customer, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// List filters, sorts and paginates the customers based on opts. It returns the requested page, the cursor of the next page
+// (empty if this is the last page) and the total number of customers matching the filters
+func List(existingCustomers models.Customers, opts Options, now time.Time) (models.Customers, string, int) {
+	keyFn := sortFields[opts.Sort]
+
+	type keyedCustomer struct {
+		key      sortKey
+		customer models.Customer
+	}
+	var matched []keyedCustomer
+	for _, customer := range existingCustomers {
+		if opts.matches(customer, now) {
+			matched = append(matched, keyedCustomer{key: keyFn(customer), customer: customer})
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if opts.Desc {
+			return matched[i].key.compare(matched[j].key) > 0
+		}
+		return matched[i].key.compare(matched[j].key) < 0
+	})
+
+	start := 0
+	if opts.Cursor != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			if opts.Desc {
+				return matched[i].key.compare(opts.Cursor.After) < 0
+			}
+			return matched[i].key.compare(opts.Cursor.After) > 0
+		})
+	}
+
+	end := start + opts.Limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	page := models.Customers{}
+	for _, keyed := range matched[start:end] {
+		page = append(page, keyed.customer)
+	}
+
+	nextCursor := ""
+	if end < len(matched) {
+		nextCursor = Cursor{Sort: opts.Sort, Desc: opts.Desc, After: matched[end-1].key}.encode()
+	}
+	return page, nextCursor, len(matched)
+}
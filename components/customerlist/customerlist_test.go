@@ -0,0 +1,238 @@
+package customerlist
+
+import (
+	"net/url"
+	"testing"
+	"time"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    url.Values
+		expOpts  Options
+		expError string
+	}{
+		{
+			name:    "defaults",
+			query:   url.Values{},
+			expOpts: Options{Limit: DefaultLimit, Sort: "name"},
+		},
+		{
+			name: "all options",
+			query: url.Values{
+				"limit":           {"10"},
+				"sort":            {"num_employees"},
+				"order":           {"desc"},
+				"country":         {"CA"},
+				"city":            {"Toronto"},
+				"min_employees":   {"50"},
+				"has_rain_within": {"48h"},
+			},
+			expOpts: Options{
+				Limit:         10,
+				Sort:          "num_employees",
+				Desc:          true,
+				Country:       "CA",
+				City:          "Toronto",
+				MinEmployees:  50,
+				HasRainWithin: 48 * time.Hour,
+			},
+		},
+		{
+			name:    "include deleted",
+			query:   url.Values{"include_deleted": {"true"}},
+			expOpts: Options{Limit: DefaultLimit, Sort: "name", IncludeDeleted: true},
+		},
+		{
+			name:     "invalid include deleted",
+			query:    url.Values{"include_deleted": {"maybe"}},
+			expError: "include_deleted must be true or false",
+		},
+		{
+			name:    "limit is capped",
+			query:   url.Values{"limit": {"100000"}},
+			expOpts: Options{Limit: MaxLimit, Sort: "name"},
+		},
+		{
+			name:     "invalid limit",
+			query:    url.Values{"limit": {"ten"}},
+			expError: "limit must be a positive integer",
+		},
+		{
+			name:     "unsupported sort",
+			query:    url.Values{"sort": {"contact"}},
+			expError: "Unsupported sort: contact",
+		},
+		{
+			name:     "invalid order",
+			query:    url.Values{"order": {"sideways"}},
+			expError: "order must be asc or desc",
+		},
+		{
+			name:     "invalid cursor",
+			query:    url.Values{"cursor": {"not a cursor"}},
+			expError: "Invalid cursor",
+		},
+		{
+			name:     "cursor from a different sort",
+			query:    url.Values{"sort": {"rain_days"}, "cursor": {Cursor{Sort: "name"}.encode()}},
+			expError: "cursor doesn't match the requested sort and order",
+		},
+		{
+			name:     "invalid has_rain_within",
+			query:    url.Values{"has_rain_within": {"soon"}},
+			expError: "has_rain_within must be a positive duration such as 48h",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts, recErr := ParseOptions(test.query)
+			if test.expError != "" {
+				assert.EqualError(t, recErr, test.expError)
+				return
+			}
+			assert.NoError(t, recErr)
+			assert.Equal(t, test.expOpts, opts)
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	rainAt := func(offsets ...time.Duration) []models.Weather {
+		var weather []models.Weather
+		for _, offset := range offsets {
+			weather = append(weather, models.Weather{Date: now.Add(offset), Type: models.WeatherTypeRain})
+		}
+		return weather
+	}
+
+	existingCustomers := models.Customers{
+		{
+			ID:             "1",
+			Name:           "Umbrella Depot",
+			NumEmployees:   20,
+			Address:        models.Address{City: "Toronto", Country: "Canada", CountryCode: "CA"},
+			WeatherDetails: rainAt(time.Hour, 3*time.Hour),
+			CreatedAt:      now.Add(-3 * time.Hour),
+		},
+		{
+			ID:             "2",
+			Name:           "acme",
+			NumEmployees:   500,
+			Address:        models.Address{City: "Chicago", Country: "US", CountryCode: "US"},
+			WeatherDetails: rainAt(72*time.Hour, 96*time.Hour),
+			CreatedAt:      now.Add(-1 * time.Hour),
+		},
+		{
+			ID:           "3",
+			Name:         "Bravo Inc",
+			NumEmployees: 100,
+			Address:      models.Address{City: "toronto", Country: "CA", CountryCode: "CA"},
+			CreatedAt:    now.Add(-2 * time.Hour),
+		},
+	}
+
+	ids := func(customers models.Customers) []string {
+		var result []string
+		for _, customer := range customers {
+			result = append(result, customer.ID)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expIDs   []string
+		expTotal int
+	}{
+		{
+			name:     "sort by name is case insensitive",
+			opts:     Options{Limit: 10, Sort: "name"},
+			expIDs:   []string{"2", "3", "1"},
+			expTotal: 3,
+		},
+		{
+			name:     "sort by created date descending",
+			opts:     Options{Limit: 10, Sort: "created_at", Desc: true},
+			expIDs:   []string{"2", "3", "1"},
+			expTotal: 3,
+		},
+		{
+			name:     "sort by rain days",
+			opts:     Options{Limit: 10, Sort: "rain_days", Desc: true},
+			expIDs:   []string{"2", "1", "3"},
+			expTotal: 3,
+		},
+		{
+			name:     "filter by country and city",
+			opts:     Options{Limit: 10, Sort: "num_employees", Country: "ca", City: "TORONTO"},
+			expIDs:   []string{"1", "3"},
+			expTotal: 2,
+		},
+		{
+			name:     "filter by min employees",
+			opts:     Options{Limit: 10, Sort: "name", MinEmployees: 100},
+			expIDs:   []string{"2", "3"},
+			expTotal: 2,
+		},
+		{
+			name:     "filter by rain within 48h",
+			opts:     Options{Limit: 10, Sort: "name", HasRainWithin: 48 * time.Hour},
+			expIDs:   []string{"1"},
+			expTotal: 1,
+		},
+		{
+			name:     "nothing matches",
+			opts:     Options{Limit: 10, Sort: "name", Country: "FR"},
+			expIDs:   nil,
+			expTotal: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, nextCursor, total := List(existingCustomers, test.opts, now)
+			assert.Equal(t, test.expIDs, ids(page))
+			assert.Equal(t, test.expTotal, total)
+			assert.Empty(t, nextCursor)
+		})
+	}
+
+	t.Run("rain filters don't depend on the clock", func(t *testing.T) {
+		page, _, _ := List(existingCustomers, Options{Limit: 10, Sort: "name", HasRainWithin: 48 * time.Hour}, time.Now())
+		assert.Equal(t, []string{"1"}, ids(page))
+	})
+
+	t.Run("paginate with cursor", func(t *testing.T) {
+		for _, desc := range []bool{false, true} {
+			opts := Options{Limit: 2, Sort: "num_employees", Desc: desc}
+
+			var pages [][]string
+			for {
+				page, nextCursor, total := List(existingCustomers, opts, now)
+				assert.Equal(t, 3, total)
+				pages = append(pages, ids(page))
+				if nextCursor == "" {
+					break
+				}
+
+				cursor, err := decodeCursor(nextCursor)
+				assert.NoError(t, err)
+				opts.Cursor = &cursor
+			}
+
+			if desc {
+				assert.Equal(t, [][]string{{"2", "3"}, {"1"}}, pages)
+			} else {
+				assert.Equal(t, [][]string{{"1", "3"}, {"2"}}, pages)
+			}
+		}
+	})
+}
@@ -0,0 +1,276 @@
+package customerstore
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+	"umbrellacorp/models"
+)
+
+// Check verifies that a customer can be saved given the existing customers in the store, e.g. to enforce uniqueness rules. Deleted
+// customers aren't passed to checks. Checks run while the store is locked so that concurrent requests can't both pass the same check
+type Check func(existingCustomers models.Customers, customer models.Customer) error
+
+// Listener is notified after every mutation of the store. before is nil when a customer is created or restored and after is nil when a
+// customer is deleted. Listeners are called synchronously while the store is locked, so that they observe mutations in the order they
+// were applied, e.g. to keep an index in sync with the store. Listeners must not call back into the store
+type Listener func(before, after *models.Customer)
+
+// Store is an in-memory customer store that is safe for concurrent use. Deleting a customer leaves a tombstone, so that the customer can
+// be restored until it's purged. Deleted customers are excluded from every method unless documented otherwise
+type Store struct {
+	mu sync.RWMutex
+	// customers contains both active and deleted customers, in the order they were created
+	customers models.Customers
+	listeners []Listener
+}
+
+// New returns a Store containing the specified customers
+func New(customers ...models.Customer) *Store {
+	return &Store{customers: append(models.Customers{}, customers...)}
+}
+
+// Subscribe registers a Listener to be notified of every subsequent mutation of the store
+func (store *Store) Subscribe(listener Listener) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.listeners = append(store.listeners, listener)
+}
+
+// List returns a copy of every active customer in the store, in the order they were created
+func (store *Store) List() models.Customers {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.active()
+}
+
+// ListDeleted returns a copy of every deleted customer that hasn't been purged yet, in the order they were created
+func (store *Store) ListDeleted() models.Customers {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	deleted := models.Customers{}
+	for _, customer := range store.customers {
+		if customer.DeletedAt != nil {
+			deleted = append(deleted, customer)
+		}
+	}
+	return deleted
+}
+
+// All returns a copy of every customer in the store, including deleted customers. The result can be passed to Restore
+func (store *Store) All() models.Customers {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return append(models.Customers{}, store.customers...)
+}
+
+// Get returns the active customer with the specified id
+func (store *Store) Get(id string) (models.Customer, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if i := store.indexOf(id); i >= 0 && store.customers[i].DeletedAt == nil {
+		return store.customers[i], true
+	}
+	return models.Customer{}, false
+}
+
+// Create adds a customer to the store after verifying it against the checks. The customer must already have an ID that isn't used by
+// another customer, including deleted customers
+func (store *Store) Create(customer models.Customer, checks ...Check) (models.Customer, error) {
+	store.mu.Lock()
+	if store.indexOf(customer.ID) >= 0 {
+		store.mu.Unlock()
+		return customer, fmt.Errorf("A customer with id: %s already exists", customer.ID)
+	}
+	if err := runChecks(store.active(), customer, checks); err != nil {
+		store.mu.Unlock()
+		return customer, err
+	}
+	customer.DeletedAt = nil
+	customer.DeletedBy = ""
+	store.customers = append(store.customers, customer)
+	store.notify(nil, &customer)
+	store.mu.Unlock()
+	return customer, nil
+}
+
+// Update replaces the active customer with the same ID after verifying it against the checks. The existing customer is excluded from
+// the customers passed to the checks. The customer's CreatedAt is preserved
+func (store *Store) Update(customer models.Customer, checks ...Check) (models.Customer, error) {
+	return store.Modify(customer.ID, func(existing models.Customer) (models.Customer, error) {
+		return customer, runChecks(store.activeExcept(customer.ID), customer, checks)
+	})
+}
+
+// Modify applies fn to the active customer with the specified id while the store is locked, so that reading and writing the customer
+// can't interleave with concurrent updates of the same customer. The customer's ID, CreatedAt and deletion details are preserved
+func (store *Store) Modify(id string, fn func(customer models.Customer) (models.Customer, error)) (models.Customer, error) {
+	store.mu.Lock()
+	i := store.indexOf(id)
+	if i < 0 || store.customers[i].DeletedAt != nil {
+		store.mu.Unlock()
+		return models.Customer{}, ErrNotFound(id)
+	}
+
+	before := store.customers[i]
+	customer, err := fn(before)
+	if err != nil {
+		store.mu.Unlock()
+		return before, err
+	}
+	customer.ID = before.ID
+	customer.CreatedAt = before.CreatedAt
+	customer.DeletedAt = nil
+	customer.DeletedBy = ""
+	store.customers[i] = customer
+	store.notify(&before, &customer)
+	store.mu.Unlock()
+	return customer, nil
+}
+
+// Delete marks the active customer with the specified id as deleted by deletedBy at the specified time, and returns the deleted customer
+func (store *Store) Delete(id, deletedBy string, at time.Time) (models.Customer, error) {
+	store.mu.Lock()
+	i := store.indexOf(id)
+	if i < 0 || store.customers[i].DeletedAt != nil {
+		store.mu.Unlock()
+		return models.Customer{}, ErrNotFound(id)
+	}
+
+	before := store.customers[i]
+	store.customers[i].DeletedAt = &at
+	store.customers[i].DeletedBy = deletedBy
+	deleted := store.customers[i]
+	store.notify(&before, nil)
+	store.mu.Unlock()
+	return deleted, nil
+}
+
+// Undelete restores a deleted customer after verifying it against the checks, e.g. to ensure an active customer with the same name
+// wasn't created since it was deleted
+func (store *Store) Undelete(id string, checks ...Check) (models.Customer, error) {
+	store.mu.Lock()
+	i := store.indexOf(id)
+	if i < 0 || store.customers[i].DeletedAt == nil {
+		store.mu.Unlock()
+		return models.Customer{}, ErrNotFound(id)
+	}
+
+	customer := store.customers[i]
+	customer.DeletedAt = nil
+	customer.DeletedBy = ""
+	if err := runChecks(store.active(), customer, checks); err != nil {
+		store.mu.Unlock()
+		return customer, err
+	}
+	store.customers[i] = customer
+	store.notify(nil, &customer)
+	store.mu.Unlock()
+	return customer, nil
+}
+
+// Purge permanently removes customers that were deleted before the cutoff, and returns them
+func (store *Store) Purge(cutoff time.Time) models.Customers {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	purged := models.Customers{}
+	remaining := store.customers[:0]
+	for _, customer := range store.customers {
+		if customer.DeletedAt != nil && customer.DeletedAt.Before(cutoff) {
+			purged = append(purged, customer)
+			continue
+		}
+		remaining = append(remaining, customer)
+	}
+	store.customers = remaining
+	return purged
+}
+
+// Restore replaces the contents of the store with the specified customers, e.g. a previous result of All. Listeners are notified of
+// every active customer that was created, updated or deleted by the restore
+func (store *Store) Restore(customers models.Customers) {
+	store.mu.Lock()
+	previous := map[string]models.Customer{}
+	for _, customer := range store.active() {
+		previous[customer.ID] = customer
+	}
+	store.customers = append(models.Customers{}, customers...)
+	restored := store.active()
+	defer store.mu.Unlock()
+
+	restoredIDs := map[string]bool{}
+	for _, customer := range restored {
+		restoredIDs[customer.ID] = true
+	}
+
+	for _, customer := range previous {
+		if !restoredIDs[customer.ID] {
+			before := customer
+			store.notify(&before, nil)
+		}
+	}
+
+	for _, customer := range restored {
+		after := customer
+		before, existed := previous[customer.ID]
+		if !existed {
+			store.notify(nil, &after)
+		} else if !reflect.DeepEqual(before, after) {
+			store.notify(&before, &after)
+		}
+	}
+}
+
+// ErrNotFound is returned when an active customer with the specified id isn't in the store, or when restoring a customer that isn't
+// deleted
+type ErrNotFound string
+
+func (id ErrNotFound) Error() string {
+	return fmt.Sprintf("Failed to locate existing customer with id: %s", string(id))
+}
+
+func runChecks(existingCustomers models.Customers, customer models.Customer, checks []Check) error {
+	for _, check := range checks {
+		if err := check(existingCustomers, customer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexOf returns the position of the customer with the specified id, or -1. The caller must hold the lock
+func (store *Store) indexOf(id string) int {
+	for i, customer := range store.customers {
+		if customer.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// active returns a copy of the customers that aren't deleted. The caller must hold the lock
+func (store *Store) active() models.Customers {
+	return store.activeExcept("")
+}
+
+// activeExcept returns a copy of the customers that aren't deleted, excluding the customer with the specified id. The caller must hold
+// the lock
+func (store *Store) activeExcept(id string) models.Customers {
+	active := models.Customers{}
+	for _, customer := range store.customers {
+		if customer.DeletedAt == nil && (id == "" || customer.ID != id) {
+			active = append(active, customer)
+		}
+	}
+	return active
+}
+
+// notify calls every listener. The caller must hold the lock
+func (store *Store) notify(before, after *models.Customer) {
+	for _, listener := range store.listeners {
+		listener(before, after)
+	}
+}
@@ -0,0 +1,317 @@
+package customerstore
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	createdAt := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	original := models.Customer{ID: "1", Name: "Awesome Company", CreatedAt: createdAt}
+	store := New(original)
+
+	type mutation struct {
+		before, after *models.Customer
+	}
+	var mutations []mutation
+	store.Subscribe(func(before, after *models.Customer) {
+		mutations = append(mutations, mutation{before, after})
+	})
+
+	uniqueName := func(existingCustomers models.Customers, customer models.Customer) error {
+		for _, existing := range existingCustomers {
+			if existing.Name == customer.Name {
+				return fmt.Errorf("duplicate name")
+			}
+		}
+		return nil
+	}
+
+	_, err := store.Create(models.Customer{ID: "2", Name: "Awesome Company"}, uniqueName)
+	assert.Equal(t, fmt.Errorf("duplicate name"), err)
+
+	_, err = store.Create(models.Customer{ID: "1", Name: "Other Company"})
+	assert.Equal(t, fmt.Errorf("A customer with id: 1 already exists"), err)
+
+	created, err := store.Create(models.Customer{ID: "2", Name: "Fortune 500 Company"}, uniqueName)
+	assert.NoError(t, err)
+
+	// The customer being updated isn't compared against itself
+	updated, err := store.Update(models.Customer{ID: "1", Name: "Awesome Company", NumEmployees: 10}, uniqueName)
+	assert.NoError(t, err)
+	assert.Equal(t, createdAt, updated.CreatedAt)
+
+	_, err = store.Update(models.Customer{ID: "3"})
+	assert.Equal(t, ErrNotFound("3"), err)
+
+	customer, ok := store.Get("1")
+	assert.True(t, ok)
+	assert.Equal(t, 10, customer.NumEmployees)
+
+	deletedAt := createdAt.Add(time.Hour)
+	deleted, err := store.Delete("1", "rep@umbrellacorp.com", deletedAt)
+	assert.NoError(t, err)
+	assert.Equal(t, &deletedAt, deleted.DeletedAt)
+	assert.Equal(t, "rep@umbrellacorp.com", deleted.DeletedBy)
+	_, err = store.Delete("1", "rep@umbrellacorp.com", deletedAt)
+	assert.Equal(t, ErrNotFound("1"), err)
+	_, ok = store.Get("1")
+	assert.False(t, ok)
+
+	assert.Equal(t, models.Customers{created}, store.List())
+	assert.Equal(t, models.Customers{deleted}, store.ListDeleted())
+	assert.Equal(t, []mutation{
+		{nil, &created},
+		{&original, &updated},
+		{&updated, nil},
+	}, mutations)
+}
+
+func TestStoreConcurrentCreates(t *testing.T) {
+	store := New()
+	unique := func(existingCustomers models.Customers, customer models.Customer) error {
+		if len(existingCustomers) > 0 {
+			return fmt.Errorf("duplicate")
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store.Create(models.Customer{ID: fmt.Sprint(i)}, unique)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, store.List(), 1)
+}
+
+func TestListenersObserveMutationsInOrder(t *testing.T) {
+	store := New(models.Customer{ID: "1"})
+	// The listener is called while the store is locked, so it doesn't need a lock of its own
+	var last models.Customer
+	store.Subscribe(func(before, after *models.Customer) {
+		last = *after
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store.Update(models.Customer{ID: "1", Name: fmt.Sprint(i)})
+		}(i)
+	}
+	wg.Wait()
+
+	customer, _ := store.Get("1")
+	assert.Equal(t, customer, last)
+}
+
+func TestModify(t *testing.T) {
+	createdAt := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	store := New(models.Customer{ID: "1", NumEmployees: 10, CreatedAt: createdAt})
+
+	modified, err := store.Modify("1", func(customer models.Customer) (models.Customer, error) {
+		customer.ID = "2"
+		customer.CreatedAt = time.Time{}
+		customer.NumEmployees++
+		return customer, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, models.Customer{ID: "1", NumEmployees: 11, CreatedAt: createdAt}, modified)
+
+	_, err = store.Modify("1", func(customer models.Customer) (models.Customer, error) {
+		return customer, fmt.Errorf("abort")
+	})
+	assert.Equal(t, fmt.Errorf("abort"), err)
+
+	_, err = store.Modify("2", func(customer models.Customer) (models.Customer, error) {
+		return customer, nil
+	})
+	assert.Equal(t, ErrNotFound("2"), err)
+
+	assert.Equal(t, models.Customers{modified}, store.List())
+}
+
+func TestRestore(t *testing.T) {
+	snapshot := models.Customers{{ID: "1", Name: "Awesome Company"}, {ID: "2", Name: "Fortune 500 Company"}}
+	store := New(snapshot...)
+
+	store.Update(models.Customer{ID: "1", Name: "Renamed Company"})
+	store.Delete("2", "rep@umbrellacorp.com", time.Now())
+	store.Create(models.Customer{ID: "3", Name: "New Company"})
+
+	var notifications []string
+	store.Subscribe(func(before, after *models.Customer) {
+		switch {
+		case before == nil:
+			notifications = append(notifications, "created "+after.Name)
+		case after == nil:
+			notifications = append(notifications, "deleted "+before.Name)
+		default:
+			notifications = append(notifications, "updated "+before.Name+" to "+after.Name)
+		}
+	})
+
+	store.Restore(snapshot)
+	assert.Equal(t, snapshot, store.List())
+	assert.Equal(t, []string{
+		"deleted New Company",
+		"updated Renamed Company to Awesome Company",
+		"created Fortune 500 Company",
+	}, notifications)
+}
+
+func TestSoftDelete(t *testing.T) {
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	store := New(models.Customer{ID: "1", Name: "Awesome Company"}, models.Customer{ID: "2", Name: "Fortune 500 Company"})
+	uniqueName := func(existingCustomers models.Customers, customer models.Customer) error {
+		for _, existing := range existingCustomers {
+			if existing.Name == customer.Name {
+				return fmt.Errorf("duplicate name")
+			}
+		}
+		return nil
+	}
+
+	var mutations []string
+	store.Subscribe(func(before, after *models.Customer) {
+		switch {
+		case before == nil:
+			mutations = append(mutations, "created "+after.ID)
+		case after == nil:
+			mutations = append(mutations, "deleted "+before.ID)
+		}
+	})
+
+	_, err := store.Delete("1", "rep", now.Add(-48*time.Hour))
+	assert.NoError(t, err)
+	_, err = store.Delete("2", "rep", now.Add(-time.Hour))
+	assert.NoError(t, err)
+
+	// Deleted customers can't be modified, and don't count towards uniqueness
+	_, err = store.Update(models.Customer{ID: "1", Name: "Renamed"})
+	assert.Equal(t, ErrNotFound("1"), err)
+	_, err = store.Create(models.Customer{ID: "1", Name: "Reused ID"})
+	assert.Equal(t, fmt.Errorf("A customer with id: 1 already exists"), err)
+	_, err = store.Create(models.Customer{ID: "3", Name: "Fortune 500 Company"}, uniqueName)
+	assert.NoError(t, err)
+
+	// Restoring fails if the customer's name was taken while it was deleted
+	_, err = store.Undelete("2", uniqueName)
+	assert.Equal(t, fmt.Errorf("duplicate name"), err)
+	_, err = store.Undelete("3", uniqueName)
+	assert.Equal(t, ErrNotFound("3"), err)
+
+	restored, err := store.Undelete("1", uniqueName)
+	assert.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+	assert.Empty(t, restored.DeletedBy)
+
+	// Only customers deleted before the cutoff are purged
+	purged := store.Purge(now.Add(-24 * time.Hour))
+	assert.Empty(t, purged)
+	store.Delete("1", "rep", now.Add(-48*time.Hour))
+	purged = store.Purge(now.Add(-24 * time.Hour))
+	assert.Len(t, purged, 1)
+	assert.Equal(t, "1", purged[0].ID)
+
+	_, err = store.Undelete("1")
+	assert.Equal(t, ErrNotFound("1"), err)
+	assert.Len(t, store.ListDeleted(), 1)
+	assert.Len(t, store.All(), 2)
+
+	assert.Equal(t, []string{"deleted 1", "deleted 2", "created 3", "created 1", "deleted 1"}, mutations)
+}
+
+func TestView(t *testing.T) {
+	toronto := models.Customer{ID: "1", Name: "Toronto Company", Address: models.Address{City: "Toronto", CountryCode: "CA"}}
+	chicago := models.Customer{ID: "2", Name: "Chicago Company", Address: models.Address{City: "Chicago", CountryCode: "US"}}
+	store := New(toronto, chicago)
+	view := store.In(func(customer models.Customer) bool {
+		return customer.Address.CountryCode == "CA"
+	})
+
+	assert.Equal(t, models.Customers{toronto}, view.List())
+	assert.Equal(t, models.Customers{toronto, chicago}, store.In(nil).List())
+
+	_, ok := view.Get("2")
+	assert.False(t, ok)
+	customer, ok := view.Get("1")
+	assert.True(t, ok)
+	assert.Equal(t, toronto, customer)
+
+	// Customers outside of the scope can't be modified, created or moved outside of the scope
+	rename := func(customer models.Customer) (models.Customer, error) {
+		customer.Name = "Renamed Company"
+		return customer, nil
+	}
+	customer, err := view.Modify("2", rename)
+	assert.Equal(t, ErrNotFound("2"), err)
+	assert.Equal(t, models.Customer{}, customer)
+
+	_, err = view.Modify("1", func(customer models.Customer) (models.Customer, error) {
+		customer.Address = chicago.Address
+		return customer, nil
+	})
+	assert.Equal(t, ErrOutOfScope("1"), err)
+
+	_, err = view.Create(models.Customer{ID: "3", Address: chicago.Address})
+	assert.Equal(t, ErrOutOfScope("3"), err)
+
+	customer, err = view.Modify("1", rename)
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed Company", customer.Name)
+
+	// Deleted customers within the scope are still included by All
+	_, err = store.Delete("1", "admin", time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, view.All(), 1)
+	assert.Empty(t, view.List())
+}
+
+func TestTenants(t *testing.T) {
+	created := []string{}
+	tenants := NewTenants(func(tenant string) *Store {
+		created = append(created, tenant)
+		return New()
+	})
+
+	assert.Equal(t, tenants.Get(""), tenants.Get(models.DefaultTenant))
+	acme := tenants.Get("acme")
+	_, err := acme.Create(models.Customer{ID: "1", Name: "Awesome Company"})
+	assert.NoError(t, err)
+
+	_, ok := tenants.Get(models.DefaultTenant).Get("1")
+	assert.False(t, ok)
+	_, ok = tenants.Get("acme").Get("1")
+	assert.True(t, ok)
+	assert.Equal(t, []string{models.DefaultTenant, "acme"}, created)
+	assert.Len(t, tenants.All(), 2)
+}
+
+func TestTenantListeners(t *testing.T) {
+	tenants := NewTenants(func(string) *Store { return New() })
+	_, err := tenants.Get("acme").Create(models.Customer{ID: "1", Name: "Awesome Company"})
+	assert.NoError(t, err)
+
+	// Listeners observe the stores that already exist and those created later
+	changed := []string{}
+	tenants.Subscribe(func(tenant string, before, after *models.Customer) {
+		changed = append(changed, tenant+"/"+after.ID)
+	})
+	_, err = tenants.Get("acme").Create(models.Customer{ID: "2", Name: "Other Company"})
+	assert.NoError(t, err)
+	_, err = tenants.Get("globex").Create(models.Customer{ID: "3", Name: "Globex"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"acme/2", "globex/3"}, changed)
+}
@@ -0,0 +1,72 @@
+package customerstore
+
+import (
+	"sync"
+	"umbrellacorp/models"
+)
+
+// Tenants partitions customers into a separate Store per tenant, so that no query can cross tenants. It's safe for concurrent use
+type Tenants struct {
+	mu        sync.Mutex
+	stores    map[string]*Store
+	newStore  func(tenant string) *Store
+	listeners []TenantListener
+}
+
+// TenantListener is notified of every change to the customers of a tenant's store, see Listener
+type TenantListener func(tenant string, before, after *models.Customer)
+
+// Default holds the customer stores shared by the application's handlers
+var Default = NewTenants(func(string) *Store { return New() })
+
+// NewTenants returns Tenants whose stores are created by newStore when each tenant is first accessed, e.g. to subscribe listeners to the
+// tenant's store
+func NewTenants(newStore func(tenant string) *Store) *Tenants {
+	return &Tenants{stores: map[string]*Store{}, newStore: newStore}
+}
+
+// Get returns the store of the tenant. The empty tenant is the default tenant
+func (tenants *Tenants) Get(tenant string) *Store {
+	if tenant == "" {
+		tenant = models.DefaultTenant
+	}
+
+	tenants.mu.Lock()
+	defer tenants.mu.Unlock()
+	store, ok := tenants.stores[tenant]
+	if !ok {
+		store = tenants.newStore(tenant)
+		for _, listener := range tenants.listeners {
+			subscribeTenant(store, tenant, listener)
+		}
+		tenants.stores[tenant] = store
+	}
+	return store
+}
+
+// All returns the store of every tenant that was accessed, by tenant
+func (tenants *Tenants) All() map[string]*Store {
+	tenants.mu.Lock()
+	defer tenants.mu.Unlock()
+	stores := make(map[string]*Store, len(tenants.stores))
+	for tenant, store := range tenants.stores {
+		stores[tenant] = store
+	}
+	return stores
+}
+
+// Subscribe registers the listener with the store of every tenant, including the stores of tenants that are first accessed later
+func (tenants *Tenants) Subscribe(listener TenantListener) {
+	tenants.mu.Lock()
+	defer tenants.mu.Unlock()
+	tenants.listeners = append(tenants.listeners, listener)
+	for tenant, store := range tenants.stores {
+		subscribeTenant(store, tenant, listener)
+	}
+}
+
+func subscribeTenant(store *Store, tenant string, listener TenantListener) {
+	store.Subscribe(func(before, after *models.Customer) {
+		listener(tenant, before, after)
+	})
+}
@@ -0,0 +1,98 @@
+package customerstore
+
+import (
+	"fmt"
+	"umbrellacorp/models"
+)
+
+// Scope restricts the customers accessible through a View, e.g. to the territories of a sales rep. It returns true if the customer is
+// within the scope
+type Scope func(customer models.Customer) bool
+
+// View is a Store restricted to the customers within a Scope. Customers outside of the scope are treated as if they didn't exist, and
+// customers can't be created or moved outside of the scope. A View with a nil Scope can access every customer
+type View struct {
+	store *Store
+	scope Scope
+}
+
+// In returns a View of the store restricted to the customers within the scope
+func (store *Store) In(scope Scope) View {
+	return View{store: store, scope: scope}
+}
+
+// Contains returns true if the customer is within the view's scope
+func (view View) Contains(customer models.Customer) bool {
+	return view.scope == nil || view.scope(customer)
+}
+
+// List returns a copy of every active customer within the scope, in the order they were created
+func (view View) List() models.Customers {
+	return view.filter(view.store.List())
+}
+
+// All returns a copy of every customer within the scope, including deleted customers
+func (view View) All() models.Customers {
+	return view.filter(view.store.All())
+}
+
+// Get returns the active customer with the specified id if it's within the scope
+func (view View) Get(id string) (models.Customer, bool) {
+	customer, ok := view.store.Get(id)
+	if !ok || !view.Contains(customer) {
+		return models.Customer{}, false
+	}
+	return customer, true
+}
+
+// Create adds a customer to the store, see Store.Create. ErrOutOfScope is returned if the customer isn't within the scope. Checks are
+// passed every active customer, including those outside of the scope, so that uniqueness rules apply across the whole store
+func (view View) Create(customer models.Customer, checks ...Check) (models.Customer, error) {
+	if !view.Contains(customer) {
+		return customer, ErrOutOfScope(customer.ID)
+	}
+	return view.store.Create(customer, checks...)
+}
+
+// Modify applies fn to the active customer with the specified id, see Store.Modify. ErrNotFound is returned if the existing customer
+// isn't within the scope, and ErrOutOfScope if fn moves the customer outside of the scope
+func (view View) Modify(id string, fn func(customer models.Customer) (models.Customer, error)) (models.Customer, error) {
+	customer, err := view.store.Modify(id, func(existing models.Customer) (models.Customer, error) {
+		if !view.Contains(existing) {
+			return existing, ErrNotFound(id)
+		}
+		customer, err := fn(existing)
+		if err != nil {
+			return customer, err
+		}
+		if !view.Contains(customer) {
+			return customer, ErrOutOfScope(id)
+		}
+		return customer, nil
+	})
+	if _, ok := err.(ErrNotFound); ok {
+		// Don't leak the existing customer when it's outside of the scope
+		return models.Customer{}, err
+	}
+	return customer, err
+}
+
+func (view View) filter(customers models.Customers) models.Customers {
+	if view.scope == nil {
+		return customers
+	}
+	filtered := models.Customers{}
+	for _, customer := range customers {
+		if view.scope(customer) {
+			filtered = append(filtered, customer)
+		}
+	}
+	return filtered
+}
+
+// ErrOutOfScope is returned when a View is used to create a customer outside of its scope, or to move a customer outside of it
+type ErrOutOfScope string
+
+func (id ErrOutOfScope) Error() string {
+	return fmt.Sprintf("The customer with id: %s would be outside of the permitted scope", string(id))
+}
@@ -0,0 +1,253 @@
+package demand
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"umbrellacorp/components/sales"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+	"unicode"
+)
+
+// minDecidedQuotes is the number of decided quotes a conversion rate must be computed from to be used, so that a couple of quotes don't
+// swing the forecast of a whole country
+const minDecidedQuotes = 5
+
+// ConversionRates are the shares of quoted umbrellas that customers ordered, which the demand of their employees is weighted by
+type ConversionRates struct {
+	// Default is the rate used when there aren't enough decided quotes to compute a rate from
+	Default float64 `json:"default"`
+	// Overall is the rate across every country, if there were enough decided quotes to compute it from
+	Overall *float64 `json:"overall,omitempty"`
+	// Countries are the rates of each country code with enough decided quotes to compute them from
+	Countries map[string]float64 `json:"countries"`
+}
+
+// For returns the conversion rate of the country, falling back to the overall rate and then the default rate
+func (rates ConversionRates) For(countryCode string) float64 {
+	if rate, ok := rates.Countries[strings.ToUpper(countryCode)]; ok {
+		return rate
+	}
+	if rates.Overall != nil {
+		return *rates.Overall
+	}
+	return rates.Default
+}
+
+// conversions counts the quoted units that were decided, either ordered, cancelled or expired, and those that were ordered
+type conversions struct {
+	quotes  int
+	quoted  int
+	ordered int
+}
+
+func (counts conversions) rate() (float64, bool) {
+	if counts.quotes < minDecidedQuotes || counts.quoted == 0 {
+		return 0, false
+	}
+	return float64(counts.ordered) / float64(counts.quoted), true
+}
+
+// HistoricalRates computes the conversion rates of decided quotes, grouped by the country of the quoted customer. Quotes that are still
+// open at now are undecided, while quotes whose orders were cancelled count as not converted. customers must include deleted customers,
+// quotes of customers that aren't found only count towards the overall rate
+func HistoricalRates(quotes []sales.Quote, orders []sales.Order, customers models.Customers, defaultRate float64, now time.Time) ConversionRates {
+	countries := map[string]string{}
+	for _, customer := range customers {
+		countries[customer.ID] = customer.Address.CountryCode
+	}
+	cancelled := map[string]bool{}
+	for _, order := range orders {
+		cancelled[order.ID] = order.Status == sales.OrderStatusCancelled
+	}
+
+	var overall conversions
+	byCountry := map[string]conversions{}
+	for _, quote := range quotes {
+		if quote.Status == sales.QuoteStatusOpen && !quote.Expired(now) {
+			continue
+		}
+		quoted, ordered := 0, 0
+		for _, item := range quote.LineItems {
+			quoted += item.Quantity
+		}
+		if quote.Status == sales.QuoteStatusOrdered && !cancelled[quote.OrderID] {
+			ordered = quoted
+		}
+
+		overall = conversions{quotes: overall.quotes + 1, quoted: overall.quoted + quoted, ordered: overall.ordered + ordered}
+		if country, ok := countries[quote.CustomerID]; ok {
+			counts := byCountry[country]
+			byCountry[country] = conversions{quotes: counts.quotes + 1, quoted: counts.quoted + quoted, ordered: counts.ordered + ordered}
+		}
+	}
+
+	rates := ConversionRates{Default: defaultRate, Countries: map[string]float64{}}
+	if rate, ok := overall.rate(); ok {
+		rates.Overall = &rate
+	}
+	for country, counts := range byCountry {
+		if rate, ok := counts.rate(); ok {
+			rates.Countries[country] = rate
+		}
+	}
+	return rates
+}
+
+// Region is a city within a country, or a whole country if the city is empty
+type Region struct {
+	CountryCode string `json:"country_code"`
+	City        string `json:"city,omitempty"`
+}
+
+// Demand is the demand for umbrellas forecast in a region on a UTC day
+type Demand struct {
+	Region
+	Date time.Time `json:"date"`
+	// RainyCustomers are the customers in the region with rain forecast on the day
+	RainyCustomers int `json:"rainy_customers"`
+	// RainPeriods are the 3 hour periods that rain is forecast in on the day, summed across the rainy customers
+	RainPeriods int `json:"rain_periods"`
+	// Employees are the employees of the rainy customers
+	Employees      int     `json:"employees"`
+	ConversionRate float64 `json:"conversion_rate"`
+	// ExpectedUnits are the umbrellas expected to be ordered for the day. Each customer is expected to order an umbrella for each of its
+	// employees, weighted by the conversion rate, ahead of the first day of rain forecast for it
+	ExpectedUnits int `json:"expected_units"`
+}
+
+// Report is the demand forecast for every region with rain forecast for its customers
+type Report struct {
+	GeneratedAt time.Time       `json:"generated_at"`
+	Range       util.DateRange  `json:"range"`
+	Rates       ConversionRates `json:"conversion_rates"`
+	// Demand is sorted by country, city and date
+	Demand []Demand `json:"demand"`
+}
+
+// Forecast rolls up the rain forecast for the active customers within the date range by city and day, weighting the employees of each
+// customer by the conversion rate of its country
+func Forecast(customers models.Customers, rates ConversionRates, dateRange util.DateRange, now time.Time) Report {
+	type key struct {
+		region Region
+		day    time.Time
+	}
+	demands := map[key]*Demand{}
+	expected := map[key]float64{}
+	for _, customer := range customers {
+		if customer.DeletedAt != nil {
+			continue
+		}
+		region := Region{CountryCode: strings.ToUpper(customer.Address.CountryCode), City: canonicalCity(customer.Address.City)}
+		employees := customer.NumEmployees
+		if employees < 1 {
+			employees = 1
+		}
+
+		periods := map[time.Time]int{}
+		var firstDay *time.Time
+		for _, weather := range customer.WeatherDetails {
+			if weather.Type != models.WeatherTypeRain || !dateRange.Contains(weather.Date) {
+				continue
+			}
+			day := truncateDay(weather.Date)
+			periods[day]++
+			if firstDay == nil || day.Before(*firstDay) {
+				firstDay = &day
+			}
+		}
+
+		for day, count := range periods {
+			k := key{region: region, day: day}
+			demand, ok := demands[k]
+			if !ok {
+				demand = &Demand{Region: region, Date: day, ConversionRate: rates.For(region.CountryCode)}
+				demands[k] = demand
+			}
+			demand.RainyCustomers++
+			demand.RainPeriods += count
+			demand.Employees += employees
+			if day.Equal(*firstDay) {
+				expected[k] += float64(employees) * demand.ConversionRate
+			}
+		}
+	}
+
+	report := Report{GeneratedAt: now, Range: dateRange, Rates: rates, Demand: []Demand{}}
+	for k, demand := range demands {
+		demand.ExpectedUnits = int(math.Round(expected[k]))
+		report.Demand = append(report.Demand, *demand)
+	}
+	sortDemand(report.Demand)
+	return report
+}
+
+// ByCountry returns the report with the demand of each country's cities rolled up into the country
+func (report Report) ByCountry() Report {
+	type key struct {
+		country string
+		day     time.Time
+	}
+	demands := map[key]*Demand{}
+	for _, cityDemand := range report.Demand {
+		k := key{country: cityDemand.CountryCode, day: cityDemand.Date}
+		demand, ok := demands[k]
+		if !ok {
+			demand = &Demand{Region: Region{CountryCode: cityDemand.CountryCode}, Date: cityDemand.Date, ConversionRate: cityDemand.ConversionRate}
+			demands[k] = demand
+		}
+		demand.RainyCustomers += cityDemand.RainyCustomers
+		demand.RainPeriods += cityDemand.RainPeriods
+		demand.Employees += cityDemand.Employees
+		demand.ExpectedUnits += cityDemand.ExpectedUnits
+	}
+
+	rolledUp := report
+	rolledUp.Demand = []Demand{}
+	for _, demand := range demands {
+		rolledUp.Demand = append(rolledUp.Demand, *demand)
+	}
+	sortDemand(rolledUp.Demand)
+	return rolledUp
+}
+
+// InCountry returns the report with only the demand of the country
+func (report Report) InCountry(countryCode string) Report {
+	filtered := report
+	filtered.Demand = []Demand{}
+	for _, demand := range report.Demand {
+		if strings.EqualFold(demand.CountryCode, countryCode) {
+			filtered.Demand = append(filtered.Demand, demand)
+		}
+	}
+	return filtered
+}
+
+func sortDemand(demands []Demand) {
+	sort.Slice(demands, func(i, j int) bool {
+		if demands[i].CountryCode != demands[j].CountryCode {
+			return demands[i].CountryCode < demands[j].CountryCode
+		}
+		if demands[i].City != demands[j].City {
+			return demands[i].City < demands[j].City
+		}
+		return demands[i].Date.Before(demands[j].Date)
+	})
+}
+
+// canonicalCity capitalizes each word of the city's name, so that customers whose cities are spelt with different cases share a region
+func canonicalCity(city string) string {
+	words := strings.Fields(strings.ToLower(city))
+	for i, word := range words {
+		runes := []rune(word)
+		words[i] = string(unicode.ToUpper(runes[0])) + string(runes[1:])
+	}
+	return strings.Join(words, " ")
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
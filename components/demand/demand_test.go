@@ -0,0 +1,100 @@
+package demand
+
+import (
+	"testing"
+	"time"
+	"umbrellacorp/components/sales"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+
+func rain(day, hour int) models.Weather {
+	return models.Weather{Date: time.Date(2017, 02, day, hour, 0, 0, 0, time.UTC), Type: models.WeatherTypeRain}
+}
+
+func quote(customerID string, status sales.QuoteStatus, quantity int, orderID string) sales.Quote {
+	return sales.Quote{
+		CustomerID: customerID,
+		Status:     status,
+		OrderID:    orderID,
+		LineItems:  []sales.LineItem{{Quantity: quantity}},
+		ValidUntil: now.AddDate(0, 0, 1),
+	}
+}
+
+func TestHistoricalRates(t *testing.T) {
+	customers := models.Customers{
+		{ID: "ca", Address: models.Address{CountryCode: "CA"}},
+		{ID: "us", Address: models.Address{CountryCode: "US"}},
+	}
+	orders := []sales.Order{{ID: "o1", Status: sales.OrderStatusShipped}, {ID: "o2", Status: sales.OrderStatusCancelled}}
+	expired := quote("us", sales.QuoteStatusOpen, 10, "")
+	expired.ValidUntil = now.Add(-time.Hour)
+
+	tests := []struct {
+		name   string
+		quotes []sales.Quote
+		exp    map[string]float64
+	}{
+		{
+			name:   "no history",
+			quotes: []sales.Quote{},
+			exp:    map[string]float64{"CA": 0.1, "GB": 0.1},
+		},
+		{
+			name: "too few quotes in a country fall back to the overall rate",
+			quotes: []sales.Quote{
+				quote("ca", sales.QuoteStatusOrdered, 30, "o1"),
+				quote("ca", sales.QuoteStatusCancelled, 10, ""),
+				quote("ca", sales.QuoteStatusOrdered, 10, "o2"),
+				quote("ca", sales.QuoteStatusCancelled, 10, ""),
+				quote("ca", sales.QuoteStatusCancelled, 40, ""),
+				expired,
+				// Open quotes are undecided
+				quote("us", sales.QuoteStatusOpen, 100, ""),
+			},
+			exp: map[string]float64{"CA": 0.3, "US": 30.0 / 110, "GB": 30.0 / 110},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rates := HistoricalRates(test.quotes, orders, customers, 0.1, now)
+			for country, exp := range test.exp {
+				assert.InDelta(t, exp, rates.For(country), 1e-9, country)
+			}
+		})
+	}
+}
+
+func TestForecast(t *testing.T) {
+	deleted := now
+	customers := models.Customers{
+		{ID: "1", NumEmployees: 100, Address: models.Address{City: "Toronto", CountryCode: "CA"}, WeatherDetails: []models.Weather{rain(17, 0), rain(17, 3), rain(18, 0)}},
+		{ID: "2", NumEmployees: 50, Address: models.Address{City: "toronto", CountryCode: "ca"}, WeatherDetails: []models.Weather{rain(18, 6), rain(30, 0)}},
+		{ID: "3", NumEmployees: 0, Address: models.Address{City: "Ottawa", CountryCode: "CA"}, WeatherDetails: []models.Weather{rain(17, 12)}},
+		{ID: "4", NumEmployees: 500, Address: models.Address{City: "Chicago", CountryCode: "US"}},
+		{ID: "5", NumEmployees: 500, Address: models.Address{City: "Ottawa", CountryCode: "CA"}, WeatherDetails: []models.Weather{rain(17, 12)}, DeletedAt: &deleted},
+	}
+	rates := ConversionRates{Default: 0.1, Countries: map[string]float64{"CA": 0.5}}
+	dateRange := util.DateRange{Start: now, End: now.AddDate(0, 0, 5)}
+	day := func(d int) time.Time { return time.Date(2017, 02, d, 0, 0, 0, 0, time.UTC) }
+
+	report := Forecast(customers, rates, dateRange, now)
+	assert.Equal(t, []Demand{
+		{Region: Region{CountryCode: "CA", City: "Ottawa"}, Date: day(17), RainyCustomers: 1, RainPeriods: 1, Employees: 1, ConversionRate: 0.5, ExpectedUnits: 1},
+		{Region: Region{CountryCode: "CA", City: "Toronto"}, Date: day(17), RainyCustomers: 1, RainPeriods: 2, Employees: 100, ConversionRate: 0.5, ExpectedUnits: 50},
+		{Region: Region{CountryCode: "CA", City: "Toronto"}, Date: day(18), RainyCustomers: 2, RainPeriods: 2, Employees: 150, ConversionRate: 0.5, ExpectedUnits: 25},
+	}, report.Demand)
+
+	assert.Equal(t, []Demand{
+		{Region: Region{CountryCode: "CA"}, Date: day(17), RainyCustomers: 2, RainPeriods: 3, Employees: 101, ConversionRate: 0.5, ExpectedUnits: 51},
+		{Region: Region{CountryCode: "CA"}, Date: day(18), RainyCustomers: 2, RainPeriods: 2, Employees: 150, ConversionRate: 0.5, ExpectedUnits: 25},
+	}, report.ByCountry().Demand)
+	assert.Len(t, report.InCountry("ca").Demand, 3)
+	assert.Empty(t, report.InCountry("US").Demand)
+}
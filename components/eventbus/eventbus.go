@@ -0,0 +1,148 @@
+package eventbus
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a notification published to a topic, e.g. customer.updated
+type Event struct {
+	// ID increases with every event published to the bus, so that subscribers can resume after the last event they received
+	ID    uint64 `json:"id"`
+	Topic string `json:"topic"`
+	// Tenant is the tenant whose data the event describes. It's empty for events that aren't specific to a tenant
+	Tenant string      `json:"tenant,omitempty"`
+	Time   time.Time   `json:"time"`
+	Data   interface{} `json:"data"`
+}
+
+// Bus is an in-process publish/subscribe event bus that is safe for concurrent use. It keeps a bounded buffer of the most recent events
+// so that subscribers can replay events they missed while disconnected
+type Bus struct {
+	mu            sync.Mutex
+	lastID        uint64
+	replay        []Event
+	replaySize    int
+	subscriptions map[*Subscription]bool
+}
+
+// Default is the bus shared by the application's handlers
+var Default = New(1000)
+
+// New returns a Bus that keeps the replaySize most recent events for replay
+func New(replaySize int) *Bus {
+	return &Bus{replaySize: replaySize, subscriptions: map[*Subscription]bool{}}
+}
+
+// Publish sends an event that isn't specific to a tenant to every subscription of the topic. Publish never blocks on slow subscribers,
+// see Subscription.Events
+func (bus *Bus) Publish(topic string, data interface{}) Event {
+	return bus.PublishTenant("", topic, data)
+}
+
+// PublishTenant sends an event describing the tenant's data to every subscription of the topic that can receive the tenant's events
+func (bus *Bus) PublishTenant(tenant, topic string, data interface{}) Event {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	bus.lastID++
+	event := Event{ID: bus.lastID, Topic: topic, Tenant: tenant, Time: time.Now(), Data: data}
+	bus.replay = append(bus.replay, event)
+	if len(bus.replay) > bus.replaySize {
+		bus.replay = bus.replay[len(bus.replay)-bus.replaySize:]
+	}
+
+	for subscription := range bus.subscriptions {
+		if !subscription.matches(event) {
+			continue
+		}
+		select {
+		case subscription.events <- event:
+		default:
+			// The subscriber isn't keeping up. Dropping it rather than the event lets it resume from the replay buffer without gaps
+			bus.unsubscribe(subscription)
+		}
+	}
+	return event
+}
+
+// Subscribe returns a subscription to events published to the topics, or to every topic if none are specified. A topic also matches its
+// sub topics, e.g. customer matches customer.created. If lastEventID is non-zero, buffered events published after it are returned for
+// replay, along with false if older events that the subscriber missed were already evicted from the buffer. bufferSize is the number of
+// events that may be pending delivery before the subscriber is considered too slow
+func (bus *Bus) Subscribe(topics []string, lastEventID uint64, bufferSize int) (*Subscription, []Event, bool) {
+	return bus.subscribe(nil, topics, lastEventID, bufferSize)
+}
+
+// SubscribeTenant is like Subscribe, except that the subscription only receives the events of the specified tenant
+func (bus *Bus) SubscribeTenant(tenant string, topics []string, lastEventID uint64, bufferSize int) (*Subscription, []Event, bool) {
+	return bus.subscribe(&tenant, topics, lastEventID, bufferSize)
+}
+
+// subscribe returns a subscription to the topics, restricted to the events of the tenant unless it's nil
+func (bus *Bus) subscribe(tenant *string, topics []string, lastEventID uint64, bufferSize int) (*Subscription, []Event, bool) {
+	subscription := &Subscription{bus: bus, tenant: tenant, topics: topics, events: make(chan Event, bufferSize)}
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	replay := []Event{}
+	complete := true
+	if lastEventID > 0 {
+		complete = lastEventID >= bus.lastID || (len(bus.replay) > 0 && bus.replay[0].ID <= lastEventID+1)
+		for _, event := range bus.replay {
+			if event.ID > lastEventID && subscription.matches(event) {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	bus.subscriptions[subscription] = true
+	return subscription, replay, complete
+}
+
+// unsubscribe removes the subscription and closes its channel. The caller must hold the lock
+func (bus *Bus) unsubscribe(subscription *Subscription) {
+	if bus.subscriptions[subscription] {
+		delete(bus.subscriptions, subscription)
+		close(subscription.events)
+	}
+}
+
+// Subscription receives the events published to its topics
+type Subscription struct {
+	bus *Bus
+	// tenant restricts the subscription to the events of a tenant, unless it's nil
+	tenant *string
+	topics []string
+	events chan Event
+}
+
+// Events returns the channel that events are delivered on. It's closed when the subscription is closed, or when the subscriber falls too
+// far behind, in which case it should resubscribe with the ID of the last event it received
+func (subscription *Subscription) Events() <-chan Event {
+	return subscription.events
+}
+
+// Close stops the delivery of events to the subscription
+func (subscription *Subscription) Close() {
+	subscription.bus.mu.Lock()
+	defer subscription.bus.mu.Unlock()
+	subscription.bus.unsubscribe(subscription)
+}
+
+func (subscription *Subscription) matches(event Event) bool {
+	if subscription.tenant != nil && *subscription.tenant != event.Tenant {
+		return false
+	}
+	if len(subscription.topics) == 0 {
+		return true
+	}
+	for _, t := range subscription.topics {
+		if event.Topic == t || strings.HasPrefix(event.Topic, t+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package eventbus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func topicsOf(events []Event) []string {
+	var topics []string
+	for _, event := range events {
+		topics = append(topics, event.Topic)
+	}
+	return topics
+}
+
+func TestSubscribe(t *testing.T) {
+	bus := New(3)
+	for _, topic := range []string{"customer.created", "forecast.changed", "customer.updated", "customer.deleted"} {
+		bus.Publish(topic, nil)
+	}
+
+	tests := []struct {
+		name        string
+		topics      []string
+		lastEventID uint64
+		expReplay   []string
+		expComplete bool
+	}{
+		{
+			name:        "no replay without last event id",
+			expComplete: true,
+		},
+		{
+			name:        "replay every topic",
+			lastEventID: 2,
+			expReplay:   []string{"customer.updated", "customer.deleted"},
+			expComplete: true,
+		},
+		{
+			name:        "topic matches sub topics",
+			topics:      []string{"forecast", "customer.deleted"},
+			lastEventID: 1,
+			expReplay:   []string{"forecast.changed", "customer.deleted"},
+			expComplete: true,
+		},
+		{
+			name:        "up to date",
+			lastEventID: 4,
+			expComplete: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subscription, replay, complete := bus.Subscribe(test.topics, test.lastEventID, 1)
+			defer subscription.Close()
+			assert.Equal(t, test.expReplay, topicsOf(replay))
+			assert.Equal(t, test.expComplete, complete)
+		})
+	}
+
+	t.Run("replay buffer evicted missed events", func(t *testing.T) {
+		subscription, replay, complete := bus.Subscribe(nil, 1, 1)
+		subscription.Close()
+		assert.Equal(t, []string{"forecast.changed", "customer.updated", "customer.deleted"}, topicsOf(replay))
+		assert.True(t, complete)
+
+		bus.Publish("customer.created", nil)
+		subscription, replay, complete = bus.Subscribe(nil, 1, 1)
+		subscription.Close()
+		assert.Equal(t, []string{"customer.updated", "customer.deleted", "customer.created"}, topicsOf(replay))
+		assert.False(t, complete)
+	})
+}
+
+func TestPublish(t *testing.T) {
+	bus := New(10)
+	customers, _, _ := bus.Subscribe([]string{"customer"}, 0, 2)
+	slow, _, _ := bus.Subscribe(nil, 0, 1)
+
+	created := bus.Publish("customer.created", "1")
+	bus.Publish("forecast.changed", "1")
+
+	assert.Equal(t, created, <-customers.Events())
+	assert.Empty(t, customers.Events())
+
+	// The second event overflowed the slow subscription's buffer, so it's dropped after receiving the first
+	assert.Equal(t, created, <-slow.Events())
+	_, ok := <-slow.Events()
+	assert.False(t, ok)
+
+	customers.Close()
+	_, ok = <-customers.Events()
+	assert.False(t, ok)
+	// Closing twice is harmless
+	customers.Close()
+	slow.Close()
+}
+
+func TestSubscribeTenant(t *testing.T) {
+	bus := New(10)
+	acme, _, _ := bus.SubscribeTenant("acme", []string{"customer"}, 0, 10)
+	every, _, _ := bus.Subscribe(nil, 0, 10)
+
+	globex := bus.PublishTenant("globex", "customer.created", "1")
+	created := bus.PublishTenant("acme", "customer.created", "2")
+	bus.Publish("customer.created", "3")
+
+	assert.Equal(t, created, <-acme.Events())
+	assert.Empty(t, acme.Events())
+	assert.Equal(t, globex, <-every.Events())
+	assert.Len(t, every.Events(), 2)
+
+	// Replays are restricted to the tenant too
+	_, replay, _ := bus.SubscribeTenant("acme", nil, globex.ID, 10)
+	assert.Equal(t, []Event{created}, replay)
+}
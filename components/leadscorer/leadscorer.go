@@ -0,0 +1,171 @@
+package leadscorer
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/models"
+)
+
+// Weights configures how much each factor contributes to a customer's LeadScore. Weights are relative to each other, so they don't need
+// to add up to 1
+type Weights struct {
+	Rain        float64 `json:"rain"`
+	CompanySize float64 `json:"company_size"`
+	Recency     float64 `json:"recency"`
+	Pipeline    float64 `json:"pipeline"`
+}
+
+// DefaultWeights favours customers with the most upcoming rain, followed by the size of the company
+var DefaultWeights = Weights{
+	Rain:        0.4,
+	CompanySize: 0.3,
+	Recency:     0.2,
+	Pipeline:    0.1,
+}
+
+var (
+	weightsMu  sync.RWMutex
+	configured = DefaultWeights
+)
+
+// Configure sets the weights used when scoring customers. It's safe to call while customers are being scored
+func Configure(w Weights) error {
+	if w.Rain < 0 || w.CompanySize < 0 || w.Recency < 0 || w.Pipeline < 0 {
+		return fmt.Errorf("Lead score weights can't be negative")
+	}
+	if w.Rain+w.CompanySize+w.Recency+w.Pipeline == 0 {
+		return fmt.Errorf("At least one lead score weight must be positive")
+	}
+	weightsMu.Lock()
+	defer weightsMu.Unlock()
+	configured = w
+	return nil
+}
+
+// CurrentWeights returns the weights used when scoring customers
+func CurrentWeights() Weights {
+	weightsMu.RLock()
+	defer weightsMu.RUnlock()
+	return configured
+}
+
+const (
+	// maxScore is the LeadScore.Total of a customer that maxes out every factor
+	maxScore = 100
+	// rainPeriodsSaturation is the number of forecasted rain periods at which the rain factor is maxed out. OpenWeatherMap reports
+	// 3 hour periods, so this is 2 days worth of rain
+	rainPeriodsSaturation = 16
+	// rainPeriod is the length of the forecast periods that rain is reported for
+	rainPeriod = 3 * time.Hour
+	// employeesSaturation is the company size at which the company size factor is maxed out
+	employeesSaturation = 1000
+	// recencySaturationDays is the number of days since last contact at which the recency factor is maxed out
+	recencySaturationDays = 30
+)
+
+// pipelineValues ranks how worthwhile a call is for each models.PipelineStatus. Customers in active negotiations are the best to call
+// while lost customers aren't worth calling at all
+var pipelineValues = map[models.PipelineStatus]float64{
+	models.PipelineStatusProspect:    0.6,
+	models.PipelineStatusContacted:   0.8,
+	models.PipelineStatusNegotiating: 1,
+	models.PipelineStatusWon:         0.3,
+	models.PipelineStatusLost:        0,
+}
+
+// Score computes the LeadScore of a customer using the configured Weights. now is used to determine the days since the customer was last
+// contacted and which rain periods are upcoming, see rainFactor
+func Score(customer models.Customer, now time.Time) models.LeadScore {
+	weights := CurrentWeights()
+	factors := []models.ScoreFactor{
+		rainFactor(customer.WeatherDetails, now, weights.Rain),
+		companySizeFactor(customer.NumEmployees, weights.CompanySize),
+		recencyFactor(customer.LastContacted, now, weights.Recency),
+		pipelineFactor(customer.PipelineStatus, weights.Pipeline),
+	}
+
+	totalWeight := weights.Rain + weights.CompanySize + weights.Recency + weights.Pipeline
+	score := models.LeadScore{ScoredAt: now}
+	for _, factor := range factors {
+		factor.Points = round(factor.Value * factor.Weight / totalWeight * maxScore)
+		score.Total += factor.Points
+		score.Breakdown = append(score.Breakdown, factor)
+	}
+	score.Total = round(score.Total)
+	return score
+}
+
+// rainFactor counts the upcoming rain periods, i.e. those that end after now. Stored forecasts only cover weatherforecaster.ForecastRange,
+// so periods are measured from its start instead once now is past it, like the rain filters of customer listings
+func rainFactor(weatherDetails []models.Weather, now time.Time, weight float64) models.ScoreFactor {
+	from := weatherforecaster.ForecastRange().Start
+	if now.Before(from) {
+		from = now
+	}
+	periods := 0
+	for _, weather := range weatherDetails {
+		if weather.Type == models.WeatherTypeRain && weather.Date.Add(rainPeriod).After(from) {
+			periods++
+		}
+	}
+	return models.ScoreFactor{
+		Name:        "rain",
+		Value:       math.Min(float64(periods)/rainPeriodsSaturation, 1),
+		Weight:      weight,
+		Explanation: fmt.Sprintf("%d upcoming rain periods forecast", periods),
+	}
+}
+
+func companySizeFactor(numEmployees int, weight float64) models.ScoreFactor {
+	value := 0.0
+	if numEmployees > 0 {
+		// Use a log scale so that the difference between 10 and 100 employees counts as much as the difference between 100 and 1000
+		value = math.Min(math.Log10(float64(numEmployees)+1)/math.Log10(employeesSaturation+1), 1)
+	}
+	return models.ScoreFactor{
+		Name:        "company_size",
+		Value:       value,
+		Weight:      weight,
+		Explanation: fmt.Sprintf("%d employees", numEmployees),
+	}
+}
+
+func recencyFactor(lastContacted *time.Time, now time.Time, weight float64) models.ScoreFactor {
+	factor := models.ScoreFactor{
+		Name:   "recency",
+		Weight: weight,
+	}
+	if lastContacted == nil {
+		factor.Value = 1
+		factor.Explanation = "Never contacted"
+		return factor
+	}
+
+	days := int(now.Sub(*lastContacted).Hours() / 24)
+	if days < 0 {
+		days = 0
+	}
+	factor.Value = math.Min(float64(days)/recencySaturationDays, 1)
+	factor.Explanation = fmt.Sprintf("Last contacted %d days ago", days)
+	return factor
+}
+
+func pipelineFactor(status models.PipelineStatus, weight float64) models.ScoreFactor {
+	if status == "" {
+		status = models.PipelineStatusProspect
+	}
+	return models.ScoreFactor{
+		Name:        "pipeline",
+		Value:       pipelineValues[status],
+		Weight:      weight,
+		Explanation: fmt.Sprintf("Pipeline status is %s", status),
+	}
+}
+
+// round rounds to 2 decimal places so that scores are readable in responses
+func round(val float64) float64 {
+	return math.Round(val*100) / 100
+}
@@ -0,0 +1,154 @@
+package leadscorer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScore(t *testing.T) {
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	lastWeek := now.AddDate(0, 0, -7)
+	lastYear := now.AddDate(-1, 0, 0)
+
+	rain := func(periods int) []models.Weather {
+		var weather []models.Weather
+		for i := 0; i < periods; i++ {
+			weather = append(weather, models.Weather{Date: now.Add(time.Duration(i*3) * time.Hour), Type: models.WeatherTypeRain})
+		}
+		return weather
+	}
+
+	tests := []struct {
+		name     string
+		input    models.Customer
+		expTotal float64
+	}{
+		{
+			name: "lost customer with nothing going for it",
+			input: models.Customer{
+				LastContacted:  &now,
+				PipelineStatus: models.PipelineStatusLost,
+			},
+			expTotal: 0,
+		},
+		{
+			name:     "new prospect that was never contacted",
+			input:    models.Customer{},
+			expTotal: 26,
+		},
+		{
+			name: "every factor maxed out",
+			input: models.Customer{
+				WeatherDetails: rain(rainPeriodsSaturation),
+				NumEmployees:   employeesSaturation,
+				LastContacted:  &lastYear,
+				PipelineStatus: models.PipelineStatusNegotiating,
+			},
+			expTotal: 100,
+		},
+		{
+			name: "rain and recency partially count",
+			input: models.Customer{
+				WeatherDetails: rain(rainPeriodsSaturation / 2),
+				LastContacted:  &lastWeek,
+				PipelineStatus: models.PipelineStatusWon,
+			},
+			expTotal: 27.67,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			score := Score(test.input, now)
+			assert.Equal(t, test.expTotal, score.Total)
+			assert.Equal(t, now, score.ScoredAt)
+			assert.Len(t, score.Breakdown, 4)
+		})
+	}
+}
+
+func TestScoreExplanations(t *testing.T) {
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	lastContacted := now.AddDate(0, 0, -3)
+	customer := models.Customer{
+		NumEmployees:   250,
+		LastContacted:  &lastContacted,
+		PipelineStatus: models.PipelineStatusContacted,
+		WeatherDetails: []models.Weather{
+			// Rain periods that already ended aren't upcoming
+			{Date: now.Add(-6 * time.Hour), Type: models.WeatherTypeRain},
+			{Date: now.Add(-3 * time.Hour), Type: models.WeatherTypeRain},
+			{Date: now.Add(-time.Hour), Type: models.WeatherTypeRain},
+		},
+	}
+
+	var explanations []string
+	for _, factor := range Score(customer, now).Breakdown {
+		explanations = append(explanations, factor.Explanation)
+	}
+	assert.Equal(t, []string{
+		"1 upcoming rain periods forecast",
+		"250 employees",
+		"Last contacted 3 days ago",
+		"Pipeline status is contacted",
+	}, explanations)
+
+	// Stored forecasts only cover the forecast range, so their rain stays upcoming after the range has passed
+	assert.Equal(t, "1 upcoming rain periods forecast", Score(customer, time.Now()).Breakdown[0].Explanation)
+}
+
+func TestConfigure(t *testing.T) {
+	defer Configure(DefaultWeights)
+
+	tests := []struct {
+		name   string
+		input  Weights
+		expErr error
+	}{
+		{
+			name:   "negative weight",
+			input:  Weights{Rain: -1, CompanySize: 1},
+			expErr: fmt.Errorf("Lead score weights can't be negative"),
+		},
+		{
+			name:   "all weights zero",
+			input:  Weights{},
+			expErr: fmt.Errorf("At least one lead score weight must be positive"),
+		},
+		{
+			name:   "only rain counts",
+			input:  Weights{Rain: 1},
+			expErr: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expErr, Configure(test.input))
+		})
+	}
+
+	score := Score(models.Customer{NumEmployees: employeesSaturation}, time.Now())
+	assert.Equal(t, float64(0), score.Total)
+}
+
+func TestConfigureWhileScoring(t *testing.T) {
+	defer Configure(DefaultWeights)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			Score(models.Customer{NumEmployees: employeesSaturation}, time.Now())
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, Configure(Weights{CompanySize: float64(i + 1)}))
+	}
+	<-done
+	assert.Equal(t, Weights{CompanySize: 100}, CurrentWeights())
+}
@@ -0,0 +1,108 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+	"umbrellacorp/components/eventbus"
+)
+
+// Supported channels
+const (
+	// ChannelWebhook publishes messages to the event bus, which delivers them to the tenant's webhook subscriptions, e.g. a CRM that
+	// contacts the customer
+	ChannelWebhook = "webhook"
+	// ChannelSMS texts messages to the customer's contact number through an SMS gateway
+	ChannelSMS = "sms"
+)
+
+// TopicMessage is the topic of the messages published to the event bus by the webhook channel
+const TopicMessage = "notification.message"
+
+// Message is a notification to a customer
+type Message struct {
+	ID     string `json:"id"`
+	Tenant string `json:"tenant"`
+	// Source identifies what sent the message, e.g. a campaign
+	Source     string `json:"source"`
+	CustomerID string `json:"customer_id"`
+	// To is the customer's address on the channel, e.g. its contact number
+	To   string `json:"to"`
+	Body string `json:"body"`
+}
+
+// Channel sends messages to customers
+type Channel interface {
+	Send(message Message) error
+}
+
+// Channels are the channels available to send messages through, keyed by name
+type Channels map[string]Channel
+
+// Names returns the names of the channels, sorted alphabetically
+func (channels Channels) Names() []string {
+	names := []string{}
+	for name := range channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// busChannel is the webhook channel
+type busChannel struct {
+	bus *eventbus.Bus
+}
+
+// NewBusChannel returns a channel publishing messages to the bus under TopicMessage
+func NewBusChannel(bus *eventbus.Bus) Channel {
+	return busChannel{bus: bus}
+}
+
+func (channel busChannel) Send(message Message) error {
+	channel.bus.PublishTenant(message.Tenant, TopicMessage, message)
+	return nil
+}
+
+// smsTimeout limits how long the SMS gateway may take to accept a message
+const smsTimeout = 10 * time.Second
+
+// smsChannel is the SMS channel
+type smsChannel struct {
+	gatewayURL string
+	client     *http.Client
+}
+
+// NewSMSChannel returns a channel posting messages to the SMS gateway at the URL, as a JSON object with to and body fields. Any 2xx
+// response means that the gateway accepted the message
+func NewSMSChannel(gatewayURL string) Channel {
+	return smsChannel{gatewayURL: gatewayURL, client: &http.Client{Timeout: smsTimeout}}
+}
+
+func (channel smsChannel) Send(message Message) error {
+	payload, err := json.Marshal(map[string]string{"to": message.To, "body": message.Body})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, channel.gatewayURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	resp, err := channel.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to reach the SMS gateway: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("SMS gateway responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"umbrellacorp/components/eventbus"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusChannel(t *testing.T) {
+	bus := eventbus.New(10)
+	subscription, _, _ := bus.Subscribe([]string{"notification"}, 0, 10)
+	message := Message{ID: "1", Tenant: "acme", CustomerID: "c1", Body: "Rain is coming"}
+	assert.NoError(t, NewBusChannel(bus).Send(message))
+
+	event := <-subscription.Events()
+	assert.Equal(t, TopicMessage, event.Topic)
+	assert.Equal(t, "acme", event.Tenant)
+	assert.Equal(t, message, event.Data)
+}
+
+func TestSMSChannel(t *testing.T) {
+	var received map[string]string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		if received["to"] == "unreachable" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+	}))
+	defer gateway.Close()
+
+	channel := NewSMSChannel(gateway.URL)
+	assert.NoError(t, channel.Send(Message{To: "4165555555", Body: "Rain is coming"}))
+	assert.Equal(t, map[string]string{"to": "4165555555", "body": "Rain is coming"}, received)
+	assert.EqualError(t, channel.Send(Message{To: "unreachable"}), "SMS gateway responded with status 422")
+	assert.Equal(t, []string{ChannelSMS, ChannelWebhook}, Channels{ChannelWebhook: nil, ChannelSMS: nil}.Names())
+}
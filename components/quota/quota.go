@@ -0,0 +1,167 @@
+package quota
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Budget caps the calls made to a provider per minute and per UTC day. Zero values don't cap calls
+type Budget struct {
+	PerMinute int `json:"per_minute"`
+	PerDay    int `json:"per_day"`
+}
+
+// Validate verifies that the budget isn't negative
+func (budget Budget) Validate() error {
+	if budget.PerMinute < 0 || budget.PerDay < 0 {
+		return fmt.Errorf("Provider budgets can't be negative")
+	}
+	return nil
+}
+
+// Priority determines how much of a budget a call can use
+type Priority int
+
+// Supported Priority values
+const (
+	// PriorityBackground calls, such as scheduled refreshes, can only use backgroundShare of each budget
+	PriorityBackground Priority = iota
+	// PriorityInteractive calls, made while a client waits, can use the whole budget
+	PriorityInteractive
+)
+
+// backgroundShare is the share of each budget available to background calls. The rest is reserved so that interactive calls can still be
+// made while background calls are deferred
+const backgroundShare = 0.8
+
+// Usage is the number of calls made to a provider in the current windows of its budget
+type Usage struct {
+	Provider       string    `json:"provider"`
+	Budget         Budget    `json:"budget"`
+	MinuteCalls    int       `json:"minute_calls"`
+	DayCalls       int       `json:"day_calls"`
+	DeniedCalls    int       `json:"denied_calls"`
+	MinuteResetsAt time.Time `json:"minute_resets_at"`
+	DayResetsAt    time.Time `json:"day_resets_at"`
+}
+
+// Manager counts the calls made to each provider and enforces their budgets. It's safe for concurrent use
+type Manager struct {
+	mu      sync.Mutex
+	budgets map[string]Budget
+	usage   map[string]*Usage
+	now     func() time.Time
+}
+
+// Default is the manager shared by the application's forecast calls
+var Default = New(nil)
+
+// New returns a Manager enforcing the budgets, by provider. Calls to providers without a budget are counted but not capped
+func New(budgets map[string]Budget) *Manager {
+	manager := &Manager{budgets: map[string]Budget{}, usage: map[string]*Usage{}, now: time.Now}
+	for provider, budget := range budgets {
+		manager.budgets[provider] = budget
+	}
+	return manager
+}
+
+// SetBudget replaces the budget of the provider
+func (manager *Manager) SetBudget(provider string, budget Budget) error {
+	if err := budget.Validate(); err != nil {
+		return err
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	manager.budgets[provider] = budget
+	return nil
+}
+
+// Acquire counts a call to the provider, or returns ErrExhausted if the provider's budget doesn't allow a call of the priority. Calls must
+// only be made to the provider if Acquire succeeds
+func (manager *Manager) Acquire(provider string, priority Priority) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	usage := manager.current(provider, manager.now())
+	if usage.MinuteCalls >= allowance(usage.Budget.PerMinute, priority) {
+		usage.DeniedCalls++
+		return ErrExhausted{Provider: provider, Window: "minute", RetryAt: usage.MinuteResetsAt}
+	}
+	if usage.DayCalls >= allowance(usage.Budget.PerDay, priority) {
+		usage.DeniedCalls++
+		return ErrExhausted{Provider: provider, Window: "day", RetryAt: usage.DayResetsAt}
+	}
+	usage.MinuteCalls++
+	usage.DayCalls++
+	return nil
+}
+
+// Usage returns the usage of every provider that was called or has a budget, sorted by provider
+func (manager *Manager) Usage() []Usage {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	now := manager.now()
+	for provider := range manager.budgets {
+		manager.current(provider, now)
+	}
+	usages := make([]Usage, 0, len(manager.usage))
+	for _, usage := range manager.usage {
+		usages = append(usages, *usage)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Provider < usages[j].Provider
+	})
+	return usages
+}
+
+// current returns the provider's usage, starting new windows if the previous ones have ended. The caller must hold the lock
+func (manager *Manager) current(provider string, now time.Time) *Usage {
+	usage, ok := manager.usage[provider]
+	if !ok {
+		usage = &Usage{Provider: provider}
+		manager.usage[provider] = usage
+	}
+	usage.Budget = manager.budgets[provider]
+
+	if !now.Before(usage.MinuteResetsAt) {
+		usage.MinuteCalls = 0
+		usage.MinuteResetsAt = now.Truncate(time.Minute).Add(time.Minute)
+	}
+	if !now.Before(usage.DayResetsAt) {
+		usage.DayCalls = 0
+		year, month, day := now.UTC().Date()
+		usage.DayResetsAt = time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+	}
+	return usage
+}
+
+// allowance returns the number of calls of the priority allowed by a budget. Background calls are allowed at least one call of a non
+// zero budget
+func allowance(budget int, priority Priority) int {
+	if budget == 0 {
+		return int(^uint(0) >> 1)
+	}
+	if priority == PriorityInteractive {
+		return budget
+	}
+	if background := int(float64(budget) * backgroundShare); background > 0 {
+		return background
+	}
+	return 1
+}
+
+// ErrExhausted is returned when a provider's budget doesn't allow another call until RetryAt
+type ErrExhausted struct {
+	Provider string
+	// Window is the budget that was exhausted, either minute or day
+	Window  string
+	RetryAt time.Time
+}
+
+func (err ErrExhausted) Error() string {
+	return fmt.Sprintf("The %s budget of %s is exhausted until %s", err.Window, err.Provider, err.RetryAt.UTC().Format(time.RFC3339))
+}
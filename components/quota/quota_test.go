@@ -0,0 +1,66 @@
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquire(t *testing.T) {
+	now := time.Date(2017, 02, 16, 23, 59, 30, 0, time.UTC)
+	manager := New(map[string]Budget{"openweathermap": {PerMinute: 5, PerDay: 8}})
+	manager.now = func() time.Time { return now }
+	nextMinute := time.Date(2017, 02, 17, 0, 0, 0, 0, time.UTC)
+
+	// Background calls leave a fifth of the budget to interactive calls
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, manager.Acquire("openweathermap", PriorityBackground))
+	}
+	assert.Equal(t, ErrExhausted{Provider: "openweathermap", Window: "minute", RetryAt: nextMinute}, manager.Acquire("openweathermap", PriorityBackground))
+	assert.NoError(t, manager.Acquire("openweathermap", PriorityInteractive))
+	assert.Error(t, manager.Acquire("openweathermap", PriorityInteractive))
+
+	// Providers without a budget aren't capped
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, manager.Acquire("mock", PriorityBackground))
+	}
+
+	// Calls are denied until the end of the minute
+	now = nextMinute.Add(-time.Nanosecond)
+	assert.Error(t, manager.Acquire("openweathermap", PriorityInteractive))
+
+	usage := manager.Usage()
+	assert.Equal(t, []Usage{
+		{Provider: "mock", MinuteCalls: 10, DayCalls: 10, MinuteResetsAt: nextMinute, DayResetsAt: nextMinute},
+		{
+			Provider:       "openweathermap",
+			Budget:         Budget{PerMinute: 5, PerDay: 8},
+			MinuteCalls:    5,
+			DayCalls:       5,
+			DeniedCalls:    3,
+			MinuteResetsAt: nextMinute,
+			DayResetsAt:    nextMinute,
+		},
+	}, usage)
+
+	// Both windows reset at midnight
+	now = nextMinute
+	assert.NoError(t, manager.Acquire("openweathermap", PriorityInteractive))
+	assert.Equal(t, 1, manager.Usage()[1].DayCalls)
+}
+
+func TestDayBudget(t *testing.T) {
+	now := time.Date(2017, 02, 16, 12, 0, 0, 0, time.UTC)
+	manager := New(nil)
+	manager.now = func() time.Time { return now }
+	assert.NoError(t, manager.SetBudget("openweathermap", Budget{PerDay: 2}))
+	assert.Error(t, manager.SetBudget("openweathermap", Budget{PerDay: -1}))
+
+	assert.NoError(t, manager.Acquire("openweathermap", PriorityBackground))
+	now = now.Add(time.Hour)
+	assert.NoError(t, manager.Acquire("openweathermap", PriorityInteractive))
+	err := manager.Acquire("openweathermap", PriorityInteractive)
+	assert.Equal(t, ErrExhausted{Provider: "openweathermap", Window: "day", RetryAt: time.Date(2017, 02, 17, 0, 0, 0, 0, time.UTC)}, err)
+	assert.EqualError(t, err, "The day budget of openweathermap is exhausted until 2017-02-17T00:00:00Z")
+}
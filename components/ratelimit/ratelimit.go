@@ -0,0 +1,156 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+	"umbrellacorp/models"
+)
+
+// Limit allows a client to make Requests per Per on average, in bursts of up to Requests. Limits with zero Requests or Per don't limit
+// requests
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// Validate verifies that the limit isn't negative
+func (limit Limit) Validate() error {
+	if limit.Requests < 0 || limit.Per < 0 {
+		return fmt.Errorf("Rate limits can't be negative")
+	}
+	return nil
+}
+
+// IsZero returns true if the limit doesn't limit requests
+func (limit Limit) IsZero() bool {
+	return limit.Requests == 0 || limit.Per == 0
+}
+
+// rate returns the number of requests that become available per nanosecond
+func (limit Limit) rate() float64 {
+	return float64(limit.Requests) / float64(limit.Per)
+}
+
+// Backend stores the token buckets of clients. Memory keeps them in process, servers sharing their limits need a Backend backed by a
+// shared store
+type Backend interface {
+	// Take refills the bucket identified by key at the limit's rate and removes a token from it, if one is available. It returns the
+	// state of the bucket afterwards
+	Take(key string, limit Limit, now time.Time) models.RateLimit
+}
+
+// Config configures the limits of a Limiter
+type Config struct {
+	// Default is the limit of routes that don't have their own limit. Requests to every such route share a client's bucket
+	Default Limit
+	// Routes are the limits of specific routes, by route name. Each route has its own bucket per client, e.g. to limit upserts that call
+	// the forecast provider more strictly than reads
+	Routes map[string]Limit
+}
+
+// Limiter rate limits clients per route, see Config. It implements router.RateLimiter
+type Limiter struct {
+	config  Config
+	backend Backend
+	now     func() time.Time
+}
+
+// New returns a Limiter that stores buckets in the backend. An error is returned if a limit is invalid
+func New(config Config, backend Backend) (*Limiter, error) {
+	if err := config.Default.Validate(); err != nil {
+		return nil, err
+	}
+	for route, limit := range config.Routes {
+		if err := limit.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid rate limit of %s: %s", route, err.Error())
+		}
+	}
+	return &Limiter{config: config, backend: backend, now: time.Now}, nil
+}
+
+// Take counts a request by the client to the route. limited is false if the route isn't rate limited
+func (limiter *Limiter) Take(client, route string) (rateLimit models.RateLimit, limited bool) {
+	key := client
+	limit, ok := limiter.config.Routes[route]
+	if ok {
+		key = client + " " + route
+	} else {
+		limit = limiter.config.Default
+	}
+	if limit.IsZero() {
+		return models.RateLimit{Allowed: true}, false
+	}
+	return limiter.backend.Take(key, limit, limiter.now()), true
+}
+
+// sweepInterval is how often Memory removes buckets that have refilled, so that clients that stopped making requests don't use memory
+const sweepInterval = time.Minute
+
+// Memory is a Backend that keeps buckets in memory. It's safe for concurrent use
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// bucket holds the tokens available to a client. Tokens are only refilled when the bucket is next used
+type bucket struct {
+	tokens  float64
+	limit   Limit
+	updated time.Time
+}
+
+// NewMemory returns an empty Memory backend
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]*bucket{}}
+}
+
+// Take implements Backend
+func (memory *Memory) Take(key string, limit Limit, now time.Time) models.RateLimit {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+
+	if now.Sub(memory.lastSweep) >= sweepInterval {
+		memory.sweep(now)
+	}
+
+	b, ok := memory.buckets[key]
+	if !ok || b.limit != limit {
+		// New clients, and clients whose limit was reconfigured, start with a full bucket
+		b = &bucket{tokens: float64(limit.Requests), limit: limit, updated: now}
+		memory.buckets[key] = b
+	}
+	b.refill(now)
+
+	rateLimit := models.RateLimit{Limit: limit.Requests}
+	if b.tokens >= 1 {
+		b.tokens--
+		rateLimit.Allowed = true
+	} else {
+		rateLimit.RetryAfter = time.Duration(math.Ceil((1 - b.tokens) / limit.rate()))
+	}
+	rateLimit.Remaining = int(b.tokens)
+	rateLimit.Reset = time.Duration(math.Ceil((float64(limit.Requests) - b.tokens) / limit.rate()))
+	return rateLimit
+}
+
+// refill adds the tokens that became available since the bucket was last updated
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Requests), b.tokens+float64(elapsed)*b.limit.rate())
+		b.updated = now
+	}
+}
+
+// sweep removes buckets that have refilled, since they're equivalent to new buckets. The caller must hold the lock
+func (memory *Memory) sweep(now time.Time) {
+	for key, b := range memory.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Requests) {
+			delete(memory.buckets, key)
+		}
+	}
+	memory.lastSweep = now
+}
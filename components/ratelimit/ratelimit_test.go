@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryTake(t *testing.T) {
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	limit := Limit{Requests: 2, Per: time.Minute}
+	memory := NewMemory()
+
+	tests := []struct {
+		name         string
+		elapsed      time.Duration
+		expRateLimit models.RateLimit
+	}{
+		{
+			name:         "full bucket",
+			expRateLimit: models.RateLimit{Allowed: true, Limit: 2, Remaining: 1, Reset: 30 * time.Second},
+		},
+		{
+			name:         "last token",
+			expRateLimit: models.RateLimit{Allowed: true, Limit: 2, Remaining: 0, Reset: time.Minute},
+		},
+		{
+			name:         "empty bucket",
+			elapsed:      10 * time.Second,
+			expRateLimit: models.RateLimit{Limit: 2, Remaining: 0, Reset: 50 * time.Second, RetryAfter: 20 * time.Second},
+		},
+		{
+			name:         "refilled bucket",
+			elapsed:      time.Minute,
+			expRateLimit: models.RateLimit{Allowed: true, Limit: 2, Remaining: 1, Reset: 30 * time.Second},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now = now.Add(test.elapsed)
+			assert.Equal(t, test.expRateLimit, memory.Take("key:1", limit, now))
+		})
+	}
+
+	// Other clients have their own bucket
+	assert.True(t, memory.Take("key:2", limit, now).Allowed)
+
+	// Refilled buckets are swept
+	memory.Take("key:3", limit, now.Add(2*sweepInterval))
+	assert.Len(t, memory.buckets, 1)
+}
+
+func TestLimiter(t *testing.T) {
+	limiter, err := New(Config{
+		Default: Limit{Requests: 2, Per: time.Minute},
+		Routes:  map[string]Limit{"Set Customer": {Requests: 1, Per: time.Minute}, "Get Leads": {}},
+	}, NewMemory())
+	assert.NoError(t, err)
+
+	// Routes without their own limit share a bucket
+	rateLimit, limited := limiter.Take("key:1", "Get Customers")
+	assert.True(t, limited)
+	assert.Equal(t, 1, rateLimit.Remaining)
+	rateLimit, _ = limiter.Take("key:1", "Search Customers")
+	assert.True(t, rateLimit.Allowed)
+	rateLimit, _ = limiter.Take("key:1", "Get Customers")
+	assert.False(t, rateLimit.Allowed)
+
+	rateLimit, _ = limiter.Take("key:1", "Set Customer")
+	assert.True(t, rateLimit.Allowed)
+	rateLimit, _ = limiter.Take("key:1", "Set Customer")
+	assert.False(t, rateLimit.Allowed)
+
+	_, limited = limiter.Take("key:1", "Get Leads")
+	assert.False(t, limited)
+
+	_, err = New(Config{Routes: map[string]Limit{"Set Customer": {Requests: -1, Per: time.Minute}}}, NewMemory())
+	assert.EqualError(t, err, "Invalid rate limit of Set Customer: Rate limits can't be negative")
+}
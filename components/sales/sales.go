@@ -0,0 +1,379 @@
+package sales
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"umbrellacorp/util"
+)
+
+// LineItem is a quantity of a catalog product, priced when it was quoted
+type LineItem struct {
+	ProductID      string `json:"product_id"`
+	SKU            string `json:"sku"`
+	Name           string `json:"name"`
+	Quantity       int    `json:"quantity"`
+	UnitPriceCents int64  `json:"unit_price_cents"`
+	TotalCents     int64  `json:"total_cents"`
+}
+
+// RainWindow is the period between the first and last rain in a customer's forecast
+type RainWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// QuoteStatus is the status of a Quote
+type QuoteStatus string
+
+// Supported QuoteStatus values
+const (
+	QuoteStatusOpen      = QuoteStatus("open")
+	QuoteStatusOrdered   = QuoteStatus("ordered")
+	QuoteStatusCancelled = QuoteStatus("cancelled")
+)
+
+// Quote offers a customer products at quoted prices until it expires
+type Quote struct {
+	ID           string     `json:"id"`
+	Tenant       string     `json:"tenant"`
+	CustomerID   string     `json:"customer_id"`
+	CustomerName string     `json:"customer_name"`
+	IssuedBy     string     `json:"issued_by"`
+	Currency     string     `json:"currency"`
+	LineItems    []LineItem `json:"line_items"`
+	TotalCents   int64      `json:"total_cents"`
+	// RainWindow is the rain forecast for the customer when the quote was issued, which the quote's validity is aligned to
+	RainWindow *RainWindow `json:"rain_window,omitempty"`
+	ValidFrom  time.Time   `json:"valid_from"`
+	ValidUntil time.Time   `json:"valid_until"`
+	Status     QuoteStatus `json:"status"`
+	// OrderID is the order that the quote was converted to
+	OrderID   string    `json:"order_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Expired returns true if the quote can no longer be ordered because its validity ended before the specified time
+func (quote Quote) Expired(now time.Time) bool {
+	return now.After(quote.ValidUntil)
+}
+
+// OrderStatus is the status of an Order
+type OrderStatus string
+
+// Supported OrderStatus values
+const (
+	OrderStatusPending   = OrderStatus("pending")
+	OrderStatusConfirmed = OrderStatus("confirmed")
+	OrderStatusShipped   = OrderStatus("shipped")
+	OrderStatusDelivered = OrderStatus("delivered")
+	OrderStatusCancelled = OrderStatus("cancelled")
+)
+
+// orderTransitions are the statuses that orders of each status can move to. Shipped orders can no longer be cancelled
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPending:   {OrderStatusConfirmed, OrderStatusCancelled},
+	OrderStatusConfirmed: {OrderStatusShipped, OrderStatusCancelled},
+	OrderStatusShipped:   {OrderStatusDelivered},
+}
+
+// Validate verifies that the status is supported
+func (status OrderStatus) Validate() error {
+	switch status {
+	case OrderStatusPending, OrderStatusConfirmed, OrderStatusShipped, OrderStatusDelivered, OrderStatusCancelled:
+		return nil
+	}
+	return fmt.Errorf("Unsupported order status: %s", status)
+}
+
+// CanMoveTo returns true if orders of the status can move to the next status
+func (status OrderStatus) CanMoveTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[status] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusChange records an order moving to a status
+type StatusChange struct {
+	Status OrderStatus `json:"status"`
+	By     string      `json:"by"`
+	At     time.Time   `json:"at"`
+}
+
+// Order is a quote that a customer accepted. The ordered stock is reserved at a warehouse until the order ships or is cancelled
+type Order struct {
+	ID           string     `json:"id"`
+	Tenant       string     `json:"tenant"`
+	QuoteID      string     `json:"quote_id"`
+	CustomerID   string     `json:"customer_id"`
+	CustomerName string     `json:"customer_name"`
+	Currency     string     `json:"currency"`
+	LineItems    []LineItem `json:"line_items"`
+	TotalCents   int64      `json:"total_cents"`
+	WarehouseID  string     `json:"warehouse_id"`
+	// ReservationIDs are the catalog reservations holding the order's stock
+	ReservationIDs []string       `json:"reservation_ids"`
+	Status         OrderStatus    `json:"status"`
+	History        []StatusChange `json:"history"`
+	CreatedAt      time.Time      `json:"created_at"`
+}
+
+// state is the persisted data of a Store
+type state struct {
+	Quotes []Quote `json:"quotes"`
+	Orders []Order `json:"orders"`
+}
+
+// Store stores every tenant's quotes and orders, persisting them to a file. It's safe for concurrent use
+type Store struct {
+	path string
+
+	mu    sync.RWMutex
+	state state
+}
+
+// Default is the store shared by the application's handlers. It's kept in memory until Load is called
+var Default, _ = New("")
+
+// New returns a Store containing the quotes and orders previously persisted to path. They're only kept in memory if path is empty
+func New(path string) (*Store, error) {
+	store := &Store{state: state{Quotes: []Quote{}, Orders: []Order{}}}
+	if path == "" {
+		return store, nil
+	}
+	if err := store.Load(path); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Load replaces the store's quotes and orders with those previously persisted to path, and persists them to path from then on
+func (store *Store) Load(path string) error {
+	loaded := state{Quotes: []Quote{}, Orders: []Order{}}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to read quotes and orders from %s: %s", path, err.Error())
+	}
+	if len(buf) > 0 {
+		if err = json.Unmarshal(buf, &loaded); err != nil {
+			return fmt.Errorf("Failed to parse quotes and orders from %s: %s", path, err.Error())
+		}
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.path, store.state = path, loaded
+	return nil
+}
+
+// CreateQuote adds the quote to the store with a new ID, as an open quote
+func (store *Store) CreateQuote(quote Quote) (Quote, error) {
+	if len(quote.LineItems) == 0 {
+		return quote, fmt.Errorf("Quotes require at least one line item")
+	}
+	if !quote.ValidUntil.After(quote.ValidFrom) {
+		return quote, fmt.Errorf("Quotes must be valid until after they're valid from")
+	}
+	quote.ID = util.NewID()
+	quote.Status = QuoteStatusOpen
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.state.Quotes = append(store.state.Quotes, quote)
+	return quote, store.save()
+}
+
+// Quotes returns the tenant's quotes, optionally only those of the specified customer, most recent first
+func (store *Store) Quotes(tenant, customerID string) []Quote {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	quotes := []Quote{}
+	for _, quote := range store.state.Quotes {
+		if quote.Tenant == tenant && (customerID == "" || quote.CustomerID == customerID) {
+			quotes = append(quotes, quote)
+		}
+	}
+	sort.SliceStable(quotes, func(i, j int) bool {
+		return quotes[i].CreatedAt.After(quotes[j].CreatedAt)
+	})
+	return quotes
+}
+
+// Quote returns the tenant's quote with the specified id
+func (store *Store) Quote(tenant, id string) (Quote, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if i := store.quoteIndex(tenant, id); i >= 0 {
+		return store.state.Quotes[i], true
+	}
+	return Quote{}, false
+}
+
+// CancelQuote cancels the tenant's open quote with the specified id
+func (store *Store) CancelQuote(tenant, id string) (Quote, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	i := store.quoteIndex(tenant, id)
+	if i < 0 {
+		return Quote{}, ErrNotFound{Kind: "quote", ID: id}
+	}
+	if store.state.Quotes[i].Status != QuoteStatusOpen {
+		return store.state.Quotes[i], ErrConflict(fmt.Sprintf("Quote %s is %s", id, store.state.Quotes[i].Status))
+	}
+	store.state.Quotes[i].Status = QuoteStatusCancelled
+	return store.state.Quotes[i], store.save()
+}
+
+// CheckOrderable returns an error if the tenant's quote with the specified id can't be ordered at the specified time, because it isn't
+// open or it expired
+func (store *Store) CheckOrderable(tenant, id string, now time.Time) (Quote, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	i := store.quoteIndex(tenant, id)
+	if i < 0 {
+		return Quote{}, ErrNotFound{Kind: "quote", ID: id}
+	}
+	return store.state.Quotes[i], checkOrderable(store.state.Quotes[i], now)
+}
+
+func checkOrderable(quote Quote, now time.Time) error {
+	if quote.Status != QuoteStatusOpen {
+		return ErrConflict(fmt.Sprintf("Quote %s is %s", quote.ID, quote.Status))
+	}
+	if quote.Expired(now) {
+		return ErrConflict(fmt.Sprintf("Quote %s expired at %s", quote.ID, quote.ValidUntil.UTC().Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// PlaceOrder converts the quote specified by the order's QuoteID into the order, which is added to the store as pending with a new ID.
+// The quote must still be orderable at the specified time
+func (store *Store) PlaceOrder(order Order, by string, now time.Time) (Order, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	i := store.quoteIndex(order.Tenant, order.QuoteID)
+	if i < 0 {
+		return order, ErrNotFound{Kind: "quote", ID: order.QuoteID}
+	}
+	quote := store.state.Quotes[i]
+	if err := checkOrderable(quote, now); err != nil {
+		return order, err
+	}
+
+	order.ID = util.NewID()
+	order.CustomerID, order.CustomerName = quote.CustomerID, quote.CustomerName
+	order.Currency, order.LineItems, order.TotalCents = quote.Currency, quote.LineItems, quote.TotalCents
+	order.Status = OrderStatusPending
+	order.History = []StatusChange{{Status: OrderStatusPending, By: by, At: now}}
+	order.CreatedAt = now
+	store.state.Orders = append(store.state.Orders, order)
+	store.state.Quotes[i].Status = QuoteStatusOrdered
+	store.state.Quotes[i].OrderID = order.ID
+	return order, store.save()
+}
+
+// Orders returns the tenant's orders, optionally only those of the specified customer and status, most recent first
+func (store *Store) Orders(tenant, customerID string, status OrderStatus) []Order {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	orders := []Order{}
+	for _, order := range store.state.Orders {
+		if order.Tenant == tenant && (customerID == "" || order.CustomerID == customerID) && (status == "" || order.Status == status) {
+			orders = append(orders, order)
+		}
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.After(orders[j].CreatedAt)
+	})
+	return orders
+}
+
+// Order returns the tenant's order with the specified id
+func (store *Store) Order(tenant, id string) (Order, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if i := store.orderIndex(tenant, id); i >= 0 {
+		return store.state.Orders[i], true
+	}
+	return Order{}, false
+}
+
+// SetOrderStatus moves the tenant's order with the specified id to the status, if its current status allows it
+func (store *Store) SetOrderStatus(tenant, id string, status OrderStatus, by string, now time.Time) (Order, error) {
+	if err := status.Validate(); err != nil {
+		return Order{}, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	i := store.orderIndex(tenant, id)
+	if i < 0 {
+		return Order{}, ErrNotFound{Kind: "order", ID: id}
+	}
+	order := &store.state.Orders[i]
+	if !order.Status.CanMoveTo(status) {
+		return *order, ErrConflict(fmt.Sprintf("Orders that are %s can't be %s", order.Status, status))
+	}
+	order.Status = status
+	order.History = append(order.History, StatusChange{Status: status, By: by, At: now})
+	return *order, store.save()
+}
+
+// save persists the quotes and orders to the store's path. The caller must hold the lock
+func (store *Store) save() error {
+	if store.path == "" {
+		return nil
+	}
+	buf, err := json.Marshal(store.state)
+	if err != nil {
+		return err
+	}
+	if err = util.WriteFileAtomic(store.path, buf); err != nil {
+		return fmt.Errorf("Failed to save quotes and orders: %s", err.Error())
+	}
+	return nil
+}
+
+// quoteIndex returns the position of the tenant's quote with the specified id, or -1. The caller must hold the lock
+func (store *Store) quoteIndex(tenant, id string) int {
+	for i, quote := range store.state.Quotes {
+		if quote.ID == id && quote.Tenant == tenant {
+			return i
+		}
+	}
+	return -1
+}
+
+// orderIndex returns the position of the tenant's order with the specified id, or -1. The caller must hold the lock
+func (store *Store) orderIndex(tenant, id string) int {
+	for i, order := range store.state.Orders {
+		if order.ID == id && order.Tenant == tenant {
+			return i
+		}
+	}
+	return -1
+}
+
+// ErrNotFound is returned when a quote or order with the specified id doesn't exist
+type ErrNotFound struct {
+	Kind string
+	ID   string
+}
+
+func (err ErrNotFound) Error() string {
+	return fmt.Sprintf("Failed to locate %s with id: %s", err.Kind, err.ID)
+}
+
+// ErrConflict is returned when a quote or order's status doesn't allow a change
+type ErrConflict string
+
+func (err ErrConflict) Error() string {
+	return string(err)
+}
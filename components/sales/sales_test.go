@@ -0,0 +1,115 @@
+package sales
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2017, 2, 16, 12, 0, 0, 0, time.UTC)
+
+func testQuote(tenant, customerID string) Quote {
+	return Quote{
+		Tenant:     tenant,
+		CustomerID: customerID,
+		Currency:   "USD",
+		LineItems:  []LineItem{{ProductID: "p1", SKU: "UMB-1", Quantity: 10, UnitPriceCents: 1500, TotalCents: 15000}},
+		TotalCents: 15000,
+		ValidFrom:  now,
+		ValidUntil: now.AddDate(0, 0, 3),
+		CreatedAt:  now,
+	}
+}
+
+func TestOrderStatus(t *testing.T) {
+	tests := []struct {
+		from OrderStatus
+		to   OrderStatus
+		exp  bool
+	}{
+		{from: OrderStatusPending, to: OrderStatusConfirmed, exp: true},
+		{from: OrderStatusPending, to: OrderStatusShipped, exp: false},
+		{from: OrderStatusConfirmed, to: OrderStatusCancelled, exp: true},
+		{from: OrderStatusShipped, to: OrderStatusCancelled, exp: false},
+		{from: OrderStatusShipped, to: OrderStatusDelivered, exp: true},
+		{from: OrderStatusDelivered, to: OrderStatusPending, exp: false},
+		{from: OrderStatusCancelled, to: OrderStatusConfirmed, exp: false},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.from)+" to "+string(test.to), func(t *testing.T) {
+			assert.Equal(t, test.exp, test.from.CanMoveTo(test.to))
+		})
+	}
+	assert.EqualError(t, OrderStatus("lost").Validate(), "Unsupported order status: lost")
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sales")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sales.json")
+
+	store, err := New(path)
+	assert.NoError(t, err)
+
+	invalid := testQuote("", "c1")
+	invalid.LineItems = nil
+	_, err = store.CreateQuote(invalid)
+	assert.EqualError(t, err, "Quotes require at least one line item")
+
+	quote, err := store.CreateQuote(testQuote("", "c1"))
+	assert.NoError(t, err)
+	assert.Equal(t, QuoteStatusOpen, quote.Status)
+	other, err := store.CreateQuote(testQuote("globex", "c1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Quote{quote}, store.Quotes("", "c1"))
+	assert.Equal(t, []Quote{}, store.Quotes("", "c2"))
+	_, ok := store.Quote("", other.ID)
+	assert.False(t, ok)
+
+	// Expired quotes can't be ordered
+	_, err = store.PlaceOrder(Order{QuoteID: quote.ID}, "rep", now.AddDate(0, 0, 4))
+	assert.IsType(t, ErrConflict(""), err)
+	_, err = store.PlaceOrder(Order{QuoteID: "missing"}, "rep", now)
+	assert.Equal(t, ErrNotFound{Kind: "quote", ID: "missing"}, err)
+
+	order, err := store.PlaceOrder(Order{QuoteID: quote.ID, WarehouseID: "w1", ReservationIDs: []string{"r1"}}, "rep", now)
+	assert.NoError(t, err)
+	assert.Equal(t, OrderStatusPending, order.Status)
+	assert.Equal(t, quote.LineItems, order.LineItems)
+	assert.Equal(t, "c1", order.CustomerID)
+	_, err = store.PlaceOrder(Order{QuoteID: quote.ID}, "rep", now)
+	assert.Equal(t, ErrConflict("Quote "+quote.ID+" is ordered"), err)
+	_, err = store.CancelQuote("", quote.ID)
+	assert.IsType(t, ErrConflict(""), err)
+
+	_, err = store.SetOrderStatus("", order.ID, OrderStatusShipped, "rep", now)
+	assert.Equal(t, ErrConflict("Orders that are pending can't be shipped"), err)
+	_, err = store.SetOrderStatus("", order.ID, OrderStatusConfirmed, "admin", now.Add(time.Hour))
+	assert.NoError(t, err)
+
+	// Quotes and orders survive restarts
+	restarted, err := New(path)
+	assert.NoError(t, err)
+	found, ok := restarted.Quote("", quote.ID)
+	assert.True(t, ok)
+	assert.Equal(t, order.ID, found.OrderID)
+	orders := restarted.Orders("", "c1", OrderStatusConfirmed)
+	assert.Len(t, orders, 1)
+	assert.Equal(t, []StatusChange{{Status: OrderStatusPending, By: "rep", At: now}, {Status: OrderStatusConfirmed, By: "admin", At: now.Add(time.Hour)}}, orders[0].History)
+	assert.Equal(t, []Order{}, restarted.Orders("", "", OrderStatusPending))
+	assert.Equal(t, []Order{}, restarted.Orders("globex", "", ""))
+
+	loaded, _ := New("")
+	assert.NoError(t, loaded.Load(path))
+	assert.Len(t, loaded.Quotes("", "c1"), 1)
+
+	cancelled, err := restarted.CancelQuote("globex", other.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, QuoteStatusCancelled, cancelled.Status)
+}
@@ -0,0 +1,249 @@
+package searchindex
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Match is a document that matched a search query
+type Match struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// Scores awarded to a document for each query token, based on how the token matched one of the document's terms
+const (
+	exactMatchScore  = 3
+	prefixMatchScore = 2
+	typoMatchScore   = 1
+)
+
+// Index is an in-memory inverted index supporting exact, prefix and typo tolerant matching of terms. It is safe for concurrent use
+type Index struct {
+	mu sync.RWMutex
+	// postings maps each term to the documents that contain it
+	postings map[string]map[string]bool
+	// documents maps each document to its terms so that it can be removed from postings
+	documents map[string][]string
+	// terms is the sorted list of postings keys, used for prefix lookups. It's rebuilt lazily when stale
+	terms      []string
+	termsStale bool
+}
+
+// New returns an empty Index
+func New() *Index {
+	return &Index{
+		postings:  map[string]map[string]bool{},
+		documents: map[string][]string{},
+	}
+}
+
+// Put indexes a document's text fields, replacing any previously indexed fields of the document
+func (index *Index) Put(id string, fields ...string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.remove(id)
+
+	terms := map[string]bool{}
+	for _, field := range fields {
+		for _, term := range Tokenize(field) {
+			terms[term] = true
+		}
+	}
+
+	for term := range terms {
+		if index.postings[term] == nil {
+			index.postings[term] = map[string]bool{}
+			index.termsStale = true
+		}
+		index.postings[term][id] = true
+		index.documents[id] = append(index.documents[id], term)
+	}
+}
+
+// Remove removes a document from the index
+func (index *Index) Remove(id string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.remove(id)
+}
+
+func (index *Index) remove(id string) {
+	for _, term := range index.documents[id] {
+		delete(index.postings[term], id)
+		if len(index.postings[term]) == 0 {
+			delete(index.postings, term)
+			index.termsStale = true
+		}
+	}
+	delete(index.documents, id)
+}
+
+// Search returns up to limit documents matching every token in the query, best matches first. Each query token matches a document term
+// exactly, as a prefix of the term, or within a small number of typos depending on the length of the token
+func (index *Index) Search(query string, limit int) []Match {
+	tokens := Tokenize(query)
+	if len(tokens) == 0 || limit <= 0 {
+		return nil
+	}
+
+	index.mu.Lock()
+	if index.termsStale {
+		index.rebuildTerms()
+	}
+	index.mu.Unlock()
+
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	var scores map[string]float64
+	for _, token := range tokens {
+		tokenScores := index.scoreToken(token)
+		if scores == nil {
+			scores = tokenScores
+			continue
+		}
+
+		// Documents must match every token
+		for id, score := range scores {
+			tokenScore, ok := tokenScores[id]
+			if !ok {
+				delete(scores, id)
+				continue
+			}
+			scores[id] = score + tokenScore
+		}
+	}
+
+	matches := make([]Match, 0, len(scores))
+	for id, score := range scores {
+		matches = append(matches, Match{ID: id, Score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// scoreToken returns the best score of each document with a term matching the token. The caller must hold the read lock
+func (index *Index) scoreToken(token string) map[string]float64 {
+	scores := map[string]float64{}
+	award := func(term string, score float64) {
+		for id := range index.postings[term] {
+			if score > scores[id] {
+				scores[id] = score
+			}
+		}
+	}
+
+	// Terms prefixed by the token are contiguous in the sorted terms list
+	start := sort.SearchStrings(index.terms, token)
+	for i := start; i < len(index.terms) && strings.HasPrefix(index.terms[i], token); i++ {
+		if index.terms[i] == token {
+			award(index.terms[i], exactMatchScore)
+		} else {
+			award(index.terms[i], prefixMatchScore)
+		}
+	}
+
+	maxTypos := allowedTypos(token)
+	if maxTypos == 0 {
+		return scores
+	}
+	for _, term := range index.terms {
+		if abs(len(term)-len(token)) > maxTypos || strings.HasPrefix(term, token) {
+			continue
+		}
+		if editDistance(token, term, maxTypos) <= maxTypos {
+			award(term, typoMatchScore)
+		}
+	}
+	return scores
+}
+
+// rebuildTerms refreshes the sorted terms list. The caller must hold the write lock
+func (index *Index) rebuildTerms() {
+	index.terms = make([]string, 0, len(index.postings))
+	for term := range index.postings {
+		index.terms = append(index.terms, term)
+	}
+	sort.Strings(index.terms)
+	index.termsStale = false
+}
+
+// allowedTypos returns the number of typos tolerated for a token. Short tokens must match exactly or as a prefix, otherwise almost
+// everything would match them
+func allowedTypos(token string) int {
+	switch length := len([]rune(token)); {
+	case length < 4:
+		return 0
+	case length < 8:
+		return 1
+	}
+	return 2
+}
+
+// Tokenize splits text into lower case terms made up of letters and digits
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// editDistance returns the Levenshtein distance between a and b. It stops early and returns max + 1 once the distance is known to
+// exceed max
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(vals ...int) int {
+	result := vals[0]
+	for _, val := range vals[1:] {
+		if val < result {
+			result = val
+		}
+	}
+	return result
+}
+
+func abs(val int) int {
+	if val < 0 {
+		return -val
+	}
+	return val
+}
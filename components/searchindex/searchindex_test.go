@@ -0,0 +1,94 @@
+package searchindex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch(t *testing.T) {
+	index := New()
+	index.Put("1", "Acme Umbrellas", "Toronto")
+	index.Put("2", "Acme Rain Gear", "Chicago")
+	index.Put("3", "Bravo Inc", "Toronto")
+	index.Put("4", "Temporary", "Toronto")
+	index.Remove("4")
+
+	tests := []struct {
+		name       string
+		query      string
+		limit      int
+		expMatches []Match
+	}{
+		{
+			name:       "exact matches rank above prefix matches",
+			query:      "acme",
+			limit:      10,
+			expMatches: []Match{{ID: "1", Score: exactMatchScore}, {ID: "2", Score: exactMatchScore}},
+		},
+		{
+			name:       "every token must match",
+			query:      "Toronto, ACME!",
+			limit:      10,
+			expMatches: []Match{{ID: "1", Score: 2 * exactMatchScore}},
+		},
+		{
+			name:       "prefix",
+			query:      "umb",
+			limit:      10,
+			expMatches: []Match{{ID: "1", Score: prefixMatchScore}},
+		},
+		{
+			name:       "typo",
+			query:      "chicgo",
+			limit:      10,
+			expMatches: []Match{{ID: "2", Score: typoMatchScore}},
+		},
+		{
+			name:       "short tokens don't tolerate typos",
+			query:      "inx",
+			limit:      10,
+			expMatches: []Match{},
+		},
+		{
+			name:       "removed documents aren't matched",
+			query:      "temporary",
+			limit:      10,
+			expMatches: []Match{},
+		},
+		{
+			name:       "limit",
+			query:      "toronto",
+			limit:      1,
+			expMatches: []Match{{ID: "1", Score: exactMatchScore}},
+		},
+		{
+			name:       "empty query",
+			query:      "  ",
+			limit:      10,
+			expMatches: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expMatches, index.Search(test.query, test.limit))
+		})
+	}
+}
+
+func TestPutReplacesFields(t *testing.T) {
+	index := New()
+	index.Put("1", "Toronto")
+	index.Put("1", "Chicago")
+
+	assert.Empty(t, index.Search("toronto", 10))
+	assert.Len(t, index.Search("chicago", 10), 1)
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("acme", "acme", 2))
+	assert.Equal(t, 1, editDistance("acme", "acne", 2))
+	assert.Equal(t, 2, editDistance("toronto", "torotno", 2))
+	assert.Equal(t, 3, editDistance("toronto", "chicago", 2))
+}
@@ -0,0 +1,609 @@
+package segment
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/models"
+	"unicode"
+)
+
+// rainPeriod is the length of the forecast periods that rain is reported for, which is the interval of OpenWeatherMap's forecasts
+const rainPeriod = 3 * time.Hour
+
+// ParseError describes why an expression couldn't be parsed, along with where
+type ParseError struct {
+	// Column is the 1-based position in the expression that the error was found at
+	Column  int
+	Message string
+}
+
+func (err ParseError) Error() string {
+	return fmt.Sprintf("Parse error at column %d: %s", err.Column, err.Message)
+}
+
+// valueType is the type that an expression evaluates to
+type valueType string
+
+const (
+	typeBool     = valueType("boolean")
+	typeNumber   = valueType("number")
+	typeString   = valueType("string")
+	typeDuration = valueType("duration")
+)
+
+// env is what expressions are evaluated against. Forecasts only cover weatherforecaster.ForecastRange, so rain is measured from
+// forecastStart rather than now
+type env struct {
+	customer      models.Customer
+	now           time.Time
+	forecastStart time.Time
+}
+
+// node is a parsed expression. Every node's type is checked when it's parsed, so evaluating it always returns a value of its type: a
+// bool, float64, string or time.Duration
+type node interface {
+	typ() valueType
+	eval(e env) interface{}
+}
+
+// fields are the customer fields that expressions can refer to
+var fields = map[string]struct {
+	typ   valueType
+	value func(customer models.Customer) interface{}
+}{
+	"name":          {typeString, func(c models.Customer) interface{} { return c.Name }},
+	"contact":       {typeString, func(c models.Customer) interface{} { return c.Contact }},
+	"city":          {typeString, func(c models.Customer) interface{} { return c.Address.City }},
+	"country":       {typeString, func(c models.Customer) interface{} { return c.Address.CountryCode }},
+	"num_employees": {typeNumber, func(c models.Customer) interface{} { return float64(c.NumEmployees) }},
+	"rain_days":     {typeNumber, func(c models.Customer) interface{} { return float64(c.RainDays()) }},
+	"pipeline_status": {typeString, func(c models.Customer) interface{} {
+		if c.PipelineStatus == "" {
+			return string(models.PipelineStatusProspect)
+		}
+		return string(c.PipelineStatus)
+	}},
+	"lead_score": {typeNumber, func(c models.Customer) interface{} {
+		if c.Lead == nil {
+			return float64(0)
+		}
+		return c.Lead.Total
+	}},
+}
+
+// functions are the functions that expressions can call. Each takes a single duration, measured from the start of the forecasts for rain
+// and from when the expression is evaluated otherwise
+var functions = map[string]struct {
+	typ  valueType
+	call func(e env, within time.Duration) interface{}
+}{
+	// rain_hours_next returns the hours of rain forecast within the duration from the start of the forecasts
+	"rain_hours_next": {typeNumber, func(e env, within time.Duration) interface{} {
+		periods := 0
+		for _, weather := range e.customer.WeatherDetails {
+			if weather.Type == models.WeatherTypeRain && !weather.Date.Before(e.forecastStart) && !weather.Date.After(e.forecastStart.Add(within)) {
+				periods++
+			}
+		}
+		return float64(periods) * rainPeriod.Hours()
+	}},
+	// has_rain_within returns true if rain is forecast within the duration from the start of the forecasts
+	"has_rain_within": {typeBool, func(e env, within time.Duration) interface{} {
+		return e.customer.HasRainWithin(e.forecastStart, within)
+	}},
+	// contacted_within returns true if the customer was last contacted within the duration before now
+	"contacted_within": {typeBool, func(e env, within time.Duration) interface{} {
+		return e.customer.LastContacted != nil && !e.customer.LastContacted.Before(e.now.Add(-within))
+	}},
+}
+
+type literal struct {
+	valueType valueType
+	value     interface{}
+}
+
+func (n literal) typ() valueType         { return n.valueType }
+func (n literal) eval(e env) interface{} { return n.value }
+
+type field struct {
+	name string
+}
+
+func (n field) typ() valueType         { return fields[n.name].typ }
+func (n field) eval(e env) interface{} { return fields[n.name].value(e.customer) }
+
+type call struct {
+	name string
+	arg  node
+}
+
+func (n call) typ() valueType { return functions[n.name].typ }
+func (n call) eval(e env) interface{} {
+	return functions[n.name].call(e, n.arg.eval(e).(time.Duration))
+}
+
+type not struct {
+	operand node
+}
+
+func (n not) typ() valueType         { return typeBool }
+func (n not) eval(e env) interface{} { return !n.operand.eval(e).(bool) }
+
+type logical struct {
+	and         bool
+	left, right node
+}
+
+func (n logical) typ() valueType { return typeBool }
+func (n logical) eval(e env) interface{} {
+	left := n.left.eval(e).(bool)
+	if n.and != left {
+		// Short circuits: false and ..., true or ...
+		return left
+	}
+	return n.right.eval(e).(bool)
+}
+
+type comparison struct {
+	op          string
+	left, right node
+}
+
+func (n comparison) typ() valueType { return typeBool }
+func (n comparison) eval(e env) interface{} {
+	return compare(n.op, n.left.eval(e), n.right.eval(e))
+}
+
+type in struct {
+	operand node
+	values  []node
+}
+
+func (n in) typ() valueType { return typeBool }
+func (n in) eval(e env) interface{} {
+	value := n.operand.eval(e)
+	for _, candidate := range n.values {
+		if compare("=", value, candidate.eval(e)) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare applies the comparison operator to values of the same type. Strings are compared case insensitively
+func compare(op string, left, right interface{}) bool {
+	var cmp int
+	switch l := left.(type) {
+	case string:
+		cmp = strings.Compare(strings.ToLower(l), strings.ToLower(right.(string)))
+	case float64:
+		cmp = compareNumbers(l, right.(float64))
+	case time.Duration:
+		cmp = compareNumbers(float64(l), float64(right.(time.Duration)))
+	case bool:
+		if l != right.(bool) {
+			cmp = 1
+		}
+	}
+
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+func compareNumbers(left, right float64) int {
+	switch {
+	case left < right:
+		return -1
+	case left > right:
+		return 1
+	}
+	return 0
+}
+
+// Expression is a parsed segment expression, e.g. country in ("CA", "US") and num_employees >= 50 and rain_hours_next(48h) > 6
+type Expression struct {
+	text string
+	root node
+}
+
+// String returns the text that the expression was parsed from
+func (expression Expression) String() string {
+	return expression.text
+}
+
+// Matches returns true if the customer satisfies the expression when it's evaluated at now. Rain functions are measured from the start of
+// weatherforecaster.ForecastRange instead, since that's the range that forecasts cover
+func (expression Expression) Matches(customer models.Customer, now time.Time) bool {
+	return expression.root.eval(env{customer: customer, now: now, forecastStart: weatherforecaster.ForecastRange().Start}).(bool)
+}
+
+// Parse parses the text of an expression. Expressions combine comparisons with and, or, not and parentheses. Comparisons compare fields,
+// function calls and literals of the same type with =, !=, <, <=, >, >= or in (...). Strings are compared case insensitively. Numbers
+// may be negative and have an exponent, e.g. -1.5 or 2e3.
+//
+// Fields: name, contact, city, country (the country code), num_employees, rain_days, pipeline_status and lead_score.
+//
+// Functions, taking a duration such as 48h, 30m or 7d: rain_hours_next(duration), has_rain_within(duration) and
+// contacted_within(duration). Rain functions measure the duration from the start of the forecasts, contacted_within from now.
+func Parse(text string) (Expression, error) {
+	tokens, err := lex(text)
+	if err != nil {
+		return Expression{}, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return Expression{}, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return Expression{}, p.errorf(next, "unexpected %s", next)
+	}
+	if root.typ() != typeBool {
+		return Expression{}, ParseError{Column: 1, Message: fmt.Sprintf("expression must be a condition, not a %s", root.typ())}
+	}
+	return Expression{text: text, root: root}, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenDuration
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	value  interface{}
+	column int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.value.(string))
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// isKeyword returns true if the token is the keyword, case insensitive
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+// lex splits the text into tokens
+func lex(text string) ([]token, error) {
+	runes := []rune(text)
+	tokens := []token{}
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", column: start + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", column: start + 1})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", column: start + 1})
+			i++
+		case strings.ContainsRune("=!<>", r):
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				i++
+			}
+			op := string(runes[start:i])
+			if op == "!" {
+				return nil, ParseError{Column: start + 1, Message: `"!" must be followed by "=", use not to negate conditions`}
+			}
+			if op == "==" {
+				op = "="
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, column: start + 1})
+		case r == '"' || r == '\'':
+			var value strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, ParseError{Column: start + 1, Message: "unterminated string"}
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: string(runes[start:i]), value: value.String(), column: start + 1})
+		case isNumberStart(runes, i):
+			t, err := lexNumber(runes, start)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, t)
+			i += len([]rune(t.text))
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[start:i]), column: start + 1})
+		default:
+			return nil, ParseError{Column: start + 1, Message: fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, column: len(runes) + 1}), nil
+}
+
+// isNumberStart returns true if a number starts at i. A minus sign only starts a number when it's followed by a digit or decimal point
+func isNumberStart(runes []rune, i int) bool {
+	if runes[i] == '-' {
+		i++
+	}
+	return i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.')
+}
+
+// lexNumber lexes the number starting at start, e.g. -1.5 or 2e3, or the duration if it's followed by a unit, e.g. 48h
+func lexNumber(runes []rune, start int) (token, error) {
+	i := start
+	if runes[i] == '-' {
+		i++
+	}
+	for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+		i++
+	}
+	if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+		i++
+		if i < len(runes) && (runes[i] == '+' || runes[i] == '-') {
+			i++
+		}
+		for i < len(runes) && unicode.IsDigit(runes[i]) {
+			i++
+		}
+	}
+	number := string(runes[start:i])
+
+	unitStart := i
+	for i < len(runes) && unicode.IsLetter(runes[i]) {
+		i++
+	}
+	if unit := string(runes[unitStart:i]); unit != "" {
+		duration, err := parseDuration(number, unit)
+		if err != nil {
+			return token{}, ParseError{Column: start + 1, Message: err.Error()}
+		}
+		if duration < 0 {
+			return token{}, ParseError{Column: start + 1, Message: fmt.Sprintf("duration %q can't be negative", number+unit)}
+		}
+		return token{kind: tokenDuration, text: number + unit, value: duration, column: start + 1}, nil
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return token{}, ParseError{Column: start + 1, Message: fmt.Sprintf("invalid number %q", number)}
+	}
+	return token{kind: tokenNumber, text: number, value: value, column: start + 1}, nil
+}
+
+// parseDuration parses a duration such as 48h. Days are supported in addition to the units of time.ParseDuration
+func parseDuration(number, unit string) (time.Duration, error) {
+	if unit == "d" {
+		days, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", number+unit)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	duration, err := time.ParseDuration(number + unit)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, use a unit of d, h, m or s", number+unit)
+	}
+	return duration, nil
+}
+
+// parser is a recursive descent parser of the tokens of an expression
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return ParseError{Column: t.column, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) expect(kind tokenKind, description string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, p.errorf(t, "expected %s but found %s", description, t)
+	}
+	return t, nil
+}
+
+// parseOr parses: and ("or" and)*
+func (p *parser) parseOr() (node, error) {
+	return p.parseLogical("or", false, p.parseAnd)
+}
+
+// parseAnd parses: not ("and" not)*
+func (p *parser) parseAnd() (node, error) {
+	return p.parseLogical("and", true, p.parseNot)
+}
+
+func (p *parser) parseLogical(keyword string, and bool, operand func() (node, error)) (node, error) {
+	start := p.peek()
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword(keyword) {
+		op := p.next()
+		if left.typ() != typeBool {
+			return nil, p.errorf(start, "%s requires conditions, but the left side is a %s", keyword, left.typ())
+		}
+		rightStart := p.peek()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if right.typ() != typeBool {
+			return nil, p.errorf(rightStart, "%s at column %d requires conditions, but the right side is a %s", keyword, op.column, right.typ())
+		}
+		left = logical{and: and, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseNot parses: "not" not | comparison
+func (p *parser) parseNot() (node, error) {
+	if !p.peek().isKeyword("not") {
+		return p.parseComparison()
+	}
+	p.next()
+	start := p.peek()
+	operand, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	if operand.typ() != typeBool {
+		return nil, p.errorf(start, "not requires a condition, but found a %s", operand.typ())
+	}
+	return not{operand: operand}, nil
+}
+
+// parseComparison parses: operand ((operator operand) | ("in" "(" operand ("," operand)* ")"))?
+func (p *parser) parseComparison() (node, error) {
+	start := p.peek()
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch next := p.peek(); {
+	case next.kind == tokenOperator:
+		p.next()
+		rightStart := p.peek()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if left.typ() != right.typ() {
+			return nil, p.errorf(rightStart, "can't compare %s %s to %s %s", left.typ(), start, right.typ(), rightStart)
+		}
+		if next.text != "=" && next.text != "!=" && left.typ() == typeBool {
+			return nil, p.errorf(next, "%s can't compare conditions, use = or !=", next.text)
+		}
+		return comparison{op: next.text, left: left, right: right}, nil
+
+	case next.isKeyword("in"):
+		p.next()
+		if _, err := p.expect(tokenLParen, `"(" after in`); err != nil {
+			return nil, err
+		}
+		list := in{operand: left}
+		for {
+			valueStart := p.peek()
+			value, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			if value.typ() != left.typ() {
+				return nil, p.errorf(valueStart, "in list of %s %s contains %s %s", left.typ(), start, value.typ(), valueStart)
+			}
+			list.values = append(list.values, value)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(tokenRParen, `"," or ")"`); err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+	return left, nil
+}
+
+// parseOperand parses: literal | field | function "(" duration ")" | "(" or ")"
+func (p *parser) parseOperand() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		return literal{valueType: typeNumber, value: t.value}, nil
+	case tokenString:
+		return literal{valueType: typeString, value: t.value}, nil
+	case tokenDuration:
+		return literal{valueType: typeDuration, value: t.value}, nil
+	case tokenLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, `")"`); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	case tokenIdent:
+		name := strings.ToLower(t.text)
+		switch name {
+		case "true", "false":
+			return literal{valueType: typeBool, value: name == "true"}, nil
+		case "and", "or", "not", "in":
+			return nil, p.errorf(t, "expected a field, function or value but found %s", t)
+		}
+		if _, ok := fields[name]; ok {
+			return field{name: name}, nil
+		}
+		if _, ok := functions[name]; !ok {
+			return nil, p.errorf(t, "unknown field or function %s", t)
+		}
+		if _, err := p.expect(tokenLParen, fmt.Sprintf(`"(" after %s`, name)); err != nil {
+			return nil, err
+		}
+		arg, err := p.expect(tokenDuration, fmt.Sprintf("a duration such as 48h as the argument of %s", name))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, `")"`); err != nil {
+			return nil, err
+		}
+		return call{name: name, arg: literal{valueType: typeDuration, value: arg.value}}, nil
+	}
+	return nil, p.errorf(t, "expected a field, function or value but found %s", t)
+}
@@ -0,0 +1,100 @@
+package segment
+
+import (
+	"testing"
+	"time"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+
+// toronto has 9 hours of rain forecast within the next 48 hours, and another 3 hours after that
+var toronto = models.Customer{
+	Name:           "Toronto Company",
+	NumEmployees:   120,
+	PipelineStatus: models.PipelineStatusNegotiating,
+	Address:        models.Address{City: "Toronto", Country: "Canada", CountryCode: "CA"},
+	WeatherDetails: []models.Weather{
+		{Date: now.Add(3 * time.Hour), Type: models.WeatherTypeRain},
+		{Date: now.Add(6 * time.Hour), Type: models.WeatherTypeRain},
+		{Date: now.Add(30 * time.Hour), Type: models.WeatherTypeRain},
+		{Date: now.Add(72 * time.Hour), Type: models.WeatherTypeRain},
+	},
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		expression string
+		exp        bool
+	}{
+		{`country in ("CA","US") and num_employees >= 50 and rain_hours_next(48h) > 6`, true},
+		{`rain_hours_next(48h) = 9`, true},
+		{`rain_hours_next(2d) > 9`, false},
+		{`rain_hours_next(7d) == 12`, true},
+		{`city = "toronto" and not (pipeline_status in ('won', 'lost'))`, true},
+		{`country != "CA" or num_employees < 100`, false},
+		{`has_rain_within(2h)`, false},
+		{`has_rain_within(180m) and rain_days >= 2`, true},
+		{`contacted_within(30d)`, false},
+		{`NOT contacted_within(30d) AND lead_score = 0`, true},
+		{`name = "Toronto \"Company\""`, false},
+		{`has_rain_within(1h) = false`, true},
+		{`lead_score > -1 and lead_score >= -.5`, true},
+		{`num_employees = 1.2e2 and num_employees < 1E+3 and num_employees > 12000e-2`, false},
+		{`num_employees = 1.2e2 and num_employees < 1E+3 and num_employees >= 12000e-2`, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			expression, err := Parse(test.expression)
+			assert.NoError(t, err)
+			assert.Equal(t, test.exp, expression.Matches(toronto, now))
+			assert.Equal(t, test.expression, expression.String())
+		})
+	}
+}
+
+func TestMatchesRainFromForecastStart(t *testing.T) {
+	// Forecasts only cover the forecast range, so rain is measured from its start whenever the expression is evaluated
+	expression, err := Parse(`rain_hours_next(48h) = 9 and has_rain_within(3h) and not contacted_within(30d)`)
+	assert.NoError(t, err)
+	assert.True(t, expression.Matches(toronto, time.Now()))
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		expErr     string
+	}{
+		{``, "Parse error at column 1: expected a field, function or value but found end of expression"},
+		{`num_employees`, "Parse error at column 1: expression must be a condition, not a number"},
+		{`country = "CA" and`, "Parse error at column 19: expected a field, function or value but found end of expression"},
+		{`country = 5`, `Parse error at column 11: can't compare string "country" to number "5"`},
+		{`employees > 5`, `Parse error at column 1: unknown field or function "employees"`},
+		{`num_employees >= 50 and city`, `Parse error at column 25: and at column 21 requires conditions, but the right side is a string`},
+		{`country in ("CA", 1)`, `Parse error at column 19: in list of string "country" contains number "1"`},
+		{`country in ("CA" "US")`, `Parse error at column 18: expected "," or ")" but found "US"`},
+		{`rain_hours_next(48) > 6`, `Parse error at column 17: expected a duration such as 48h as the argument of rain_hours_next but found "48"`},
+		{`rain_hours_next(48x) > 6`, `Parse error at column 17: invalid duration "48x", use a unit of d, h, m or s`},
+		{`city = "Toronto`, "Parse error at column 8: unterminated string"},
+		{`city ! "Toronto"`, `Parse error at column 6: "!" must be followed by "=", use not to negate conditions`},
+		{`city = "Toronto" )`, `Parse error at column 18: unexpected ")"`},
+		{`not city`, "Parse error at column 5: not requires a condition, but found a string"},
+		{`has_rain_within(1h) < true`, `Parse error at column 21: < can't compare conditions, use = or !=`},
+		{`city = "Toronto" # comment`, `Parse error at column 18: unexpected character '#'`},
+		{`num_employees > 1e999`, `Parse error at column 17: invalid number "1e999"`},
+		{`num_employees > 1e`, `Parse error at column 17: invalid number "1e"`},
+		{`num_employees > - 5`, `Parse error at column 17: unexpected character '-'`},
+		{`rain_hours_next(-48h) > 6`, `Parse error at column 17: duration "-48h" can't be negative`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := Parse(test.expression)
+			assert.EqualError(t, err, test.expErr)
+			assert.IsType(t, ParseError{}, err)
+		})
+	}
+}
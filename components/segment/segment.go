@@ -0,0 +1,181 @@
+package segment
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"umbrellacorp/util"
+)
+
+// Segment is a named expression selecting customers, see Parse
+type Segment struct {
+	ID          string    `json:"id"`
+	Tenant      string    `json:"tenant"`
+	Name        string    `json:"name" api:"required"`
+	Description string    `json:"description"`
+	Expression  string    `json:"expression" api:"required"`
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Validate verifies that the segment is named and that its expression parses
+func (segment Segment) Validate() error {
+	if strings.TrimSpace(segment.Name) == "" {
+		return fmt.Errorf("name required")
+	}
+	_, err := Parse(segment.Expression)
+	return err
+}
+
+// Store stores every tenant's segments, persisting them to a file. It's safe for concurrent use
+type Store struct {
+	path string
+
+	mu       sync.RWMutex
+	segments []Segment
+}
+
+// New returns a Store containing the segments previously persisted to path. They're only kept in memory if path is empty
+func New(path string) (*Store, error) {
+	store := &Store{path: path, segments: []Segment{}}
+	if path == "" {
+		return store, nil
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to read segments from %s: %s", path, err.Error())
+	}
+	if len(buf) > 0 {
+		if err = json.Unmarshal(buf, &store.segments); err != nil {
+			return nil, fmt.Errorf("Failed to parse segments from %s: %s", path, err.Error())
+		}
+	}
+	return store, nil
+}
+
+// Create adds the segment to the store with a new ID. Segment names are unique within a tenant, case insensitive
+func (store *Store) Create(segment Segment) (Segment, error) {
+	if err := segment.Validate(); err != nil {
+		return segment, err
+	}
+	segment.ID = util.NewID()
+	segment.UpdatedAt = segment.CreatedAt
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if err := store.checkName(segment); err != nil {
+		return segment, err
+	}
+	store.segments = append(store.segments, segment)
+	return segment, store.save()
+}
+
+// Update replaces the name, description and expression of the tenant's segment with the same ID
+func (store *Store) Update(segment Segment) (Segment, error) {
+	if err := segment.Validate(); err != nil {
+		return segment, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	i := store.index(segment.Tenant, segment.ID)
+	if i < 0 {
+		return segment, ErrNotFound(segment.ID)
+	}
+	if err := store.checkName(segment); err != nil {
+		return segment, err
+	}
+	existing := &store.segments[i]
+	existing.Name, existing.Description, existing.Expression, existing.UpdatedAt = segment.Name, segment.Description, segment.Expression, segment.UpdatedAt
+	return *existing, store.save()
+}
+
+// Delete removes the tenant's segment with the specified id
+func (store *Store) Delete(tenant, id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	i := store.index(tenant, id)
+	if i < 0 {
+		return ErrNotFound(id)
+	}
+	store.segments = append(store.segments[:i], store.segments[i+1:]...)
+	return store.save()
+}
+
+// Segments returns the tenant's segments, in the order they were created
+func (store *Store) Segments(tenant string) []Segment {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	segments := []Segment{}
+	for _, segment := range store.segments {
+		if segment.Tenant == tenant {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// Segment returns the tenant's segment with the specified id
+func (store *Store) Segment(tenant, id string) (Segment, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if i := store.index(tenant, id); i >= 0 {
+		return store.segments[i], true
+	}
+	return Segment{}, false
+}
+
+// checkName returns ErrConflict if another of the tenant's segments has the segment's name. The caller must hold the lock
+func (store *Store) checkName(segment Segment) error {
+	for _, existing := range store.segments {
+		if existing.Tenant == segment.Tenant && existing.ID != segment.ID && strings.EqualFold(existing.Name, segment.Name) {
+			return ErrConflict(fmt.Sprintf("An existing segment is named %s", existing.Name))
+		}
+	}
+	return nil
+}
+
+// save persists the segments to the store's path. The caller must hold the lock
+func (store *Store) save() error {
+	if store.path == "" {
+		return nil
+	}
+	buf, err := json.Marshal(store.segments)
+	if err != nil {
+		return err
+	}
+	if err = util.WriteFileAtomic(store.path, buf); err != nil {
+		return fmt.Errorf("Failed to save segments: %s", err.Error())
+	}
+	return nil
+}
+
+// index returns the position of the tenant's segment with the specified id, or -1. The caller must hold the lock
+func (store *Store) index(tenant, id string) int {
+	for i, segment := range store.segments {
+		if segment.ID == id && segment.Tenant == tenant {
+			return i
+		}
+	}
+	return -1
+}
+
+// ErrNotFound is returned when a segment with the specified id doesn't exist
+type ErrNotFound string
+
+func (err ErrNotFound) Error() string {
+	return fmt.Sprintf("Failed to locate segment with id: %s", string(err))
+}
+
+// ErrConflict is returned when a segment's name is taken
+type ErrConflict string
+
+func (err ErrConflict) Error() string {
+	return string(err)
+}
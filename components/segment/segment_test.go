@@ -0,0 +1,45 @@
+package segment
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "segments")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "segments.json")
+
+	store, err := New(path)
+	assert.NoError(t, err)
+	_, err = store.Create(Segment{Name: "Broken", Expression: "num_employees >"})
+	assert.IsType(t, ParseError{}, err)
+
+	large, err := store.Create(Segment{Name: "Large", Expression: "num_employees >= 500"})
+	assert.NoError(t, err)
+	_, err = store.Create(Segment{Name: "large", Expression: "num_employees >= 1000"})
+	assert.Equal(t, ErrConflict("An existing segment is named Large"), err)
+	_, err = store.Create(Segment{Tenant: "acme", Name: "Large", Expression: "num_employees >= 1000"})
+	assert.NoError(t, err)
+
+	large.Expression = "num_employees >= 1000"
+	_, err = store.Update(large)
+	assert.NoError(t, err)
+
+	// Segments survive restarts
+	restarted, err := New(path)
+	assert.NoError(t, err)
+	found, ok := restarted.Segment("", large.ID)
+	assert.True(t, ok)
+	assert.Equal(t, "num_employees >= 1000", found.Expression)
+	assert.Len(t, restarted.Segments("acme"), 1)
+
+	assert.NoError(t, restarted.Delete("", large.ID))
+	assert.Equal(t, ErrNotFound(large.ID), restarted.Delete("", large.ID))
+	assert.Empty(t, restarted.Segments(""))
+}
@@ -0,0 +1,139 @@
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/models"
+)
+
+// Tenant is a sales organization whose data is isolated from other tenants, along with its configuration
+type Tenant struct {
+	ID         string                           `json:"id"`
+	Name       string                           `json:"name"`
+	Forecaster weatherforecaster.ProviderConfig `json:"forecaster"`
+	AlertRules AlertRules                       `json:"alert_rules"`
+}
+
+// AlertRules configure which rain alerts are published for a tenant's customers
+type AlertRules struct {
+	// Disabled stops rain alerts from being published
+	Disabled bool `json:"disabled"`
+	// WithinHours only alerts about rain forecast to start within the number of hours. Every upcoming rain period is alerted if it's zero
+	WithinHours int `json:"within_hours"`
+	// MinEmployees only alerts about customers with at least the number of employees
+	MinEmployees int `json:"min_employees"`
+}
+
+// Filter returns the alerts about the customer that satisfy the rules at the specified time
+func (rules AlertRules) Filter(customer models.Customer, alerts []models.RainAlert, now time.Time) []models.RainAlert {
+	if rules.Disabled || customer.NumEmployees < rules.MinEmployees {
+		return nil
+	}
+
+	filtered := []models.RainAlert{}
+	for _, alert := range alerts {
+		if rules.WithinHours > 0 && alert.Date.After(now.Add(time.Duration(rules.WithinHours)*time.Hour)) {
+			continue
+		}
+		filtered = append(filtered, alert)
+	}
+	return filtered
+}
+
+// idPattern matches valid tenant ids. Ids are restricted to characters that are valid in subdomains, so that tenants can be resolved
+// from the host of requests
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Validate verifies the tenant's configuration
+func (tenant Tenant) Validate() error {
+	if !idPattern.MatchString(tenant.ID) {
+		return fmt.Errorf("Tenant ids must consist of lowercase letters, digits and hyphens: %q", tenant.ID)
+	}
+	if tenant.AlertRules.WithinHours < 0 || tenant.AlertRules.MinEmployees < 0 {
+		return fmt.Errorf("Alert rules of tenant %s can't be negative", tenant.ID)
+	}
+	return tenant.Forecaster.Validate()
+}
+
+// Registry contains the configured tenants. It's safe for concurrent use
+type Registry struct {
+	mu      sync.RWMutex
+	tenants map[string]Tenant
+}
+
+// Default is the registry shared by the application's handlers
+var Default = New()
+
+// New returns a Registry containing the specified tenants, along with the default tenant if it isn't specified
+func New(tenants ...Tenant) *Registry {
+	registry := &Registry{}
+	registry.set(tenants)
+	return registry
+}
+
+// Load replaces the registry's tenants with those listed in the json file at path
+func (registry *Registry) Load(path string) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read tenants from %s: %s", path, err.Error())
+	}
+
+	var tenants []Tenant
+	if err = json.Unmarshal(buf, &tenants); err != nil {
+		return fmt.Errorf("Failed to parse tenants from %s: %s", path, err.Error())
+	}
+	for _, tenant := range tenants {
+		if err = tenant.Validate(); err != nil {
+			return err
+		}
+	}
+
+	registry.set(tenants)
+	return nil
+}
+
+// Get returns the tenant with the specified id
+func (registry *Registry) Get(id string) (Tenant, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	tenant, ok := registry.tenants[id]
+	return tenant, ok
+}
+
+// Exists returns true if a tenant with the specified id exists
+func (registry *Registry) Exists(id string) bool {
+	_, ok := registry.Get(id)
+	return ok
+}
+
+// List returns every tenant, sorted by id
+func (registry *Registry) List() []Tenant {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	tenants := make([]Tenant, 0, len(registry.tenants))
+	for _, tenant := range registry.tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].ID < tenants[j].ID
+	})
+	return tenants
+}
+
+// set replaces the registry's tenants. The default tenant is added if it isn't specified, so that requests without a tenant can be served
+func (registry *Registry) set(tenants []Tenant) {
+	byID := map[string]Tenant{models.DefaultTenant: {ID: models.DefaultTenant, Name: "Default"}}
+	for _, tenant := range tenants {
+		byID[tenant.ID] = tenant
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.tenants = byID
+}
@@ -0,0 +1,84 @@
+package tenant
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlertRulesFilter(t *testing.T) {
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	soon := models.RainAlert{CustomerID: "1", Date: now.Add(6 * time.Hour)}
+	later := models.RainAlert{CustomerID: "1", Date: now.Add(48 * time.Hour)}
+	customer := models.Customer{ID: "1", NumEmployees: 50}
+
+	tests := []struct {
+		name      string
+		rules     AlertRules
+		expAlerts []models.RainAlert
+	}{
+		{name: "no rules", rules: AlertRules{}, expAlerts: []models.RainAlert{soon, later}},
+		{name: "within hours", rules: AlertRules{WithinHours: 24}, expAlerts: []models.RainAlert{soon}},
+		{name: "enough employees", rules: AlertRules{MinEmployees: 50}, expAlerts: []models.RainAlert{soon, later}},
+		{name: "too few employees", rules: AlertRules{MinEmployees: 51}, expAlerts: nil},
+		{name: "disabled", rules: AlertRules{Disabled: true}, expAlerts: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expAlerts, test.rules.Filter(customer, []models.RainAlert{soon, later}, now))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		tenant   Tenant
+		expError string
+	}{
+		{name: "valid", tenant: Tenant{ID: "acme-2", Forecaster: weatherforecaster.ProviderConfig{Provider: weatherforecaster.ProviderMock}}},
+		{name: "invalid id", tenant: Tenant{ID: "Acme Inc"}, expError: `Tenant ids must consist of lowercase letters, digits and hyphens: "Acme Inc"`},
+		{name: "negative rules", tenant: Tenant{ID: "acme", AlertRules: AlertRules{WithinHours: -1}}, expError: "Alert rules of tenant acme can't be negative"},
+		{name: "unknown provider", tenant: Tenant{ID: "acme", Forecaster: weatherforecaster.ProviderConfig{Provider: "darksky"}}, expError: "Unsupported forecast provider: darksky"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.tenant.Validate()
+			if test.expError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, test.expError)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenants")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tenants.json")
+
+	registry := New()
+	assert.Equal(t, []Tenant{{ID: models.DefaultTenant, Name: "Default"}}, registry.List())
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`[{"id": "acme", "name": "Acme", "alert_rules": {"within_hours": 24}}]`), 0600))
+	assert.NoError(t, registry.Load(path))
+	assert.True(t, registry.Exists("acme"))
+	assert.True(t, registry.Exists(models.DefaultTenant))
+	assert.False(t, registry.Exists("globex"))
+	acme, _ := registry.Get("acme")
+	assert.Equal(t, 24, acme.AlertRules.WithinHours)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`[{"id": "Acme"}]`), 0600))
+	assert.Error(t, registry.Load(path))
+	assert.True(t, registry.Exists("acme"))
+}
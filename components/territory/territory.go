@@ -0,0 +1,325 @@
+package territory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+)
+
+// Territory is a sales territory. A customer is within the territory if its address is in one of the territory's countries or cities, or
+// its coordinates are inside one of the territory's polygons
+type Territory struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name" api:"required"`
+	Countries []string  `json:"countries"`
+	Cities    []City    `json:"cities"`
+	Polygons  []Polygon `json:"polygons"`
+}
+
+// City is a city within a country. Cities with the same name in different countries are different cities
+type City struct {
+	Name    string `json:"name"`
+	Country string `json:"country"`
+}
+
+// Polygon is a geographic area bounded by its vertices. The last vertex connects back to the first
+type Polygon []models.Coordinates
+
+// Normalize validates the territory, and returns it with its countries translated to ISO-3166 alpha-2 codes
+func (territory Territory) Normalize() (Territory, error) {
+	if strings.TrimSpace(territory.Name) == "" {
+		return territory, fmt.Errorf("name required")
+	}
+	if len(territory.Countries) == 0 && len(territory.Cities) == 0 && len(territory.Polygons) == 0 {
+		return territory, fmt.Errorf("A territory must contain at least one country, city or polygon")
+	}
+
+	countries := []string{}
+	for _, country := range territory.Countries {
+		code, err := countryCode(country)
+		if err != nil {
+			return territory, err
+		}
+		countries = append(countries, code)
+	}
+
+	cities := []City{}
+	for _, city := range territory.Cities {
+		if city.Name == "" {
+			return territory, fmt.Errorf("Cities require a name")
+		}
+		code, err := countryCode(city.Country)
+		if err != nil {
+			return territory, err
+		}
+		cities = append(cities, City{Name: city.Name, Country: code})
+	}
+
+	for _, polygon := range territory.Polygons {
+		if len(polygon) < 3 {
+			return territory, fmt.Errorf("Polygons require at least 3 vertices")
+		}
+		for _, vertex := range polygon {
+			if err := vertex.Validate(); err != nil {
+				return territory, err
+			}
+		}
+	}
+	if territory.Polygons == nil {
+		territory.Polygons = []Polygon{}
+	}
+
+	territory.Countries, territory.Cities = countries, cities
+	return territory, nil
+}
+
+// countryCode translates a country name or code to its ISO-3166 alpha-2 code
+func countryCode(country string) (string, error) {
+	address, err := models.Address{Country: country}.SetCountryCode()
+	if err != nil {
+		return "", fmt.Errorf("Unknown country: %s", country)
+	}
+	return address.CountryCode, nil
+}
+
+// Contains returns true if the address is within the territory. The address's CountryCode must be set
+func (territory Territory) Contains(address models.Address) bool {
+	for _, country := range territory.Countries {
+		if strings.EqualFold(country, address.CountryCode) {
+			return true
+		}
+	}
+	for _, city := range territory.Cities {
+		if strings.EqualFold(city.Country, address.CountryCode) && strings.EqualFold(city.Name, address.City) {
+			return true
+		}
+	}
+	if address.Coordinates != nil {
+		for _, polygon := range territory.Polygons {
+			if polygon.Contains(*address.Coordinates) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Contains returns true if the point is inside the polygon, using the even-odd rule. Longitudes are treated as planar x coordinates, so
+// polygons shouldn't cross the antimeridian
+func (polygon Polygon) Contains(point models.Coordinates) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		// Count the polygon's edges crossed by a ray cast from the point towards increasing longitudes
+		if (a.Latitude > point.Latitude) != (b.Latitude > point.Latitude) {
+			crossing := a.Longitude + (point.Latitude-a.Latitude)*(b.Longitude-a.Longitude)/(b.Latitude-a.Latitude)
+			if point.Longitude < crossing {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+// Registry stores territories and the territories assigned to each sales rep. It's safe for concurrent use
+type Registry struct {
+	mu          sync.RWMutex
+	territories []Territory
+	// assignments maps reps to the ids of their territories
+	assignments map[string][]string
+}
+
+// Tenants holds a separate Registry per tenant. It's safe for concurrent use
+type Tenants struct {
+	mu         sync.Mutex
+	registries map[string]*Registry
+}
+
+// Default holds the registries shared by the application's handlers
+var Default = NewTenants()
+
+// NewTenants returns Tenants without any territories
+func NewTenants() *Tenants {
+	return &Tenants{registries: map[string]*Registry{}}
+}
+
+// Get returns the registry of the tenant. The empty tenant is the default tenant
+func (tenants *Tenants) Get(tenant string) *Registry {
+	if tenant == "" {
+		tenant = models.DefaultTenant
+	}
+
+	tenants.mu.Lock()
+	defer tenants.mu.Unlock()
+	registry, ok := tenants.registries[tenant]
+	if !ok {
+		registry = New()
+		tenants.registries[tenant] = registry
+	}
+	return registry
+}
+
+// New returns an empty Registry
+func New() *Registry {
+	return &Registry{assignments: map[string][]string{}}
+}
+
+// List returns every territory, in the order they were created
+func (registry *Registry) List() []Territory {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return append([]Territory{}, registry.territories...)
+}
+
+// Get returns the territory with the specified id
+func (registry *Registry) Get(id string) (Territory, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	if i := registry.indexOf(id); i >= 0 {
+		return registry.territories[i], true
+	}
+	return Territory{}, false
+}
+
+// Create validates the territory and adds it to the registry with a new ID
+func (registry *Registry) Create(territory Territory) (Territory, error) {
+	territory, err := territory.Normalize()
+	if err != nil {
+		return territory, err
+	}
+	territory.ID = util.NewID()
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.territories = append(registry.territories, territory)
+	return territory, nil
+}
+
+// Update validates the territory and replaces the existing territory with the same ID
+func (registry *Registry) Update(territory Territory) (Territory, error) {
+	territory, err := territory.Normalize()
+	if err != nil {
+		return territory, err
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	i := registry.indexOf(territory.ID)
+	if i < 0 {
+		return territory, ErrNotFound(territory.ID)
+	}
+	registry.territories[i] = territory
+	return territory, nil
+}
+
+// Delete removes the territory with the specified id, and unassigns it from every rep
+func (registry *Registry) Delete(id string) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	i := registry.indexOf(id)
+	if i < 0 {
+		return ErrNotFound(id)
+	}
+	registry.territories = append(registry.territories[:i], registry.territories[i+1:]...)
+
+	for rep, ids := range registry.assignments {
+		remaining := []string{}
+		for _, assigned := range ids {
+			if assigned != id {
+				remaining = append(remaining, assigned)
+			}
+		}
+		registry.setAssignment(rep, remaining)
+	}
+	return nil
+}
+
+// Assign replaces the territories assigned to the rep. Assigning no territories removes the rep's access to every customer
+func (registry *Registry) Assign(rep string, territoryIDs []string) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	ids := []string{}
+	assigned := map[string]bool{}
+	for _, id := range territoryIDs {
+		if registry.indexOf(id) < 0 {
+			return ErrNotFound(id)
+		}
+		if !assigned[id] {
+			assigned[id] = true
+			ids = append(ids, id)
+		}
+	}
+	registry.setAssignment(rep, ids)
+	return nil
+}
+
+// Assignments returns the ids of the territories assigned to each rep that has territories
+func (registry *Registry) Assignments() map[string][]string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	assignments := map[string][]string{}
+	for rep, ids := range registry.assignments {
+		assignments[rep] = append([]string{}, ids...)
+	}
+	return assignments
+}
+
+// Territories returns the territories assigned to the rep, sorted by name
+func (registry *Registry) Territories(rep string) []Territory {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	territories := []Territory{}
+	for _, id := range registry.assignments[rep] {
+		if i := registry.indexOf(id); i >= 0 {
+			territories = append(territories, registry.territories[i])
+		}
+	}
+	sort.SliceStable(territories, func(i, j int) bool {
+		return territories[i].Name < territories[j].Name
+	})
+	return territories
+}
+
+// Scope returns a fn that returns true if a customer is within one of the rep's territories. The rep's territories are read when Scope
+// is called, so the fn isn't affected by later changes to the registry
+func (registry *Registry) Scope(rep string) func(customer models.Customer) bool {
+	territories := registry.Territories(rep)
+	return func(customer models.Customer) bool {
+		for _, territory := range territories {
+			if territory.Contains(customer.Address) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// setAssignment sets the rep's territories, removing reps without any. The caller must hold the lock
+func (registry *Registry) setAssignment(rep string, ids []string) {
+	if len(ids) == 0 {
+		delete(registry.assignments, rep)
+		return
+	}
+	registry.assignments[rep] = ids
+}
+
+// indexOf returns the position of the territory with the specified id, or -1. The caller must hold the lock
+func (registry *Registry) indexOf(id string) int {
+	for i, territory := range registry.territories {
+		if territory.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ErrNotFound is returned when a territory with the specified id doesn't exist
+type ErrNotFound string
+
+func (id ErrNotFound) Error() string {
+	return fmt.Sprintf("Failed to locate territory with id: %s", string(id))
+}
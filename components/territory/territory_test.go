@@ -0,0 +1,150 @@
+package territory
+
+import (
+	"fmt"
+	"testing"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// greaterToronto roughly bounds the Greater Toronto Area
+var greaterToronto = Polygon{
+	{Latitude: 43.4, Longitude: -80.0},
+	{Latitude: 44.1, Longitude: -80.0},
+	{Latitude: 44.1, Longitude: -78.8},
+	{Latitude: 43.4, Longitude: -78.8},
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        Territory
+		expTerritory Territory
+		expErr       error
+	}{
+		{
+			name:   "Missing name",
+			input:  Territory{Countries: []string{"CA"}},
+			expErr: fmt.Errorf("name required"),
+		},
+		{
+			name:   "Empty territory",
+			input:  Territory{Name: "Nowhere"},
+			expErr: fmt.Errorf("A territory must contain at least one country, city or polygon"),
+		},
+		{
+			name:   "Unknown country",
+			input:  Territory{Name: "Atlantis", Countries: []string{"Atlantis"}},
+			expErr: fmt.Errorf("Unknown country: Atlantis"),
+		},
+		{
+			name:   "Degenerate polygon",
+			input:  Territory{Name: "Line", Polygons: []Polygon{greaterToronto[:2]}},
+			expErr: fmt.Errorf("Polygons require at least 3 vertices"),
+		},
+		{
+			name:  "Countries translated to codes",
+			input: Territory{Name: "North America", Countries: []string{"Canada", "USA"}, Cities: []City{{Name: "London", Country: "United Kingdom"}}},
+			expTerritory: Territory{
+				Name:      "North America",
+				Countries: []string{"CA", "US"},
+				Cities:    []City{{Name: "London", Country: "GB"}},
+				Polygons:  []Polygon{},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			territory, err := test.input.Normalize()
+			assert.Equal(t, test.expErr, err)
+			if test.expErr == nil {
+				assert.Equal(t, test.expTerritory, territory)
+			}
+		})
+	}
+}
+
+func TestContains(t *testing.T) {
+	territory := Territory{
+		Countries: []string{"US"},
+		Cities:    []City{{Name: "London", Country: "GB"}},
+		Polygons:  []Polygon{greaterToronto},
+	}
+
+	tests := []struct {
+		name    string
+		address models.Address
+		exp     bool
+	}{
+		{
+			name:    "Country",
+			address: models.Address{City: "Chicago", CountryCode: "US"},
+			exp:     true,
+		},
+		{
+			name:    "City",
+			address: models.Address{City: "london", CountryCode: "GB"},
+			exp:     true,
+		},
+		{
+			name:    "City in another country",
+			address: models.Address{City: "London", CountryCode: "CA"},
+			exp:     false,
+		},
+		{
+			name:    "Inside polygon",
+			address: models.Address{City: "Toronto", CountryCode: "CA", Coordinates: &models.Coordinates{Latitude: 43.65, Longitude: -79.38}},
+			exp:     true,
+		},
+		{
+			name:    "Outside polygon",
+			address: models.Address{City: "Ottawa", CountryCode: "CA", Coordinates: &models.Coordinates{Latitude: 45.42, Longitude: -75.69}},
+			exp:     false,
+		},
+		{
+			name:    "No coordinates",
+			address: models.Address{City: "Toronto", CountryCode: "CA"},
+			exp:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.exp, territory.Contains(test.address))
+		})
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := New()
+
+	canada, err := registry.Create(Territory{Name: "Canada", Countries: []string{"CA"}})
+	assert.NoError(t, err)
+	chicago, err := registry.Create(Territory{Name: "Chicago", Cities: []City{{Name: "Chicago", Country: "US"}}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, ErrNotFound("missing"), registry.Assign("rep", []string{canada.ID, "missing"}))
+	assert.NoError(t, registry.Assign("rep", []string{chicago.ID, canada.ID, canada.ID}))
+	assert.Equal(t, map[string][]string{"rep": {chicago.ID, canada.ID}}, registry.Assignments())
+	assert.Equal(t, []Territory{canada, chicago}, registry.Territories("rep"))
+
+	scope := registry.Scope("rep")
+	assert.True(t, scope(models.Customer{Address: models.Address{City: "Toronto", CountryCode: "CA"}}))
+	assert.True(t, scope(models.Customer{Address: models.Address{City: "Chicago", CountryCode: "US"}}))
+	assert.False(t, scope(models.Customer{Address: models.Address{City: "Boston", CountryCode: "US"}}))
+	assert.False(t, registry.Scope("other rep")(models.Customer{Address: models.Address{City: "Toronto", CountryCode: "CA"}}))
+
+	chicago.Cities = []City{{Name: "Boston", Country: "US"}}
+	_, err = registry.Update(chicago)
+	assert.NoError(t, err)
+	assert.True(t, registry.Scope("rep")(models.Customer{Address: models.Address{City: "Boston", CountryCode: "US"}}))
+
+	// Deleting territories unassigns them, and reps without territories are removed
+	assert.NoError(t, registry.Delete(canada.ID))
+	assert.Equal(t, map[string][]string{"rep": {chicago.ID}}, registry.Assignments())
+	assert.NoError(t, registry.Delete(chicago.ID))
+	assert.Equal(t, map[string][]string{}, registry.Assignments())
+	assert.Equal(t, ErrNotFound(chicago.ID), registry.Delete(chicago.ID))
+}
@@ -0,0 +1,72 @@
+package weatherforecaster
+
+import (
+	"strings"
+	"sync"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+)
+
+// Location is a city within a country
+type Location struct {
+	City        string `json:"city"`
+	CountryCode string `json:"country_code"`
+}
+
+// key identifies the location regardless of case
+func (location Location) key() string {
+	return strings.ToLower(location.City) + "," + strings.ToUpper(location.CountryCode)
+}
+
+// LocationForecast is the upcoming weather at a location, or the error that prevented obtaining it
+type LocationForecast struct {
+	Location
+	Weather []models.Weather
+	Err     error
+}
+
+// batchConcurrency is the number of calls providers without a bulk endpoint make at a time when obtaining the weather of many locations
+const batchConcurrency = 8
+
+// distinctLocations returns the locations without duplicates, in the order they're first specified
+func distinctLocations(locations []Location) []Location {
+	seen := map[string]bool{}
+	distinct := []Location{}
+	for _, location := range locations {
+		if !seen[location.key()] {
+			seen[location.key()] = true
+			distinct = append(distinct, location)
+		}
+	}
+	return distinct
+}
+
+// FanOut obtains the upcoming weather of each distinct location with one UpcomingWeather call per location, making up to concurrency calls
+// at a time. It implements UpcomingWeatherBatch for forecasters without a bulk endpoint. Results are in the order that locations are first
+// specified
+func FanOut(forecaster Forecaster, concurrency int, locations []Location, dateRange util.DateRange, types ...models.WeatherType) []LocationForecast {
+	distinct := distinctLocations(locations)
+	results := make([]LocationForecast, len(distinct))
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency && worker < len(distinct); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				weather, err := forecaster.UpcomingWeather(distinct[i].City, distinct[i].CountryCode, dateRange, types...)
+				results[i] = LocationForecast{Location: distinct[i], Weather: weather, Err: err}
+			}
+		}()
+	}
+	for i := range distinct {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,90 @@
+package weatherforecaster
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingForecaster returns the city as the weather type, fails for unknown cities and records the peak number of concurrent calls
+type recordingForecaster struct {
+	mu      sync.Mutex
+	active  int
+	peak    int
+	calls   []string
+	unknown string
+}
+
+func (forecaster *recordingForecaster) UpcomingWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error) {
+	forecaster.mu.Lock()
+	forecaster.active++
+	if forecaster.active > forecaster.peak {
+		forecaster.peak = forecaster.active
+	}
+	forecaster.calls = append(forecaster.calls, city)
+	forecaster.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	forecaster.mu.Lock()
+	forecaster.active--
+	forecaster.mu.Unlock()
+	if city == forecaster.unknown {
+		return nil, fmt.Errorf("City not found: %s", city)
+	}
+	return []models.Weather{{Date: dateRange.Start, Type: models.WeatherType(city)}}, nil
+}
+
+func (forecaster *recordingForecaster) UpcomingWeatherBatch(locations []Location, dateRange util.DateRange, types ...models.WeatherType) []LocationForecast {
+	return FanOut(forecaster, 2, locations, dateRange, types...)
+}
+
+func TestFanOut(t *testing.T) {
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	dateRange := util.DateRange{Start: now, End: now.AddDate(0, 0, 5)}
+	forecaster := &recordingForecaster{unknown: "Atlantis"}
+
+	results := forecaster.UpcomingWeatherBatch([]Location{
+		{City: "Toronto", CountryCode: "CA"},
+		{City: "Chicago", CountryCode: "US"},
+		{City: "toronto", CountryCode: "ca"},
+		{City: "Atlantis", CountryCode: "GR"},
+		{City: "Boston", CountryCode: "US"},
+	}, dateRange, models.WeatherTypeRain)
+
+	if assert.Len(t, results, 4) {
+		assert.Equal(t, LocationForecast{Location: Location{City: "Toronto", CountryCode: "CA"}, Weather: []models.Weather{{Date: now, Type: "Toronto"}}}, results[0])
+		assert.Equal(t, Location{City: "Chicago", CountryCode: "US"}, results[1].Location)
+		assert.EqualError(t, results[2].Err, "City not found: Atlantis")
+		assert.Equal(t, Location{City: "Boston", CountryCode: "US"}, results[3].Location)
+		assert.NoError(t, results[3].Err)
+	}
+	assert.Len(t, forecaster.calls, 4)
+	assert.Equal(t, 2, forecaster.peak)
+	assert.Empty(t, FanOut(forecaster, 2, nil, dateRange))
+}
+
+func TestMockBatch(t *testing.T) {
+	Configure(ProviderConfig{Provider: ProviderMock})
+	defer Configure(ProviderConfig{})
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	dateRange := util.DateRange{Start: now, End: now.AddDate(0, 0, 5)}
+
+	results := NewProvider(ProviderConfig{}).UpcomingWeatherBatch([]Location{
+		{City: "Toronto", CountryCode: "CA"},
+		{City: "Toronto", CountryCode: "CA"},
+		{City: "Chicago", CountryCode: "US"},
+	}, dateRange, models.WeatherTypeRain)
+
+	if assert.Len(t, results, 2) {
+		for _, result := range results {
+			assert.NoError(t, result.Err)
+			assert.Len(t, result.Weather, 11)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package weatherforecaster
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+)
+
+// coalescingForecaster shares one in-flight call to a forecaster between concurrent identical calls, so that customers saved at the same
+// time in the same city only cause one provider call
+type coalescingForecaster struct {
+	name       string
+	forecaster Forecaster
+}
+
+// Coalesce returns a Forecaster that shares one in-flight call to the forecaster between concurrent calls for the same location, date
+// range and weather types. Calls are only shared with calls to forecasters of the same name, which must identify the provider and the
+// credentials it's called with
+func Coalesce(name string, forecaster Forecaster) Forecaster {
+	return &coalescingForecaster{name: name, forecaster: forecaster}
+}
+
+func (coalescing *coalescingForecaster) UpcomingWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error) {
+	key := fmt.Sprintf("%s|%s|%s|%d|%d|%v", coalescing.name, strings.ToLower(city), strings.ToUpper(countrycode),
+		dateRange.Start.UnixNano(), dateRange.End.UnixNano(), types)
+	return flights.do(key, func() ([]models.Weather, error) {
+		return coalescing.forecaster.UpcomingWeather(city, countrycode, dateRange, types...)
+	})
+}
+
+// UpcomingWeatherBatch fans out to UpcomingWeather, so that each location is coalesced with concurrent calls for it
+func (coalescing *coalescingForecaster) UpcomingWeatherBatch(locations []Location, dateRange util.DateRange, types ...models.WeatherType) []LocationForecast {
+	return FanOut(coalescing, batchConcurrency, locations, dateRange, types...)
+}
+
+// CoalescingStats counts the calls made through coalescing forecasters, see Coalesce
+type CoalescingStats struct {
+	// Calls is the number of calls made to coalescing forecasters
+	Calls uint64 `json:"calls"`
+	// Coalesced is the number of calls that shared the result of an in-flight call rather than calling the provider
+	Coalesced uint64 `json:"coalesced"`
+}
+
+// Coalescing returns the number of calls that were coalesced since the application started
+func Coalescing() CoalescingStats {
+	flights.mu.Lock()
+	defer flights.mu.Unlock()
+	return flights.stats
+}
+
+// flights is shared by every coalescing forecaster, since forecasters are created per call
+var flights = newFlightGroup()
+
+// flightGroup tracks the in-flight calls by key. It's safe for concurrent use
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+	stats CoalescingStats
+}
+
+// flight is an in-flight call. weather and err are set before done is closed
+type flight struct {
+	done    chan struct{}
+	weather []models.Weather
+	err     error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: map[string]*flight{}}
+}
+
+// do calls fn, unless a call with the same key is in flight in which case its result is returned once it completes. Every caller gets
+// its own copy of the weather details
+func (group *flightGroup) do(key string, fn func() ([]models.Weather, error)) ([]models.Weather, error) {
+	group.mu.Lock()
+	group.stats.Calls++
+	if inFlight, ok := group.calls[key]; ok {
+		group.stats.Coalesced++
+		group.mu.Unlock()
+		<-inFlight.done
+		return copyWeather(inFlight.weather), inFlight.err
+	}
+	call := &flight{done: make(chan struct{})}
+	group.calls[key] = call
+	group.mu.Unlock()
+
+	call.weather, call.err = fn()
+
+	// Later calls make a new call rather than sharing a completed result, which may be stale
+	group.mu.Lock()
+	delete(group.calls, key)
+	group.mu.Unlock()
+	close(call.done)
+	return copyWeather(call.weather), call.err
+}
+
+func copyWeather(weather []models.Weather) []models.Weather {
+	if weather == nil {
+		return nil
+	}
+	return append([]models.Weather{}, weather...)
+}
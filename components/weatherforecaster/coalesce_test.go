@@ -0,0 +1,76 @@
+package weatherforecaster
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingForecaster counts its calls, which block until release is closed
+type blockingForecaster struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (forecaster *blockingForecaster) UpcomingWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error) {
+	atomic.AddInt32(&forecaster.calls, 1)
+	<-forecaster.release
+	return []models.Weather{{Date: dateRange.Start, Type: models.WeatherTypeRain}}, nil
+}
+
+func (forecaster *blockingForecaster) UpcomingWeatherBatch(locations []Location, dateRange util.DateRange, types ...models.WeatherType) []LocationForecast {
+	return FanOut(forecaster, 1, locations, dateRange, types...)
+}
+
+func TestCoalesce(t *testing.T) {
+	flights = newFlightGroup()
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	dateRange := util.DateRange{Start: now, End: now.AddDate(0, 0, 5)}
+	provider := &blockingForecaster{release: make(chan struct{})}
+	forecaster := Coalesce("test", provider)
+
+	const callers = 10
+	results := make([][]models.Weather, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			city := "Toronto"
+			if i%2 == 0 {
+				city = "toronto"
+			}
+			weather, err := forecaster.UpcomingWeather(city, "ca", dateRange, models.WeatherTypeRain)
+			assert.NoError(t, err)
+			results[i] = weather
+		}(i)
+	}
+
+	// Wait until every caller has joined the in-flight call before releasing it
+	for Coalescing().Calls < callers {
+		time.Sleep(time.Millisecond)
+	}
+	close(provider.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&provider.calls))
+	assert.Equal(t, CoalescingStats{Calls: callers, Coalesced: callers - 1}, Coalescing())
+	for _, weather := range results {
+		assert.Equal(t, []models.Weather{{Date: now, Type: models.WeatherTypeRain}}, weather)
+	}
+	// Callers get their own copy of the result
+	results[0][0].Type = "snow"
+	assert.Equal(t, models.WeatherTypeRain, results[1][0].Type)
+
+	// Completed calls aren't shared, nor are calls to other locations or forecasters
+	forecaster.UpcomingWeather("Toronto", "CA", dateRange, models.WeatherTypeRain)
+	forecaster.UpcomingWeather("Chicago", "US", dateRange, models.WeatherTypeRain)
+	Coalesce("other", provider).UpcomingWeather("Toronto", "CA", dateRange, models.WeatherTypeRain)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&provider.calls))
+	assert.Equal(t, uint64(callers-1), Coalescing().Coalesced)
+}
@@ -0,0 +1,200 @@
+package weatherforecaster
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// contractStart and contractEnd bound the date range that the contract's canned forecast is requested for
+var (
+	contractStart = time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	contractEnd   = contractStart.AddDate(0, 0, 1)
+)
+
+// contractPayload is the canned OpenWeatherMap forecast that the stand-in provider serves for Toronto. It has weather on both bounds of
+// the date range and just outside them, weather without a models.WeatherType, and a period with more than one kind of weather
+var contractPayload = fmt.Sprintf(`{"cod":"200","cnt":7,"list":[
+	{"dt":%d,"weather":[{"main":"Rain"}]},
+	{"dt":%d,"weather":[{"main":"Rain"}]},
+	{"dt":%d,"weather":[{"main":"Clear"}]},
+	{"dt":%d,"weather":[{"main":"Clouds"},{"main":"Rain"}]},
+	{"dt":%d,"weather":[{"main":"Snow"}]},
+	{"dt":%d,"weather":[{"main":"Rain"}]},
+	{"dt":%d,"weather":[{"main":"Rain"}]}
+]}`,
+	contractStart.Add(-time.Second).Unix(),
+	contractStart.Unix(),
+	contractStart.Add(3*time.Hour).Unix(),
+	contractStart.Add(6*time.Hour).Unix(),
+	contractStart.Add(9*time.Hour).Unix(),
+	contractEnd.Unix(),
+	contractEnd.Add(time.Second).Unix(),
+)
+
+// contractStandIn returns a stand-in OpenWeatherMap server that serves contractPayload for Toronto, a malformed response for Broken, an
+// internal error for Down and not found for any other city
+func contractStandIn() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("q") {
+		case "Toronto,CA":
+			fmt.Fprint(w, contractPayload)
+		case "Broken,CA":
+			fmt.Fprint(w, `{"list": [`)
+		case "Down,CA":
+			http.Error(w, `{"cod":"500","message":"internal error"}`, http.StatusInternalServerError)
+		default:
+			http.Error(w, `{"cod":"404","message":"city not found"}`, http.StatusNotFound)
+		}
+	}))
+}
+
+// recordThenReplay records each forecast with recorder before returning the replayed recording, so that replay can be verified against
+// the stand-in provider's payloads
+type recordThenReplay struct {
+	recorder Forecaster
+	replay   Forecaster
+}
+
+func (forecaster recordThenReplay) UpcomingWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error) {
+	forecaster.recorder.UpcomingWeather(city, countrycode, dateRange, types...)
+	return forecaster.replay.UpcomingWeather(city, countrycode, dateRange, types...)
+}
+
+func (forecaster recordThenReplay) UpcomingWeatherBatch(locations []Location, dateRange util.DateRange, types ...models.WeatherType) []LocationForecast {
+	forecaster.recorder.UpcomingWeatherBatch(locations, dateRange, types...)
+	return forecaster.replay.UpcomingWeatherBatch(locations, dateRange, types...)
+}
+
+// contractProviders create each provider whose forecasts come from OpenWeatherMap, calling the stand-in server at baseURL instead
+var contractProviders = map[string]func(t *testing.T, baseURL string) Forecaster{
+	"openweathermap": func(t *testing.T, baseURL string) Forecaster {
+		return NewProvider(ProviderConfig{BaseURL: baseURL})
+	},
+	"coalesced": func(t *testing.T, baseURL string) Forecaster {
+		return NewConfiguredForecaster(ProviderConfig{BaseURL: baseURL})
+	},
+	"record": func(t *testing.T, baseURL string) Forecaster {
+		return NewProvider(ProviderConfig{Provider: ProviderRecord, BaseURL: baseURL, Fixtures: t.TempDir()})
+	},
+	"replay": func(t *testing.T, baseURL string) Forecaster {
+		fixtures := filepath.Join(t.TempDir(), "forecasts")
+		return recordThenReplay{
+			recorder: NewProvider(ProviderConfig{Provider: ProviderRecord, BaseURL: baseURL, Fixtures: fixtures}),
+			replay:   NewProvider(ProviderConfig{Provider: ProviderReplay, Fixtures: fixtures}),
+		}
+	},
+}
+
+// TestContract verifies that every provider filters and translates forecasts the same way, as documented on Forecaster
+func TestContract(t *testing.T) {
+	standIn := contractStandIn()
+	defer standIn.Close()
+
+	toronto, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Skipf("Time zone database isn't available: %s", err.Error())
+	}
+	rain := func(date time.Time) models.Weather {
+		return models.Weather{Date: date, Type: models.WeatherTypeRain}
+	}
+	inRange := []models.Weather{rain(contractStart), rain(contractStart.Add(6 * time.Hour)), rain(contractEnd)}
+
+	tests := []struct {
+		name       string
+		city       string
+		dateRange  util.DateRange
+		types      []models.WeatherType
+		expWeather []models.Weather
+		expError   string
+	}{
+		{
+			name:       "date range is inclusive",
+			city:       "Toronto",
+			dateRange:  util.DateRange{Start: contractStart, End: contractEnd},
+			types:      []models.WeatherType{models.WeatherTypeRain},
+			expWeather: inRange,
+		},
+		{
+			name:       "empty types return all data",
+			city:       "Toronto",
+			dateRange:  util.DateRange{Start: contractStart, End: contractEnd},
+			expWeather: inRange,
+		},
+		{
+			name:      "unknown types return nothing",
+			city:      "Toronto",
+			dateRange: util.DateRange{Start: contractStart, End: contractEnd},
+			types:     []models.WeatherType{"hail"},
+		},
+		{
+			name:       "single instant range",
+			city:       "Toronto",
+			dateRange:  util.DateRange{Start: contractEnd, End: contractEnd},
+			types:      []models.WeatherType{models.WeatherTypeRain},
+			expWeather: []models.Weather{rain(contractEnd)},
+		},
+		{
+			name:       "date range in another time zone returns UTC dates",
+			city:       "Toronto",
+			dateRange:  util.DateRange{Start: contractStart.In(toronto), End: contractEnd.In(toronto)},
+			types:      []models.WeatherType{models.WeatherTypeRain},
+			expWeather: inRange,
+		},
+		{
+			name:      "malformed response",
+			city:      "Broken",
+			dateRange: util.DateRange{Start: contractStart, End: contractEnd},
+			expError:  "Error parsing response from forecast provider",
+		},
+		{
+			name:      "provider error",
+			city:      "Down",
+			dateRange: util.DateRange{Start: contractStart, End: contractEnd},
+			expError:  "status 500",
+		},
+		{
+			name:      "unknown city",
+			city:      "Atlantis",
+			dateRange: util.DateRange{Start: contractStart, End: contractEnd},
+			expError:  "status 404",
+		},
+	}
+
+	for provider, newForecaster := range contractProviders {
+		for _, test := range tests {
+			name := provider + ": " + test.name
+			forecaster := newForecaster(t, standIn.URL)
+			weather, err := forecaster.UpcomingWeather(test.city, "CA", test.dateRange, test.types...)
+			batch := forecaster.UpcomingWeatherBatch([]Location{{City: test.city, CountryCode: "CA"}}, test.dateRange, test.types...)
+
+			if test.expError != "" {
+				// Replays of calls that failed fail because they weren't recorded
+				if provider == "replay" {
+					test.expError = "No recorded forecast for " + test.city
+				}
+				if assert.Error(t, err, name) {
+					assert.Contains(t, err.Error(), test.expError, name)
+				}
+				if assert.Len(t, batch, 1, name) && assert.Error(t, batch[0].Err, name) {
+					assert.Contains(t, batch[0].Err.Error(), test.expError, name)
+				}
+				continue
+			}
+
+			assert.NoError(t, err, name)
+			assert.Equal(t, test.expWeather, weather, name)
+			if assert.Len(t, batch, 1, name) {
+				assert.NoError(t, batch[0].Err, name)
+				assert.Equal(t, test.expWeather, batch[0].Weather, name)
+			}
+		}
+	}
+}
@@ -1,26 +1,54 @@
 package weatherforecaster
 
 import (
+	_ "embed"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"umbrellacorp/models"
 	"umbrellacorp/util"
 )
 
+// mockResponse is a sample OpenWeatherMap response, embedded so that the mock provider works regardless of the working directory
+//
+//go:embed mock_response.json
+var mockResponse []byte
+
+// mockProvider returns the same sample forecast for every location. Use the replay provider for forecasts that differ by location
 type mockProvider struct{}
 
 func (provider *mockProvider) UpcomingWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error) {
-	buf, err := ioutil.ReadFile("mock_response.json")
+	resp, err := readMockResponse()
 	if err != nil {
-		return nil, fmt.Errorf("Couldn't read mock response file: %s", err.Error())
+		return nil, err
 	}
+	return filterAndTranslate(resp, dateRange, types)
+}
 
+// UpcomingWeatherBatch reads the mock response once for every location, like a bulk endpoint would make a single call
+func (provider *mockProvider) UpcomingWeatherBatch(locations []Location, dateRange util.DateRange, types ...models.WeatherType) []LocationForecast {
+	resp, err := readMockResponse()
+
+	results := []LocationForecast{}
+	for _, location := range distinctLocations(locations) {
+		result := LocationForecast{Location: location, Err: err}
+		if err == nil {
+			result.Weather, result.Err = filterAndTranslate(resp, dateRange, types)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// ObservedWeather returns the sample forecast, so that the mock provider's forecasts always come true
+func (provider *mockProvider) ObservedWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error) {
+	return provider.UpcomingWeather(city, countrycode, dateRange, types...)
+}
+
+func readMockResponse() (openWeatherResponse, error) {
 	var resp openWeatherResponse
-	err = json.Unmarshal(buf, &resp)
+	err := json.Unmarshal(mockResponse, &resp)
 	if err != nil {
-		return nil, fmt.Errorf("Couldn't unmarshal response details: %s", err.Error())
+		return resp, fmt.Errorf("Couldn't unmarshal response details: %s", err.Error())
 	}
-
-	return filterAndTranslate(resp, dateRange, types)
+	return resp, nil
 }
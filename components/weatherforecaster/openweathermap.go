@@ -10,8 +10,13 @@ import (
 	"umbrellacorp/util"
 )
 
+// openWeatherMapSampleKey is the API key of OpenWeatherMap's sample API
+const openWeatherMapSampleKey = "b6907d289e10d714a6e88b30761fae22"
+
 type openWeatherMap struct {
 	baseURL    string
+	historyURL string
+	apiKey     string
 	httpClient http.Client
 }
 
@@ -60,22 +65,71 @@ type openWeatherSummary struct {
 	Main openWeatherType `json:"main"`
 }
 
-func newOpenWeatherMap() *openWeatherMap {
-	provider := &openWeatherMap{}
-	provider.baseURL = "https://samples.openweathermap.org/data/2.5/forecast"
+// openWeatherMapURL and openWeatherMapHistoryURL are the URLs of OpenWeatherMap's forecast and hourly history APIs
+const (
+	openWeatherMapURL        = "https://samples.openweathermap.org/data/2.5/forecast"
+	openWeatherMapHistoryURL = "https://history.openweathermap.org/data/2.5/history/city"
+)
+
+func newOpenWeatherMap(config ProviderConfig) *openWeatherMap {
+	provider := &openWeatherMap{apiKey: config.APIKey, baseURL: config.BaseURL, historyURL: config.HistoryURL}
+	if provider.apiKey == "" {
+		provider.apiKey = openWeatherMapSampleKey
+	}
+	if provider.baseURL == "" {
+		provider.baseURL = openWeatherMapURL
+	}
+	if provider.historyURL == "" {
+		provider.historyURL = openWeatherMapHistoryURL
+	}
 	provider.httpClient = http.Client{Timeout: 30 * time.Second}
 	return provider
 }
 
 func (provider *openWeatherMap) UpcomingWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error) {
-	req, err := http.NewRequest("GET", provider.baseURL, nil)
+	body, err := provider.fetch(city, countrycode)
+	if err != nil {
+		return nil, err
+	}
+	return parseAndTranslate(body, dateRange, types)
+}
+
+// ObservedWeather obtains the hourly weather observed within the dateRange from the provider's history API
+func (provider *openWeatherMap) ObservedWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error) {
+	body, err := provider.fetchHistory(city, countrycode, dateRange)
+	if err != nil {
+		return nil, err
+	}
+	return parseAndTranslate(body, dateRange, types)
+}
+
+// fetch returns the provider's raw forecast response for the location
+func (provider *openWeatherMap) fetch(city, countrycode string) ([]byte, error) {
+	return provider.get(provider.baseURL, city, countrycode, nil)
+}
+
+// fetchHistory returns the provider's raw response of the weather observed at the location within the dateRange
+func (provider *openWeatherMap) fetchHistory(city, countrycode string, dateRange util.DateRange) ([]byte, error) {
+	return provider.get(provider.historyURL, city, countrycode, map[string]string{
+		"type":  "hour",
+		"start": fmt.Sprint(dateRange.Start.Unix()),
+		"end":   fmt.Sprint(dateRange.End.Unix()),
+	})
+}
+
+// get calls the provider's API at url for the location, with the additional query parameters
+func (provider *openWeatherMap) get(url, city, countrycode string, params map[string]string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Error creating request to forecast provider: %s", err.Error())
 	}
 
 	q := req.URL.Query()
 	q.Add("q", fmt.Sprintf("%s,%s", city, countrycode))
-	q.Add("appid", "b6907d289e10d714a6e88b30761fae22")
+	q.Add("appid", provider.apiKey)
+	for key, value := range params {
+		q.Add(key, value)
+	}
 	req.URL.RawQuery = q.Encode()
 
 	resp, err := provider.httpClient.Do(req)
@@ -90,8 +144,16 @@ func (provider *openWeatherMap) UpcomingWeather(city, countrycode string, dateRa
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Forecast provider responded with status %d: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// parseAndTranslate parses a raw forecast response of the provider and translates the weather within dateRange
+func parseAndTranslate(body []byte, dateRange util.DateRange, types []models.WeatherType) ([]models.Weather, error) {
 	var openWeatherResp openWeatherResponse
-	err = json.Unmarshal(body, &openWeatherResp)
+	err := json.Unmarshal(body, &openWeatherResp)
 	if err != nil {
 		return nil, fmt.Errorf("Error parsing response from forecast provider: %v", err.Error())
 	}
@@ -99,6 +161,11 @@ func (provider *openWeatherMap) UpcomingWeather(city, countrycode string, dateRa
 	return filterAndTranslate(openWeatherResp, dateRange, types)
 }
 
+// UpcomingWeatherBatch calls the provider for each location, since the forecast API doesn't support looking up many cities by name
+func (provider *openWeatherMap) UpcomingWeatherBatch(locations []Location, dateRange util.DateRange, types ...models.WeatherType) []LocationForecast {
+	return FanOut(provider, batchConcurrency, locations, dateRange, types...)
+}
+
 func filterAndTranslate(resp openWeatherResponse, dateRange util.DateRange, types []models.WeatherType) ([]models.Weather, error) {
 	weatherDataset := filterData(resp.List, dateRange, types)
 
@@ -111,7 +178,8 @@ func filterAndTranslate(resp openWeatherResponse, dateRange util.DateRange, type
 				continue
 			}
 
-			found := false
+			// Every weather type is requested if none are specified
+			found := len(types) == 0
 			for _, requestedWeatherType := range types {
 				if requestedWeatherType == weatherType {
 					found = true
@@ -120,7 +188,7 @@ func filterAndTranslate(resp openWeatherResponse, dateRange util.DateRange, type
 			}
 			if found {
 				result = append(result, models.Weather{
-					Date: time.Unix(weatherData.Dt, 0),
+					Date: time.Unix(weatherData.Dt, 0).UTC(),
 					Type: weatherType,
 				})
 			}
@@ -130,12 +198,13 @@ func filterAndTranslate(resp openWeatherResponse, dateRange util.DateRange, type
 	return result, nil
 }
 
-// filterData filters the list of openWeatherData based on the specified dateRange, as well as optionally specified WeatherTypes
+// filterData filters the list of openWeatherData based on the specified dateRange, as well as optionally specified WeatherTypes. The
+// dateRange is inclusive of its start and end
 func filterData(weatherDataset []openWeatherData, dateRange util.DateRange, types []models.WeatherType) []openWeatherData {
 	var result []openWeatherData
 	for i, weatherData := range weatherDataset {
 		if dateRange.Contains(time.Unix(weatherData.Dt, 0)) {
-			if types != nil && weatherData.containsWeather(types) {
+			if len(types) == 0 || weatherData.containsWeather(types) {
 				result = append(result, weatherDataset[i])
 			}
 		}
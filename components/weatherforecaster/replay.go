@@ -0,0 +1,131 @@
+package weatherforecaster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+)
+
+// defaultFixture is replayed for locations that don't have a fixture of their own
+const defaultFixture = "default.json"
+
+// historyFixtures is the subdirectory of the fixtures directory that observed weather is recorded to
+const historyFixtures = "history"
+
+// fixtureSeparators matches the characters of city names that aren't used in fixture names
+var fixtureSeparators = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// fixtureName returns the name of the file that the forecast of the location is recorded to, e.g. new-york_us.json. Names are lowercase,
+// so that fixtures are found regardless of the case locations are specified in
+func fixtureName(city, countrycode string) string {
+	city = strings.Trim(fixtureSeparators.ReplaceAllString(strings.ToLower(city), "-"), "-")
+	countrycode = strings.Trim(fixtureSeparators.ReplaceAllString(strings.ToLower(countrycode), "-"), "-")
+	return city + "_" + countrycode + ".json"
+}
+
+// replayProvider returns forecasts from a directory of OpenWeatherMap responses recorded by recordProvider, so that tests and
+// development don't depend on the provider being reachable, or on the forecast it returns that day
+type replayProvider struct {
+	dir string
+}
+
+func newReplay(dir string) *replayProvider {
+	return &replayProvider{dir: dir}
+}
+
+func (provider *replayProvider) UpcomingWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error) {
+	body, err := read(provider.dir, city, countrycode)
+	if err != nil {
+		return nil, err
+	}
+	return parseAndTranslate(body, dateRange, types)
+}
+
+// ObservedWeather returns the observed weather recorded in the history subdirectory
+func (provider *replayProvider) ObservedWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error) {
+	body, err := read(filepath.Join(provider.dir, historyFixtures), city, countrycode)
+	if err != nil {
+		return nil, err
+	}
+	return parseAndTranslate(body, dateRange, types)
+}
+
+// UpcomingWeatherBatch reads each location's fixture in turn, since reading files doesn't benefit from concurrency
+func (provider *replayProvider) UpcomingWeatherBatch(locations []Location, dateRange util.DateRange, types ...models.WeatherType) []LocationForecast {
+	return FanOut(provider, 1, locations, dateRange, types...)
+}
+
+// read returns the response recorded in dir for the location, or the default fixture if the location wasn't recorded
+func read(dir, city, countrycode string) ([]byte, error) {
+	body, err := ioutil.ReadFile(filepath.Join(dir, fixtureName(city, countrycode)))
+	if os.IsNotExist(err) {
+		body, err = ioutil.ReadFile(filepath.Join(dir, defaultFixture))
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("No recorded forecast for %s, %s in %s", city, countrycode, dir)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read recorded forecast: %s", err.Error())
+	}
+	return body, nil
+}
+
+// recordProvider calls OpenWeatherMap, or a stand-in server at its base url, and saves each response to a directory that replayProvider
+// can replay
+type recordProvider struct {
+	dir      string
+	upstream *openWeatherMap
+}
+
+func newRecorder(dir string, upstream *openWeatherMap) *recordProvider {
+	return &recordProvider{dir: dir, upstream: upstream}
+}
+
+func (provider *recordProvider) UpcomingWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error) {
+	body, err := provider.upstream.fetch(city, countrycode)
+	if err != nil {
+		return nil, err
+	}
+	if err = save(provider.dir, city, countrycode, body); err != nil {
+		return nil, err
+	}
+	return parseAndTranslate(body, dateRange, types)
+}
+
+// ObservedWeather calls the provider's history API and records the response to the history subdirectory
+func (provider *recordProvider) ObservedWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error) {
+	body, err := provider.upstream.fetchHistory(city, countrycode, dateRange)
+	if err != nil {
+		return nil, err
+	}
+	if err = save(filepath.Join(provider.dir, historyFixtures), city, countrycode, body); err != nil {
+		return nil, err
+	}
+	return parseAndTranslate(body, dateRange, types)
+}
+
+// UpcomingWeatherBatch calls the provider for each location, like openWeatherMap does
+func (provider *recordProvider) UpcomingWeatherBatch(locations []Location, dateRange util.DateRange, types ...models.WeatherType) []LocationForecast {
+	return FanOut(provider, batchConcurrency, locations, dateRange, types...)
+}
+
+// save records the response for the location to dir, replacing any previous recording. Responses that can't be parsed aren't recorded
+func save(dir, city, countrycode string, body []byte) error {
+	if _, err := parseAndTranslate(body, util.DateRange{}, nil); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Couldn't create forecast fixtures directory: %s", err.Error())
+	}
+
+	// Written atomically, so that a concurrent replay never reads a partial recording
+	if err := util.WriteFileAtomic(filepath.Join(dir, fixtureName(city, countrycode)), body); err != nil {
+		return fmt.Errorf("Couldn't record forecast: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package weatherforecaster
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+	"umbrellacorp/models"
+	"umbrellacorp/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixtureName(t *testing.T) {
+	tests := []struct {
+		city, countryCode, expName string
+	}{
+		{city: "Toronto", countryCode: "CA", expName: "toronto_ca.json"},
+		{city: "New York", countryCode: "us", expName: "new-york_us.json"},
+		{city: "São Paulo", countryCode: "BR", expName: "são-paulo_br.json"},
+		{city: "../etc/passwd", countryCode: "..", expName: "etc-passwd_.json"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expName, fixtureName(test.city, test.countryCode), test.city)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	dateRange := util.DateRange{Start: now, End: now.AddDate(0, 0, 5)}
+	replay := NewProvider(ProviderConfig{Provider: ProviderReplay, Fixtures: "testdata/forecasts"})
+
+	results := replay.UpcomingWeatherBatch([]Location{
+		{City: "Toronto", CountryCode: "CA"},
+		{City: "LONDON", CountryCode: "gb"},
+		{City: "Chicago", CountryCode: "US"},
+	}, dateRange, models.WeatherTypeRain)
+	if assert.Len(t, results, 3) {
+		assert.Len(t, results[0].Weather, 11)
+		// London's recording has no rain
+		assert.NoError(t, results[1].Err)
+		assert.Empty(t, results[1].Weather)
+		// Chicago wasn't recorded, so the default fixture is replayed
+		assert.Equal(t, results[0].Weather, results[2].Weather)
+	}
+
+	_, err := NewProvider(ProviderConfig{Provider: ProviderReplay, Fixtures: t.TempDir()}).UpcomingWeather("Toronto", "CA", dateRange)
+	assert.Contains(t, err.Error(), "No recorded forecast for Toronto, CA")
+}
+
+func TestRecord(t *testing.T) {
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	dateRange := util.DateRange{Start: now, End: now.AddDate(0, 0, 5)}
+	sample, err := ioutil.ReadFile("testdata/forecasts/toronto_ca.json")
+	assert.NoError(t, err)
+
+	// The stand-in provider knows Toronto's forecast only
+	standIn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") != "Toronto,CA" {
+			http.Error(w, `{"cod":"404","message":"city not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Write(sample)
+	}))
+	defer standIn.Close()
+
+	dir := filepath.Join(t.TempDir(), "forecasts")
+	record := ProviderConfig{Provider: ProviderRecord, BaseURL: standIn.URL, Fixtures: dir}
+	assert.NoError(t, record.Validate())
+	assert.Equal(t, ProviderOpenWeatherMap, record.Name())
+
+	weather, err := NewProvider(record).UpcomingWeather("Toronto", "CA", dateRange, models.WeatherTypeRain)
+	assert.NoError(t, err)
+	assert.Len(t, weather, 11)
+	_, err = NewProvider(record).UpcomingWeather("Atlantis", "GR", dateRange, models.WeatherTypeRain)
+	assert.Contains(t, err.Error(), "Forecast provider responded with status 404")
+
+	// The recording is replayed without the stand-in provider, and failed calls aren't recorded
+	standIn.Close()
+	replay := NewProvider(ProviderConfig{Provider: ProviderReplay, Fixtures: dir})
+	replayed, err := replay.UpcomingWeather("toronto", "ca", dateRange, models.WeatherTypeRain)
+	assert.NoError(t, err)
+	assert.Equal(t, weather, replayed)
+	_, err = replay.UpcomingWeather("Atlantis", "GR", dateRange, models.WeatherTypeRain)
+	assert.Error(t, err)
+}
+
+func TestConfigureOverride(t *testing.T) {
+	tests := []struct {
+		name       string
+		override   ProviderConfig
+		config     ProviderConfig
+		expName    string
+		expAccount string
+	}{
+		{name: "no override", config: ProviderConfig{APIKey: "key"}, expName: ProviderOpenWeatherMap, expAccount: "openweathermap:key"},
+		{name: "stand-in", config: ProviderConfig{APIKey: "key", BaseURL: "http://localhost:9000"}, expName: ProviderOpenWeatherMap, expAccount: "openweathermap:key@http://localhost:9000"},
+		{name: "record", config: ProviderConfig{Provider: ProviderRecord, APIKey: "key", Fixtures: "fixtures"}, expName: ProviderOpenWeatherMap, expAccount: "record:fixtures:openweathermap:key"},
+		{name: "replay override", override: ProviderConfig{Provider: ProviderReplay, Fixtures: "fixtures"}, config: ProviderConfig{APIKey: "key"}, expName: ProviderReplay, expAccount: "replay:fixtures"},
+		{name: "mock override", override: ProviderConfig{Provider: ProviderMock}, config: ProviderConfig{Provider: ProviderReplay, Fixtures: "fixtures"}, expName: ProviderMock, expAccount: ProviderMock},
+	}
+	defer Configure(ProviderConfig{})
+	for _, test := range tests {
+		Configure(test.override)
+		assert.Equal(t, test.expName, test.config.Name(), test.name)
+		assert.Equal(t, test.expAccount, test.config.Account(), test.name)
+	}
+
+	assert.EqualError(t, ProviderConfig{Provider: ProviderReplay}.Validate(), "The replay forecast provider requires a fixtures directory")
+}
+
+func TestObservedWeather(t *testing.T) {
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	dateRange := util.DateRange{Start: now, End: now.AddDate(0, 0, 5)}
+	sample, err := ioutil.ReadFile("testdata/forecasts/toronto_ca.json")
+	assert.NoError(t, err)
+
+	// The stand-in history API serves Toronto's sample forecast as its observed weather
+	var query url.Values
+	standIn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write(sample)
+	}))
+	defer standIn.Close()
+
+	dir := t.TempDir()
+	record := ProviderConfig{Provider: ProviderRecord, HistoryURL: standIn.URL, Fixtures: dir}
+	recordObserver, err := NewObserver(record)
+	assert.NoError(t, err)
+	observed, err := recordObserver.ObservedWeather("Toronto", "CA", dateRange, models.WeatherTypeRain)
+	assert.NoError(t, err)
+	assert.Len(t, observed, 11)
+	assert.Equal(t, url.Values{
+		"q":     {"Toronto,CA"},
+		"appid": {openWeatherMapSampleKey},
+		"type":  {"hour"},
+		"start": {"1487203200"},
+		"end":   {"1487635200"},
+	}, query)
+
+	// Observed weather is recorded separately from forecasts
+	replay := ProviderConfig{Provider: ProviderReplay, Fixtures: dir}
+	replayObserver, err := NewObserver(replay)
+	assert.NoError(t, err)
+	replayed, err := replayObserver.ObservedWeather("Toronto", "CA", dateRange, models.WeatherTypeRain)
+	assert.NoError(t, err)
+	assert.Equal(t, observed, replayed)
+	_, err = NewProvider(replay).UpcomingWeather("Toronto", "CA", dateRange, models.WeatherTypeRain)
+	assert.Error(t, err)
+
+	// The mock provider's forecasts always come true
+	mock := ProviderConfig{Provider: ProviderMock}
+	forecast, err := NewProvider(mock).UpcomingWeather("Toronto", "CA", dateRange, models.WeatherTypeRain)
+	assert.NoError(t, err)
+	mockObserver, err := NewObserver(mock)
+	assert.NoError(t, err)
+	observed, err = mockObserver.ObservedWeather("Toronto", "CA", dateRange, models.WeatherTypeRain)
+	assert.NoError(t, err)
+	assert.Equal(t, forecast, observed)
+}
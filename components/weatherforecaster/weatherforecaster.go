@@ -1,28 +1,161 @@
 package weatherforecaster
 
 import (
+	"fmt"
+	"time"
 	"umbrellacorp/models"
 	"umbrellacorp/util"
 )
 
-var isMock bool
+// override replaces every configured provider if its Provider is set
+var override ProviderConfig
 
-// Configure configures the pkg in either prod or mock mode
-func Configure(mock bool) {
-	isMock = mock
+// Configure replaces the provider of every ProviderConfig with the override, e.g. to replay recorded forecasts in tests or development.
+// Each ProviderConfig's own provider is used if the override's Provider is empty
+func Configure(config ProviderConfig) {
+	override = config
+}
+
+// ForecastRange returns the date range that forecasts are fetched for. Stored forecasts only cover this range, so forecasts should be
+// evaluated from its start rather than from the current time
+func ForecastRange() util.DateRange {
+	// Forecaster API seems to only give data from Feb 2017
+	start := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	return util.DateRange{Start: start, End: start.AddDate(0, 0, 5)}
 }
 
 // Forecaster exposes functionality to retrieve weather details
 type Forecaster interface {
 	// Obtain upcoming weather for a specific (city, countryCode) combination, with ability to filter for specific weather types within a dateRange.
-	// If weather types are not specified, all obtained data from provider is returned
+	// If weather types are not specified, all obtained data from provider is returned. The dateRange is inclusive, and dates are returned in
+	// UTC
 	UpcomingWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error)
+	// Obtain upcoming weather for many locations within a dateRange. Duplicate locations are only obtained once, and the result of each
+	// distinct location is returned in the order they're first specified, along with the error obtaining it if any
+	UpcomingWeatherBatch(locations []Location, dateRange util.DateRange, types ...models.WeatherType) []LocationForecast
+}
+
+// Supported ProviderConfig providers
+const (
+	ProviderOpenWeatherMap = "openweathermap"
+	ProviderMock           = "mock"
+	ProviderReplay         = "replay"
+	ProviderRecord         = "record"
+)
+
+// ProviderConfig selects and configures a forecast provider, e.g. for a tenant
+type ProviderConfig struct {
+	// Provider is one of openweathermap, mock, replay or record. Defaults to openweathermap. replay returns the forecasts recorded in
+	// Fixtures, while record calls OpenWeatherMap and saves its responses to Fixtures
+	Provider string `json:"provider"`
+	// APIKey authenticates requests to the provider. OpenWeatherMap's sample key is used if it's empty
+	APIKey string `json:"api_key,omitempty"`
+	// BaseURL overrides the URL of OpenWeatherMap's forecast API, e.g. to call a stand-in server
+	BaseURL string `json:"base_url,omitempty"`
+	// HistoryURL overrides the URL of OpenWeatherMap's history API, which observed weather is obtained from
+	HistoryURL string `json:"history_url,omitempty"`
+	// Fixtures is the directory that the replay and record providers read and save forecasts to. Each location's forecast is saved to a
+	// file named after its city and country code, e.g. toronto_ca.json, and default.json is replayed for locations without one
+	Fixtures string `json:"fixtures,omitempty"`
+}
+
+// Validate verifies that the provider is supported and configured
+func (config ProviderConfig) Validate() error {
+	switch config.Provider {
+	case "", ProviderOpenWeatherMap, ProviderMock:
+		return nil
+	case ProviderReplay, ProviderRecord:
+		if config.Fixtures == "" {
+			return fmt.Errorf("The %s forecast provider requires a fixtures directory", config.Provider)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unsupported forecast provider: %s", config.Provider)
+}
+
+// effective returns the config that NewConfiguredForecaster uses, which is the pkg's override if it's set
+func (config ProviderConfig) effective() ProviderConfig {
+	if override.Provider != "" {
+		return override
+	}
+	return config
+}
+
+// Name returns the name of the provider that NewConfiguredForecaster returns for the config, e.g. to track its usage. The record provider
+// is named openweathermap, since it makes a call to OpenWeatherMap for every forecast
+func (config ProviderConfig) Name() string {
+	config = config.effective()
+	switch config.Provider {
+	case ProviderMock, ProviderReplay:
+		return config.Provider
+	}
+	return ProviderOpenWeatherMap
 }
 
-// NewForecaster returns a forecast provider
+// Account identifies the provider and the credentials it's called with, e.g. to share calls made with the same credentials
+func (config ProviderConfig) Account() string {
+	config = config.effective()
+	switch config.Provider {
+	case ProviderMock:
+		return ProviderMock
+	case ProviderReplay:
+		return ProviderReplay + ":" + config.Fixtures
+	}
+	apiKey := config.APIKey
+	if apiKey == "" {
+		apiKey = openWeatherMapSampleKey
+	}
+	account := ProviderOpenWeatherMap + ":" + apiKey
+	if config.BaseURL != "" {
+		account += "@" + config.BaseURL
+	}
+	if config.Provider == ProviderRecord {
+		// Calls that are recorded aren't shared with calls that aren't
+		account = ProviderRecord + ":" + config.Fixtures + ":" + account
+	}
+	return account
+}
+
+// NewForecaster returns the default forecast provider
 func NewForecaster() Forecaster {
-	if isMock {
+	return NewConfiguredForecaster(ProviderConfig{})
+}
+
+// NewConfiguredForecaster returns the forecast provider specified by the config, with concurrent identical calls coalesced. The pkg's
+// override takes precedence over the config
+func NewConfiguredForecaster(config ProviderConfig) Forecaster {
+	return Coalesce(config.Account(), NewProvider(config))
+}
+
+// NewProvider returns the forecast provider specified by the config, without coalescing calls. The pkg's override takes precedence over
+// the config
+func NewProvider(config ProviderConfig) Forecaster {
+	config = config.effective()
+	switch config.Provider {
+	case ProviderMock:
 		return &mockProvider{}
+	case ProviderReplay:
+		return newReplay(config.Fixtures)
+	case ProviderRecord:
+		return newRecorder(config.Fixtures, newOpenWeatherMap(config))
+	}
+	return newOpenWeatherMap(config)
+}
+
+// Observer obtains the weather that was observed at locations, e.g. to measure how accurate forecasts were
+type Observer interface {
+	// Obtain the weather observed at a specific (city, countryCode) combination within a past dateRange, filtered by weather types like
+	// Forecaster.UpcomingWeather
+	ObservedWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error)
+}
+
+// NewObserver returns the observer of the provider specified by the config. The pkg's override takes precedence over the config. An error
+// is returned if the provider can't observe the weather
+func NewObserver(config ProviderConfig) (Observer, error) {
+	provider := NewProvider(config)
+	observer, ok := provider.(Observer)
+	if !ok {
+		return nil, fmt.Errorf("The %s provider can't observe the weather", config.Name())
 	}
-	return newOpenWeatherMap()
+	return observer, nil
 }
@@ -10,7 +10,8 @@ import (
 )
 
 func TestOpenWeather(t *testing.T) {
-	Configure(true)
+	Configure(ProviderConfig{Provider: ProviderMock})
+	defer Configure(ProviderConfig{})
 
 	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
 	dateRange := util.DateRange{Start: now, End: now.AddDate(0, 0, 5)}
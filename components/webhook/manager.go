@@ -0,0 +1,545 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"umbrellacorp/util"
+)
+
+// DeliveryStatus is the state of a Delivery in the queue
+type DeliveryStatus string
+
+// Supported DeliveryStatus values
+const (
+	StatusPending   = DeliveryStatus("pending")
+	StatusDelivered = DeliveryStatus("delivered")
+	// StatusDead marks deliveries that failed too many times, they're kept until redelivered manually
+	StatusDead = DeliveryStatus("dead")
+)
+
+// Delivery is an event queued for delivery to a subscription, along with the log of its attempts
+type Delivery struct {
+	ID             string          `json:"id"`
+	Tenant         string          `json:"tenant"`
+	SubscriptionID string          `json:"subscription_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         DeliveryStatus  `json:"status"`
+	Attempts       []Attempt       `json:"attempts"`
+	// Failures counts the failed attempts since the delivery was queued or last redelivered
+	Failures      int       `json:"failures"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Attempt records the outcome of sending a delivery
+type Attempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Config configures a Manager
+type Config struct {
+	// Path is the file that subscriptions and the delivery queue are persisted to, so that pending deliveries survive restarts. They're
+	// only kept in memory if Path is empty
+	Path string
+	// MaxAttempts is the number of failed attempts after which a delivery is dead lettered
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. It doubles after every failure, up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout limits how long a receiver may take to respond
+	Timeout time.Duration
+	// Concurrency is the maximum number of deliveries sent at once
+	Concurrency int
+	// DeliveredRetention is how long successful deliveries are kept in the delivery log
+	DeliveredRetention time.Duration
+	// DeadRetention is how long dead lettered deliveries are kept for redelivery after their last attempt
+	DeadRetention time.Duration
+	// SaveInterval is how often Run persists queued deliveries and their attempts. Deliveries are saved in batches rather than on every
+	// change, so that queueing many events doesn't rewrite the file for each of them. Changes to subscriptions are saved straight away
+	SaveInterval time.Duration
+}
+
+// DefaultConfig retries a delivery for roughly 4 hours before dead lettering it
+var DefaultConfig = Config{
+	MaxAttempts:        8,
+	InitialBackoff:     30 * time.Second,
+	MaxBackoff:         time.Hour,
+	Timeout:            10 * time.Second,
+	Concurrency:        8,
+	DeliveredRetention: 7 * 24 * time.Hour,
+	DeadRetention:      30 * 24 * time.Hour,
+	SaveInterval:       time.Second,
+}
+
+// state is the persisted data of a Manager
+type state struct {
+	Subscriptions []Subscription `json:"subscriptions"`
+	Deliveries    []Delivery     `json:"deliveries"`
+}
+
+// Manager manages webhook subscriptions and delivers queued events to them. It's safe for concurrent use
+type Manager struct {
+	config Config
+	client *http.Client
+	// now is overridden by tests to control retry timing
+	now func() time.Time
+
+	// saveMu serializes writes to config.Path, so that an older state can't overwrite a newer one
+	saveMu sync.Mutex
+
+	mu    sync.Mutex
+	state state
+	// dirty is set when the state changed since it was last persisted
+	dirty    bool
+	inFlight map[string]bool
+	// wake signals Run that deliveries were queued
+	wake chan struct{}
+}
+
+// New returns a Manager, loading previously persisted subscriptions and deliveries from config.Path. Zero config values keep their
+// defaults
+func New(config Config) (*Manager, error) {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultConfig.MaxAttempts
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = DefaultConfig.InitialBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultConfig.MaxBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultConfig.Timeout
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = DefaultConfig.Concurrency
+	}
+	if config.DeliveredRetention <= 0 {
+		config.DeliveredRetention = DefaultConfig.DeliveredRetention
+	}
+	if config.DeadRetention <= 0 {
+		config.DeadRetention = DefaultConfig.DeadRetention
+	}
+	if config.SaveInterval <= 0 {
+		config.SaveInterval = DefaultConfig.SaveInterval
+	}
+
+	manager := &Manager{
+		config:   config,
+		client:   &http.Client{Timeout: config.Timeout},
+		now:      time.Now,
+		state:    state{Subscriptions: []Subscription{}, Deliveries: []Delivery{}},
+		inFlight: map[string]bool{},
+		wake:     make(chan struct{}, 1),
+	}
+
+	if config.Path != "" {
+		buf, err := ioutil.ReadFile(config.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Failed to read webhooks from %s: %s", config.Path, err.Error())
+		}
+		if len(buf) > 0 {
+			if err = json.Unmarshal(buf, &manager.state); err != nil {
+				return nil, fmt.Errorf("Failed to parse webhooks from %s: %s", config.Path, err.Error())
+			}
+		}
+	}
+	return manager, nil
+}
+
+// Subscriptions returns every subscription of the tenant, in the order they were created
+func (manager *Manager) Subscriptions(tenant string) []Subscription {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	subs := []Subscription{}
+	for _, sub := range manager.state.Subscriptions {
+		if sub.Tenant == tenant {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// Subscription returns the tenant's subscription with the specified id
+func (manager *Manager) Subscription(tenant, id string) (Subscription, bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if i := manager.subscriptionIndex(tenant, id); i >= 0 {
+		return manager.state.Subscriptions[i], true
+	}
+	return Subscription{}, false
+}
+
+// CreateSubscription validates and saves a new subscription. A secret is generated if the subscription doesn't specify one
+func (manager *Manager) CreateSubscription(sub Subscription) (Subscription, error) {
+	if err := sub.Validate(); err != nil {
+		return sub, err
+	}
+	sub.ID = util.NewID()
+	sub.CreatedAt = manager.now()
+	if sub.Secret == "" {
+		sub.Secret = util.NewID() + util.NewID()
+	}
+
+	manager.mu.Lock()
+	manager.state.Subscriptions = append(manager.state.Subscriptions, sub)
+	manager.dirty = true
+	manager.mu.Unlock()
+	return sub, manager.Flush()
+}
+
+// UpdateSubscription replaces the subscription with the same ID and tenant. The existing secret is kept if the subscription doesn't
+// specify one
+func (manager *Manager) UpdateSubscription(sub Subscription) (Subscription, error) {
+	if err := sub.Validate(); err != nil {
+		return sub, err
+	}
+
+	manager.mu.Lock()
+	i := manager.subscriptionIndex(sub.Tenant, sub.ID)
+	if i < 0 {
+		manager.mu.Unlock()
+		return sub, ErrNotFound{Entity: "webhook subscription", ID: sub.ID}
+	}
+	existing := manager.state.Subscriptions[i]
+	sub.CreatedAt = existing.CreatedAt
+	if sub.Secret == "" {
+		sub.Secret = existing.Secret
+	}
+	manager.state.Subscriptions[i] = sub
+	manager.dirty = true
+	manager.mu.Unlock()
+	return sub, manager.Flush()
+}
+
+// DeleteSubscription removes the tenant's subscription with the specified id. Its pending deliveries are dead lettered when they're next
+// due
+func (manager *Manager) DeleteSubscription(tenant, id string) error {
+	manager.mu.Lock()
+	i := manager.subscriptionIndex(tenant, id)
+	if i < 0 {
+		manager.mu.Unlock()
+		return ErrNotFound{Entity: "webhook subscription", ID: id}
+	}
+	manager.state.Subscriptions = append(manager.state.Subscriptions[:i], manager.state.Subscriptions[i+1:]...)
+	manager.dirty = true
+	manager.mu.Unlock()
+	return manager.Flush()
+}
+
+// Enqueue queues the payload of the tenant's event for delivery to every active subscription of the tenant matching the event type. The
+// queued deliveries are persisted by Run within config.SaveInterval
+func (manager *Manager) Enqueue(tenant, eventType string, payload []byte) ([]Delivery, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	now := manager.now()
+	queued := []Delivery{}
+	for _, sub := range manager.state.Subscriptions {
+		if !sub.Matches(tenant, eventType) {
+			continue
+		}
+		delivery := Delivery{
+			ID:             util.NewID(),
+			Tenant:         tenant,
+			SubscriptionID: sub.ID,
+			EventType:      eventType,
+			Payload:        json.RawMessage(payload),
+			Status:         StatusPending,
+			Attempts:       []Attempt{},
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		}
+		manager.state.Deliveries = append(manager.state.Deliveries, delivery)
+		queued = append(queued, delivery)
+	}
+	if len(queued) == 0 {
+		return queued, nil
+	}
+	manager.dirty = true
+
+	select {
+	case manager.wake <- struct{}{}:
+	default:
+	}
+	return queued, nil
+}
+
+// Deliveries returns the tenant's delivery log, oldest first. Empty filters match every delivery of the tenant
+func (manager *Manager) Deliveries(tenant, subscriptionID string, status DeliveryStatus) []Delivery {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	deliveries := []Delivery{}
+	for _, delivery := range manager.state.Deliveries {
+		if delivery.Tenant == tenant && (subscriptionID == "" || delivery.SubscriptionID == subscriptionID) && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
+
+// Redeliver queues the tenant's dead lettered delivery to be attempted again straight away, with a fresh set of attempts
+func (manager *Manager) Redeliver(tenant, id string) (Delivery, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	i := manager.deliveryIndex(id)
+	if i < 0 || manager.state.Deliveries[i].Tenant != tenant {
+		return Delivery{}, ErrNotFound{Entity: "webhook delivery", ID: id}
+	}
+	delivery := &manager.state.Deliveries[i]
+	if delivery.Status != StatusDead {
+		return *delivery, fmt.Errorf("Only dead deliveries can be redelivered, delivery %s is %s", id, delivery.Status)
+	}
+	delivery.Status = StatusPending
+	delivery.Failures = 0
+	delivery.NextAttemptAt = manager.now()
+	manager.dirty = true
+
+	select {
+	case manager.wake <- struct{}{}:
+	default:
+	}
+	return *delivery, nil
+}
+
+// Run delivers queued events as they become due, and persists the queue every config.SaveInterval, until stop is closed. The queue is
+// persisted once more when it stops
+func (manager *Manager) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	saveTicker := time.NewTicker(manager.config.SaveInterval)
+	defer saveTicker.Stop()
+	defer manager.logFlush()
+
+	for {
+		manager.DeliverDue()
+		select {
+		case <-stop:
+			return
+		case <-manager.wake:
+		case <-ticker.C:
+		case <-saveTicker.C:
+			manager.logFlush()
+		}
+	}
+}
+
+// DeliverDue sends every pending delivery whose next attempt is due, waits for them to complete and returns how many were attempted.
+// Failed deliveries are retried with exponential backoff and dead lettered after MaxAttempts failures
+func (manager *Manager) DeliverDue() int {
+	type job struct {
+		delivery Delivery
+		sub      Subscription
+		found    bool
+	}
+
+	manager.mu.Lock()
+	now := manager.now()
+	var jobs []job
+	for _, delivery := range manager.state.Deliveries {
+		if delivery.Status != StatusPending || delivery.NextAttemptAt.After(now) || manager.inFlight[delivery.ID] {
+			continue
+		}
+		manager.inFlight[delivery.ID] = true
+		j := job{delivery: delivery}
+		if i := manager.subscriptionIndex(delivery.Tenant, delivery.SubscriptionID); i >= 0 {
+			j.sub, j.found = manager.state.Subscriptions[i], true
+		}
+		jobs = append(jobs, j)
+	}
+	manager.prune(now)
+	manager.mu.Unlock()
+
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, manager.config.Concurrency)
+	for _, j := range jobs {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(j job) {
+			defer wg.Done()
+			defer func() { <-limit }()
+
+			attempt := Attempt{Time: manager.now(), Error: "Subscription was deleted"}
+			if j.found {
+				attempt = manager.send(j.sub, j.delivery)
+			}
+			manager.record(j.delivery.ID, attempt, !j.found)
+		}(j)
+	}
+	wg.Wait()
+	return len(jobs)
+}
+
+// send posts the delivery's payload to the subscription's URL
+func (manager *Manager) send(sub Subscription, delivery Delivery) Attempt {
+	attempt := Attempt{Time: manager.now()}
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, attempt.Time, delivery.Payload))
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+
+	resp, err := manager.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("Receiver responded with status %d", resp.StatusCode)
+	}
+	return attempt
+}
+
+// record updates the delivery with the outcome of an attempt
+func (manager *Manager) record(id string, attempt Attempt, dead bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	delete(manager.inFlight, id)
+
+	i := manager.deliveryIndex(id)
+	if i < 0 {
+		return
+	}
+	manager.dirty = true
+	delivery := &manager.state.Deliveries[i]
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	if attempt.Error == "" {
+		delivery.Status = StatusDelivered
+		return
+	}
+
+	delivery.Failures++
+	if dead || delivery.Failures >= manager.config.MaxAttempts {
+		delivery.Status = StatusDead
+		return
+	}
+	delivery.NextAttemptAt = attempt.Time.Add(manager.backoff(delivery.Failures))
+}
+
+// backoff returns the delay before retrying a delivery that failed the specified number of times
+func (manager *Manager) backoff(failures int) time.Duration {
+	delay := manager.config.InitialBackoff
+	for i := 1; i < failures && delay < manager.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > manager.config.MaxBackoff {
+		delay = manager.config.MaxBackoff
+	}
+	return delay
+}
+
+// prune removes successful deliveries older than DeliveredRetention and dead deliveries last attempted longer than DeadRetention ago from
+// the log. The caller must hold the lock
+func (manager *Manager) prune(now time.Time) {
+	deliveredCutoff := now.Add(-manager.config.DeliveredRetention)
+	deadCutoff := now.Add(-manager.config.DeadRetention)
+	remaining := manager.state.Deliveries[:0]
+	for _, delivery := range manager.state.Deliveries {
+		if delivery.Status == StatusDelivered && delivery.CreatedAt.Before(deliveredCutoff) {
+			continue
+		}
+		if delivery.Status == StatusDead && delivery.lastAttemptAt().Before(deadCutoff) {
+			continue
+		}
+		remaining = append(remaining, delivery)
+	}
+	if len(remaining) < len(manager.state.Deliveries) {
+		manager.dirty = true
+	}
+	manager.state.Deliveries = remaining
+}
+
+// lastAttemptAt returns the time of the delivery's last attempt, or when it was queued if it wasn't attempted
+func (delivery Delivery) lastAttemptAt() time.Time {
+	if len(delivery.Attempts) == 0 {
+		return delivery.CreatedAt
+	}
+	return delivery.Attempts[len(delivery.Attempts)-1].Time
+}
+
+// Flush persists the manager's state to config.Path if it changed since it was last persisted. The state is written without holding the
+// lock, so that deliveries aren't blocked while the file is written
+func (manager *Manager) Flush() error {
+	manager.saveMu.Lock()
+	defer manager.saveMu.Unlock()
+
+	manager.mu.Lock()
+	if manager.config.Path == "" || !manager.dirty {
+		manager.mu.Unlock()
+		return nil
+	}
+	buf, err := json.Marshal(manager.state)
+	if err == nil {
+		manager.dirty = false
+	}
+	manager.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = util.WriteFileAtomic(manager.config.Path, buf); err != nil {
+		manager.mu.Lock()
+		manager.dirty = true
+		manager.mu.Unlock()
+		return fmt.Errorf("Failed to save webhooks: %s", err.Error())
+	}
+	return nil
+}
+
+// logFlush flushes the manager's state, logging failures. It's retried on the next flush
+func (manager *Manager) logFlush() {
+	if err := manager.Flush(); err != nil {
+		log.Printf("%s", err.Error())
+	}
+}
+
+// subscriptionIndex returns the position of the tenant's subscription with the specified id, or -1. The caller must hold the lock
+func (manager *Manager) subscriptionIndex(tenant, id string) int {
+	for i, sub := range manager.state.Subscriptions {
+		if sub.ID == id && sub.Tenant == tenant {
+			return i
+		}
+	}
+	return -1
+}
+
+// deliveryIndex returns the position of the delivery with the specified id, or -1. The caller must hold the lock
+func (manager *Manager) deliveryIndex(id string) int {
+	for i, delivery := range manager.state.Deliveries {
+		if delivery.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ErrNotFound is returned when a subscription or delivery with the specified id doesn't exist
+type ErrNotFound struct {
+	Entity string
+	ID     string
+}
+
+func (err ErrNotFound) Error() string {
+	return fmt.Sprintf("Failed to locate %s with id: %s", err.Entity, err.ID)
+}
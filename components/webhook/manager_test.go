@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receiver is a local webhook endpoint that verifies signatures and responds with the next queued status
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	received []string
+	secret   string
+	t        *testing.T
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses, t: t}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		assert.NoError(t, Verify(r.secret, req.Header.Get(SignatureHeader), body, time.Hour, time.Now()))
+		assert.NotEmpty(t, req.Header.Get(DeliveryHeader))
+		r.received = append(r.received, req.Header.Get(EventHeader))
+
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	return r
+}
+
+func TestManager(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	newManager := func(path string) *Manager {
+		manager, err := New(Config{Path: path, MaxAttempts: 3, InitialBackoff: time.Minute})
+		assert.NoError(t, err)
+		manager.now = func() time.Time { return now }
+		return manager
+	}
+
+	t.Run("delivers matching events", func(t *testing.T) {
+		r := newReceiver(t)
+		defer r.Close()
+		manager := newManager("")
+		sub, err := manager.CreateSubscription(Subscription{URL: r.URL, Events: []string{"rain"}, Active: true})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, sub.Secret)
+		r.secret = sub.Secret
+
+		queued, err := manager.Enqueue("", "rain.alert", []byte(`{}`))
+		assert.NoError(t, err)
+		assert.Len(t, queued, 1)
+		queued, _ = manager.Enqueue("", "customer.created", []byte(`{}`))
+		assert.Empty(t, queued)
+
+		assert.Equal(t, 1, manager.DeliverDue())
+		assert.Equal(t, []string{"rain.alert"}, r.received)
+		delivered := manager.Deliveries("", sub.ID, StatusDelivered)
+		assert.Len(t, delivered, 1)
+		assert.Equal(t, http.StatusOK, delivered[0].Attempts[0].StatusCode)
+		assert.Equal(t, 0, manager.DeliverDue())
+	})
+
+	t.Run("retries with backoff then dead letters", func(t *testing.T) {
+		r := newReceiver(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK)
+		defer r.Close()
+		manager := newManager("")
+		sub, _ := manager.CreateSubscription(Subscription{URL: r.URL, Active: true})
+		r.secret = sub.Secret
+		manager.Enqueue("", "rain.alert", []byte(`{}`))
+
+		assert.Equal(t, 1, manager.DeliverDue())
+		delivery := manager.Deliveries("", "", StatusPending)[0]
+		assert.Equal(t, now.Add(time.Minute), delivery.NextAttemptAt)
+		assert.Equal(t, "Receiver responded with status 500", delivery.Attempts[0].Error)
+
+		// Not due yet
+		assert.Equal(t, 0, manager.DeliverDue())
+
+		now = now.Add(time.Minute)
+		assert.Equal(t, 1, manager.DeliverDue())
+		assert.Equal(t, now.Add(2*time.Minute), manager.Deliveries("", "", StatusPending)[0].NextAttemptAt)
+
+		now = now.Add(2 * time.Minute)
+		assert.Equal(t, 1, manager.DeliverDue())
+		dead := manager.Deliveries("", "", StatusDead)
+		assert.Len(t, dead, 1)
+		assert.Len(t, dead[0].Attempts, 3)
+
+		_, err := manager.Redeliver("", "unknown")
+		assert.Equal(t, ErrNotFound{Entity: "webhook delivery", ID: "unknown"}, err)
+		redelivered, err := manager.Redeliver("", dead[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, StatusPending, redelivered.Status)
+		_, err = manager.Redeliver("", dead[0].ID)
+		assert.Error(t, err)
+
+		assert.Equal(t, 1, manager.DeliverDue())
+		assert.Len(t, manager.Deliveries("", "", StatusDelivered), 1)
+	})
+
+	t.Run("deleted subscriptions are dead lettered", func(t *testing.T) {
+		manager := newManager("")
+		sub, _ := manager.CreateSubscription(Subscription{URL: "http://localhost:1", Active: true})
+		manager.Enqueue("", "rain.alert", []byte(`{}`))
+		assert.NoError(t, manager.DeleteSubscription("", sub.ID))
+		assert.Equal(t, ErrNotFound{Entity: "webhook subscription", ID: sub.ID}, manager.DeleteSubscription("", sub.ID))
+
+		assert.Equal(t, 1, manager.DeliverDue())
+		dead := manager.Deliveries("", sub.ID, StatusDead)
+		assert.Len(t, dead, 1)
+		assert.Equal(t, "Subscription was deleted", dead[0].Attempts[0].Error)
+
+		// Dead deliveries are pruned once they weren't attempted for DeadRetention
+		now = now.Add(DefaultConfig.DeadRetention - time.Second)
+		manager.DeliverDue()
+		assert.Len(t, manager.Deliveries("", sub.ID, StatusDead), 1)
+		now = now.Add(2 * time.Second)
+		manager.DeliverDue()
+		assert.Len(t, manager.Deliveries("", sub.ID, StatusDead), 0)
+	})
+
+	t.Run("queue is persisted", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "webhooks")
+		assert.NoError(t, err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "webhooks.json")
+
+		manager := newManager(path)
+		sub, _ := manager.CreateSubscription(Subscription{Tenant: "acme", URL: "http://localhost:1", Active: true})
+		manager.Enqueue("acme", "rain.alert", []byte(`{"id":1}`))
+
+		// Queued deliveries are saved in batches rather than on every change
+		assert.Len(t, newManager(path).Deliveries("acme", sub.ID, StatusPending), 0)
+		assert.NoError(t, manager.Flush())
+
+		restarted := newManager(path)
+		assert.Equal(t, []Subscription{sub}, restarted.Subscriptions("acme"))
+		assert.Equal(t, []Subscription{}, restarted.Subscriptions("globex"))
+		pending := restarted.Deliveries("acme", sub.ID, StatusPending)
+		assert.Len(t, pending, 1)
+		assert.JSONEq(t, `{"id":1}`, string(pending[0].Payload))
+
+		assert.NoError(t, ioutil.WriteFile(path, []byte("not json"), 0600))
+		_, err = New(Config{Path: path})
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		_, err := newManager("").CreateSubscription(Subscription{URL: "/relative"})
+		assert.EqualError(t, err, "url must be an absolute http or https URL")
+	})
+}
@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the http header carrying the signature of a delivery's payload, formatted as t=<unix timestamp>,v1=<hex signature>.
+// The signature is the HMAC-SHA256 of "<timestamp>.<payload>" keyed with the subscription's secret. Including the timestamp allows
+// receivers to reject replayed deliveries
+const SignatureHeader = "X-Umbrellacorp-Signature"
+
+// Other http headers sent with every delivery
+const (
+	EventHeader    = "X-Umbrellacorp-Event"
+	DeliveryHeader = "X-Umbrellacorp-Delivery"
+)
+
+// Subscription registers a URL to receive the events matching its event types
+type Subscription struct {
+	ID string `json:"id"`
+	// Tenant is the tenant whose events are delivered to the subscription
+	Tenant string `json:"tenant"`
+	URL    string `json:"url"`
+	// Events are the event types delivered to the subscription. A type also matches its sub types, e.g. customer matches
+	// customer.created. Every event is delivered if none are specified
+	Events []string `json:"events"`
+	// Secret is the key used to sign payloads
+	Secret    string    `json:"secret,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AllowLoopback permits subscriptions to loopback URLs, e.g. for tests and local development whose receivers run on the same host.
+// Link-local URLs are rejected regardless
+var AllowLoopback = false
+
+// Validate verifies that the subscription's URL can receive deliveries. URLs of loopback and link-local hosts are rejected, so that
+// subscriptions can't make the server post signed payloads to its own or the cloud provider's internal endpoints
+func (sub Subscription) Validate() error {
+	parsed, err := url.Parse(sub.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	ip := net.ParseIP(host)
+	if ip != nil && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()) {
+		return fmt.Errorf("url must not be a link-local address")
+	}
+	loopback := host == "localhost" || strings.HasSuffix(host, ".localhost") || (ip != nil && (ip.IsLoopback() || ip.IsUnspecified()))
+	if loopback && !AllowLoopback {
+		return fmt.Errorf("url must not be a loopback address")
+	}
+	return nil
+}
+
+// Matches returns true if the tenant's events of the specified type should be delivered to the subscription
+func (sub Subscription) Matches(tenant, eventType string) bool {
+	if !sub.Active || sub.Tenant != tenant {
+		return false
+	}
+	if len(sub.Events) == 0 {
+		return true
+	}
+	for _, event := range sub.Events {
+		if eventType == event || strings.HasPrefix(eventType, event+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the SignatureHeader value of the payload signed with the secret at the specified time
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, signature(secret, ts, payload))
+}
+
+// Verify checks the SignatureHeader value of a payload, and that it was signed no longer than tolerance before now
+func Verify(secret, header string, payload []byte, tolerance time.Duration, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			sig = kv[1]
+		}
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return fmt.Errorf("Malformed signature header")
+	}
+	if now.Sub(time.Unix(unix, 0)) > tolerance {
+		return fmt.Errorf("Signature timestamp is too old")
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, ts, payload))) {
+		return fmt.Errorf("Signature doesn't match payload")
+	}
+	return nil
+}
+
+func signature(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(t *testing.M) {
+	// The receivers of the tests run on the same host
+	AllowLoopback = true
+	os.Exit(t.Run())
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	payload := []byte(`{"topic":"rain.alert"}`)
+	header := Sign("secret", now, payload)
+
+	tests := []struct {
+		name     string
+		secret   string
+		header   string
+		payload  []byte
+		now      time.Time
+		expError string
+	}{
+		{
+			name:    "valid",
+			secret:  "secret",
+			header:  header,
+			payload: payload,
+			now:     now.Add(time.Minute),
+		},
+		{
+			name:     "wrong secret",
+			secret:   "other",
+			header:   header,
+			payload:  payload,
+			now:      now,
+			expError: "Signature doesn't match payload",
+		},
+		{
+			name:     "tampered payload",
+			secret:   "secret",
+			header:   header,
+			payload:  []byte(`{"topic":"customer.deleted"}`),
+			now:      now,
+			expError: "Signature doesn't match payload",
+		},
+		{
+			name:     "replayed",
+			secret:   "secret",
+			header:   header,
+			payload:  payload,
+			now:      now.Add(time.Hour),
+			expError: "Signature timestamp is too old",
+		},
+		{
+			name:     "malformed",
+			secret:   "secret",
+			header:   "sha256=abc",
+			payload:  payload,
+			now:      now,
+			expError: "Malformed signature header",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Verify(test.secret, test.header, test.payload, 5*time.Minute, test.now)
+			if test.expError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.expError)
+			}
+		})
+	}
+}
+
+func TestSubscriptionMatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		sub       Subscription
+		tenant    string
+		eventType string
+		expMatch  bool
+	}{
+		{name: "every event", sub: Subscription{Active: true}, eventType: "rain.alert", expMatch: true},
+		{name: "exact", sub: Subscription{Active: true, Events: []string{"rain.alert"}}, eventType: "rain.alert", expMatch: true},
+		{name: "prefix", sub: Subscription{Active: true, Events: []string{"customer"}}, eventType: "customer.created", expMatch: true},
+		{name: "partial word", sub: Subscription{Active: true, Events: []string{"cust"}}, eventType: "customer.created", expMatch: false},
+		{name: "inactive", sub: Subscription{Events: []string{"rain.alert"}}, eventType: "rain.alert", expMatch: false},
+		{name: "other tenant", sub: Subscription{Active: true, Tenant: "acme"}, tenant: "globex", eventType: "rain.alert", expMatch: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expMatch, test.sub.Matches(test.tenant, test.eventType))
+		})
+	}
+}
+
+func TestSubscriptionValidate(t *testing.T) {
+	AllowLoopback = false
+	defer func() { AllowLoopback = true }()
+
+	tests := []struct {
+		url      string
+		expError string
+	}{
+		{url: "https://hooks.example.com/umbrellacorp"},
+		{url: "http://203.0.113.10:8080/hook"},
+		{url: "/relative", expError: "url must be an absolute http or https URL"},
+		{url: "ftp://hooks.example.com", expError: "url must be an absolute http or https URL"},
+		{url: "http://localhost:8080/hook", expError: "url must not be a loopback address"},
+		{url: "http://api.localhost./hook", expError: "url must not be a loopback address"},
+		{url: "http://127.0.0.1/hook", expError: "url must not be a loopback address"},
+		{url: "http://[::1]/hook", expError: "url must not be a loopback address"},
+		{url: "http://0.0.0.0/hook", expError: "url must not be a loopback address"},
+		{url: "http://169.254.169.254/latest/meta-data", expError: "url must not be a link-local address"},
+		{url: "http://[fe80::1]/hook", expError: "url must not be a link-local address"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			err := Subscription{URL: test.url}.Validate()
+			if test.expError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, test.expError)
+		})
+	}
+
+	// Link-local addresses are rejected even where loopback addresses are allowed
+	AllowLoopback = true
+	assert.NoError(t, Subscription{URL: "http://127.0.0.1/hook"}.Validate())
+	assert.Error(t, Subscription{URL: "http://169.254.169.254/"}.Validate())
+}
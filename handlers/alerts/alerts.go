@@ -0,0 +1,288 @@
+package alerts
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// subscriberBufferSize is the number of events that may be pending delivery to a client before it's disconnected as too slow, so that
+	// a slow client can never hold up publishers such as the forecast refresher
+	subscriberBufferSize = 64
+	// writeWait is how long a single write to the client may take
+	writeWait = 10 * time.Second
+	// pongWait is how long the client may stay silent before the connection is considered dead. Clients answer pings automatically
+	pongWait = 60 * time.Second
+	// pingInterval must be shorter than pongWait so that responsive clients are never timed out
+	pingInterval = pongWait * 9 / 10
+	// maxMessageSize limits the size of subscription messages sent by clients
+	maxMessageSize = 4096
+)
+
+// Topics of the messages sent to clients, in addition to the events they're subscribed to
+const (
+	topicSubscription = "subscription.updated"
+	topicError        = "error"
+)
+
+// feedTopics are the event bus topics pushed to clients
+var feedTopics = []string{"rain.alert", "forecast.changed"}
+
+// bus is the event bus that clients are subscribed to, tests may override it
+var bus = eventbus.Default
+
+var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// Init registers handlers with the router
+func Init() {
+	routes := router.Routes{
+		{
+			Name:        "Live Rain Alerts",
+			Methods:     []string{http.MethodGet},
+			Path:        "/alerts/live",
+			HandlerFunc: liveAlerts,
+			Role:        models.RoleViewer,
+		},
+	}
+	router.RegisterRoutes("alerts", routes)
+}
+
+// territories defines the sales territories that clients may subscribe to, tests may override it
+var territories = territory.Default
+
+// subscription is the set of locations that a client receives alerts for. Empty sets match every location
+type subscription struct {
+	Countries []string `json:"countries"`
+	Cities    []string `json:"cities"`
+	// Territories are the ids of the tenant's territories. Territories are looked up when matching events, so that changes to their
+	// definitions apply to existing subscriptions
+	Territories []string `json:"territories"`
+
+	tenant string
+}
+
+// newSubscription resolves the countries to country codes, so that clients may specify countries by name or code, and verifies that the
+// tenant's territories exist
+func newSubscription(tenant string, countries, cities, territoryIDs []string) (subscription, error) {
+	sub := subscription{Countries: []string{}, Cities: []string{}, Territories: []string{}, tenant: tenant}
+	for _, country := range countries {
+		address, err := models.Address{Country: country}.SetCountryCode()
+		if err != nil {
+			return sub, router.NewError(http.StatusBadRequest, "Unknown country: %s", country)
+		}
+		sub.Countries = append(sub.Countries, address.CountryCode)
+	}
+	sub.Cities = append(sub.Cities, cities...)
+	for _, id := range territoryIDs {
+		if _, ok := territories.Get(tenant).Get(id); !ok {
+			return sub, router.NewError(http.StatusBadRequest, "Unknown territory: %s", id)
+		}
+		sub.Territories = append(sub.Territories, id)
+	}
+	return sub, nil
+}
+
+func (sub subscription) matches(event eventbus.Event) bool {
+	var city, countryCode string
+	switch data := event.Data.(type) {
+	case models.RainAlert:
+		city, countryCode = data.City, data.CountryCode
+	case models.ForecastChange:
+		city, countryCode = data.City, data.CountryCode
+	default:
+		return false
+	}
+
+	return containsFold(sub.Countries, countryCode) && containsFold(sub.Cities, city) && sub.inTerritories(models.Address{City: city, CountryCode: countryCode})
+}
+
+// inTerritories returns true if the subscription has no territories or the address is within one of them. Territories deleted since the
+// client subscribed don't contain any address
+func (sub subscription) inTerritories(address models.Address) bool {
+	if len(sub.Territories) == 0 {
+		return true
+	}
+	registry := territories.Get(sub.tenant)
+	for _, id := range sub.Territories {
+		if found, ok := registry.Get(id); ok && found.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold returns true if values is empty or contains value, case insensitive
+func containsFold(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribeMessage is sent by clients to replace their subscription
+type subscribeMessage struct {
+	Action      string   `json:"action"`
+	Countries   []string `json:"countries"`
+	Cities      []string `json:"cities"`
+	Territories []string `json:"territories"`
+}
+
+// liveAlerts upgrades the connection to a WebSocket that pushes the rain alerts and forecast changes of the request's tenant as they
+// happen. Supported query params:
+//   - countries: comma separated country names or codes to receive alerts for. Defaults to every country
+//   - cities: comma separated cities to receive alerts for. Defaults to every city
+//   - territories: comma separated ids of the tenant's sales territories to receive alerts for. Defaults to every territory
+//
+// Clients may change their subscription at any time by sending {"action": "subscribe", "countries": [...], "cities": [...],
+// "territories": [...]}
+func liveAlerts(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+
+	sub, err := newSubscription(req.Tenant, splitList(req.Query.Get("countries")), splitList(req.Query.Get("cities")), splitList(req.Query.Get("territories")))
+	if err != nil {
+		return resp, err
+	}
+
+	resp.Upgrade = func(w http.ResponseWriter, httpReq *http.Request) {
+		// The upgrader responds to the client itself if the upgrade fails
+		conn, err := upgrader.Upgrade(w, httpReq, nil)
+		if err != nil {
+			return
+		}
+		newClient(conn, req.Tenant, sub).serve()
+	}
+	return resp, nil
+}
+
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// client pushes events to a single WebSocket connection. Only serve writes to the connection and only readMessages reads from it
+type client struct {
+	conn *websocket.Conn
+	// tenant is the tenant whose alerts are pushed to the client
+	tenant string
+
+	mu  sync.Mutex
+	sub subscription
+
+	// replies are messages for serve to write in response to the client's messages
+	replies chan eventbus.Event
+	// closed is closed once serve stops writing to the connection
+	closed chan struct{}
+}
+
+func newClient(conn *websocket.Conn, tenant string, sub subscription) *client {
+	return &client{conn: conn, tenant: tenant, sub: sub, replies: make(chan eventbus.Event, 1), closed: make(chan struct{})}
+}
+
+func (c *client) subscription() subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sub
+}
+
+// serve writes matching events and heartbeats to the connection until either side closes it
+func (c *client) serve() {
+	events, _, _ := bus.SubscribeTenant(c.tenant, feedTopics, 0, subscriberBufferSize)
+	defer events.Close()
+	defer c.conn.Close()
+	defer close(c.closed)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.readMessages()
+	}()
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+
+	c.write(eventbus.Event{Topic: topicSubscription, Time: time.Now(), Data: c.subscription()})
+	for {
+		select {
+		case <-done:
+			return
+		case event, ok := <-events.Events():
+			if !ok {
+				// The bus dropped the subscription because the client isn't keeping up
+				msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "Too slow to keep up with alerts, please reconnect")
+				c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+				return
+			}
+			if c.subscription().matches(event) && c.write(event) != nil {
+				return
+			}
+		case reply := <-c.replies:
+			if c.write(reply) != nil {
+				return
+			}
+		case <-ping.C:
+			if c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *client) write(event eventbus.Event) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.conn.WriteJSON(event)
+}
+
+// readMessages applies subscription messages sent by the client until the connection fails or the client stops answering pings
+func (c *client) readMessages() {
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, buf, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		reply := eventbus.Event{Topic: topicSubscription, Time: time.Now()}
+		var msg subscribeMessage
+		if err = json.Unmarshal(buf, &msg); err != nil || msg.Action != "subscribe" {
+			reply.Topic, reply.Data = topicError, map[string]string{"error": `Expected {"action": "subscribe", "countries": [...], "cities": [...], "territories": [...]}`}
+		} else if sub, err := newSubscription(c.tenant, msg.Countries, msg.Cities, msg.Territories); err != nil {
+			reply.Topic, reply.Data = topicError, map[string]string{"error": err.Error()}
+		} else {
+			c.mu.Lock()
+			c.sub = sub
+			c.mu.Unlock()
+			reply.Data = sub
+		}
+
+		select {
+		case c.replies <- reply:
+		case <-c.closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package alerts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+type message struct {
+	Topic string                 `json:"topic"`
+	Data  map[string]interface{} `json:"data"`
+}
+
+func dial(t *testing.T, query string) (*websocket.Conn, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		resp, err := liveAlerts(router.Request{Query: req.URL.Query()})
+		if err != nil {
+			http.Error(w, err.Error(), router.StatusCode(err))
+			return
+		}
+		resp.Upgrade(w, req)
+	}))
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?"+query, nil)
+	if !assert.NoError(t, err) {
+		server.Close()
+		t.FailNow()
+	}
+	return conn, func() {
+		conn.Close()
+		server.Close()
+	}
+}
+
+func read(t *testing.T, conn *websocket.Conn) message {
+	var msg message
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, conn.ReadJSON(&msg))
+	return msg
+}
+
+func TestLiveAlerts(t *testing.T) {
+	bus = eventbus.New(10)
+	toronto := models.RainAlert{CustomerID: "1", City: "Toronto", CountryCode: "CA"}
+	chicago := models.RainAlert{CustomerID: "2", City: "Chicago", CountryCode: "US"}
+
+	t.Run("filters by country", func(t *testing.T) {
+		conn, closeFn := dial(t, url.Values{"countries": {"Canada"}}.Encode())
+		defer closeFn()
+
+		msg := read(t, conn)
+		assert.Equal(t, topicSubscription, msg.Topic)
+		assert.Equal(t, []interface{}{"CA"}, msg.Data["countries"])
+
+		bus.Publish("rain.alert", chicago)
+		bus.Publish("customer.updated", models.Customer{ID: "1"})
+		bus.Publish("forecast.changed", models.ForecastChange{CustomerID: "1", City: "Toronto", CountryCode: "CA"})
+		bus.Publish("rain.alert", toronto)
+
+		msg = read(t, conn)
+		assert.Equal(t, "forecast.changed", msg.Topic)
+		msg = read(t, conn)
+		assert.Equal(t, "rain.alert", msg.Topic)
+		assert.Equal(t, "1", msg.Data["customer_id"])
+	})
+
+	t.Run("subscription messages", func(t *testing.T) {
+		conn, closeFn := dial(t, "")
+		defer closeFn()
+		read(t, conn)
+
+		assert.NoError(t, conn.WriteJSON(map[string]interface{}{"action": "subscribe", "countries": []string{"Atlantis"}}))
+		msg := read(t, conn)
+		assert.Equal(t, topicError, msg.Topic)
+		assert.Equal(t, "Unknown country: Atlantis", msg.Data["error"])
+
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+		assert.Equal(t, topicError, read(t, conn).Topic)
+
+		assert.NoError(t, conn.WriteJSON(map[string]interface{}{"action": "subscribe", "cities": []string{"chicago"}}))
+		msg = read(t, conn)
+		assert.Equal(t, topicSubscription, msg.Topic)
+		assert.Equal(t, []interface{}{"chicago"}, msg.Data["cities"])
+
+		bus.Publish("rain.alert", toronto)
+		bus.Publish("rain.alert", chicago)
+		assert.Equal(t, "2", read(t, conn).Data["customer_id"])
+	})
+
+	t.Run("slow clients are disconnected", func(t *testing.T) {
+		conn, closeFn := dial(t, "")
+		defer closeFn()
+		read(t, conn)
+
+		// Publishing never blocks, even though the client isn't reading
+		for i := 0; i < subscriberBufferSize*100; i++ {
+			bus.Publish("rain.alert", toronto)
+		}
+
+		var err error
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		for err == nil {
+			_, _, err = conn.ReadMessage()
+		}
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater), err.Error())
+	})
+
+	t.Run("filters by territory", func(t *testing.T) {
+		territories = territory.NewTenants()
+		defer func() { territories = territory.Default }()
+		greatLakes, err := territories.Get(models.DefaultTenant).Create(territory.Territory{
+			Name:   "Great Lakes",
+			Cities: []territory.City{{Name: "Chicago", Country: "US"}, {Name: "Toronto", Country: "CA"}},
+		})
+		assert.NoError(t, err)
+		west, err := territories.Get(models.DefaultTenant).Create(territory.Territory{Name: "West", Cities: []territory.City{{Name: "Vancouver", Country: "CA"}}})
+		assert.NoError(t, err)
+
+		conn, closeFn := dial(t, url.Values{"territories": {west.ID}}.Encode())
+		defer closeFn()
+		msg := read(t, conn)
+		assert.Equal(t, []interface{}{west.ID}, msg.Data["territories"])
+
+		assert.NoError(t, conn.WriteJSON(map[string]interface{}{"action": "subscribe", "countries": []string{"US"}, "territories": []string{greatLakes.ID}}))
+		assert.Equal(t, topicSubscription, read(t, conn).Topic)
+		bus.Publish("rain.alert", toronto)
+		bus.Publish("rain.alert", models.RainAlert{CustomerID: "3", City: "Vancouver", CountryCode: "CA"})
+		bus.Publish("rain.alert", chicago)
+		assert.Equal(t, "2", read(t, conn).Data["customer_id"])
+
+		_, err = liveAlerts(router.Request{Query: url.Values{"territories": {"missing"}}})
+		assert.Equal(t, router.NewError(http.StatusBadRequest, "Unknown territory: missing"), err)
+	})
+
+	t.Run("unknown country", func(t *testing.T) {
+		_, err := liveAlerts(router.Request{Query: url.Values{"countries": {"CA,Atlantis"}}})
+		assert.Equal(t, router.NewError(http.StatusBadRequest, "Unknown country: Atlantis"), err)
+	})
+}
@@ -0,0 +1,203 @@
+package apikeys
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"umbrellacorp/components/auth"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// Config configures authentication
+type Config struct {
+	// KeysPath is the file that API keys are persisted to. Keys are only kept in memory if it's empty
+	KeysPath string
+	// JWTKey is the HS256 key that JWTs are signed with. JWTs aren't accepted if it's empty
+	JWTKey []byte
+	// BootstrapKey is the admin key created when no API keys exist yet. Only its hash is stored
+	BootstrapKey string
+	// BootstrapKeyPath is the file that a generated admin key is written to when no API keys exist yet and BootstrapKey is empty. The
+	// key is never logged, so the file is the only place it can be read from
+	BootstrapKeyPath string
+}
+
+// minBootstrapKeyLength is the shortest bootstrap key accepted. Keys are hashed with a fast hash, so they must be long random values
+const minBootstrapKeyLength = 32
+
+var (
+	configMu sync.RWMutex
+	config   Config
+)
+
+// Configure sets the configuration of authentication. It must be called before Init
+func Configure(c Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+}
+
+// keys stores the API keys accepted by the router
+var keys *auth.KeyStore
+
+// Init registers handlers with the router and enables authentication of every route. If no API keys exist yet, an admin key is created
+// so that the first real keys can be created with it
+func Init() {
+	configMu.RLock()
+	c := config
+	configMu.RUnlock()
+
+	var err error
+	keys, err = auth.NewKeyStore(c.KeysPath)
+	if err != nil {
+		log.Fatalf("Failed to initialize API keys: %s", err.Error())
+	}
+	if len(keys.List()) == 0 {
+		if err = bootstrap(c); err != nil {
+			log.Fatalf("Failed to create bootstrap API key: %s", err.Error())
+		}
+	}
+	router.SetAuthenticator(auth.NewAuthenticator(keys, c.JWTKey))
+
+	routes := router.Routes{
+		{
+			Name:        "Get API Keys",
+			Methods:     []string{http.MethodGet},
+			Path:        "/api-keys",
+			HandlerFunc: getKeys,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Create API Key",
+			Methods:     []string{http.MethodPost},
+			Path:        "/api-keys",
+			HandlerFunc: createKey,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Revoke API Key",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/api-keys/{id}",
+			HandlerFunc: revokeKey,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Who Am I",
+			Methods:     []string{http.MethodGet},
+			Path:        "/whoami",
+			HandlerFunc: whoAmI,
+			Role:        models.RoleViewer,
+		},
+	}
+	router.RegisterRoutes("apikeys", routes)
+}
+
+// bootstrap creates the admin key that the first real keys are created with. The configured bootstrap key is used if there's one,
+// otherwise a key is generated and written to the bootstrap key file, readable only by the current user
+func bootstrap(c Config) error {
+	if c.BootstrapKey != "" {
+		if len(c.BootstrapKey) < minBootstrapKeyLength {
+			return fmt.Errorf("Bootstrap API keys must be at least %d characters", minBootstrapKeyLength)
+		}
+		_, err := keys.Add("bootstrap", models.RoleAdmin, "", c.BootstrapKey)
+		if err == nil {
+			log.Printf("Created the configured bootstrap admin API key. Use it to create other keys, then revoke it")
+		}
+		return err
+	}
+	if c.BootstrapKeyPath == "" {
+		return fmt.Errorf("Either a bootstrap key or a file to write a generated one to is required")
+	}
+
+	// The file is recreated rather than truncated, so that an existing file with looser permissions isn't reused
+	if err := os.Remove(c.BootstrapKeyPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	file, err := os.OpenFile(c.BootstrapKeyPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, plaintext, err := keys.Create("bootstrap", models.RoleAdmin, "")
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteString(plaintext + "\n"); err != nil {
+		return err
+	}
+	log.Printf("Created an admin API key in %s. Use it to create other keys, then revoke it and delete the file", c.BootstrapKeyPath)
+	return file.Close()
+}
+
+// keyRequest is the body of create requests
+type keyRequest struct {
+	Name string      `json:"name" api:"required"`
+	Role models.Role `json:"role" api:"required"`
+}
+
+// getKeys returns every API key of the tenant. Only the hashes of keys are stored, so the keys themselves aren't returned
+func getKeys(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	apiKeys := []auth.APIKey{}
+	for _, apiKey := range keys.List() {
+		if isVisible(req.Tenant, apiKey) {
+			apiKeys = append(apiKeys, apiKey)
+		}
+	}
+	resp.Info["api_keys"] = apiKeys
+	return resp, nil
+}
+
+// isVisible returns true if the API key can be managed from the tenant. Keys that aren't bound to a tenant, such as the bootstrap key,
+// are managed from the default tenant
+func isVisible(tenant string, apiKey auth.APIKey) bool {
+	return apiKey.Tenant == tenant || (apiKey.Tenant == "" && tenant == models.DefaultTenant)
+}
+
+// createKey creates an API key granting the specified role within the tenant. The response contains the key, which can't be retrieved
+// again
+func createKey(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	var keyReq keyRequest
+	if err := req.Parse(&keyReq); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+	if err := keyReq.Role.Validate(); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+
+	apiKey, plaintext, err := keys.Create(keyReq.Name, keyReq.Role, req.Tenant)
+	if err != nil {
+		return resp, err
+	}
+	resp.Info["api_key"] = apiKey
+	resp.Info["key"] = plaintext
+	return resp, nil
+}
+
+// revokeKey revokes the tenant's API key specified by the id path param
+func revokeKey(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	for _, apiKey := range keys.List() {
+		if apiKey.ID == req.Vars["id"] && !isVisible(req.Tenant, apiKey) {
+			return resp, router.NewError(http.StatusNotFound, "%s", auth.ErrNotFound(apiKey.ID).Error())
+		}
+	}
+	if err := keys.Revoke(req.Vars["id"]); err != nil {
+		if _, ok := err.(auth.ErrNotFound); ok {
+			return resp, router.NewError(http.StatusNotFound, "%s", err.Error())
+		}
+		return resp, err
+	}
+	return resp, nil
+}
+
+// whoAmI returns the authenticated principal making the request, and the tenant it's acting for
+func whoAmI(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	resp.Info["principal"] = models.Principal{Subject: req.Actor, Role: req.Role, Tenant: req.Tenant}
+	return resp, nil
+}
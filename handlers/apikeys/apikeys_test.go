@@ -0,0 +1,114 @@
+package apikeys
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"umbrellacorp/components/auth"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateKey(t *testing.T) {
+	keys, _ = auth.NewKeyStore("")
+
+	tests := []struct {
+		name     string
+		info     map[string]interface{}
+		expRole  models.Role
+		expError error
+	}{
+		{
+			name:    "create",
+			info:    map[string]interface{}{"name": "marketing automation", "role": "viewer"},
+			expRole: models.RoleViewer,
+		},
+		{
+			name:     "unknown role",
+			info:     map[string]interface{}{"name": "marketing automation", "role": "owner"},
+			expError: router.NewError(http.StatusBadRequest, "Unknown role: owner"),
+		},
+		{
+			name:     "missing name",
+			info:     map[string]interface{}{"role": "viewer"},
+			expError: router.NewError(http.StatusBadRequest, "Request validation failed: name required"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := createKey(router.Request{Tenant: "acme", Info: test.info})
+			assert.Equal(t, test.expError, err)
+			if err != nil {
+				return
+			}
+
+			apiKey := resp.Info["api_key"].(auth.APIKey)
+			assert.Equal(t, test.expRole, apiKey.Role)
+			found, ok := keys.Lookup(resp.Info["key"].(string))
+			assert.True(t, ok)
+			assert.Equal(t, apiKey, found)
+
+			assert.Equal(t, "acme", apiKey.Tenant)
+
+			// Keys can only be managed from their own tenant
+			resp, _ = getKeys(router.Request{Tenant: "globex"})
+			assert.Equal(t, []auth.APIKey{}, resp.Info["api_keys"])
+			_, err = revokeKey(router.Request{Tenant: "globex", Vars: map[string]string{"id": apiKey.ID}})
+			assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate API key with id: %s", apiKey.ID), err)
+
+			_, err = revokeKey(router.Request{Tenant: "acme", Vars: map[string]string{"id": apiKey.ID}})
+			assert.NoError(t, err)
+			_, err = revokeKey(router.Request{Tenant: "acme", Vars: map[string]string{"id": apiKey.ID}})
+			assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate API key with id: %s", apiKey.ID), err)
+		})
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apikeys")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bootstrap_api_key.txt")
+
+	t.Run("configured key", func(t *testing.T) {
+		keys, _ = auth.NewKeyStore("")
+		configured := strings.Repeat("k", minBootstrapKeyLength)
+		assert.NoError(t, bootstrap(Config{BootstrapKey: configured, BootstrapKeyPath: path}))
+		found, ok := keys.Lookup(configured)
+		assert.True(t, ok)
+		assert.Equal(t, models.RoleAdmin, found.Role)
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+
+		keys, _ = auth.NewKeyStore("")
+		assert.EqualError(t, bootstrap(Config{BootstrapKey: "short"}), "Bootstrap API keys must be at least 32 characters")
+		assert.Empty(t, keys.List())
+	})
+
+	t.Run("generated key is written to a private file", func(t *testing.T) {
+		keys, _ = auth.NewKeyStore("")
+		assert.NoError(t, ioutil.WriteFile(path, []byte("stale"), 0644))
+		assert.NoError(t, bootstrap(Config{BootstrapKeyPath: path}))
+
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		buf, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		found, ok := keys.Lookup(strings.TrimSpace(string(buf)))
+		assert.True(t, ok)
+		assert.Equal(t, models.RoleAdmin, found.Role)
+	})
+
+	t.Run("nowhere to put the key", func(t *testing.T) {
+		keys, _ = auth.NewKeyStore("")
+		assert.Error(t, bootstrap(Config{}))
+		assert.Empty(t, keys.List())
+	})
+}
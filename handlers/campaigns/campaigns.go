@@ -0,0 +1,346 @@
+package campaigns
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"umbrellacorp/components/campaign"
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/components/notify"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/handlers/scope"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// Config configures the campaign handlers
+type Config struct {
+	// Path is the file that campaigns and opt outs are persisted to. They're only kept in memory if it's empty
+	Path string
+	// SMSGatewayURL is the URL that campaign messages sent through the SMS channel are posted to. The channel is unavailable if it's empty
+	SMSGatewayURL string
+}
+
+var (
+	configMu sync.RWMutex
+	config   Config
+)
+
+// Configure sets the configuration of the campaign handlers. It must be called before Init
+func Configure(c Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+}
+
+// campaignCheckInterval is how often campaigns are checked for being due
+const campaignCheckInterval = time.Minute
+
+// campaigns stores the campaigns of every tenant. It's kept in memory until Init loads the configured file, tests may override it
+var campaigns, _ = campaign.New("")
+
+// customers are the customers that campaigns message, tests may override them
+var customers = customerstore.Default
+
+// territories defines the customers accessible to each sales rep, see scope.Customers
+var territories = territory.Default
+
+// timeNow is used when scheduling and running campaigns, tests may override it
+var timeNow = time.Now
+
+// channels are the channels that campaigns can send messages through. The SMS channel is added by Init if a gateway is configured, tests
+// may override it
+var channels = notify.Channels{notify.ChannelWebhook: notify.NewBusChannel(eventbus.Default)}
+
+// Init loads the configured campaigns, registers handlers with the router and starts running scheduled campaigns
+func Init() {
+	configMu.RLock()
+	c := config
+	configMu.RUnlock()
+
+	var err error
+	campaigns, err = campaign.New(c.Path)
+	if err != nil {
+		log.Fatalf("Failed to initialize campaigns: %s", err.Error())
+	}
+	if c.SMSGatewayURL != "" {
+		channels[notify.ChannelSMS] = notify.NewSMSChannel(c.SMSGatewayURL)
+	}
+
+	routes := router.Routes{
+		{
+			Name:        "Create Campaign",
+			Methods:     []string{http.MethodPost},
+			Path:        "/campaigns",
+			HandlerFunc: createCampaign,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Campaigns",
+			Methods:     []string{http.MethodGet},
+			Path:        "/campaigns",
+			HandlerFunc: getCampaigns,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Get Campaign",
+			Methods:     []string{http.MethodGet},
+			Path:        "/campaigns/{id}",
+			HandlerFunc: getCampaign,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Update Campaign",
+			Methods:     []string{http.MethodPut},
+			Path:        "/campaigns/{id}",
+			HandlerFunc: updateCampaign,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Cancel Campaign",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/campaigns/{id}",
+			HandlerFunc: cancelCampaign,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Preview Campaign",
+			Methods:     []string{http.MethodGet},
+			Path:        "/campaigns/{id}/preview",
+			HandlerFunc: previewCampaign,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Run Campaign",
+			Methods:     []string{http.MethodPost},
+			Path:        "/campaigns/{id}/run",
+			HandlerFunc: runCampaignNow,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Opt Out Customer",
+			Methods:     []string{http.MethodPut},
+			Path:        "/customers/{id}/opt-out",
+			HandlerFunc: optOutCustomer,
+			Role:        models.RoleRep,
+		},
+		{
+			Name:        "Opt In Customer",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/customers/{id}/opt-out",
+			HandlerFunc: optInCustomer,
+			Role:        models.RoleRep,
+		},
+	}
+	router.RegisterRoutes("campaigns", routes)
+
+	go runCampaignScheduler()
+}
+
+// parseCampaign parses the campaign in the body of the request, verifying that its channel is available
+func parseCampaign(req router.Request) (campaign.Campaign, error) {
+	var parsed campaign.Campaign
+	if err := req.Parse(&parsed); err != nil {
+		return parsed, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+	if _, ok := channels[parsed.Channel]; !ok {
+		return parsed, router.NewError(http.StatusBadRequest, "Unsupported channel: %s. Supported channels: %v", parsed.Channel, channels.Names())
+	}
+	parsed.Tenant = scope.Tenant(req.Tenant)
+	return parsed, nil
+}
+
+// createCampaign schedules a campaign messaging the customers in its segment
+func createCampaign(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	parsed, err := parseCampaign(req)
+	if err != nil {
+		return resp, err
+	}
+	parsed.CreatedBy, parsed.CreatedAt = req.Actor, timeNow()
+
+	created, err := campaigns.Create(parsed)
+	if err != nil {
+		return resp, campaignError(err)
+	}
+	resp.Info["campaign"] = created
+	return resp, nil
+}
+
+// updateCampaign replaces the campaign specified by the id path param, if it hasn't run yet
+func updateCampaign(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	parsed, err := parseCampaign(req)
+	if err != nil {
+		return resp, err
+	}
+	parsed.ID = req.Vars["id"]
+
+	updated, err := campaigns.Update(parsed)
+	if err != nil {
+		return resp, campaignError(err)
+	}
+	resp.Info["campaign"] = updated
+	return resp, nil
+}
+
+// cancelCampaign cancels the campaign specified by the id path param, if it hasn't run yet
+func cancelCampaign(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	cancelled, err := campaigns.Cancel(scope.Tenant(req.Tenant), req.Vars["id"])
+	if err != nil {
+		return resp, campaignError(err)
+	}
+	resp.Info["campaign"] = cancelled
+	return resp, nil
+}
+
+// getCampaigns returns the tenant's campaigns, in the order they were created
+func getCampaigns(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	resp.Info["campaigns"] = campaigns.Campaigns(scope.Tenant(req.Tenant))
+	return resp, nil
+}
+
+// getCampaign returns the campaign specified by the id path param along with its runs
+func getCampaign(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	found, ok := campaigns.Campaign(scope.Tenant(req.Tenant), req.Vars["id"])
+	if !ok {
+		return resp, campaignError(campaign.ErrNotFound{Kind: "campaign", ID: req.Vars["id"]})
+	}
+	resp.Info["campaign"] = found
+	resp.Info["runs"] = campaigns.Runs(found.Tenant, found.ID)
+	return resp, nil
+}
+
+// previewCampaign returns the messages that the campaign specified by the id path param would send if it ran, to the customers
+// accessible to the caller
+func previewCampaign(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	found, ok := campaigns.Campaign(scope.Tenant(req.Tenant), req.Vars["id"])
+	if !ok {
+		return resp, campaignError(campaign.ErrNotFound{Kind: "campaign", ID: req.Vars["id"]})
+	}
+
+	previews, err := campaign.PreviewMessages(found, scope.Customers(req, customers, territories).List(), campaigns.OptedOut(found.Tenant))
+	if err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+	resp.Info["recipients"] = previews
+	resp.Info["total_count"] = len(previews)
+	return resp, nil
+}
+
+// runCampaignNow runs the scheduled campaign specified by the id path param without waiting for its schedule
+func runCampaignNow(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	tenantID := scope.Tenant(req.Tenant)
+	run, err := runCampaign(tenantID, req.Vars["id"], customers.Get(tenantID).List())
+	if err != nil {
+		return resp, campaignError(err)
+	}
+	resp.Info["run"] = run
+	return resp, nil
+}
+
+// runCampaign messages the tenant's customers that are in the scheduled campaign's segment through its channel
+func runCampaign(tenantID, id string, recipients models.Customers) (campaign.Run, error) {
+	started, err := campaigns.Start(tenantID, id)
+	if err != nil {
+		return campaign.Run{}, err
+	}
+
+	channel, ok := channels[started.Channel]
+	if !ok {
+		// The channel was configured when the campaign was created, but isn't anymore
+		channel = unavailableChannel(started.Channel)
+	}
+	run := campaign.Execute(started, recipients, campaigns.OptedOut(tenantID), channel, timeNow())
+	run.CompletedAt = timeNow()
+	return run, campaigns.Complete(run)
+}
+
+// unavailableChannel fails to send every message because its channel isn't configured
+type unavailableChannel string
+
+func (channel unavailableChannel) Send(message notify.Message) error {
+	return fmt.Errorf("The %s channel isn't configured", string(channel))
+}
+
+// runCampaignScheduler periodically runs the campaigns of every tenant that are due. It never returns, so it should be run in its own
+// goroutine
+func runCampaignScheduler() {
+	for {
+		time.Sleep(campaignCheckInterval)
+		runDueCampaigns()
+	}
+}
+
+// runDueCampaigns runs the campaigns of every tenant whose schedule has passed
+func runDueCampaigns() int {
+	ran := 0
+	for _, due := range campaigns.Due(timeNow()) {
+		// Customers are read while no atomic batch is in progress, so that customers created by a batch that's rolled back aren't messaged
+		var tenantCustomers models.Customers
+		router.WithMutationLock(func() {
+			tenantCustomers = customers.Get(due.Tenant).List()
+		})
+		run, err := runCampaign(due.Tenant, due.ID, tenantCustomers)
+		if err != nil {
+			log.Printf("Failed to run campaign %s: %s", due.ID, err.Error())
+			continue
+		}
+		log.Printf("Ran campaign %s: %v", due.ID, run.Counts)
+		ran++
+	}
+	return ran
+}
+
+// optOutCustomer records that the customer specified by the id path param no longer wants to receive campaign messages
+func optOutCustomer(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	customer, ok := scope.Customers(req, customers, territories).Get(req.Vars["id"])
+	if !ok {
+		return resp, customerNotFound(req.Vars["id"])
+	}
+	optOut, err := campaigns.OptOut(campaign.OptOut{Tenant: scope.Tenant(req.Tenant), CustomerID: customer.ID, By: req.Actor, At: timeNow()})
+	if err != nil {
+		return resp, err
+	}
+	resp.Info["opt_out"] = optOut
+	return resp, nil
+}
+
+// optInCustomer removes the opt out of the customer specified by the id path param
+func optInCustomer(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	customer, ok := scope.Customers(req, customers, territories).Get(req.Vars["id"])
+	if !ok {
+		return resp, customerNotFound(req.Vars["id"])
+	}
+	if err := campaigns.OptIn(scope.Tenant(req.Tenant), customer.ID); err != nil {
+		return resp, err
+	}
+	resp.Info["customer_id"] = customer.ID
+	return resp, nil
+}
+
+// campaignError translates errors returned by the campaign store into router errors with the appropriate status
+func campaignError(err error) error {
+	switch err.(type) {
+	case campaign.ErrNotFound:
+		return router.NewError(http.StatusNotFound, "%s", err.Error())
+	case campaign.ErrConflict:
+		return router.NewError(http.StatusConflict, "%s", err.Error())
+	}
+	return router.NewError(http.StatusBadRequest, "%s", err.Error())
+}
+
+// customerNotFound returns the error of customers that don't exist or aren't accessible to the caller
+func customerNotFound(id string) error {
+	return router.NewError(http.StatusNotFound, "%s", customerstore.ErrNotFound(id).Error())
+}
@@ -0,0 +1,161 @@
+package campaigns
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+	"umbrellacorp/components/campaign"
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/components/notify"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(t *testing.M) {
+	timeNow = func() time.Time { return time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC) }
+	os.Exit(t.Run())
+}
+
+// newCustomers returns customer stores whose default tenant's store contains the specified customers
+func newCustomers(existingCustomers ...models.Customer) *customerstore.Tenants {
+	return customerstore.NewTenants(func(tenantID string) *customerstore.Store {
+		if tenantID == models.DefaultTenant {
+			return customerstore.New(existingCustomers...)
+		}
+		return customerstore.New()
+	})
+}
+
+func TestCampaigns(t *testing.T) {
+	rain := []models.Weather{{Date: time.Date(2017, 02, 17, 6, 0, 0, 0, time.UTC), Type: models.WeatherTypeRain}}
+	seattle := models.Customer{ID: "1", Name: "Seattle Company", Contact: "Jane", ContactNumber: "2065555555", Address: models.Address{City: "Seattle", Country: "US", CountryCode: "US"}, WeatherDetails: rain}
+	portland := models.Customer{ID: "2", Name: "Portland Company", Contact: "Sam", ContactNumber: "5035555555", Address: models.Address{City: "Portland", Country: "US", CountryCode: "US"}, WeatherDetails: rain}
+	boston := models.Customer{ID: "3", Name: "Boston Company", ContactNumber: "6175555555", Address: models.Address{City: "Boston", Country: "US", CountryCode: "US"}, WeatherDetails: rain}
+	customers = newCustomers(seattle, portland, boston)
+	campaigns, _ = campaign.New("")
+	bus := eventbus.New(10)
+	channels = notify.Channels{notify.ChannelWebhook: notify.NewBusChannel(bus)}
+	defer func() { channels = notify.Channels{notify.ChannelWebhook: notify.NewBusChannel(eventbus.Default)} }()
+	subscription, _, _ := bus.Subscribe([]string{notify.TopicMessage}, 0, 10)
+
+	admin := router.Request{Actor: "marketing", Role: models.RoleAdmin}
+	create := admin
+	create.Info = map[string]interface{}{
+		"name":     "Rainy week in the Pacific Northwest",
+		"channel":  "fax",
+		"template": "Hi {{.Contact}}, rain is forecast in {{.City}} on {{.FirstRain}}",
+		"segment":  map[string]interface{}{"cities": []string{"Seattle", "Portland"}, "rain_within_hours": 72},
+	}
+	_, err := createCampaign(create)
+	assert.Equal(t, router.NewError(http.StatusBadRequest, "Unsupported channel: fax. Supported channels: [webhook]"), err)
+
+	create.Info["channel"] = notify.ChannelWebhook
+	resp, err := createCampaign(create)
+	assert.NoError(t, err)
+	created := resp.Info["campaign"].(campaign.Campaign)
+	assert.Equal(t, campaign.StatusScheduled, created.Status)
+
+	_, err = optOutCustomer(router.Request{Actor: "rep", Role: models.RoleAdmin, Vars: map[string]string{"id": portland.ID}})
+	assert.NoError(t, err)
+
+	resp, err = previewCampaign(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": created.ID}})
+	assert.NoError(t, err)
+	assert.Equal(t, []campaign.Preview{
+		{CustomerID: "1", CustomerName: "Seattle Company", To: "2065555555", Message: "Hi Jane, rain is forecast in Seattle on Friday, February 17"},
+		{CustomerID: "2", CustomerName: "Portland Company", To: "5035555555", OptedOut: true, Message: "Hi Sam, rain is forecast in Portland on Friday, February 17"},
+	}, resp.Info["recipients"])
+
+	// Campaigns without a schedule only run manually, and only once
+	assert.Equal(t, 0, runDueCampaigns())
+	resp, err = runCampaignNow(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": created.ID}})
+	assert.NoError(t, err)
+	run := resp.Info["run"].(campaign.Run)
+	assert.Equal(t, map[campaign.OutcomeStatus]int{campaign.OutcomeSent: 1, campaign.OutcomeOptedOut: 1}, run.Counts)
+	_, err = runCampaignNow(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": created.ID}})
+	assert.Equal(t, router.NewError(http.StatusConflict, "Campaign %s is completed", created.ID), err)
+
+	event := <-subscription.Events()
+	message := event.Data.(notify.Message)
+	assert.Equal(t, seattle.ID, message.CustomerID)
+	assert.Equal(t, "Hi Jane, rain is forecast in Seattle on Friday, February 17", message.Body)
+
+	resp, err = getCampaign(router.Request{Role: models.RoleViewer, Vars: map[string]string{"id": created.ID}})
+	assert.NoError(t, err)
+	assert.Equal(t, campaign.StatusCompleted, resp.Info["campaign"].(campaign.Campaign).Status)
+	assert.Equal(t, []campaign.Run{run}, resp.Info["runs"])
+}
+
+func TestScheduledCampaigns(t *testing.T) {
+	customers = newCustomers(models.Customer{ID: "1", Name: "Seattle Company", ContactNumber: "2065555555", Address: models.Address{City: "Seattle", CountryCode: "US"}})
+	campaigns, _ = campaign.New("")
+	sent := &recordingChannel{}
+	channels = notify.Channels{notify.ChannelSMS: sent}
+	defer func() { channels = notify.Channels{notify.ChannelWebhook: notify.NewBusChannel(eventbus.Default)} }()
+
+	later := timeNow().Add(time.Hour)
+	for _, scheduledAt := range []time.Time{timeNow(), later} {
+		_, err := createCampaign(router.Request{Role: models.RoleAdmin, Info: map[string]interface{}{
+			"name":         "Rainy day",
+			"channel":      notify.ChannelSMS,
+			"template":     "Hi {{.Name}}",
+			"scheduled_at": scheduledAt,
+		}})
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 1, runDueCampaigns())
+	assert.Equal(t, 0, runDueCampaigns())
+	assert.Equal(t, []string{"Hi Seattle Company"}, sent.bodies)
+
+	// Campaigns whose channel is no longer configured fail every recipient
+	channels = notify.Channels{}
+	due := campaigns.Campaigns(models.DefaultTenant)[1]
+	run, err := runCampaign(models.DefaultTenant, due.ID, customers.Get(models.DefaultTenant).List())
+	assert.NoError(t, err)
+	assert.Equal(t, "The sms channel isn't configured", run.Outcomes[0].Error)
+
+	// Opted out customers can opt back in
+	req := router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": "1"}}
+	_, err = optOutCustomer(req)
+	assert.NoError(t, err)
+	_, err = optInCustomer(req)
+	assert.NoError(t, err)
+	assert.Empty(t, campaigns.OptedOut(models.DefaultTenant))
+	_, err = optOutCustomer(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": "missing"}})
+	assert.Equal(t, http.StatusNotFound, router.StatusCode(err))
+}
+
+// recordingChannel records the bodies of the messages sent through it
+type recordingChannel struct {
+	bodies []string
+}
+
+func (channel *recordingChannel) Send(message notify.Message) error {
+	channel.bodies = append(channel.bodies, message.Body)
+	return nil
+}
+
+func TestCampaignRainWithClock(t *testing.T) {
+	// The forecasts stored for customers only cover weatherforecaster.ForecastRange, so rain segments have to match regardless of the
+	// current time
+	timeNow = time.Now
+	defer func() { timeNow = func() time.Time { return time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC) } }()
+	rain := []models.Weather{{Date: time.Date(2017, 02, 17, 6, 0, 0, 0, time.UTC), Type: models.WeatherTypeRain}}
+	customers = newCustomers(models.Customer{ID: "1", Name: "Seattle Company", ContactNumber: "2065555555", Address: models.Address{City: "Seattle", CountryCode: "US"}, WeatherDetails: rain})
+	campaigns, _ = campaign.New("")
+
+	resp, err := createCampaign(router.Request{Role: models.RoleAdmin, Info: map[string]interface{}{
+		"name":     "Rain tomorrow",
+		"channel":  notify.ChannelWebhook,
+		"template": "Rain is forecast on {{.FirstRain}}",
+		"segment":  map[string]interface{}{"rain_within_hours": 48},
+	}})
+	assert.NoError(t, err)
+	resp, err = previewCampaign(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": resp.Info["campaign"].(campaign.Campaign).ID}})
+	assert.NoError(t, err)
+	assert.Equal(t, []campaign.Preview{{CustomerID: "1", CustomerName: "Seattle Company", To: "2065555555", Message: "Rain is forecast on Friday, February 17"}}, resp.Info["recipients"])
+}
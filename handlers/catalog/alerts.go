@@ -0,0 +1,138 @@
+package catalog
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"umbrellacorp/components/catalog"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/models"
+)
+
+// topicLowStock is the topic of the alerts published when a warehouse becomes low on a product while rain is forecast for many of its
+// customers
+const topicLowStock = "stock.low"
+
+// eventBus is the bus that low stock alerts are published to, tests may override it
+var eventBus = eventbus.Default
+
+// busBufferSize is the number of customer events that may be pending before the subscription to the bus is dropped and resumed from the
+// bus's replay buffer
+const busBufferSize = 1000
+
+// customerCache holds the customers of each tenant with rain in their forecast, as published to the event bus by the customer handlers.
+// It's safe for concurrent use
+type customerCache struct {
+	mu        sync.RWMutex
+	customers map[string]map[string]models.Customer
+}
+
+func newCustomerCache() *customerCache {
+	return &customerCache{customers: map[string]map[string]models.Customer{}}
+}
+
+// rainyCustomers are the customers that low stock alerts are based on, tests may override it
+var rainyCustomers = newCustomerCache()
+
+// apply updates the cache from a customer event, returning true if the tenant's rainy customers changed
+func (cache *customerCache) apply(event eventbus.Event) bool {
+	customer, ok := event.Data.(models.Customer)
+	if !ok {
+		return false
+	}
+	tenant := event.Tenant
+	if tenant == "" {
+		tenant = models.DefaultTenant
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	customers, ok := cache.customers[tenant]
+	if !ok {
+		customers = map[string]models.Customer{}
+		cache.customers[tenant] = customers
+	}
+
+	_, wasRainy := customers[customer.ID]
+	if event.Topic == "customer.deleted" || customer.RainDays() == 0 {
+		delete(customers, customer.ID)
+		return wasRainy
+	}
+	customers[customer.ID] = customer
+	return true
+}
+
+// isOrderEvent returns true if the event describes an order being placed or changing status, which changes the stock available
+func isOrderEvent(event eventbus.Event) bool {
+	return strings.HasPrefix(event.Topic, "order.")
+}
+
+// list returns the tenant's customers with rain in their forecast
+func (cache *customerCache) list(tenant string) models.Customers {
+	if tenant == "" {
+		tenant = models.DefaultTenant
+	}
+
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	customers := models.Customers{}
+	for _, customer := range cache.customers[tenant] {
+		customers = append(customers, customer)
+	}
+	return customers
+}
+
+// trackRainyCustomers keeps rainyCustomers in sync with the customer events published to the bus, and checks the low stock of tenants
+// whose rainy customers change or whose orders reserve or ship stock. It never returns, so it should be run in its own goroutine
+func trackRainyCustomers(bus *eventbus.Bus) {
+	var lastID uint64
+	for {
+		subscription, replay, complete := bus.Subscribe([]string{"customer", "order"}, lastID, busBufferSize)
+		if !complete {
+			log.Printf("Customer and order events published after event %d were evicted from the replay buffer before low stock was checked", lastID)
+		}
+		for _, event := range replay {
+			if rainyCustomers.apply(event) || isOrderEvent(event) {
+				checkLowStock(event.Tenant)
+			}
+			lastID = event.ID
+		}
+		for event := range subscription.Events() {
+			if rainyCustomers.apply(event) || isOrderEvent(event) {
+				checkLowStock(event.Tenant)
+			}
+			lastID = event.ID
+		}
+		// The bus dropped the subscription because checking fell behind, resume from the last event
+	}
+}
+
+var (
+	alertedMu sync.Mutex
+	// alerted holds the low stock currently alerted for each tenant, so that each product a warehouse is low on is only alerted once until
+	// it's restocked or rain is no longer forecast for its customers
+	alerted = map[string]map[string]bool{}
+)
+
+// checkLowStock publishes an alert for each product that a warehouse of the tenant has newly become low on
+func checkLowStock(tenant string) []catalog.StockAlert {
+	if tenant == "" {
+		tenant = models.DefaultTenant
+	}
+	alerts := catalogs.Get(tenant).LowStock(rainyCustomers.list(tenant), currentConfig().MinRainyCustomers)
+
+	alertedMu.Lock()
+	defer alertedMu.Unlock()
+	low := map[string]bool{}
+	published := []catalog.StockAlert{}
+	for _, alert := range alerts {
+		key := alert.WarehouseID + "|" + alert.ProductID
+		low[key] = true
+		if !alerted[tenant][key] {
+			eventBus.PublishTenant(tenant, topicLowStock, alert)
+			published = append(published, alert)
+		}
+	}
+	alerted[tenant] = low
+	return published
+}
@@ -0,0 +1,378 @@
+package catalog
+
+import (
+	"net/http"
+	"sync"
+	"umbrellacorp/components/catalog"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// Config configures the catalog handlers
+type Config struct {
+	// MinRainyCustomers is the number of customers served by a warehouse that must have rain in their forecast before the warehouse's low
+	// stock is alerted
+	MinRainyCustomers int
+}
+
+// DefaultConfig alerts low stock once rain is forecast for 5 of a warehouse's customers
+var DefaultConfig = Config{MinRainyCustomers: 5}
+
+var (
+	configMu sync.RWMutex
+	config   = DefaultConfig
+)
+
+// Configure sets the configuration of the catalog handlers. Zero values keep their defaults
+func Configure(c Config) {
+	if c.MinRainyCustomers <= 0 {
+		c.MinRainyCustomers = DefaultConfig.MinRainyCustomers
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+}
+
+func currentConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+// catalogs store each tenant's products, warehouses and stock
+var catalogs = catalog.Default
+
+// Init registers handlers with the router, and starts tracking the customers that rain is forecast for
+func Init() {
+	routes := router.Routes{
+		{
+			Name:        "Get Products",
+			Methods:     []string{http.MethodGet},
+			Path:        "/products",
+			HandlerFunc: getProducts,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Create Product",
+			Methods:     []string{http.MethodPost},
+			Path:        "/products",
+			HandlerFunc: createProduct,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Product",
+			Methods:     []string{http.MethodGet},
+			Path:        "/products/{id}",
+			HandlerFunc: getProduct,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Update Product",
+			Methods:     []string{http.MethodPut},
+			Path:        "/products/{id}",
+			HandlerFunc: updateProduct,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Delete Product",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/products/{id}",
+			HandlerFunc: deleteProduct,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Product Stock",
+			Methods:     []string{http.MethodGet},
+			Path:        "/products/{id}/stock",
+			HandlerFunc: getStock,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Set Product Stock",
+			Methods:     []string{http.MethodPut},
+			Path:        "/products/{id}/stock/{warehouse}",
+			HandlerFunc: setStock,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Warehouses",
+			Methods:     []string{http.MethodGet},
+			Path:        "/warehouses",
+			HandlerFunc: getWarehouses,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Create Warehouse",
+			Methods:     []string{http.MethodPost},
+			Path:        "/warehouses",
+			HandlerFunc: createWarehouse,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Warehouse",
+			Methods:     []string{http.MethodGet},
+			Path:        "/warehouses/{id}",
+			HandlerFunc: getWarehouse,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Update Warehouse",
+			Methods:     []string{http.MethodPut},
+			Path:        "/warehouses/{id}",
+			HandlerFunc: updateWarehouse,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Delete Warehouse",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/warehouses/{id}",
+			HandlerFunc: deleteWarehouse,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Reservations",
+			Methods:     []string{http.MethodGet},
+			Path:        "/reservations",
+			HandlerFunc: getReservations,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Reserve Stock",
+			Methods:     []string{http.MethodPost},
+			Path:        "/reservations",
+			HandlerFunc: reserveStock,
+			Role:        models.RoleRep,
+		},
+		{
+			Name:        "Release Reservation",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/reservations/{id}",
+			HandlerFunc: releaseReservation,
+			Role:        models.RoleRep,
+		},
+		{
+			Name:        "Get Low Stock",
+			Methods:     []string{http.MethodGet},
+			Path:        "/stock/alerts",
+			HandlerFunc: getLowStock,
+			Role:        models.RoleViewer,
+		},
+	}
+	router.RegisterRoutes("catalog", routes)
+
+	go trackRainyCustomers(eventbus.Default)
+}
+
+// getProducts returns every product
+func getProducts(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	resp.Info["products"] = catalogs.Get(req.Tenant).Products()
+	return resp, nil
+}
+
+// getProduct returns the product specified by the id path param
+func getProduct(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	product, ok := catalogs.Get(req.Tenant).Product(req.Vars["id"])
+	if !ok {
+		return resp, catalogError(catalog.ErrNotFound{Kind: "product", ID: req.Vars["id"]})
+	}
+	resp.Info["product"] = product
+	return resp, nil
+}
+
+// createProduct creates a product from its sku, description and price tiers. SKUs are unique within a tenant's catalog
+func createProduct(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	var product catalog.Product
+	if err := req.Parse(&product); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+
+	product, err := catalogs.Get(req.Tenant).CreateProduct(product)
+	if err != nil {
+		return resp, catalogError(err)
+	}
+	resp.Info["product"] = product
+	return resp, nil
+}
+
+// updateProduct replaces the product specified by the id path param. Its stock is kept
+func updateProduct(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	var product catalog.Product
+	if err := req.Parse(&product); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+
+	product.ID = req.Vars["id"]
+	product, err := catalogs.Get(req.Tenant).UpdateProduct(product)
+	if err != nil {
+		return resp, catalogError(err)
+	}
+	checkLowStock(req.Tenant)
+	resp.Info["product"] = product
+	return resp, nil
+}
+
+// deleteProduct deletes the product specified by the id path param along with its stock
+func deleteProduct(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	if err := catalogs.Get(req.Tenant).DeleteProduct(req.Vars["id"]); err != nil {
+		return resp, catalogError(err)
+	}
+	return resp, nil
+}
+
+// getStock returns the stock of the product specified by the id path param at every warehouse
+func getStock(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	stock, err := catalogs.Get(req.Tenant).Stock(req.Vars["id"])
+	if err != nil {
+		return resp, catalogError(err)
+	}
+	resp.Info["stock"] = stock
+	return resp, nil
+}
+
+// stockRequest is the body of set stock requests
+type stockRequest struct {
+	OnHand *int `json:"on_hand" api:"required"`
+}
+
+// setStock sets the stock on hand of the product specified by the id path param at the warehouse specified by the warehouse path param
+func setStock(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	var stockReq stockRequest
+	if err := req.Parse(&stockReq); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+
+	stock, err := catalogs.Get(req.Tenant).SetStock(req.Vars["id"], req.Vars["warehouse"], *stockReq.OnHand)
+	if err != nil {
+		return resp, catalogError(err)
+	}
+	checkLowStock(req.Tenant)
+	resp.Info["stock"] = stock
+	return resp, nil
+}
+
+// getWarehouses returns every warehouse
+func getWarehouses(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	resp.Info["warehouses"] = catalogs.Get(req.Tenant).Warehouses()
+	return resp, nil
+}
+
+// getWarehouse returns the warehouse specified by the id path param
+func getWarehouse(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	warehouse, ok := catalogs.Get(req.Tenant).Warehouse(req.Vars["id"])
+	if !ok {
+		return resp, catalogError(catalog.ErrNotFound{Kind: "warehouse", ID: req.Vars["id"]})
+	}
+	resp.Info["warehouse"] = warehouse
+	return resp, nil
+}
+
+// createWarehouse creates a warehouse from the countries and cities it serves. Countries may be specified by name or ISO-3166 code
+func createWarehouse(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	var warehouse catalog.Warehouse
+	if err := req.Parse(&warehouse); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+
+	warehouse, err := catalogs.Get(req.Tenant).CreateWarehouse(warehouse)
+	if err != nil {
+		return resp, catalogError(err)
+	}
+	checkLowStock(req.Tenant)
+	resp.Info["warehouse"] = warehouse
+	return resp, nil
+}
+
+// updateWarehouse replaces the warehouse specified by the id path param. Its stock is kept
+func updateWarehouse(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	var warehouse catalog.Warehouse
+	if err := req.Parse(&warehouse); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+
+	warehouse.ID = req.Vars["id"]
+	warehouse, err := catalogs.Get(req.Tenant).UpdateWarehouse(warehouse)
+	if err != nil {
+		return resp, catalogError(err)
+	}
+	checkLowStock(req.Tenant)
+	resp.Info["warehouse"] = warehouse
+	return resp, nil
+}
+
+// deleteWarehouse deletes the warehouse specified by the id path param along with its stock
+func deleteWarehouse(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	if err := catalogs.Get(req.Tenant).DeleteWarehouse(req.Vars["id"]); err != nil {
+		return resp, catalogError(err)
+	}
+	return resp, nil
+}
+
+// getReservations returns every reservation of stock
+func getReservations(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	resp.Info["reservations"] = catalogs.Get(req.Tenant).Reservations()
+	return resp, nil
+}
+
+// reserveStock reserves a quantity of a product at a warehouse, so that it isn't sold twice. The request fails with a 409 if not enough
+// of the product is available at the warehouse
+func reserveStock(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	var reservation catalog.Reservation
+	if err := req.Parse(&reservation); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+
+	reservation, err := catalogs.Get(req.Tenant).Reserve(reservation)
+	if err != nil {
+		return resp, catalogError(err)
+	}
+	checkLowStock(req.Tenant)
+	resp.Info["reservation"] = reservation
+	return resp, nil
+}
+
+// releaseReservation releases the reservation specified by the id path param, making its stock available again
+func releaseReservation(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	reservation, err := catalogs.Get(req.Tenant).Release(req.Vars["id"])
+	if err != nil {
+		return resp, catalogError(err)
+	}
+	checkLowStock(req.Tenant)
+	resp.Info["reservation"] = reservation
+	return resp, nil
+}
+
+// getLowStock returns the products that warehouses are low on while rain is forecast for many of the customers they serve
+func getLowStock(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	resp.Info["alerts"] = catalogs.Get(req.Tenant).LowStock(rainyCustomers.list(req.Tenant), currentConfig().MinRainyCustomers)
+	return resp, nil
+}
+
+// catalogError translates errors returned by the catalog into router errors with the appropriate status. Other errors are validation
+// failures
+func catalogError(err error) error {
+	switch err.(type) {
+	case catalog.ErrNotFound:
+		return router.NewError(http.StatusNotFound, "%s", err.Error())
+	case catalog.ErrConflict, catalog.ErrInsufficientStock:
+		return router.NewError(http.StatusConflict, "%s", err.Error())
+	}
+	return router.NewError(http.StatusBadRequest, "%s", err.Error())
+}
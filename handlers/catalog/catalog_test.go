@@ -0,0 +1,133 @@
+package catalog
+
+import (
+	"net/http"
+	"testing"
+	"time"
+	"umbrellacorp/components/catalog"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateProduct(t *testing.T) {
+	catalogs = catalog.NewTenants()
+
+	tests := []struct {
+		name     string
+		info     map[string]interface{}
+		expError error
+	}{
+		{
+			name: "create",
+			info: map[string]interface{}{"sku": "umb-1", "name": "Compact", "price_tiers": []map[string]interface{}{{"min_quantity": 1, "unit_price_cents": 1500}}},
+		},
+		{
+			name:     "missing price tiers",
+			info:     map[string]interface{}{"sku": "umb-2", "name": "Golf"},
+			expError: router.NewError(http.StatusBadRequest, "Request validation failed: price_tiers required"),
+		},
+		{
+			name:     "duplicate sku",
+			info:     map[string]interface{}{"sku": "UMB-1", "name": "Compact", "price_tiers": []map[string]interface{}{{"min_quantity": 1}}},
+			expError: router.NewError(http.StatusConflict, "An existing product has the sku UMB-1"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := createProduct(router.Request{Info: test.info})
+			assert.Equal(t, test.expError, err)
+			if err != nil {
+				return
+			}
+
+			created := resp.Info["product"].(catalog.Product)
+			assert.Equal(t, "UMB-1", created.SKU)
+			resp, err = getProduct(router.Request{Vars: map[string]string{"id": created.ID}})
+			assert.NoError(t, err)
+			assert.Equal(t, created, resp.Info["product"])
+
+			// Other tenants have their own catalogs
+			_, err = getProduct(router.Request{Tenant: "globex", Vars: map[string]string{"id": created.ID}})
+			assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate product with id: %s", created.ID), err)
+		})
+	}
+}
+
+func TestReserveStock(t *testing.T) {
+	catalogs = catalog.NewTenants()
+	product, _ := catalogs.Get(models.DefaultTenant).CreateProduct(catalog.Product{SKU: "UMB-1", Name: "Compact", PriceTiers: []catalog.PriceTier{{MinQuantity: 1}}})
+	warehouse, _ := catalogs.Get(models.DefaultTenant).CreateWarehouse(catalog.Warehouse{Name: "Canada", Countries: []string{"CA"}})
+	vars := map[string]string{"id": product.ID, "warehouse": warehouse.ID}
+
+	_, err := setStock(router.Request{Vars: vars, Info: map[string]interface{}{}})
+	assert.Equal(t, router.NewError(http.StatusBadRequest, "Request validation failed: on_hand required"), err)
+	resp, err := setStock(router.Request{Vars: vars, Info: map[string]interface{}{"on_hand": 3}})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, resp.Info["stock"].(catalog.Stock).Available)
+
+	reserve := map[string]interface{}{"product_id": product.ID, "warehouse_id": warehouse.ID, "quantity": 2}
+	resp, err = reserveStock(router.Request{Info: reserve})
+	assert.NoError(t, err)
+	reservation := resp.Info["reservation"].(catalog.Reservation)
+	_, err = reserveStock(router.Request{Info: reserve})
+	assert.Equal(t, http.StatusConflict, err.(router.Error).Status)
+
+	_, err = releaseReservation(router.Request{Vars: map[string]string{"id": reservation.ID}})
+	assert.NoError(t, err)
+	_, err = releaseReservation(router.Request{Vars: map[string]string{"id": reservation.ID}})
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate reservation with id: %s", reservation.ID), err)
+}
+
+func TestLowStockAlerts(t *testing.T) {
+	catalogs = catalog.NewTenants()
+	rainyCustomers = newCustomerCache()
+	eventBus = eventbus.New(10)
+	defer func() { eventBus = eventbus.Default }()
+	Configure(Config{MinRainyCustomers: 2})
+	defer Configure(DefaultConfig)
+
+	product, _ := catalogs.Get(models.DefaultTenant).CreateProduct(catalog.Product{SKU: "UMB-1", Name: "Compact", LowStockThreshold: 1, PriceTiers: []catalog.PriceTier{{MinQuantity: 1}}})
+	warehouse, _ := catalogs.Get(models.DefaultTenant).CreateWarehouse(catalog.Warehouse{Name: "Canada", Countries: []string{"CA"}})
+	alerts, _, _ := eventBus.Subscribe([]string{topicLowStock}, 0, 10)
+	defer alerts.Close()
+
+	rain := []models.Weather{{Date: time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC), Type: models.WeatherTypeRain}}
+	customerEvent := func(topic, id string, weather []models.Weather) eventbus.Event {
+		return eventbus.Event{Topic: topic, Tenant: models.DefaultTenant, Data: models.Customer{
+			ID: id, Address: models.Address{City: "Toronto", CountryCode: "CA"}, WeatherDetails: weather,
+		}}
+	}
+
+	assert.True(t, rainyCustomers.apply(customerEvent("customer.created", "1", rain)))
+	assert.False(t, rainyCustomers.apply(customerEvent("customer.created", "2", nil)))
+	assert.Empty(t, checkLowStock(models.DefaultTenant))
+
+	// The second rainy customer makes the warehouse's empty stock alertable, which is only alerted once
+	assert.True(t, rainyCustomers.apply(customerEvent("customer.updated", "2", rain)))
+	published := checkLowStock(models.DefaultTenant)
+	if assert.Len(t, published, 1) {
+		assert.Equal(t, catalog.StockAlert{ProductID: product.ID, SKU: "UMB-1", WarehouseID: warehouse.ID, WarehouseName: "Canada", Threshold: 1, RainyCustomers: 2}, published[0])
+		event := <-alerts.Events()
+		assert.Equal(t, published[0], event.Data)
+	}
+	assert.Empty(t, checkLowStock(models.DefaultTenant))
+	resp, _ := getLowStock(router.Request{})
+	assert.Len(t, resp.Info["alerts"], 1)
+
+	// Restocking resolves the alert, so that it's alerted again once the stock runs low
+	_, err := setStock(router.Request{Vars: map[string]string{"id": product.ID, "warehouse": warehouse.ID}, Info: map[string]interface{}{"on_hand": 10}})
+	assert.NoError(t, err)
+	resp, _ = getLowStock(router.Request{})
+	assert.Empty(t, resp.Info["alerts"])
+	catalogs.Get(models.DefaultTenant).SetStock(product.ID, warehouse.ID, 0)
+	assert.Len(t, checkLowStock(models.DefaultTenant), 1)
+
+	// Customers that are deleted no longer count
+	assert.True(t, rainyCustomers.apply(customerEvent("customer.deleted", "2", rain)))
+	assert.Empty(t, checkLowStock(models.DefaultTenant))
+	assert.Len(t, rainyCustomers.list(models.DefaultTenant), 1)
+}
@@ -0,0 +1,332 @@
+package customer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"umbrellacorp/components/audit"
+	"umbrellacorp/handlers/scope"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+	"umbrellacorp/util"
+)
+
+// Supported import and export formats
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// formatContentTypes maps each format to the Content-Type of exports
+var formatContentTypes = map[string]string{
+	formatCSV:    "text/csv; charset=UTF-8",
+	formatNDJSON: "application/x-ndjson; charset=UTF-8",
+}
+
+// csvColumns are the columns written by csv exports. Imports read the same columns, except for id and created_at which are assigned to
+// imported customers. Unknown columns are ignored by imports
+var csvColumns = []string{
+	"id", "name", "contact", "contact_number", "city", "country", "num_employees", "pipeline_status", "last_contacted", "created_at", "notes",
+}
+
+// importRow is a single record of an import, in the same format as the json body accepted by setCustomer
+type importRow struct {
+	Row  int
+	Info map[string]interface{}
+	Err  error
+}
+
+// importRowError reports why a row of an import was rejected. Rows are numbered from 1, excluding the csv header
+type importRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// importCustomers creates customers from a csv or ndjson request body. The format is specified by the format query param, or the request's
+// Content-Type. Each row is validated like the body of setCustomer, and must be unique among existing customers as well as the other rows
+// of the import. Valid rows are imported even if other rows are rejected, unless the dry_run query param is true in which case nothing is
+// imported. Forecasts of imported customers are fetched in the background
+func importCustomers(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+
+	format, err := importFormat(req)
+	if err != nil {
+		return resp, err
+	}
+
+	dryRun := false
+	if dryRunParam := req.Query.Get("dry_run"); dryRunParam != "" {
+		dryRun, err = strconv.ParseBool(dryRunParam)
+		if err != nil {
+			return resp, router.NewError(http.StatusBadRequest, "dry_run must be true or false")
+		}
+	}
+
+	var rows []importRow
+	if format == formatCSV {
+		rows, err = parseCSVRows(req.Body)
+	} else {
+		rows, err = parseNDJSONRows(req.Body)
+	}
+	if err != nil {
+		return resp, err
+	}
+
+	rowErrors := []importRowError{}
+	var accepted models.Customers
+	var acceptedRows []int
+	existingCustomers := stores.Get(req.Tenant).List()
+	for _, row := range rows {
+		customer, err := validateImportRow(row, append(existingCustomers, accepted...))
+		if err != nil {
+			rowErrors = append(rowErrors, importRowError{Row: row.Row, Error: err.Error()})
+			continue
+		}
+		accepted = append(accepted, customer)
+		acceptedRows = append(acceptedRows, row.Row)
+	}
+
+	importedIDs := []string{}
+	if !dryRun {
+		view := scope.Customers(req, stores, territories)
+		for i, customer := range accepted {
+			customer.ID = util.NewID()
+			customer.CreatedAt = timeNow()
+			// Re-checked in case a concurrent request created the same customer since the rows were validated
+			created, err := view.Create(scoreLead(customer), validateUniqueCustomer)
+			if err != nil {
+				rowErrors = append(rowErrors, importRowError{Row: acceptedRows[i], Error: err.Error()})
+				continue
+			}
+			recordChange(req.Tenant, audit.ActionCreate, req.Actor, req.Route, nil, &created)
+			importedIDs = append(importedIDs, customer.ID)
+		}
+		refresher.enqueue(req.Tenant, importedIDs...)
+	}
+
+	resp.Info["dry_run"] = dryRun
+	resp.Info["total_rows"] = len(rows)
+	resp.Info["valid_rows"] = len(accepted)
+	resp.Info["imported_rows"] = len(importedIDs)
+	resp.Info["customer_ids"] = importedIDs
+	resp.Info["errors"] = rowErrors
+	return resp, nil
+}
+
+// importFormat determines the format of an import from the format query param, falling back to the request's Content-Type
+func importFormat(req router.Request) (string, error) {
+	if format := req.Query.Get("format"); format != "" {
+		if _, ok := formatContentTypes[format]; !ok {
+			return "", router.NewError(http.StatusBadRequest, "Unsupported format: %s", format)
+		}
+		return format, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.ContentType)
+	switch mediaType {
+	case "text/csv":
+		return formatCSV, nil
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return formatNDJSON, nil
+	}
+	return "", router.NewError(http.StatusUnsupportedMediaType, "Specify the import format with the format query param or a text/csv or application/x-ndjson Content-Type")
+}
+
+// validateImportRow converts an import row into a customer, applying the same validation as setCustomer
+func validateImportRow(row importRow, existingCustomers models.Customers) (models.Customer, error) {
+	var customer models.Customer
+	if row.Err != nil {
+		return customer, row.Err
+	}
+
+	if err := (router.Request{Info: row.Info}).Parse(&customer); err != nil {
+		return customer, err
+	}
+	if err := customer.Validate(); err != nil {
+		return customer, err
+	}
+
+	var err error
+	customer.Address, err = customer.Address.SetCountryCode()
+	if err != nil {
+		return customer, err
+	}
+
+	if err := validateUniqueCustomer(existingCustomers, customer); err != nil {
+		return customer, err
+	}
+
+	// Imported customers are always new, their ids and forecasts are assigned by the import
+	customer.ID = ""
+	customer.CreatedAt = time.Time{}
+	customer.WeatherDetails = nil
+	return customer, nil
+}
+
+// parseCSVRows reads csv records into import rows using the header record to name the columns
+func parseCSVRows(body []byte) ([]importRow, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, router.NewError(http.StatusBadRequest, "The csv header is missing")
+	} else if err != nil {
+		return nil, router.NewError(http.StatusBadRequest, "Failed to read the csv header: %s", err.Error())
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+
+	var rows []importRow
+	for rowNum := 1; ; rowNum++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		row := importRow{Row: rowNum}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return nil, router.NewError(http.StatusBadRequest, "Failed to read csv: %s", err.Error())
+			}
+			row.Err = err
+			rows = append(rows, row)
+			continue
+		}
+
+		row.Info, row.Err = csvRecordInfo(header, record)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// csvRecordInfo converts a csv record to the json format accepted by setCustomer. Empty values are omitted
+func csvRecordInfo(header, record []string) (map[string]interface{}, error) {
+	info := map[string]interface{}{}
+	address := map[string]interface{}{}
+	for i, column := range header {
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			continue
+		}
+
+		switch column {
+		case "name", "contact", "contact_number", "pipeline_status", "last_contacted", "notes":
+			info[column] = value
+		case "city", "country":
+			address[column] = value
+		case "num_employees":
+			numEmployees, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("num_employees must be an integer")
+			}
+			info[column] = numEmployees
+		}
+	}
+	if len(address) > 0 {
+		info["address"] = address
+	}
+	return info, nil
+}
+
+// parseNDJSONRows reads each non-empty line of the body as a json object
+func parseNDJSONRows(body []byte) ([]importRow, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+
+	var rows []importRow
+	rowNum := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		rowNum++
+
+		row := importRow{Row: rowNum}
+		if err := json.Unmarshal(line, &row.Info); err != nil {
+			row.Err = fmt.Errorf("Invalid json: %s", err.Error())
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, router.NewError(http.StatusBadRequest, "Failed to read ndjson: %s", err.Error())
+	}
+	return rows, nil
+}
+
+// exportCustomers streams every customer as csv or ndjson, as specified by the format query param. Defaults to csv
+func exportCustomers(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+
+	format := req.Query.Get("format")
+	if format == "" {
+		format = formatCSV
+	}
+	if _, ok := formatContentTypes[format]; !ok {
+		return resp, router.NewError(http.StatusBadRequest, "Unsupported format: %s", format)
+	}
+
+	customers := scope.Customers(req, stores, territories).List()
+	resp.ContentType = formatContentTypes[format]
+	resp.Stream = func(w io.Writer) error {
+		if format == formatNDJSON {
+			return writeNDJSON(w, customers)
+		}
+		return writeCSV(w, customers)
+	}
+	return resp, nil
+}
+
+func writeNDJSON(w io.Writer, customers models.Customers) error {
+	encoder := json.NewEncoder(w)
+	for _, customer := range customers {
+		if err := encoder.Encode(customer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, customers models.Customers) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvColumns); err != nil {
+		return err
+	}
+
+	for _, customer := range customers {
+		lastContacted := ""
+		if customer.LastContacted != nil {
+			lastContacted = customer.LastContacted.Format(time.RFC3339)
+		}
+
+		err := writer.Write([]string{
+			customer.ID,
+			customer.Name,
+			customer.Contact,
+			customer.ContactNumber,
+			customer.Address.City,
+			customer.Address.Country,
+			strconv.Itoa(customer.NumEmployees),
+			string(customer.PipelineStatus),
+			lastContacted,
+			customer.CreatedAt.Format(time.RFC3339),
+			customer.Notes,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,175 @@
+package customer
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportCustomers(t *testing.T) {
+	csvBody := strings.Join([]string{
+		"name,contact_number,city,country,num_employees,notes",
+		"Awesome Company,4165555555,Toronto,CA,50,Likes umbrellas",
+		"Short Number,123,Toronto,CA,10,",
+		"Duplicate Number,4165555555,Chicago,US,10,",
+		"Bad Employees,4165555556,Chicago,US,many,",
+		"No Address,4165555557,,,10,",
+		"Existing Company,4165555558,Chicago,US,10,",
+	}, "\n")
+
+	ndjsonBody := strings.Join([]string{
+		`{"name": "Awesome Company", "contact_number": "4165555555", "address": {"city": "Toronto", "country": "CA"}}`,
+		``,
+		`{"name": "Broken"`,
+		`{"contact_number": "4165555556", "address": {"city": "Toronto", "country": "CA"}}`,
+	}, "\n")
+
+	existing := models.Customer{ID: "1", Name: "Existing Company", ContactNumber: "9055555555"}
+
+	tests := []struct {
+		name        string
+		req         router.Request
+		expImported int
+		expErrors   []importRowError
+		expError    error
+	}{
+		{
+			name: "csv with row errors",
+			req:  router.Request{Role: models.RoleAdmin, Body: []byte(csvBody), ContentType: "text/csv"},
+			expErrors: []importRowError{
+				{Row: 2, Error: "The contact number must be a minimum of 7 digits"},
+				{Row: 3, Error: "An existing customer with the same contact number exists"},
+				{Row: 4, Error: "num_employees must be an integer"},
+				{Row: 5, Error: "Request validation failed: address required"},
+				{Row: 6, Error: "An existing customer with the same name exists"},
+			},
+			expImported: 1,
+		},
+		{
+			name:        "csv dry run",
+			req:         router.Request{Role: models.RoleAdmin, Body: []byte(csvBody), Query: url.Values{"format": {"csv"}, "dry_run": {"true"}}},
+			expErrors:   []importRowError{{Row: 2}, {Row: 3}, {Row: 4}, {Row: 5}, {Row: 6}},
+			expImported: 0,
+		},
+		{
+			name: "ndjson",
+			req:  router.Request{Role: models.RoleAdmin, Body: []byte(ndjsonBody), ContentType: "application/x-ndjson"},
+			expErrors: []importRowError{
+				{Row: 2, Error: "Invalid json: unexpected end of JSON input"},
+				{Row: 3, Error: "Request validation failed: name required"},
+			},
+			expImported: 1,
+		},
+		{
+			name:     "unknown format",
+			req:      router.Request{Role: models.RoleAdmin, Body: []byte(csvBody), ContentType: "application/json"},
+			expError: router.NewError(http.StatusUnsupportedMediaType, "Specify the import format with the format query param or a text/csv or application/x-ndjson Content-Type"),
+		},
+		{
+			name:     "missing csv header",
+			req:      router.Request{Role: models.RoleAdmin, ContentType: "text/csv"},
+			expError: router.NewError(http.StatusBadRequest, "The csv header is missing"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stores, searchIndex = newStores(existing)
+			refresher = newForecastRefresher()
+
+			resp, recErr := importCustomers(test.req)
+			assert.Equal(t, test.expError, recErr)
+			if recErr != nil {
+				return
+			}
+
+			recErrors := resp.Info["errors"].([]importRowError)
+			assert.Len(t, recErrors, len(test.expErrors))
+			for i, expErr := range test.expErrors {
+				assert.Equal(t, expErr.Row, recErrors[i].Row)
+				if expErr.Error != "" {
+					assert.Equal(t, expErr.Error, recErrors[i].Error)
+				}
+			}
+
+			assert.Equal(t, test.expImported, resp.Info["imported_rows"])
+			assert.Len(t, defaultStore().List(), 1+test.expImported)
+
+			// Forecasts are fetched in the background rather than during the import
+			assert.Len(t, refresher.drain(), test.expImported)
+			for _, customer := range defaultStore().List() {
+				assert.Empty(t, customer.WeatherDetails)
+			}
+		})
+	}
+}
+
+func TestExportCustomers(t *testing.T) {
+	stores, searchIndex = newStores(models.Customer{
+		ID:            "1",
+		Name:          "Awesome Company",
+		ContactNumber: "4165555555",
+		Address:       models.Address{City: "Toronto", Country: "CA", CountryCode: "CA"},
+		NumEmployees:  50,
+		CreatedAt:     timeNow(),
+		Notes:         "Prefers calls, not email",
+	})
+
+	tests := []struct {
+		name           string
+		query          url.Values
+		expContentType string
+		expBody        string
+		expError       error
+	}{
+		{
+			name:           "csv by default",
+			query:          url.Values{},
+			expContentType: "text/csv; charset=UTF-8",
+			expBody: "id,name,contact,contact_number,city,country,num_employees,pipeline_status,last_contacted,created_at,notes\n" +
+				"1,Awesome Company,,4165555555,Toronto,CA,50,,,2017-02-16T00:00:00Z,\"Prefers calls, not email\"\n",
+		},
+		{
+			name:           "ndjson",
+			query:          url.Values{"format": {"ndjson"}},
+			expContentType: "application/x-ndjson; charset=UTF-8",
+		},
+		{
+			name:     "unknown format",
+			query:    url.Values{"format": {"xml"}},
+			expError: router.NewError(http.StatusBadRequest, "Unsupported format: xml"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, recErr := exportCustomers(router.Request{Role: models.RoleAdmin, Query: test.query})
+			assert.Equal(t, test.expError, recErr)
+			if recErr != nil {
+				return
+			}
+
+			var body bytes.Buffer
+			assert.NoError(t, resp.Stream(&body))
+			assert.Equal(t, test.expContentType, resp.ContentType)
+			if test.expBody != "" {
+				assert.Equal(t, test.expBody, body.String())
+			}
+
+			// Exports can be imported into an empty customer book
+			stores, searchIndex = newStores()
+			importResp, err := importCustomers(router.Request{Role: models.RoleAdmin, Body: body.Bytes(), ContentType: resp.ContentType})
+			assert.NoError(t, err)
+			if !assert.Equal(t, 1, importResp.Info["imported_rows"]) {
+				t.Fatal(importResp.Info["errors"])
+			}
+			assert.Equal(t, "Prefers calls, not email", defaultStore().List()[0].Notes)
+		})
+	}
+}
@@ -1,12 +1,23 @@
 package customer
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"reflect"
 	"strings"
 	"time"
+	"umbrellacorp/components/accuracy"
+	"umbrellacorp/components/audit"
+	"umbrellacorp/components/customerlist"
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/components/quota"
+	"umbrellacorp/components/searchindex"
+	"umbrellacorp/components/tenant"
+	"umbrellacorp/components/territory"
 	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/handlers/scope"
 	"umbrellacorp/models"
 	"umbrellacorp/router"
 	"umbrellacorp/util"
@@ -14,31 +25,195 @@ import (
 
 // Init registers handlers with the router
 func Init() {
+	var err error
+	auditLog, err = audit.New(currentConfig().AuditPath)
+	if err != nil {
+		log.Fatalf("Failed to initialize the audit log: %s", err.Error())
+	}
+
 	routes := router.Routes{
 		{
 			Name:        "Get Customers",
 			Methods:     []string{http.MethodGet},
 			Path:        "/customers",
 			HandlerFunc: getCustomers,
+			Role:        models.RoleViewer,
 		},
 		{
-			Name:        "Set Customer",
-			Methods:     []string{http.MethodPost, http.MethodPut},
-			Path:        "/customers",
-			HandlerFunc: setCustomer,
+			Name:          "Set Customer",
+			Methods:       []string{http.MethodPost, http.MethodPut},
+			Path:          "/customers",
+			HandlerFunc:   setCustomer,
+			Role:          models.RoleRep,
+			Prepare:       prefetchForecast,
+			Transactional: true,
+		},
+		{
+			Name:        "Search Customers",
+			Methods:     []string{http.MethodGet},
+			Path:        "/customers/search",
+			HandlerFunc: searchCustomers,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Import Customers",
+			Methods:     []string{http.MethodPost},
+			Path:        "/customers/import",
+			HandlerFunc: importCustomers,
+			RawBody:     true,
+			Role:        models.RoleRep,
+		},
+		{
+			Name:        "Export Customers",
+			Methods:     []string{http.MethodGet},
+			Path:        "/customers/export",
+			HandlerFunc: exportCustomers,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:          "Delete Customer",
+			Methods:       []string{http.MethodDelete},
+			Path:          "/customers/{id}",
+			HandlerFunc:   deleteCustomer,
+			Role:          models.RoleAdmin,
+			Transactional: true,
+		},
+		{
+			Name:          "Restore Customer",
+			Methods:       []string{http.MethodPost},
+			Path:          "/customers/{id}/restore",
+			HandlerFunc:   restoreCustomer,
+			Role:          models.RoleAdmin,
+			Transactional: true,
+		},
+		{
+			Name:        "Get Customer History",
+			Methods:     []string{http.MethodGet},
+			Path:        "/customers/{id}/history",
+			HandlerFunc: getCustomerHistory,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Export Customer History",
+			Methods:     []string{http.MethodGet},
+			Path:        "/customers/history",
+			HandlerFunc: exportHistory,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Leads",
+			Methods:     []string{http.MethodGet},
+			Path:        "/leads",
+			HandlerFunc: getLeads,
+			Role:        models.RoleViewer,
 		},
 	}
 	router.RegisterRoutes("customer", routes)
+	router.RegisterTransactor(storeTransactor{})
+
+	go refresher.run()
+	go runPurger()
+}
+
+// storeTransactor allows atomic batches to roll back changes to the customer stores. The events of the batch's changes are held back until
+// it commits, and discarded along with the events of the rollback if it fails
+type storeTransactor struct{}
+
+func (storeTransactor) Begin() router.Transaction {
+	snapshots := map[string]models.Customers{}
+	for tenantID, store := range stores.All() {
+		snapshots[tenantID] = store.All()
+	}
+	heldEvents.hold()
+	return router.Transaction{
+		Commit: func() {
+			heldEvents.release(true)
+		},
+		Rollback: func() {
+			// Stores of tenants first accessed by the batch are restored to empty
+			for tenantID, store := range stores.All() {
+				rolledBack := store.All()
+				store.Restore(snapshots[tenantID])
+				recordRestore(tenantID, rolledBack, snapshots[tenantID])
+			}
+			heldEvents.release(false)
+		},
+	}
+}
+
+var stores, searchIndex = customerstore.Default, watchStores(customerstore.Default)
+
+// newStores returns the customer stores of every tenant, with the default tenant's store containing the specified customers, along with
+// a search index that is kept in sync with the stores. Tests use them in place of the stores shared with the other handlers
+func newStores(existingCustomers ...models.Customer) (*customerstore.Tenants, *searchindex.Index) {
+	tenantStores := customerstore.NewTenants(func(tenantID string) *customerstore.Store {
+		if tenantID == models.DefaultTenant {
+			return customerstore.New(existingCustomers...)
+		}
+		return customerstore.New()
+	})
+	index := watchStores(tenantStores)
+	for _, customer := range existingCustomers {
+		indexCustomer(index, customer)
+	}
+	return tenantStores, index
+}
+
+// watchStores returns a search index that is kept in sync with the stores. Customer ids are unique across tenants, so the index is shared
+// and searches are isolated by looking up matches in the tenant's store. Changes to the stores are also published to the event bus
+func watchStores(tenantStores *customerstore.Tenants) *searchindex.Index {
+	index := searchindex.New()
+	tenantStores.Subscribe(func(tenantID string, before, after *models.Customer) {
+		if after == nil {
+			index.Remove(before.ID)
+			return
+		}
+		indexCustomer(index, *after)
+	})
+	tenantStores.Subscribe(publishChange)
+	return index
+}
+
+// territories defines the customers accessible to each sales rep, see scope.Customers
+var territories = territory.Default
+
+// tenantConfigs configures the forecast provider and alert rules of each tenant
+var tenantConfigs = tenant.Default
+
+// tenantConfig returns the configuration of the tenant. Tenants are resolved by the router, so an unknown tenant only occurs if the
+// tenants are reloaded, in which case the defaults are used
+func tenantConfig(tenantID string) tenant.Tenant {
+	tenantID = scope.Tenant(tenantID)
+	config, ok := tenantConfigs.Get(tenantID)
+	if !ok {
+		return tenant.Tenant{ID: tenantID}
+	}
+	return config
 }
 
-var customers models.Customers
+// timeNow is used when scoring customers, tests may override it to get deterministic scores
+var timeNow = time.Now
 
+// getCustomers returns a page of customers. See customerlist.ParseOptions for the supported filtering, sorting and pagination query params
 func getCustomers(req router.Request) (router.Response, error) {
 	resp := router.Response{Info: map[string]interface{}{}}
 
+	opts, err := customerlist.ParseOptions(req.Query)
+	if err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+
 	// TODO: Customers' weather forecast should be accurate. One option would be to fetch weather details here but we shouldn't
 	// couple the client's request with 3rd party here. A better option would be to have an async task on our server that updates customers' weather details
-	resp.Info["customers"] = customers
+	view := scope.Customers(req, stores, territories)
+	existingCustomers := view.List()
+	if opts.IncludeDeleted {
+		existingCustomers = view.All()
+	}
+	page, nextCursor, total := customerlist.List(existingCustomers, opts, timeNow())
+	resp.Info["customers"] = page
+	resp.Info["next_cursor"] = nextCursor
+	resp.Info["total_count"] = total
 	return resp, nil
 }
 
@@ -51,62 +226,128 @@ func setCustomer(req router.Request) (router.Response, error) {
 		return resp, err
 	}
 
-	if err := customer.Validate(); err != nil {
-		return resp, err
-	}
-
-	addressModified := false
-	prevAddress := customer.Address
-	customer.Address, err = customer.Address.SetCountryCode()
+	customer, addressModified, err := prepareCustomer(customer)
 	if err != nil {
 		return resp, err
 	}
 
-	if !reflect.DeepEqual(prevAddress, customer.Address) {
-		addressModified = true
-	}
-
-	updateWeatherDetailsFn := func(cus models.Customer) (models.Customer, error) {
-		if !addressModified {
-			return cus, nil
+	// refreshFn updates the customer's weather details if needed and re-scores the customer as a lead
+	refreshFn := func(cus models.Customer) (models.Customer, error) {
+		if addressModified {
+			weatherDetails, err := prefetchedForecast(req, cus.Address)
+			if _, ok := err.(quota.ErrExhausted); ok {
+				return cus, router.NewError(http.StatusServiceUnavailable, "Failed to obtain upcoming weather: %s", err.Error())
+			}
+			if err != nil {
+				return cus, fmt.Errorf("Failed to obtain upcoming weather: %s", err.Error())
+			}
+			cus.WeatherDetails = weatherDetails
 		}
 
-		weatherDetails, err := fetchForecast(cus.Address)
-		if err != nil {
-			return cus, fmt.Errorf("Failed to obtain upcoming weather: %s", err.Error())
-		}
-		cus.WeatherDetails = weatherDetails
-		return cus, nil
+		return scoreLead(cus), nil
 	}
 
+	view := scope.Customers(req, stores, territories)
 	if customer.ID != "" {
-		customer, err = updateWeatherDetailsFn(customer)
+		customer, err = refreshFn(customer)
 		if err != nil {
 			return resp, err
 		}
 
-		if err := updateCustomer(customers, customer); err != nil {
-			return resp, err
+		var before models.Customer
+		customer, err = view.Modify(customer.ID, func(existing models.Customer) (models.Customer, error) {
+			before = existing
+			return customer, nil
+		})
+		if err != nil {
+			return resp, storeError(err)
 		}
+		recordChange(req.Tenant, audit.ActionUpdate, req.Actor, req.Route, &before, &customer)
 
 	} else {
-		if err := validateUniqueCustomer(customers, customer); err != nil {
+		if err := validateUniqueCustomer(stores.Get(req.Tenant).List(), customer); err != nil {
 			return resp, err
 		}
 		customer.ID = util.NewID()
+		customer.CreatedAt = timeNow()
+		if !view.Contains(customer) {
+			// Checked before fetching the forecast, and again when the customer is created
+			return resp, storeError(customerstore.ErrOutOfScope(customer.ID))
+		}
 
-		customer, err = updateWeatherDetailsFn(customer)
+		customer, err = refreshFn(customer)
 		if err != nil {
 			return resp, err
 		}
 
-		customers = append(customers, customer)
+		// Uniqueness is verified again while the store is locked in case a concurrent request created the same customer while the forecast
+		// was being fetched
+		customer, err = view.Create(customer, validateUniqueCustomer)
+		if err != nil {
+			return resp, storeError(err)
+		}
+		recordChange(req.Tenant, audit.ActionCreate, req.Actor, req.Route, nil, &customer)
 	}
 
 	resp.Info["customer"] = customer
 	return resp, err
 }
 
+// prepareCustomer validates a customer being set and fills in its country code. It returns true if filling in the address modified it, in
+// which case the customer's forecast needs to be fetched
+func prepareCustomer(customer models.Customer) (models.Customer, bool, error) {
+	if err := customer.Validate(); err != nil {
+		return customer, false, err
+	}
+
+	prevAddress := customer.Address
+	address, err := customer.Address.SetCountryCode()
+	if err != nil {
+		return customer, false, err
+	}
+	customer.Address = address
+	return customer, !reflect.DeepEqual(prevAddress, customer.Address), nil
+}
+
+// prefetchedKey is the context key of the forecast fetched by prefetchForecast
+type prefetchedKey struct{}
+
+// prefetched is a forecast fetched before the customer at the address is set
+type prefetched struct {
+	address models.Address
+	weather []models.Weather
+	err     error
+}
+
+// prefetchForecast fetches the forecast of a customer being set within an atomic batch before the batch acquires the mutation lock, so that
+// the forecast provider doesn't block every other mutation
+func prefetchForecast(req router.Request) context.Context {
+	ctx := req.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var customer models.Customer
+	if err := req.Parse(&customer); err != nil {
+		return ctx
+	}
+	customer, addressModified, err := prepareCustomer(customer)
+	if err != nil || !addressModified {
+		return ctx
+	}
+	weather, err := fetchForecast(req.Tenant, customer.Address, quota.PriorityInteractive)
+	return context.WithValue(ctx, prefetchedKey{}, prefetched{address: customer.Address, weather: weather, err: err})
+}
+
+// prefetchedForecast returns the forecast at the address that was fetched by prefetchForecast, or fetches it if it wasn't
+func prefetchedForecast(req router.Request, address models.Address) ([]models.Weather, error) {
+	if req.Context != nil {
+		if forecast, ok := req.Context.Value(prefetchedKey{}).(prefetched); ok && forecast.address == address {
+			return forecast.weather, forecast.err
+		}
+	}
+	return fetchForecast(req.Tenant, address, quota.PriorityInteractive)
+}
+
 // validateUniqueCustomer verifies that there isn't an existing customer with the same name or contact number
 func validateUniqueCustomer(existingCustomers models.Customers, customer models.Customer) error {
 	for _, existingCustomer := range existingCustomers {
@@ -121,24 +362,132 @@ func validateUniqueCustomer(existingCustomers models.Customers, customer models.
 	return nil
 }
 
-func updateCustomer(existingCustomers models.Customers, customer models.Customer) error {
-	located := false
-	for i, existingCustomer := range existingCustomers {
-		if existingCustomer.ID == customer.ID {
-			existingCustomers[i] = customer
-			located = true
-			break
+// deleteCustomer soft deletes the customer specified by the id path param. The customer can be restored until it's purged, see runPurger
+func deleteCustomer(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	customer, err := stores.Get(req.Tenant).Delete(req.Vars["id"], req.Actor, timeNow())
+	if err != nil {
+		return resp, storeError(err)
+	}
+	before := customer
+	before.DeletedAt, before.DeletedBy = nil, ""
+	recordChange(req.Tenant, audit.ActionDelete, req.Actor, req.Route, &before, &customer)
+
+	resp.Info["customer"] = customer
+	return resp, nil
+}
+
+// restoreCustomer restores the deleted customer specified by the id path param, provided that no active customer has taken its name or
+// contact number since it was deleted
+func restoreCustomer(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	store := stores.Get(req.Tenant)
+	var deleted *models.Customer
+	deletedCustomers := store.ListDeleted()
+	for i := range deletedCustomers {
+		if deletedCustomers[i].ID == req.Vars["id"] {
+			deleted = &deletedCustomers[i]
 		}
 	}
-	if !located {
-		return fmt.Errorf("Failed to locate existing customer with id: %s", customer.ID)
+
+	customer, err := store.Undelete(req.Vars["id"], validateUniqueCustomer)
+	if err != nil {
+		if _, ok := err.(customerstore.ErrNotFound); ok {
+			return resp, router.NewError(http.StatusNotFound, "Failed to locate deleted customer with id: %s", req.Vars["id"])
+		}
+		return resp, router.NewError(http.StatusConflict, "%s", err.Error())
 	}
-	return nil
+	recordChange(req.Tenant, audit.ActionRestore, req.Actor, req.Route, deleted, &customer)
+
+	resp.Info["customer"] = customer
+	return resp, nil
+}
+
+// storeError translates errors returned by the customer store into router errors with the appropriate status
+func storeError(err error) error {
+	switch err.(type) {
+	case customerstore.ErrNotFound:
+		return router.NewError(http.StatusNotFound, "%s", err.Error())
+	case customerstore.ErrOutOfScope:
+		return router.NewError(http.StatusForbidden, "%s", err.Error())
+	}
+	return err
+}
+
+// providerQuotas enforces the budgets of the forecast providers, tests may override it
+var providerQuotas = quota.Default
+
+// fetchForecast fetches the upcoming rain at the address from the tenant's forecast provider. quota.ErrExhausted is returned if the
+// provider's budget doesn't allow a call of the priority. Concurrent fetches for the same location share one provider call, which only
+// counts once against the budget
+func fetchForecast(tenantID string, address models.Address, priority quota.Priority) ([]models.Weather, error) {
+	config := tenantConfig(tenantID).Forecaster
+	// Calls of different priorities aren't coalesced, so that interactive calls don't fail because a background call exhausted its budget
+	forecaster := weatherforecaster.Coalesce(fmt.Sprintf("%s|%d", config.Account(), priority), budgetedForecaster{
+		forecaster: weatherforecaster.NewProvider(config),
+		provider:   config.Name(),
+		priority:   priority,
+	})
+
+	dateRange := weatherforecaster.ForecastRange()
+	weatherDetails, err := forecaster.UpcomingWeather(address.City, address.CountryCode, dateRange, models.WeatherTypeRain)
+	if err == nil {
+		forecastAccuracy.Record(accuracy.Snapshot{
+			Provider: config,
+			Location: weatherforecaster.Location{City: address.City, CountryCode: address.CountryCode},
+			Range:    dateRange,
+			Forecast: weatherDetails,
+		})
+	}
+	return weatherDetails, err
+}
+
+// forecastAccuracy snapshots fetched forecasts so that their accuracy is measured once their date range has passed, tests may override it
+var forecastAccuracy = accuracy.Default
+
+// fetchForecasts fetches the upcoming rain at each distinct location from the tenant's forecast provider in a single batch. The provider's
+// budget is acquired for the locations in order, so that if it runs out, the locations specified first are fetched and the rest fail with
+// quota.ErrExhausted
+func fetchForecasts(tenantID string, locations []weatherforecaster.Location, priority quota.Priority) []weatherforecaster.LocationForecast {
+	config := tenantConfig(tenantID).Forecaster
+
+	var results, fetched []weatherforecaster.LocationForecast
+	var allowed []weatherforecaster.Location
+	for _, location := range locations {
+		if err := providerQuotas.Acquire(config.Name(), priority); err != nil {
+			results = append(results, weatherforecaster.LocationForecast{Location: location, Err: err})
+			continue
+		}
+		allowed = append(allowed, location)
+	}
+
+	if len(allowed) > 0 {
+		dateRange := weatherforecaster.ForecastRange()
+		fetched = weatherforecaster.NewConfiguredForecaster(config).UpcomingWeatherBatch(allowed, dateRange, models.WeatherTypeRain)
+		for _, result := range fetched {
+			if result.Err == nil {
+				forecastAccuracy.Record(accuracy.Snapshot{Provider: config, Location: result.Location, Range: dateRange, Forecast: result.Weather})
+			}
+		}
+	}
+	return append(fetched, results...)
+}
+
+// budgetedForecaster acquires a call from the provider's budget before each call to the forecaster
+type budgetedForecaster struct {
+	forecaster weatherforecaster.Forecaster
+	provider   string
+	priority   quota.Priority
+}
+
+func (budgeted budgetedForecaster) UpcomingWeather(city, countrycode string, dateRange util.DateRange, types ...models.WeatherType) ([]models.Weather, error) {
+	if err := providerQuotas.Acquire(budgeted.provider, budgeted.priority); err != nil {
+		return nil, err
+	}
+	return budgeted.forecaster.UpcomingWeather(city, countrycode, dateRange, types...)
 }
 
-func fetchForecast(address models.Address) ([]models.Weather, error) {
-	// Forecaster API seems to only give data from Feb 2017
-	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
-	dateRange := util.DateRange{Start: now, End: now.AddDate(0, 0, 5)}
-	return weatherforecaster.NewForecaster().UpcomingWeather(address.City, address.CountryCode, dateRange, models.WeatherTypeRain)
+// UpcomingWeatherBatch fans out to UpcomingWeather, so that each location is budgeted
+func (budgeted budgetedForecaster) UpcomingWeatherBatch(locations []weatherforecaster.Location, dateRange util.DateRange, types ...models.WeatherType) []weatherforecaster.LocationForecast {
+	return weatherforecaster.FanOut(budgeted, 1, locations, dateRange, types...)
 }
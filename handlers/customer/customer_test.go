@@ -2,9 +2,14 @@ package customer
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"testing"
 	"time"
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/components/leadscorer"
+	"umbrellacorp/components/territory"
 	"umbrellacorp/components/weatherforecaster"
 	"umbrellacorp/models"
 	"umbrellacorp/router"
@@ -14,10 +19,16 @@ import (
 )
 
 func TestMain(t *testing.M) {
-	weatherforecaster.Configure(true)
+	weatherforecaster.Configure(weatherforecaster.ProviderConfig{Provider: weatherforecaster.ProviderReplay, Fixtures: "../../components/weatherforecaster/testdata/forecasts"})
+	timeNow = func() time.Time { return time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC) }
 	os.Exit(t.Run())
 }
 
+// defaultStore returns the default tenant's store, which tests populate through newStores
+func defaultStore() *customerstore.Store {
+	return stores.Get(models.DefaultTenant)
+}
+
 func TestValidateUniqueCustomer(t *testing.T) {
 	tests := []struct {
 		Name              string
@@ -92,7 +103,7 @@ func TestSetCustomer(t *testing.T) {
 	dateRange := util.DateRange{Start: now, End: now.AddDate(0, 0, 5)}
 	weatherDetails, err := weatherforecaster.NewForecaster().UpcomingWeather("Toronto", "CA", dateRange, models.WeatherTypeRain)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	tests := []struct {
@@ -122,6 +133,7 @@ func TestSetCustomer(t *testing.T) {
 						CountryCode: "CA",
 					},
 					WeatherDetails: weatherDetails,
+					CreatedAt:      now,
 				},
 			},
 			expError: nil,
@@ -197,7 +209,7 @@ func TestSetCustomer(t *testing.T) {
 					},
 				},
 			},
-			expError: fmt.Errorf("Failed to locate existing customer with id: 2"),
+			expError: router.NewError(http.StatusNotFound, "Failed to locate existing customer with id: 2"),
 		},
 		{
 			Name: "Update a customer record successfully",
@@ -241,12 +253,14 @@ func TestSetCustomer(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.Name, func(t *testing.T) {
-			customers = test.existingCustomers
+			stores, searchIndex = newStores(test.existingCustomers...)
 
-			req := router.Request{Info: test.input}
+			req := router.Request{Role: models.RoleAdmin, Info: test.input}
 			_, recErr := setCustomer(req)
 			assert.Equal(t, test.expError, recErr)
 
+			customers := defaultStore().List()
+
 			if len(test.expCustomers) != len(customers) {
 				t.Fatalf("Exp customer size: %d, actual customers size: %d", len(test.expCustomers), len(customers))
 			}
@@ -255,8 +269,187 @@ func TestSetCustomer(t *testing.T) {
 			for i := range test.expCustomers {
 				assert.NotEmpty(t, customers[i].ID)
 				test.expCustomers[i].ID = customers[i].ID
+
+				// Successfully saved customers are scored as leads
+				if test.expError == nil {
+					score := leadscorer.Score(test.expCustomers[i], timeNow())
+					test.expCustomers[i].Lead = &score
+				}
 			}
 			assert.Equal(t, test.expCustomers, customers)
 		})
 	}
 }
+
+func TestDeleteCustomer(t *testing.T) {
+	stores, searchIndex = newStores(models.Customer{ID: "1", Name: "Awesome Company"})
+
+	_, err := deleteCustomer(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": "2"}})
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate existing customer with id: 2"), err)
+
+	resp, err := deleteCustomer(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": "1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", resp.Info["customer"].(models.Customer).ID)
+	assert.Empty(t, defaultStore().List())
+	assert.Empty(t, searchIndex.Search("awesome", 10))
+}
+
+func TestRestoreCustomer(t *testing.T) {
+	stores, searchIndex = newStores(
+		models.Customer{ID: "1", Name: "Awesome Company", ContactNumber: "4165555555"},
+		models.Customer{ID: "2", Name: "Fortune 500 Company", ContactNumber: "4165555556"},
+	)
+	_, err := deleteCustomer(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": "1"}, Actor: "rep@umbrellacorp.com"})
+	assert.NoError(t, err)
+	_, err = deleteCustomer(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": "2"}, Actor: "rep@umbrellacorp.com"})
+	assert.NoError(t, err)
+
+	deleted := defaultStore().ListDeleted()
+	assert.Len(t, deleted, 2)
+	assert.Equal(t, "rep@umbrellacorp.com", deleted[0].DeletedBy)
+	assert.Equal(t, timeNow(), *deleted[0].DeletedAt)
+
+	// A new customer took the deleted customer's contact number
+	_, err = defaultStore().Create(models.Customer{ID: "3", Name: "New Company", ContactNumber: "4165555556"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		id       string
+		expError error
+	}{
+		{
+			name:     "restore deleted customer",
+			id:       "1",
+			expError: nil,
+		},
+		{
+			name:     "customer isn't deleted",
+			id:       "3",
+			expError: router.NewError(http.StatusNotFound, "Failed to locate deleted customer with id: 3"),
+		},
+		{
+			name:     "contact number was taken",
+			id:       "2",
+			expError: router.NewError(http.StatusConflict, "An existing customer with the same contact number exists"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, recErr := restoreCustomer(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": test.id}})
+			assert.Equal(t, test.expError, recErr)
+		})
+	}
+
+	// Restored customers are searchable again
+	assert.Len(t, searchIndex.Search("awesome", 10), 1)
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	defer Configure(DefaultConfig)
+	Configure(Config{DeletedRetention: 24 * time.Hour})
+
+	stores, searchIndex = newStores(models.Customer{ID: "1"}, models.Customer{ID: "2"})
+	defaultStore().Delete("1", "rep", timeNow().Add(-25*time.Hour))
+	defaultStore().Delete("2", "rep", timeNow().Add(-23*time.Hour))
+
+	assert.Equal(t, 1, purgeDeleted())
+	assert.Len(t, defaultStore().ListDeleted(), 1)
+	assert.Equal(t, "2", defaultStore().ListDeleted()[0].ID)
+}
+
+func TestTerritoryScope(t *testing.T) {
+	toronto := models.Customer{ID: "1", Name: "Toronto Company", ContactNumber: "4165555555", Address: models.Address{City: "Toronto", Country: "Canada", CountryCode: "CA"}}
+	chicago := models.Customer{ID: "2", Name: "Chicago Company", ContactNumber: "3125555555", Address: models.Address{City: "Chicago", Country: "US", CountryCode: "US"}}
+	stores, searchIndex = newStores(toronto, chicago)
+	territories = territory.NewTenants()
+	registry := territories.Get(models.DefaultTenant)
+	canada, err := registry.Create(territory.Territory{Name: "Canada", Countries: []string{"CA"}})
+	assert.NoError(t, err)
+	assert.NoError(t, registry.Assign("alice", []string{canada.ID}))
+
+	rep := router.Request{Actor: "alice", Role: models.RoleRep}
+	resp, err := getCustomers(rep)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Customers{toronto}, resp.Info["customers"])
+
+	// Callers without territories can't access any customers, while admins can access every customer
+	resp, _ = getCustomers(router.Request{Actor: "bob", Role: models.RoleViewer})
+	assert.Equal(t, models.Customers{}, resp.Info["customers"])
+	resp, _ = getCustomers(router.Request{Actor: "carol", Role: models.RoleAdmin})
+	assert.Len(t, resp.Info["customers"], 2)
+
+	search := rep
+	search.Query = url.Values{"q": {"company"}, "limit": {"1"}}
+	resp, err = searchCustomers(search)
+	assert.NoError(t, err)
+	results := resp.Info["results"].([]searchResult)
+	assert.Len(t, results, 1)
+	assert.Equal(t, toronto, results[0].Customer)
+
+	_, err = getCustomerHistory(router.Request{Actor: "alice", Role: models.RoleRep, Vars: map[string]string{"id": chicago.ID}})
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate existing customer with id: 2"), err)
+
+	update := rep
+	update.Info = map[string]interface{}{"id": chicago.ID, "name": "Renamed Company", "contact_number": chicago.ContactNumber, "address": map[string]interface{}{"city": "Chicago", "country": "US"}}
+	_, err = setCustomer(update)
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate existing customer with id: 2"), err)
+
+	create := rep
+	create.Info = map[string]interface{}{"name": "Boston Company", "contact_number": "6175555555", "address": map[string]interface{}{"city": "Boston", "country": "US"}}
+	_, err = setCustomer(create)
+	assert.Equal(t, http.StatusForbidden, router.StatusCode(err))
+	assert.Len(t, defaultStore().List(), 2)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	toronto := models.Customer{ID: "1", Name: "Toronto Company", ContactNumber: "4165555555", Address: models.Address{City: "Toronto", Country: "Canada", CountryCode: "CA"}}
+	stores, searchIndex = newStores(toronto)
+
+	acme := router.Request{Tenant: "acme", Actor: "alice", Role: models.RoleAdmin}
+	resp, err := getCustomers(acme)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Customers{}, resp.Info["customers"])
+
+	// Names and contact numbers only need to be unique within a tenant
+	create := acme
+	create.Info = map[string]interface{}{"name": toronto.Name, "contact_number": toronto.ContactNumber, "address": map[string]interface{}{"city": "Toronto", "country": "Canada"}}
+	resp, err = setCustomer(create)
+	assert.NoError(t, err)
+	created := resp.Info["customer"].(models.Customer)
+
+	search := acme
+	search.Query = url.Values{"q": {"toronto"}}
+	resp, _ = searchCustomers(search)
+	results := resp.Info["results"].([]searchResult)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, created.ID, results[0].Customer.ID)
+	}
+
+	_, err = deleteCustomer(router.Request{Tenant: "acme", Role: models.RoleAdmin, Vars: map[string]string{"id": toronto.ID}})
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate existing customer with id: 1"), err)
+	resp, _ = getCustomerHistory(router.Request{Tenant: models.DefaultTenant, Role: models.RoleAdmin, Vars: map[string]string{"id": created.ID}})
+	assert.Empty(t, resp.Info["history"])
+	assert.Equal(t, models.Customers{toronto}, defaultStore().List())
+}
+
+func TestGetCustomersRainFilterWithClock(t *testing.T) {
+	// The forecasts stored for customers only cover weatherforecaster.ForecastRange, so the rain filter has to match regardless of the current time
+	timeNow = time.Now
+	defer func() { timeNow = func() time.Time { return time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC) } }()
+	rainy := models.Customer{ID: "1", Name: "Rainy Company", Address: models.Address{City: "Toronto", CountryCode: "CA"}, WeatherDetails: []models.Weather{
+		{Date: weatherforecaster.ForecastRange().Start.Add(6 * time.Hour), Type: models.WeatherTypeRain},
+	}}
+	stores, searchIndex = newStores(rainy, models.Customer{ID: "2", Name: "Dry Company", Address: models.Address{City: "Toronto", CountryCode: "CA"}})
+
+	resp, err := getCustomers(router.Request{Role: models.RoleAdmin, Query: url.Values{"has_rain_within": {"48h"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, models.Customers{rainy}, resp.Info["customers"])
+}
+
+func TestGetCustomersInvalidQuery(t *testing.T) {
+	stores, searchIndex = newStores()
+	_, err := getCustomers(router.Request{Role: models.RoleAdmin, Query: url.Values{"limit": {"ten"}}})
+	assert.Equal(t, router.NewError(http.StatusBadRequest, "limit must be a positive integer"), err)
+}
@@ -0,0 +1,95 @@
+package customer
+
+import (
+	"reflect"
+	"sync"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/models"
+)
+
+// Topics of the events published when customers change
+const (
+	topicCustomerCreated = "customer.created"
+	topicCustomerUpdated = "customer.updated"
+	topicCustomerDeleted = "customer.deleted"
+	topicForecastChanged = "forecast.changed"
+	topicRainAlert       = "rain.alert"
+)
+
+// eventBus is the bus that customer changes are published to, tests may override it
+var eventBus = eventbus.Default
+
+// heldEvents holds back the events of the changes made by an atomic batch until it commits, so that subscribers and webhooks aren't
+// notified of changes that are rolled back
+var heldEvents = &eventHold{}
+
+// eventHold queues events while it's holding. It's safe for concurrent use
+type eventHold struct {
+	mu      sync.Mutex
+	holding bool
+	events  []eventbus.Event
+}
+
+// hold queues the events published from now on until release is called
+func (hold *eventHold) hold() {
+	hold.mu.Lock()
+	defer hold.mu.Unlock()
+	hold.holding, hold.events = true, nil
+}
+
+// release stops holding events, and publishes the queued events if publish is true or discards them otherwise
+func (hold *eventHold) release(publish bool) {
+	hold.mu.Lock()
+	events := hold.events
+	hold.holding, hold.events = false, nil
+	hold.mu.Unlock()
+
+	if publish {
+		for _, event := range events {
+			eventBus.PublishTenant(event.Tenant, event.Topic, event.Data)
+		}
+	}
+}
+
+// publish publishes an event describing a change to the tenant's customers, unless events are being held
+func publish(tenantID, topic string, data interface{}) {
+	heldEvents.mu.Lock()
+	if heldEvents.holding {
+		heldEvents.events = append(heldEvents.events, eventbus.Event{Tenant: tenantID, Topic: topic, Data: data})
+		heldEvents.mu.Unlock()
+		return
+	}
+	heldEvents.mu.Unlock()
+	eventBus.PublishTenant(tenantID, topic, data)
+}
+
+// publishChange publishes events describing each change to the tenant's store. Customers that become active, either by being created or
+// restored, are published as created. Rain newly forecast for an active customer is published as rain alerts, subject to the tenant's
+// alert rules
+func publishChange(tenantID string, before, after *models.Customer) {
+	switch {
+	case before == nil:
+		publish(tenantID, topicCustomerCreated, *after)
+		publishRainAlerts(tenantID, *after, nil)
+	case after == nil:
+		publish(tenantID, topicCustomerDeleted, *before)
+	default:
+		publish(tenantID, topicCustomerUpdated, *after)
+		if !reflect.DeepEqual(before.WeatherDetails, after.WeatherDetails) {
+			publish(tenantID, topicForecastChanged, models.ForecastChange{
+				CustomerID:     after.ID,
+				City:           after.Address.City,
+				CountryCode:    after.Address.CountryCode,
+				WeatherDetails: after.WeatherDetails,
+			})
+			publishRainAlerts(tenantID, *after, before.WeatherDetails)
+		}
+	}
+}
+
+func publishRainAlerts(tenantID string, customer models.Customer, previous []models.Weather) {
+	alerts := tenantConfig(tenantID).AlertRules.Filter(customer, models.NewRainAlerts(customer, previous), timeNow())
+	for _, alert := range alerts {
+		publish(tenantID, topicRainAlert, alert)
+	}
+}
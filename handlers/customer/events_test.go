@@ -0,0 +1,117 @@
+package customer
+
+import (
+	"testing"
+	"time"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishChange(t *testing.T) {
+	eventBus = eventbus.New(10)
+	defer func() { eventBus = eventbus.Default }()
+
+	customer := models.Customer{ID: "1", Name: "Awesome Company", Address: models.Address{City: "Toronto", Country: "Canada", CountryCode: "CA"}}
+	rainy := customer
+	rainy.WeatherDetails = []models.Weather{{Date: timeNow().Add(time.Hour), Type: models.WeatherTypeRain}}
+	alert := models.RainAlert{CustomerID: "1", CustomerName: "Awesome Company", City: "Toronto", CountryCode: "CA", Date: timeNow().Add(time.Hour)}
+	renamed := rainy
+	renamed.Name = "Awesomer Company"
+
+	tests := []struct {
+		name      string
+		before    *models.Customer
+		after     *models.Customer
+		expTopics []string
+		expData   []interface{}
+	}{
+		{
+			name:      "created",
+			after:     &customer,
+			expTopics: []string{topicCustomerCreated},
+			expData:   []interface{}{customer},
+		},
+		{
+			name:      "forecast changed",
+			before:    &customer,
+			after:     &rainy,
+			expTopics: []string{topicCustomerUpdated, topicForecastChanged, topicRainAlert},
+			expData: []interface{}{
+				rainy,
+				models.ForecastChange{CustomerID: "1", City: "Toronto", CountryCode: "CA", WeatherDetails: rainy.WeatherDetails},
+				alert,
+			},
+		},
+		{
+			name:      "created with rain",
+			after:     &rainy,
+			expTopics: []string{topicCustomerCreated, topicRainAlert},
+			expData:   []interface{}{rainy, alert},
+		},
+		{
+			name:      "updated",
+			before:    &rainy,
+			after:     &renamed,
+			expTopics: []string{topicCustomerUpdated},
+			expData:   []interface{}{renamed},
+		},
+		{
+			name:      "deleted",
+			before:    &renamed,
+			expTopics: []string{topicCustomerDeleted},
+			expData:   []interface{}{renamed},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subscription, _, _ := eventBus.Subscribe(nil, 0, 10)
+			defer subscription.Close()
+
+			publishChange("", test.before, test.after)
+
+			var topics []string
+			var data []interface{}
+			for len(subscription.Events()) > 0 {
+				event := <-subscription.Events()
+				topics = append(topics, event.Topic)
+				data = append(data, event.Data)
+			}
+			assert.Equal(t, test.expTopics, topics)
+			assert.Equal(t, test.expData, data)
+		})
+	}
+}
+
+func TestBatchEvents(t *testing.T) {
+	eventBus = eventbus.New(10)
+	defer func() { eventBus = eventbus.Default }()
+	stores, searchIndex = newStores()
+	topics := func(subscription *eventbus.Subscription) []string {
+		var topics []string
+		for len(subscription.Events()) > 0 {
+			topics = append(topics, (<-subscription.Events()).Topic)
+		}
+		return topics
+	}
+
+	// Events of a rolled back batch are discarded, including those of the rollback itself
+	subscription, _, _ := eventBus.Subscribe(nil, 0, 10)
+	defer subscription.Close()
+	transaction := storeTransactor{}.Begin()
+	_, err := defaultStore().Create(models.Customer{ID: "1", Name: "Awesome Company"})
+	assert.NoError(t, err)
+	transaction.Rollback()
+	assert.Empty(t, topics(subscription))
+	assert.Empty(t, defaultStore().List())
+
+	// Events of a committed batch are published once it commits
+	transaction = storeTransactor{}.Begin()
+	_, err = defaultStore().Create(models.Customer{ID: "1", Name: "Awesome Company"})
+	assert.NoError(t, err)
+	assert.Empty(t, topics(subscription))
+	transaction.Commit()
+	assert.Equal(t, []string{topicCustomerCreated}, topics(subscription))
+}
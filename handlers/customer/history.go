@@ -0,0 +1,232 @@
+package customer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+	"umbrellacorp/components/audit"
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/handlers/scope"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+	"umbrellacorp/util"
+)
+
+// auditLog records every change to customers. It's kept in memory until Init loads the configured file, tests may override it
+var auditLog = audit.NewLog()
+
+// systemActor is the actor of changes made by background jobs rather than requests
+const systemActor = "system"
+
+// Route names recorded for changes made by background jobs
+const (
+	forecastRefreshRoute = "Forecast Refresh"
+	purgeRoute           = "Purge Deleted Customers"
+	batchRollbackRoute   = "Batch Rollback"
+)
+
+// auditIgnoredFields are customer fields derived from other fields, that would only add noise to the audit log
+var auditIgnoredFields = []string{"lead"}
+
+// refreshIgnoredFields are the fields that aren't diffed for background forecast refreshes. Forecasts change for every customer on every
+// refresh, so their changes are recorded as a summary, see weatherSummary, rather than as a change of every forecast period
+var refreshIgnoredFields = append([]string{"weather"}, auditIgnoredFields...)
+
+// recordChange appends an entry to the tenant's audit log describing the change from before to after. before is nil for created customers
+// and after is nil for purged customers
+func recordChange(tenantID string, action audit.Action, actor, route string, before, after *models.Customer) {
+	entityID := ""
+	if after != nil {
+		entityID = after.ID
+	} else if before != nil {
+		entityID = before.ID
+	}
+
+	ignored := auditIgnoredFields
+	if route == forecastRefreshRoute {
+		ignored = refreshIgnoredFields
+	}
+	changes, err := audit.Diff(before, after, ignored...)
+	if err != nil {
+		log.Printf("Failed to compute audit changes for customer %s: %s", entityID, err.Error())
+	}
+	if route == forecastRefreshRoute && before != nil && after != nil && !sameWeather(before.WeatherDetails, after.WeatherDetails) {
+		changes = append(changes, audit.FieldChange{Field: "weather", Before: weatherSummary(before.WeatherDetails), After: weatherSummary(after.WeatherDetails)})
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	}
+	if route == forecastRefreshRoute && err == nil && len(changes) == 0 {
+		return
+	}
+
+	_, err = auditLog.Append(audit.Entry{
+		Tenant:   scope.Tenant(tenantID),
+		EntityID: entityID,
+		Action:   action,
+		Actor:    actor,
+		Route:    route,
+		Time:     timeNow(),
+		Changes:  changes,
+	})
+	if err != nil {
+		log.Printf("Failed to record audit entry for customer %s: %s", entityID, err.Error())
+	}
+}
+
+// sameWeather returns true if both forecasts have the same weather on the same dates
+func sameWeather(a, b []models.Weather) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Date.Equal(b[i].Date) || a[i].Type != b[i].Type {
+			return false
+		}
+	}
+	return true
+}
+
+// weatherSummary describes a forecast in the audit log, e.g. "40 periods, 6 with rain from 2017-02-16T03:00:00Z"
+func weatherSummary(weatherDetails []models.Weather) string {
+	rain := 0
+	var firstRain time.Time
+	for _, weather := range weatherDetails {
+		if weather.Type != models.WeatherTypeRain {
+			continue
+		}
+		if rain == 0 {
+			firstRain = weather.Date
+		}
+		rain++
+	}
+	if rain == 0 {
+		return fmt.Sprintf("%d periods, no rain", len(weatherDetails))
+	}
+	return fmt.Sprintf("%d periods, %d with rain from %s", len(weatherDetails), rain, firstRain.UTC().Format(time.RFC3339))
+}
+
+// recordRestore records the changes made by restoring the tenant's store to a snapshot, e.g. when an atomic batch is rolled back
+func recordRestore(tenantID string, before, after models.Customers) {
+	afterByID := map[string]models.Customer{}
+	for _, customer := range after {
+		afterByID[customer.ID] = customer
+	}
+
+	beforeIDs := map[string]bool{}
+	for i := range before {
+		beforeIDs[before[i].ID] = true
+		restored, ok := afterByID[before[i].ID]
+		if !ok {
+			recordChange(tenantID, audit.ActionPurge, systemActor, batchRollbackRoute, &before[i], nil)
+			continue
+		}
+
+		changes, err := audit.Diff(before[i], restored, auditIgnoredFields...)
+		if err == nil && len(changes) > 0 {
+			recordChange(tenantID, audit.ActionUpdate, systemActor, batchRollbackRoute, &before[i], &restored)
+		}
+	}
+
+	for i := range after {
+		if !beforeIDs[after[i].ID] {
+			recordChange(tenantID, audit.ActionCreate, systemActor, batchRollbackRoute, nil, &after[i])
+		}
+	}
+}
+
+// getCustomerHistory returns the audit entries of the customer specified by the id path param, oldest first. The history of deleted and
+// purged customers remains available to admins. Other callers can only access the history of customers within their territories
+func getCustomerHistory(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	if !req.Role.Allows(models.RoleAdmin) && !isAccessible(scope.Customers(req, stores, territories), req.Vars["id"]) {
+		return resp, storeError(customerstore.ErrNotFound(req.Vars["id"]))
+	}
+	resp.Info["history"] = auditLog.History(scope.Tenant(req.Tenant), req.Vars["id"])
+	return resp, nil
+}
+
+// isAccessible returns true if the view contains the customer with the specified id, including deleted customers
+func isAccessible(view customerstore.View, id string) bool {
+	for _, customer := range view.All() {
+		if customer.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// exportHistory streams the audit entries of every customer, oldest first. Supported query params:
+//   - format: csv or ndjson, defaults to csv. csv exports contain a row per field change
+//   - from, to: RFC 3339 timestamps limiting the entries to those recorded within the range
+func exportHistory(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+
+	format := req.Query.Get("format")
+	if format == "" {
+		format = formatCSV
+	}
+	if _, ok := formatContentTypes[format]; !ok {
+		return resp, router.NewError(http.StatusBadRequest, "Unsupported format: %s", format)
+	}
+
+	var dateRange util.DateRange
+	for param, bound := range map[string]*time.Time{"from": &dateRange.Start, "to": &dateRange.End} {
+		if value := req.Query.Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return resp, router.NewError(http.StatusBadRequest, "%s must be an RFC 3339 timestamp", param)
+			}
+			*bound = parsed
+		}
+	}
+
+	entries := auditLog.Entries(scope.Tenant(req.Tenant), dateRange)
+	resp.ContentType = formatContentTypes[format]
+	resp.Stream = func(w io.Writer) error {
+		if format == formatNDJSON {
+			encoder := json.NewEncoder(w)
+			for _, entry := range entries {
+				if err := encoder.Encode(entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return writeHistoryCSV(w, entries)
+	}
+	return resp, nil
+}
+
+func writeHistoryCSV(w io.Writer, entries []audit.Entry) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"id", "customer_id", "action", "actor", "route", "time", "field", "before", "after"}); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		changes := entry.Changes
+		if len(changes) == 0 {
+			// Still export entries that didn't change any field, e.g. restores of customers that were purged
+			changes = []audit.FieldChange{{}}
+		}
+
+		for _, change := range changes {
+			record := []string{entry.ID, entry.EntityID, string(entry.Action), entry.Actor, entry.Route, entry.Time.Format(time.RFC3339), change.Field, "", ""}
+			if change.Field != "" {
+				before, _ := json.Marshal(change.Before)
+				after, _ := json.Marshal(change.After)
+				record[7], record[8] = string(before), string(after)
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,154 @@
+package customer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+	"umbrellacorp/components/audit"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCustomerHistory(t *testing.T) {
+	auditLog = audit.NewLog()
+	stores, searchIndex = newStores()
+
+	created, err := setCustomer(router.Request{Role: models.RoleAdmin,
+		Info:  map[string]interface{}{"name": "Awesome Company", "contact_number": "4165550100", "address": map[string]interface{}{"city": "Toronto", "country": "Canada"}},
+		Actor: "alice",
+		Route: "Set Customer",
+	})
+	assert.NoError(t, err)
+	id := created.Info["customer"].(models.Customer).ID
+
+	_, err = setCustomer(router.Request{Role: models.RoleAdmin,
+		Info:  map[string]interface{}{"id": id, "name": "Awesome Company", "contact_number": "4165550101", "address": map[string]interface{}{"city": "Toronto", "country": "Canada"}},
+		Actor: "bob",
+		Route: "Set Customer",
+	})
+	assert.NoError(t, err)
+
+	_, err = deleteCustomer(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": id}, Actor: "bob", Route: "Delete Customer"})
+	assert.NoError(t, err)
+	_, err = restoreCustomer(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": id}, Actor: "alice", Route: "Restore Customer"})
+	assert.NoError(t, err)
+
+	resp, err := getCustomerHistory(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": id}})
+	assert.NoError(t, err)
+	history := resp.Info["history"].([]audit.Entry)
+	if !assert.Len(t, history, 4) {
+		return
+	}
+
+	type summary struct {
+		action audit.Action
+		actor  string
+		route  string
+	}
+	var summaries []summary
+	for _, entry := range history {
+		assert.Equal(t, id, entry.EntityID)
+		assert.Equal(t, timeNow(), entry.Time)
+		summaries = append(summaries, summary{entry.Action, entry.Actor, entry.Route})
+	}
+	assert.Equal(t, []summary{
+		{audit.ActionCreate, "alice", "Set Customer"},
+		{audit.ActionUpdate, "bob", "Set Customer"},
+		{audit.ActionDelete, "bob", "Delete Customer"},
+		{audit.ActionRestore, "alice", "Restore Customer"},
+	}, summaries)
+
+	assert.Equal(t, []audit.FieldChange{{Field: "contact_number", Before: "4165550100", After: "4165550101"}}, history[1].Changes)
+	assert.Equal(t, []audit.FieldChange{
+		{Field: "deleted_at", After: "2017-02-16T00:00:00Z"},
+		{Field: "deleted_by", After: "bob"},
+	}, history[2].Changes)
+	assert.Equal(t, []audit.FieldChange{
+		{Field: "deleted_at", Before: "2017-02-16T00:00:00Z"},
+		{Field: "deleted_by", Before: "bob"},
+	}, history[3].Changes)
+
+	// Background forecast refreshes record a summary of the changed forecast, and nothing if it didn't change
+	forecast := []models.Weather{{Date: timeNow(), Type: models.WeatherTypeRain}, {Date: timeNow().Add(3 * time.Hour)}}
+	previous := weatherSummary(defaultStore().List()[0].WeatherDetails)
+	refresher.save(models.DefaultTenant, models.Address{City: "Toronto", CountryCode: "CA"}, []string{id}, forecast)
+	refresher.save(models.DefaultTenant, models.Address{City: "Toronto", CountryCode: "CA"}, []string{id}, forecast)
+	resp, _ = getCustomerHistory(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": id}})
+	history = resp.Info["history"].([]audit.Entry)
+	if !assert.Len(t, history, 5) {
+		return
+	}
+	assert.Equal(t, summary{audit.ActionUpdate, systemActor, forecastRefreshRoute}, summary{history[4].Action, history[4].Actor, history[4].Route})
+	assert.Equal(t, []audit.FieldChange{
+		{Field: "weather", Before: previous, After: "2 periods, 1 with rain from 2017-02-16T00:00:00Z"},
+	}, history[4].Changes)
+
+	// History outlives the customer
+	_, err = defaultStore().Delete(id, "bob", timeNow().Add(-2*DefaultConfig.DeletedRetention))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purgeDeleted())
+	resp, _ = getCustomerHistory(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": id}})
+	history = resp.Info["history"].([]audit.Entry)
+	assert.Equal(t, audit.ActionPurge, history[len(history)-1].Action)
+	assert.Equal(t, systemActor, history[len(history)-1].Actor)
+}
+
+func TestExportHistory(t *testing.T) {
+	auditLog = audit.NewLog()
+	before := models.Customer{ID: "1", Name: "Awesome Company"}
+	after := models.Customer{ID: "1", Name: "Awesomer Company"}
+	recordChange("", audit.ActionUpdate, "alice", "Set Customer", &before, &after)
+
+	tests := []struct {
+		name     string
+		query    url.Values
+		expRows  int
+		expError error
+	}{
+		{
+			name:    "csv",
+			query:   url.Values{},
+			expRows: 2,
+		},
+		{
+			name:    "range excludes entries",
+			query:   url.Values{"from": {"2017-02-17T00:00:00Z"}},
+			expRows: 1,
+		},
+		{
+			name:     "invalid from",
+			query:    url.Values{"from": {"yesterday"}},
+			expError: router.NewError(http.StatusBadRequest, "from must be an RFC 3339 timestamp"),
+		},
+		{
+			name:     "unsupported format",
+			query:    url.Values{"format": {"xml"}},
+			expError: router.NewError(http.StatusBadRequest, "Unsupported format: xml"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := exportHistory(router.Request{Role: models.RoleAdmin, Query: test.query})
+			assert.Equal(t, test.expError, err)
+			if err != nil {
+				return
+			}
+
+			var buf bytes.Buffer
+			assert.NoError(t, resp.Stream(&buf))
+			records, err := csv.NewReader(&buf).ReadAll()
+			assert.NoError(t, err)
+			assert.Len(t, records, test.expRows)
+			if test.expRows > 1 {
+				assert.Equal(t, []string{"1", "update", "alice", "name", `"Awesome Company"`, `"Awesomer Company"`},
+					[]string{records[1][1], records[1][2], records[1][3], records[1][6], records[1][7], records[1][8]})
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package customer
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"umbrellacorp/components/leadscorer"
+	"umbrellacorp/handlers/scope"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// defaultLeadsLimit is the number of leads returned when the client doesn't specify a limit
+const defaultLeadsLimit = 10
+
+// lead is a customer ranked by their LeadScore, along with the explanation of how the score was computed
+type lead struct {
+	CustomerID    string               `json:"customer_id"`
+	Name          string               `json:"name"`
+	Contact       string               `json:"contact"`
+	ContactNumber string               `json:"contact_number"`
+	Score         float64              `json:"score"`
+	Breakdown     []models.ScoreFactor `json:"breakdown"`
+}
+
+// getLeads returns the top N customers to call, ranked by LeadScore. Supported query params:
+//   - sort: the ranking to use, only "score" is supported
+//   - limit: the max number of leads to return, defaults to 10
+func getLeads(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+
+	if sortBy := req.Query.Get("sort"); sortBy != "" && sortBy != "score" {
+		return resp, router.NewError(http.StatusBadRequest, "Unsupported sort: %s", sortBy)
+	}
+
+	limit := defaultLeadsLimit
+	if limitParam := req.Query.Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			return resp, router.NewError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+	}
+
+	resp.Info["leads"] = rankLeads(scope.Customers(req, stores, territories).List(), limit)
+	return resp, nil
+}
+
+// scoreLead returns the customer with its LeadScore recomputed
+func scoreLead(customer models.Customer) models.Customer {
+	score := leadscorer.Score(customer, timeNow())
+	customer.Lead = &score
+	return customer
+}
+
+// rankLeads sorts customers by descending LeadScore and returns the top limit leads. Customers that haven't been scored yet are scored on
+// the fly
+func rankLeads(existingCustomers models.Customers, limit int) []lead {
+	leads := make([]lead, 0, len(existingCustomers))
+	for _, customer := range existingCustomers {
+		score := customer.Lead
+		if score == nil {
+			computed := leadscorer.Score(customer, timeNow())
+			score = &computed
+		}
+		leads = append(leads, lead{
+			CustomerID:    customer.ID,
+			Name:          customer.Name,
+			Contact:       customer.Contact,
+			ContactNumber: customer.ContactNumber,
+			Score:         score.Total,
+			Breakdown:     score.Breakdown,
+		})
+	}
+
+	sort.SliceStable(leads, func(i, j int) bool {
+		return leads[i].Score > leads[j].Score
+	})
+
+	if len(leads) > limit {
+		leads = leads[:limit]
+	}
+	return leads
+}
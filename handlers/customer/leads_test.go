@@ -0,0 +1,89 @@
+package customer
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRankLeads(t *testing.T) {
+	existingCustomers := models.Customers{
+		{ID: "1", Name: "Small Company", NumEmployees: 5, Lead: &models.LeadScore{Total: 10}},
+		{ID: "2", Name: "Big Company", NumEmployees: 5000, Lead: &models.LeadScore{Total: 80}},
+		{ID: "3", Name: "Unscored Company", PipelineStatus: models.PipelineStatusLost},
+		{ID: "4", Name: "Medium Company", NumEmployees: 100, Lead: &models.LeadScore{Total: 45}},
+	}
+
+	tests := []struct {
+		name   string
+		limit  int
+		expIDs []string
+	}{
+		{
+			// The unscored customer is scored on the fly and was never contacted, so it outranks the small company
+			name:   "limit larger than customers",
+			limit:  10,
+			expIDs: []string{"2", "4", "3", "1"},
+		},
+		{
+			name:   "top N",
+			limit:  2,
+			expIDs: []string{"2", "4"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var recIDs []string
+			for _, lead := range rankLeads(existingCustomers, test.limit) {
+				recIDs = append(recIDs, lead.CustomerID)
+			}
+			assert.Equal(t, test.expIDs, recIDs)
+		})
+	}
+}
+
+func TestGetLeads(t *testing.T) {
+	stores, searchIndex = newStores(models.Customer{ID: "1", Name: "Awesome Company"})
+
+	tests := []struct {
+		name     string
+		query    url.Values
+		expError error
+	}{
+		{
+			name:     "default sort and limit",
+			query:    url.Values{},
+			expError: nil,
+		},
+		{
+			name:     "sort by score",
+			query:    url.Values{"sort": {"score"}, "limit": {"5"}},
+			expError: nil,
+		},
+		{
+			name:     "unsupported sort",
+			query:    url.Values{"sort": {"name"}},
+			expError: router.NewError(http.StatusBadRequest, "Unsupported sort: name"),
+		},
+		{
+			name:     "invalid limit",
+			query:    url.Values{"limit": {"-1"}},
+			expError: router.NewError(http.StatusBadRequest, "limit must be a positive integer"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, recErr := getLeads(router.Request{Role: models.RoleAdmin, Query: test.query})
+			assert.Equal(t, test.expError, recErr)
+			if recErr == nil {
+				assert.Len(t, resp.Info["leads"], 1)
+			}
+		})
+	}
+}
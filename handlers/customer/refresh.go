@@ -0,0 +1,242 @@
+package customer
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"umbrellacorp/components/audit"
+	"umbrellacorp/components/quota"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/handlers/scope"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// forecastRefresher refreshes customers' weather details in the background so that bulk operations such as imports don't block on, or
+// make one call per customer to, the forecast provider
+type forecastRefresher struct {
+	mu      sync.Mutex
+	pending map[customerRef]bool
+	wake    chan struct{}
+}
+
+// customerRef identifies a customer within its tenant's store
+type customerRef struct {
+	Tenant string
+	ID     string
+}
+
+func newForecastRefresher() *forecastRefresher {
+	return &forecastRefresher{
+		pending: map[customerRef]bool{},
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+var refresher = newForecastRefresher()
+
+// errLocationChanged aborts saving a refreshed forecast for a customer whose address was updated while the forecast was being fetched.
+// The address update fetches its own forecast
+var errLocationChanged = fmt.Errorf("Customer location changed")
+
+// enqueue schedules a forecast refresh of the specified customers of the tenant. Customers that are already pending are only refreshed
+// once
+func (refresher *forecastRefresher) enqueue(tenantID string, ids ...string) {
+	tenantID = scope.Tenant(tenantID)
+	refs := make([]customerRef, len(ids))
+	for i, id := range ids {
+		refs[i] = customerRef{Tenant: tenantID, ID: id}
+	}
+	refresher.enqueueRefs(refs...)
+}
+
+// enqueueRefs schedules a forecast refresh of the specified customers
+func (refresher *forecastRefresher) enqueueRefs(refs ...customerRef) {
+	refresher.mu.Lock()
+	for _, ref := range refs {
+		refresher.pending[ref] = true
+	}
+	refresher.mu.Unlock()
+
+	select {
+	case refresher.wake <- struct{}{}:
+	default:
+		// A wake up is already queued and will pick up these customers
+	}
+}
+
+// run refreshes pending customers as they're enqueued. Customers that couldn't be refreshed within the forecast providers' budgets are
+// enqueued again once the budget resets. It never returns, so it should be run in its own goroutine
+func (refresher *forecastRefresher) run() {
+	for range refresher.wake {
+		deferred, retryAt := refresher.refresh(refresher.drain())
+		if len(deferred) > 0 {
+			log.Printf("Deferred forecast refresh of %d customers until %s, the forecast provider's budget is exhausted", len(deferred), retryAt)
+			time.AfterFunc(time.Until(retryAt), func() {
+				refresher.enqueueRefs(deferred...)
+			})
+		}
+	}
+}
+
+// drain returns and clears the pending customers
+func (refresher *forecastRefresher) drain() []customerRef {
+	refresher.mu.Lock()
+	defer refresher.mu.Unlock()
+
+	refs := make([]customerRef, 0, len(refresher.pending))
+	for ref := range refresher.pending {
+		refs = append(refs, ref)
+	}
+	refresher.pending = map[customerRef]bool{}
+	return refs
+}
+
+// tenantLocation is a location whose forecast is fetched from a tenant's forecast provider
+type tenantLocation struct {
+	Tenant   string
+	Location models.Address
+}
+
+// locationRefresh is the customers of a tenant at a location, which are refreshed with a single forecast call
+type locationRefresh struct {
+	tenantLocation
+	ids []string
+	// mostUrgent is the customer at the location whose refresh is most urgent, see isMoreUrgent
+	mostUrgent models.Customer
+}
+
+// refresh fetches the forecast of each distinct location among each tenant's customers once, in a batch per tenant, and updates every
+// customer of the tenant at that location. Tenants may use different forecast providers, so forecasts aren't shared between tenants. Locations are refreshed in
+// order of urgency, so that if a provider's background budget runs out, the remaining customers are returned to be refreshed at retryAt
+func (refresher *forecastRefresher) refresh(refs []customerRef) (deferred []customerRef, retryAt time.Time) {
+	byLocation := map[tenantLocation]*locationRefresh{}
+	var locations []*locationRefresh
+	for _, ref := range refs {
+		customer, ok := stores.Get(ref.Tenant).Get(ref.ID)
+		if !ok {
+			// Deleted since it was enqueued
+			continue
+		}
+		key := tenantLocation{
+			Tenant:   ref.Tenant,
+			Location: models.Address{City: customer.Address.City, CountryCode: customer.Address.CountryCode},
+		}
+		location, ok := byLocation[key]
+		if !ok {
+			location = &locationRefresh{tenantLocation: key, mostUrgent: customer}
+			byLocation[key] = location
+			locations = append(locations, location)
+		}
+		location.ids = append(location.ids, ref.ID)
+		if isMoreUrgent(customer, location.mostUrgent) {
+			location.mostUrgent = customer
+		}
+	}
+	sort.SliceStable(locations, func(i, j int) bool {
+		return isMoreUrgent(locations[i].mostUrgent, locations[j].mostUrgent)
+	})
+
+	// Each tenant's locations are fetched in a single batch, in order of urgency
+	var tenantIDs []string
+	byTenant := map[string][]*locationRefresh{}
+	for _, refresh := range locations {
+		if _, ok := byTenant[refresh.Tenant]; !ok {
+			tenantIDs = append(tenantIDs, refresh.Tenant)
+		}
+		byTenant[refresh.Tenant] = append(byTenant[refresh.Tenant], refresh)
+	}
+
+	for _, tenantID := range tenantIDs {
+		batch := []weatherforecaster.Location{}
+		for _, refresh := range byTenant[tenantID] {
+			batch = append(batch, weatherforecaster.Location{City: refresh.Location.City, CountryCode: refresh.Location.CountryCode})
+		}
+		results := map[string]weatherforecaster.LocationForecast{}
+		for _, result := range fetchForecasts(tenantID, batch, quota.PriorityBackground) {
+			results[locationKey(result.City, result.CountryCode)] = result
+		}
+
+		for _, refresh := range byTenant[tenantID] {
+			location := refresh.Location
+			result := results[locationKey(location.City, location.CountryCode)]
+			if exhausted, ok := result.Err.(quota.ErrExhausted); ok {
+				for _, id := range refresh.ids {
+					deferred = append(deferred, customerRef{Tenant: tenantID, ID: id})
+				}
+				if retryAt.IsZero() || exhausted.RetryAt.Before(retryAt) {
+					retryAt = exhausted.RetryAt
+				}
+				continue
+			}
+			if result.Err != nil {
+				log.Printf("Failed to refresh forecast for %s, %s: %s", location.City, location.CountryCode, result.Err.Error())
+				continue
+			}
+			refresher.save(tenantID, location, refresh.ids, result.Weather)
+		}
+	}
+	return deferred, retryAt
+}
+
+// save sets the weather details of the tenant's customers that are still at the location. Customers are saved while no atomic batch is in
+// progress, so that its rollback can't undo the refresh
+func (refresher *forecastRefresher) save(tenantID string, location models.Address, ids []string, weatherDetails []models.Weather) {
+	router.WithMutationLock(func() {
+		refresher.saveLocked(tenantID, location, ids, weatherDetails)
+	})
+}
+
+// saveLocked saves the refreshed weather details, see save. The caller must hold the mutation lock
+func (refresher *forecastRefresher) saveLocked(tenantID string, location models.Address, ids []string, weatherDetails []models.Weather) {
+	store := stores.Get(tenantID)
+	for _, id := range ids {
+		var before models.Customer
+		after, err := store.Modify(id, func(customer models.Customer) (models.Customer, error) {
+			if customer.Address.City != location.City || customer.Address.CountryCode != location.CountryCode {
+				return customer, errLocationChanged
+			}
+			before = customer
+			customer.WeatherDetails = weatherDetails
+			return scoreLead(customer), nil
+		})
+		if err != nil {
+			if err != errLocationChanged {
+				log.Printf("Failed to save refreshed forecast for customer %s: %s", id, err.Error())
+			}
+			continue
+		}
+		recordChange(tenantID, audit.ActionUpdate, systemActor, forecastRefreshRoute, &before, &after)
+	}
+}
+
+// locationKey identifies a location regardless of case, like forecasters do when grouping duplicate locations
+func locationKey(city, countryCode string) string {
+	return strings.ToLower(city) + "," + strings.ToUpper(countryCode)
+}
+
+// pipelineUrgency ranks how soon each models.PipelineStatus is likely to lead to a sales call, so that the forecasts of customers in
+// active deals are refreshed first
+var pipelineUrgency = map[models.PipelineStatus]int{
+	models.PipelineStatusNegotiating: 4,
+	models.PipelineStatusContacted:   3,
+	models.PipelineStatusProspect:    2,
+	"":                               2,
+	models.PipelineStatusWon:         1,
+	models.PipelineStatusLost:        0,
+}
+
+// isMoreUrgent returns true if a's forecast should be refreshed before b's. Customers further along the pipeline are more urgent, followed
+// by customers contacted more recently
+func isMoreUrgent(a, b models.Customer) bool {
+	if pipelineUrgency[a.PipelineStatus] != pipelineUrgency[b.PipelineStatus] {
+		return pipelineUrgency[a.PipelineStatus] > pipelineUrgency[b.PipelineStatus]
+	}
+	if a.LastContacted == nil || b.LastContacted == nil {
+		return a.LastContacted != nil && b.LastContacted == nil
+	}
+	return a.LastContacted.After(*b.LastContacted)
+}
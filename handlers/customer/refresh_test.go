@@ -0,0 +1,85 @@
+package customer
+
+import (
+	"testing"
+	"time"
+	"umbrellacorp/components/quota"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForecastRefresher(t *testing.T) {
+	toronto := models.Address{City: "Toronto", Country: "CA", CountryCode: "CA"}
+	stores, searchIndex = newStores(
+		models.Customer{ID: "1", Name: "Awesome Company", Address: toronto},
+		models.Customer{ID: "2", Name: "Fortune 500 Company", Address: toronto},
+		models.Customer{ID: "3", Name: "Untouched Company", Address: toronto},
+	)
+
+	providerQuotas = quota.New(nil)
+
+	testRefresher := newForecastRefresher()
+	testRefresher.enqueue("", "1", "2")
+	testRefresher.enqueue(models.DefaultTenant, "2", "deleted")
+	// Customers of other tenants aren't found in the default tenant's store
+	testRefresher.enqueue("acme", "3")
+	refs := testRefresher.drain()
+	assert.ElementsMatch(t, []customerRef{
+		{Tenant: models.DefaultTenant, ID: "1"},
+		{Tenant: models.DefaultTenant, ID: "2"},
+		{Tenant: models.DefaultTenant, ID: "deleted"},
+		{Tenant: "acme", ID: "3"},
+	}, refs)
+	assert.Empty(t, testRefresher.drain())
+
+	deferred, _ := testRefresher.refresh(refs)
+	assert.Empty(t, deferred)
+
+	expWeather, err := fetchForecast(models.DefaultTenant, toronto, quota.PriorityInteractive)
+	assert.NoError(t, err)
+	for _, customer := range defaultStore().List() {
+		if customer.ID == "3" {
+			assert.Empty(t, customer.WeatherDetails)
+			assert.Nil(t, customer.Lead)
+			continue
+		}
+		assert.Equal(t, expWeather, customer.WeatherDetails)
+		assert.NotNil(t, customer.Lead)
+	}
+}
+
+func TestDeferredRefresh(t *testing.T) {
+	lastWeek := timeNow().AddDate(0, 0, -7)
+	yesterday := timeNow().AddDate(0, 0, -1)
+	stores, searchIndex = newStores(
+		models.Customer{ID: "1", Name: "Prospect", Address: models.Address{City: "Toronto", CountryCode: "CA"}},
+		models.Customer{ID: "2", Name: "Negotiating", PipelineStatus: models.PipelineStatusNegotiating, Address: models.Address{City: "Chicago", CountryCode: "US"}},
+		models.Customer{ID: "3", Name: "Contacted", PipelineStatus: models.PipelineStatusContacted, LastContacted: &lastWeek, Address: models.Address{City: "Boston", CountryCode: "US"}},
+		models.Customer{ID: "4", Name: "Recently Contacted", PipelineStatus: models.PipelineStatusContacted, LastContacted: &yesterday, Address: models.Address{City: "Denver", CountryCode: "US"}},
+	)
+	// Background refreshes can make 1 call a minute
+	providerQuotas = quota.New(map[string]quota.Budget{weatherforecaster.ProviderReplay: {PerMinute: 2}})
+	defer func() { providerQuotas = quota.Default }()
+
+	deferred, retryAt := newForecastRefresher().refresh([]customerRef{
+		{Tenant: models.DefaultTenant, ID: "1"},
+		{Tenant: models.DefaultTenant, ID: "3"},
+		{Tenant: models.DefaultTenant, ID: "4"},
+		{Tenant: models.DefaultTenant, ID: "2"},
+	})
+	assert.Equal(t, []customerRef{
+		{Tenant: models.DefaultTenant, ID: "4"},
+		{Tenant: models.DefaultTenant, ID: "3"},
+		{Tenant: models.DefaultTenant, ID: "1"},
+	}, deferred)
+	assert.WithinDuration(t, time.Now(), retryAt, time.Minute)
+
+	negotiating, _ := defaultStore().Get("2")
+	assert.NotEmpty(t, negotiating.WeatherDetails)
+
+	// Upserts can still use the budget reserved for interactive calls
+	_, err := fetchForecast(models.DefaultTenant, models.Address{City: "Toronto", CountryCode: "CA"}, quota.PriorityInteractive)
+	assert.NoError(t, err)
+}
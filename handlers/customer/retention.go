@@ -0,0 +1,77 @@
+package customer
+
+import (
+	"log"
+	"sync"
+	"time"
+	"umbrellacorp/components/audit"
+	"umbrellacorp/router"
+)
+
+// Config configures the customer handlers
+type Config struct {
+	// DeletedRetention is how long deleted customers can be restored before they're permanently purged
+	DeletedRetention time.Duration
+	// PurgeInterval is how often deleted customers past their retention are purged
+	PurgeInterval time.Duration
+	// AuditPath is the file that the audit log of customer changes is appended to. It's only kept in memory if it's empty
+	AuditPath string
+}
+
+// DefaultConfig keeps deleted customers for 30 days
+var DefaultConfig = Config{
+	DeletedRetention: 30 * 24 * time.Hour,
+	PurgeInterval:    time.Hour,
+}
+
+var (
+	configMu sync.RWMutex
+	config   = DefaultConfig
+)
+
+// Configure sets the configuration of the customer handlers. It must be called before Init. Zero values keep their defaults
+func Configure(c Config) {
+	if c.DeletedRetention <= 0 {
+		c.DeletedRetention = DefaultConfig.DeletedRetention
+	}
+	if c.PurgeInterval <= 0 {
+		c.PurgeInterval = DefaultConfig.PurgeInterval
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+}
+
+func currentConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+// runPurger periodically purges deleted customers past their retention. It never returns, so it should be run in its own goroutine
+func runPurger() {
+	for {
+		time.Sleep(currentConfig().PurgeInterval)
+		router.WithMutationLock(func() {
+			purgeDeleted()
+		})
+	}
+}
+
+// purgeDeleted permanently removes every tenant's customers that were deleted longer than the configured retention ago
+func purgeDeleted() int {
+	cutoff := timeNow().Add(-currentConfig().DeletedRetention)
+	total := 0
+	for tenantID, store := range stores.All() {
+		purged := store.Purge(cutoff)
+		for i := range purged {
+			recordChange(tenantID, audit.ActionPurge, systemActor, purgeRoute, &purged[i], nil)
+		}
+		total += len(purged)
+	}
+	if total > 0 {
+		log.Printf("Purged %d deleted customers", total)
+	}
+	return total
+}
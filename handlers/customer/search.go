@@ -0,0 +1,63 @@
+package customer
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"umbrellacorp/components/searchindex"
+	"umbrellacorp/handlers/scope"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// defaultSearchLimit is the number of search results returned when the client doesn't specify a limit
+const defaultSearchLimit = 20
+
+// searchResult is a customer that matched a search query
+type searchResult struct {
+	Customer models.Customer `json:"customer"`
+	Score    float64         `json:"score"`
+}
+
+// indexCustomer adds the customer's searchable fields to the index
+func indexCustomer(index *searchindex.Index, customer models.Customer) {
+	index.Put(customer.ID, customer.Name, customer.Contact, customer.Address.City, customer.Notes)
+}
+
+// searchCustomers returns customers matching every word in the q query param across their name, contact, city and notes. Words match
+// as prefixes and tolerate typos. The optional limit query param caps the number of results, defaulting to 20
+func searchCustomers(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+
+	query := req.Query.Get("q")
+	if len(searchindex.Tokenize(query)) == 0 {
+		return resp, router.NewError(http.StatusBadRequest, "q required")
+	}
+
+	limit := defaultSearchLimit
+	if limitParam := req.Query.Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			return resp, router.NewError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+	}
+
+	// Every match is ranked, so that limit results are returned even if some matches aren't accessible to the caller
+	view := scope.Customers(req, stores, territories)
+	results := []searchResult{}
+	for _, match := range searchIndex.Search(query, math.MaxInt32) {
+		if len(results) == limit {
+			break
+		}
+		// The customer may have been deleted since the search ran
+		customer, ok := view.Get(match.ID)
+		if !ok {
+			continue
+		}
+		results = append(results, searchResult{Customer: customer, Score: match.Score})
+	}
+
+	resp.Info["results"] = results
+	return resp, nil
+}
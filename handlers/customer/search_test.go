@@ -0,0 +1,88 @@
+package customer
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchCustomers(t *testing.T) {
+	stores, searchIndex = newStores(
+		models.Customer{ID: "1", Name: "Acme Umbrellas", Contact: "Jane Doe", Address: models.Address{City: "Toronto"}},
+		models.Customer{ID: "2", Name: "Acme Rain Gear", Address: models.Address{City: "Chicago"}, Notes: "Prefers calls in the morning"},
+	)
+
+	// Mutations made through the store are reflected in search results
+	_, err := defaultStore().Create(models.Customer{ID: "3", Name: "Toronto Raincoats", Address: models.Address{City: "Toronto"}})
+	assert.NoError(t, err)
+	_, err = defaultStore().Update(models.Customer{ID: "2", Name: "Acme Rain Gear", Address: models.Address{City: "Vancouver"}, Notes: "Prefers calls in the morning"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		query    url.Values
+		expIDs   []string
+		expError error
+	}{
+		{
+			name:   "multiple words must all match",
+			query:  url.Values{"q": {"toronto acme"}},
+			expIDs: []string{"1"},
+		},
+		{
+			name:   "prefix match",
+			query:  url.Values{"q": {"tor"}},
+			expIDs: []string{"1", "3"},
+		},
+		{
+			name:   "typo match",
+			query:  url.Values{"q": {"tornto"}},
+			expIDs: []string{"1", "3"},
+		},
+		{
+			name:   "notes and contact are searchable",
+			query:  url.Values{"q": {"morning"}},
+			expIDs: []string{"2"},
+		},
+		{
+			name:   "updated city",
+			query:  url.Values{"q": {"acme vancouver"}},
+			expIDs: []string{"2"},
+		},
+		{
+			name:   "limit",
+			query:  url.Values{"q": {"toronto"}, "limit": {"1"}},
+			expIDs: []string{"1"},
+		},
+		{
+			name:   "no matches",
+			query:  url.Values{"q": {"chicago"}},
+			expIDs: nil,
+		},
+		{
+			name:     "missing query",
+			query:    url.Values{"q": {" "}},
+			expError: router.NewError(http.StatusBadRequest, "q required"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, recErr := searchCustomers(router.Request{Role: models.RoleAdmin, Query: test.query})
+			assert.Equal(t, test.expError, recErr)
+			if recErr != nil {
+				return
+			}
+
+			var recIDs []string
+			for _, result := range resp.Info["results"].([]searchResult) {
+				recIDs = append(recIDs, result.Customer.ID)
+			}
+			assert.Equal(t, test.expIDs, recIDs)
+		})
+	}
+}
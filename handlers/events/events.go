@@ -0,0 +1,185 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/components/notify"
+	"umbrellacorp/components/sales"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/handlers/scope"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+const (
+	// subscriberBufferSize is the number of events that may be pending delivery to a client before it's disconnected as too slow. The
+	// client resumes from the replay buffer when it reconnects
+	subscriberBufferSize = 100
+	// keepAliveInterval is how often a comment is sent on idle streams, so that proxies don't time out the connection
+	keepAliveInterval = 15 * time.Second
+	// resetTopic is sent first when the client's Last-Event-ID is older than the replay buffer, to signal that events were missed and
+	// that it should reload its state
+	resetTopic = "stream.reset"
+)
+
+// bus is the event bus that clients are subscribed to, tests may override it
+var bus = eventbus.Default
+
+// customers are the customers that events describe, tests may override them
+var customers = customerstore.Default
+
+// territories defines the customers accessible to each sales rep, whose events are only streamed to callers that can access them
+var territories = territory.Default
+
+// Init registers handlers with the router
+func Init() {
+	routes := router.Routes{
+		{
+			Name:        "Stream Events",
+			Methods:     []string{http.MethodGet},
+			Path:        "/events",
+			HandlerFunc: streamEvents,
+			Role:        models.RoleViewer,
+		},
+	}
+	router.RegisterRoutes("events", routes)
+}
+
+// streamEvents streams the events of the request's tenant to the client as Server-Sent Events until it disconnects. Events describing
+// customers that aren't accessible to the caller are skipped, see isVisible. Supported params:
+//   - topics query param: comma separated topics to receive, e.g. customer,forecast.changed. Defaults to every topic
+//   - Last-Event-ID header or last_event_id query param: replays buffered events published after the specified event
+func streamEvents(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+
+	var topics []string
+	for _, topic := range strings.Split(req.Query.Get("topics"), ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+
+	lastEventID := req.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = req.Query.Get("last_event_id")
+	}
+	var afterID uint64
+	if lastEventID != "" {
+		var err error
+		afterID, err = strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			return resp, router.NewError(http.StatusBadRequest, "Invalid Last-Event-ID: %s", lastEventID)
+		}
+	}
+
+	ctx := req.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	resp.ContentType = "text/event-stream"
+	resp.Header = http.Header{"Cache-Control": {"no-cache"}}
+	resp.Stream = func(w io.Writer) error {
+		subscription, replay, complete := bus.SubscribeTenant(req.Tenant, topics, afterID, subscriberBufferSize)
+		defer subscription.Close()
+
+		// Sending a comment straight away lets the client know the stream is established before any event is published
+		if _, err := io.WriteString(w, ": connected\n\n"); err != nil {
+			return err
+		}
+		if !complete {
+			if err := writeEvent(w, eventbus.Event{Topic: resetTopic, Time: time.Now()}); err != nil {
+				return err
+			}
+		}
+		for _, event := range replay {
+			if !isVisible(req, event) {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return err
+			}
+		}
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case event, ok := <-subscription.Events():
+				if !ok {
+					// Dropped for falling behind. Ending the stream makes the client reconnect and resume from its last event
+					return nil
+				}
+				if !isVisible(req, event) {
+					continue
+				}
+				if err := writeEvent(w, event); err != nil {
+					return err
+				}
+			case <-keepAlive.C:
+				if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return resp, nil
+}
+
+// isVisible returns true if the caller may receive the event. Events describing a customer, or its forecast, orders or messages, are only
+// visible to callers that can access the customer, see scope.Customers. Other events are visible to every caller of the tenant
+func isVisible(req router.Request, event eventbus.Event) bool {
+	if req.Role.Allows(models.RoleAdmin) {
+		return true
+	}
+
+	var customerID string
+	switch data := event.Data.(type) {
+	case models.Customer:
+		// Deleted and purged customers are described by the event, so their scope is checked without looking them up
+		return scope.Customers(req, customers, territories).Contains(data)
+	case models.ForecastChange:
+		customerID = data.CustomerID
+	case models.RainAlert:
+		customerID = data.CustomerID
+	case sales.Order:
+		customerID = data.CustomerID
+	case notify.Message:
+		customerID = data.CustomerID
+	default:
+		return true
+	}
+
+	for _, customer := range scope.Customers(req, customers, territories).All() {
+		if customer.ID == customerID {
+			return true
+		}
+	}
+	return false
+}
+
+// writeEvent writes the event in the Server-Sent Events format. Events without an ID, such as resets, don't change the client's
+// Last-Event-ID
+func writeEvent(w io.Writer, event eventbus.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("event: %s\ndata: %s\n\n", event.Topic, data)
+	if event.ID > 0 {
+		msg = fmt.Sprintf("id: %d\n", event.ID) + msg
+	}
+	_, err = io.WriteString(w, msg)
+	return err
+}
@@ -0,0 +1,166 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readEvents reads n events from the stream, returning every line except the data lines
+func readEvents(scanner *bufio.Scanner, n int) []string {
+	var lines []string
+	for n > 0 && scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			n--
+		}
+		if !strings.HasPrefix(line, "data: ") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestStreamEvents(t *testing.T) {
+	bus = eventbus.New(2)
+	bus.Publish("customer.created", nil)
+	bus.Publish("forecast.changed", nil)
+	bus.Publish("customer.updated", nil)
+	bus.Publish("forecast.changed", nil)
+
+	tests := []struct {
+		name      string
+		query     url.Values
+		header    http.Header
+		publish   string
+		expEvents int
+		expLines  []string
+		expError  error
+	}{
+		{
+			name:      "replay from header",
+			query:     url.Values{},
+			header:    http.Header{"Last-Event-Id": {"3"}},
+			expEvents: 2,
+			expLines:  []string{": connected", "", "id: 4", "event: forecast.changed", ""},
+		},
+		{
+			name:      "replay from query param with topic filter",
+			query:     url.Values{"last_event_id": {"2"}, "topics": {"customer"}},
+			header:    http.Header{},
+			expEvents: 2,
+			expLines:  []string{": connected", "", "id: 3", "event: customer.updated", ""},
+		},
+		{
+			name:      "reset when missed events were evicted",
+			query:     url.Values{"last_event_id": {"1"}, "topics": {"forecast.changed"}},
+			header:    http.Header{},
+			expEvents: 3,
+			expLines:  []string{": connected", "", "event: stream.reset", "", "id: 4", "event: forecast.changed", ""},
+		},
+		{
+			name:      "live events",
+			query:     url.Values{"topics": {"customer, forecast"}},
+			header:    http.Header{},
+			publish:   "customer.deleted",
+			expEvents: 1,
+			expLines:  []string{"id: 5", "event: customer.deleted", ""},
+		},
+		{
+			name:     "invalid last event id",
+			query:    url.Values{},
+			header:   http.Header{"Last-Event-Id": {"latest"}},
+			expError: router.NewError(http.StatusBadRequest, "Invalid Last-Event-ID: latest"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			resp, err := streamEvents(router.Request{Query: test.query, Header: test.header, Context: ctx})
+			assert.Equal(t, test.expError, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, "text/event-stream", resp.ContentType)
+
+			r, w := io.Pipe()
+			done := make(chan error)
+			go func() {
+				done <- resp.Stream(w)
+			}()
+
+			scanner := bufio.NewScanner(r)
+			if test.publish != "" {
+				// The client is subscribed once the stream is established
+				assert.Equal(t, []string{": connected", ""}, readEvents(scanner, 1))
+				bus.Publish(test.publish, nil)
+			}
+
+			assert.Equal(t, test.expLines, readEvents(scanner, test.expEvents))
+
+			// Disconnecting the client ends the stream
+			cancel()
+			assert.NoError(t, <-done)
+		})
+	}
+}
+
+func TestStreamEventsInTerritory(t *testing.T) {
+	toronto := models.Customer{ID: "1", Name: "Toronto Company", Address: models.Address{City: "Toronto", CountryCode: "CA"}}
+	chicago := models.Customer{ID: "2", Name: "Chicago Company", Address: models.Address{City: "Chicago", CountryCode: "US"}}
+	customers = customerstore.NewTenants(func(tenantID string) *customerstore.Store {
+		if tenantID == models.DefaultTenant {
+			return customerstore.New(toronto, chicago)
+		}
+		return customerstore.New()
+	})
+	territories = territory.NewTenants()
+	canada, err := territories.Get(models.DefaultTenant).Create(territory.Territory{Name: "Canada", Countries: []string{"CA"}})
+	assert.NoError(t, err)
+	assert.NoError(t, territories.Get(models.DefaultTenant).Assign("alice", []string{canada.ID}))
+
+	bus = eventbus.New(10)
+	bus.PublishTenant(models.DefaultTenant, "stream.start", nil)
+	bus.PublishTenant(models.DefaultTenant, "customer.created", toronto)
+	bus.PublishTenant(models.DefaultTenant, "customer.created", chicago)
+	bus.PublishTenant(models.DefaultTenant, "rain.alert", models.RainAlert{CustomerID: chicago.ID, City: "Chicago", CountryCode: "US"})
+	bus.PublishTenant(models.DefaultTenant, "forecast.changed", models.ForecastChange{CustomerID: toronto.ID, City: "Toronto", CountryCode: "CA"})
+	bus.PublishTenant(models.DefaultTenant, "stock.low", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rep := router.Request{Actor: "alice", Role: models.RoleRep, Tenant: models.DefaultTenant, Query: url.Values{"last_event_id": {"1"}}, Header: http.Header{}, Context: ctx}
+	resp, err := streamEvents(rep)
+	assert.NoError(t, err)
+
+	r, w := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- resp.Stream(w)
+	}()
+	scanner := bufio.NewScanner(r)
+	assert.Equal(t, []string{": connected", "", "id: 2", "event: customer.created", "", "id: 5", "event: forecast.changed", "", "id: 6", "event: stock.low", ""},
+		readEvents(scanner, 4))
+
+	// Live events of customers outside of the rep's territories are skipped too
+	bus.PublishTenant(models.DefaultTenant, "customer.deleted", chicago)
+	bus.PublishTenant(models.DefaultTenant, "customer.deleted", toronto)
+	assert.Equal(t, []string{"id: 8", "event: customer.deleted", ""}, readEvents(scanner, 1))
+
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -1,10 +1,34 @@
 package handlers
 
 import (
+	alerts "umbrellacorp/handlers/alerts"
+	apikeys "umbrellacorp/handlers/apikeys"
+	campaigns "umbrellacorp/handlers/campaigns"
+	catalog "umbrellacorp/handlers/catalog"
 	customer "umbrellacorp/handlers/customer"
+	events "umbrellacorp/handlers/events"
+	providers "umbrellacorp/handlers/providers"
+	reports "umbrellacorp/handlers/reports"
+	sales "umbrellacorp/handlers/sales"
+	segments "umbrellacorp/handlers/segments"
+	tenants "umbrellacorp/handlers/tenants"
+	territories "umbrellacorp/handlers/territories"
+	webhooks "umbrellacorp/handlers/webhooks"
 )
 
 // Init initializes all entity handlers
 func Init() {
+	tenants.Init()
+	apikeys.Init()
+	providers.Init()
 	customer.Init()
+	sales.Init()
+	segments.Init()
+	reports.Init()
+	campaigns.Init()
+	territories.Init()
+	catalog.Init()
+	events.Init()
+	alerts.Init()
+	webhooks.Init()
 }
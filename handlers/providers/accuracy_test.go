@@ -0,0 +1,50 @@
+package providers
+
+import (
+	"testing"
+	"time"
+	"umbrellacorp/components/accuracy"
+	"umbrellacorp/components/quota"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+	"umbrellacorp/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveDue(t *testing.T) {
+	replay := weatherforecaster.ProviderConfig{Provider: weatherforecaster.ProviderReplay, Fixtures: "../../components/weatherforecaster/testdata/forecasts"}
+	tracker = accuracy.New()
+	defer func() { tracker = accuracy.Default }()
+
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	dateRange := util.DateRange{Start: now, End: now.AddDate(0, 0, 5)}
+	forecaster := weatherforecaster.NewProvider(replay)
+	// London's recording has no rain, but rain was observed there
+	for _, location := range []weatherforecaster.Location{{City: "Toronto", CountryCode: "CA"}, {City: "London", CountryCode: "GB"}} {
+		forecast, err := forecaster.UpcomingWeather(location.City, location.CountryCode, dateRange, models.WeatherTypeRain)
+		assert.NoError(t, err)
+		tracker.Record(accuracy.Snapshot{Provider: replay, Location: location, Range: dateRange, Forecast: forecast})
+	}
+
+	// Observations are background calls, which can make 1 call a minute
+	quotas = quota.New(map[string]quota.Budget{weatherforecaster.ProviderReplay: {PerMinute: 2}})
+	defer func() { quotas = quota.Default }()
+	assert.Equal(t, 1, observeDue(time.Now()))
+	assert.Equal(t, 1, tracker.Pending())
+
+	quotas = quota.New(nil)
+	assert.Equal(t, 1, observeDue(time.Now()))
+	assert.Equal(t, 0, tracker.Pending())
+
+	resp, err := getAccuracy(router.Request{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Info["pending_forecasts"])
+	report := resp.Info["providers"].([]accuracy.ProviderAccuracy)
+	if assert.Len(t, report, 1) {
+		assert.Equal(t, 2, report[0].Forecasts)
+		assert.Equal(t, accuracy.Counts{Periods: 80, Hits: 11, Misses: 11, CorrectNegatives: 58}, report[0].Counts)
+		assert.Len(t, report[0].LeadTimes, 5)
+	}
+}
@@ -0,0 +1,137 @@
+package providers
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"umbrellacorp/components/accuracy"
+	"umbrellacorp/components/quota"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// Config configures the budgets of the forecast providers
+type Config struct {
+	// Budgets are the call budgets of providers, by provider name. Providers without a budget aren't capped
+	Budgets map[string]quota.Budget
+	// ObserveInterval is how often forecasts whose date range has passed are compared with the observed weather. Defaults to
+	// defaultObserveInterval
+	ObserveInterval time.Duration
+}
+
+// defaultObserveInterval is the default Config.ObserveInterval
+const defaultObserveInterval = 10 * time.Minute
+
+var (
+	configMu sync.RWMutex
+	config   Config
+)
+
+// Configure sets the budgets of the forecast providers. It must be called before Init
+func Configure(c Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+}
+
+// quotas tracks the usage of the forecast providers
+var quotas = quota.Default
+
+// tracker measures the accuracy of the forecast providers
+var tracker = accuracy.Default
+
+// Init applies the configured budgets and registers handlers with the router
+func Init() {
+	configMu.RLock()
+	c := config
+	configMu.RUnlock()
+
+	for provider, budget := range c.Budgets {
+		if err := quotas.SetBudget(provider, budget); err != nil {
+			log.Fatalf("Failed to configure the budget of %s: %s", provider, err.Error())
+		}
+	}
+
+	routes := router.Routes{
+		{
+			Name:        "Get Provider Usage",
+			Methods:     []string{http.MethodGet},
+			Path:        "/providers/usage",
+			HandlerFunc: getUsage,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Provider Accuracy",
+			Methods:     []string{http.MethodGet},
+			Path:        "/providers/accuracy",
+			HandlerFunc: getAccuracy,
+			Role:        models.RoleAdmin,
+		},
+	}
+	router.RegisterRoutes("providers", routes)
+
+	interval := c.ObserveInterval
+	if interval <= 0 {
+		interval = defaultObserveInterval
+	}
+	go runObserver(interval)
+}
+
+// getUsage returns the calls made to each forecast provider within the current minute and day, along with their budgets. Usage is shared
+// by every tenant using a provider. The response also counts the forecast lookups that shared an identical in-flight call
+func getUsage(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	resp.Info["providers"] = quotas.Usage()
+	resp.Info["coalescing"] = weatherforecaster.Coalescing()
+	return resp, nil
+}
+
+// getAccuracy returns how accurate each forecast provider's forecasts were, overall and by the days they were forecast in advance, most
+// accurate first. Accuracy is shared by every tenant using a provider. The ranking is only reported, forecasts aren't routed by it, since
+// each tenant is configured with a single provider and there's no failover order to feed it into yet
+func getAccuracy(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	resp.Info["providers"] = tracker.Report()
+	resp.Info["pending_forecasts"] = tracker.Pending()
+	return resp, nil
+}
+
+// runObserver periodically compares forecasts whose date range has passed with the observed weather. It never returns, so it should be
+// run in its own goroutine
+func runObserver(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		observeDue(time.Now())
+	}
+}
+
+// observeDue obtains the observed weather of each forecast that's due from the provider that forecast it. Observations are background
+// calls within the providers' budgets, so observing stops until the next interval once a budget is exhausted
+func observeDue(now time.Time) int {
+	observed := 0
+	due := tracker.Due(now)
+	for i, snapshot := range due {
+		if err := quotas.Acquire(snapshot.Provider.Name(), quota.PriorityBackground); err != nil {
+			log.Printf("Deferred observing the weather of %d forecasts: %s", len(due)-i, err.Error())
+			break
+		}
+
+		observer, err := weatherforecaster.NewObserver(snapshot.Provider)
+		if err != nil {
+			log.Printf("Failed to observe the weather at %s, %s: %s", snapshot.Location.City, snapshot.Location.CountryCode, err.Error())
+			tracker.Fail(snapshot)
+			continue
+		}
+		weather, err := observer.ObservedWeather(snapshot.Location.City, snapshot.Location.CountryCode, snapshot.Range, models.WeatherTypeRain)
+		if err != nil {
+			log.Printf("Failed to observe the weather at %s, %s: %s", snapshot.Location.City, snapshot.Location.CountryCode, err.Error())
+			tracker.Fail(snapshot)
+			continue
+		}
+		tracker.Observe(snapshot, weather)
+		observed++
+	}
+	return observed
+}
@@ -0,0 +1,26 @@
+package providers
+
+import (
+	"testing"
+	"umbrellacorp/components/quota"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetUsage(t *testing.T) {
+	quotas = quota.New(map[string]quota.Budget{"openweathermap": {PerMinute: 60, PerDay: 1000}})
+	assert.NoError(t, quotas.Acquire("openweathermap", quota.PriorityInteractive))
+
+	resp, err := getUsage(router.Request{})
+	assert.NoError(t, err)
+	usage := resp.Info["providers"].([]quota.Usage)
+	if assert.Len(t, usage, 1) {
+		assert.Equal(t, "openweathermap", usage[0].Provider)
+		assert.Equal(t, quota.Budget{PerMinute: 60, PerDay: 1000}, usage[0].Budget)
+		assert.Equal(t, 1, usage[0].MinuteCalls)
+		assert.Equal(t, 1, usage[0].DayCalls)
+	}
+	assert.IsType(t, weatherforecaster.CoalescingStats{}, resp.Info["coalescing"])
+}
@@ -0,0 +1,126 @@
+package reports
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"umbrellacorp/components/demand"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/handlers/scope"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+var (
+	demandReportsMu sync.RWMutex
+	// demandReports holds the last demand forecast aggregated for each tenant
+	demandReports = map[string]demand.Report{}
+)
+
+// runDemandAggregator periodically aggregates the demand forecast of every tenant. It never returns, so it should be run in its own
+// goroutine
+func runDemandAggregator() {
+	for {
+		for tenantID, store := range customers.All() {
+			// Customers are read while no atomic batch is in progress, so that the demand of customers it may yet roll back isn't counted
+			var active, all models.Customers
+			router.WithMutationLock(func() {
+				active, all = store.List(), store.All()
+			})
+			aggregateDemand(tenantID, active, all)
+		}
+		time.Sleep(currentConfig().DemandInterval)
+	}
+}
+
+// aggregateDemand aggregates the tenant's demand forecast from the forecasts of its active customers and the conversion of its quotes. Only
+// active customers have demand, but the quotes of all customers, including deleted ones, count towards the conversion rate of their country
+func aggregateDemand(tenantID string, active, all models.Customers) demand.Report {
+	tenantID = scope.Tenant(tenantID)
+	now := timeNow()
+	rates := demand.HistoricalRates(salesStore.Quotes(tenantID, ""), salesStore.Orders(tenantID, "", ""), all, currentConfig().DefaultConversionRate, now)
+	report := demand.Forecast(active, rates, weatherforecaster.ForecastRange(), now)
+
+	demandReportsMu.Lock()
+	defer demandReportsMu.Unlock()
+	demandReports[tenantID] = report
+	return report
+}
+
+// getDemandReport returns the tenant's last aggregated demand forecast, aggregating it if it hasn't been yet. Supported query params:
+//   - format: json or csv, defaults to json
+//   - group_by: city or country, defaults to city
+//   - country: only returns the demand of the country, by name or code
+func getDemandReport(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	format := req.Query.Get("format")
+	if format == "" {
+		format = formatJSON
+	}
+	if format != formatJSON && format != formatCSV {
+		return resp, router.NewError(http.StatusBadRequest, "Unsupported format: %s", format)
+	}
+	groupBy := req.Query.Get("group_by")
+	if groupBy != "" && groupBy != "city" && groupBy != "country" {
+		return resp, router.NewError(http.StatusBadRequest, "Unsupported group_by: %s. Supported values: city, country", groupBy)
+	}
+
+	demandReportsMu.RLock()
+	report, ok := demandReports[scope.Tenant(req.Tenant)]
+	demandReportsMu.RUnlock()
+	if !ok {
+		// Handlers already hold the mutation lock when they're called within an atomic batch, so the store is read directly rather than
+		// through router.WithMutationLock
+		store := customers.Get(req.Tenant)
+		report = aggregateDemand(req.Tenant, store.List(), store.All())
+	}
+
+	if country := req.Query.Get("country"); country != "" {
+		address, err := models.Address{Country: country}.SetCountryCode()
+		if err != nil {
+			return resp, router.NewError(http.StatusBadRequest, "Unknown country: %s", country)
+		}
+		report = report.InCountry(address.CountryCode)
+	}
+	if groupBy == "country" {
+		report = report.ByCountry()
+	}
+
+	if format == formatJSON {
+		resp.Info["report"] = report
+		return resp, nil
+	}
+	resp.ContentType = "text/csv; charset=UTF-8"
+	resp.Header = http.Header{"Content-Disposition": {`attachment; filename="demand.csv"`}}
+	resp.Stream = func(w io.Writer) error {
+		return writeDemandCSV(w, report.Demand)
+	}
+	return resp, nil
+}
+
+func writeDemandCSV(w io.Writer, demands []demand.Demand) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"country_code", "city", "date", "rainy_customers", "rain_periods", "employees", "conversion_rate", "expected_units"}); err != nil {
+		return err
+	}
+	for _, d := range demands {
+		record := []string{
+			d.CountryCode,
+			d.City,
+			d.Date.Format("2006-01-02"),
+			strconv.Itoa(d.RainyCustomers),
+			strconv.Itoa(d.RainPeriods),
+			strconv.Itoa(d.Employees),
+			strconv.FormatFloat(d.ConversionRate, 'f', -1, 64),
+			strconv.Itoa(d.ExpectedUnits),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
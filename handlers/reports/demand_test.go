@@ -0,0 +1,130 @@
+package reports
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"umbrellacorp/components/demand"
+	"umbrellacorp/components/sales"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDemandReport(t *testing.T) {
+	rain := models.Weather{Date: time.Date(2017, 02, 17, 6, 0, 0, 0, time.UTC), Type: models.WeatherTypeRain}
+	customers = newCustomers(
+		models.Customer{ID: "1", NumEmployees: 200, Address: models.Address{City: "Toronto", CountryCode: "CA"}, WeatherDetails: []models.Weather{rain}},
+		models.Customer{ID: "2", NumEmployees: 40, Address: models.Address{City: "Ottawa", CountryCode: "CA"}, WeatherDetails: []models.Weather{rain}},
+		models.Customer{ID: "3", NumEmployees: 500, Address: models.Address{City: "Chicago", CountryCode: "US"}, WeatherDetails: []models.Weather{rain}},
+	)
+	salesStore, _ = sales.New("")
+	demandReports = map[string]demand.Report{}
+
+	tests := []struct {
+		name     string
+		query    url.Values
+		expUnits []int
+		expCSV   string
+		expError error
+	}{
+		{
+			name:     "by city",
+			query:    url.Values{},
+			expUnits: []int{4, 20, 50},
+		},
+		{
+			name:     "by country",
+			query:    url.Values{"group_by": {"country"}},
+			expUnits: []int{24, 50},
+		},
+		{
+			name:     "country",
+			query:    url.Values{"country": {"United States"}},
+			expUnits: []int{50},
+		},
+		{
+			name:  "csv",
+			query: url.Values{"format": {"csv"}, "group_by": {"country"}, "country": {"CA"}},
+			expCSV: "country_code,city,date,rainy_customers,rain_periods,employees,conversion_rate,expected_units\n" +
+				"CA,,2017-02-17,2,2,240,0.1,24\n",
+		},
+		{
+			name:     "unknown country",
+			query:    url.Values{"country": {"Atlantis"}},
+			expError: router.NewError(http.StatusBadRequest, "Unknown country: Atlantis"),
+		},
+		{
+			name:     "unsupported grouping",
+			query:    url.Values{"group_by": {"continent"}},
+			expError: router.NewError(http.StatusBadRequest, "Unsupported group_by: continent. Supported values: city, country"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := getDemandReport(router.Request{Role: models.RoleAdmin, Query: test.query})
+			assert.Equal(t, test.expError, err)
+			if err != nil {
+				return
+			}
+
+			if test.expCSV != "" {
+				var buf bytes.Buffer
+				assert.NoError(t, resp.Stream(&buf))
+				assert.Equal(t, test.expCSV, buf.String())
+				return
+			}
+			units := []int{}
+			for _, d := range resp.Info["report"].(demand.Report).Demand {
+				units = append(units, d.ExpectedUnits)
+			}
+			assert.Equal(t, test.expUnits, units)
+		})
+	}
+
+	// Deleted customers have no demand
+	_, err := customers.Get(models.DefaultTenant).Delete("3", "admin", timeNow())
+	assert.NoError(t, err)
+	store := customers.Get(models.DefaultTenant)
+	report := aggregateDemand("", store.List(), store.All())
+	assert.Len(t, report.Demand, 2)
+
+	// Reports are served from the last aggregation
+	customers.Get(models.DefaultTenant).Restore(models.Customers{})
+	resp, _ := getDemandReport(router.Request{Role: models.RoleAdmin})
+	assert.Len(t, resp.Info["report"].(demand.Report).Demand, 2)
+	aggregateDemand("", store.List(), store.All())
+	resp, _ = getDemandReport(router.Request{Role: models.RoleAdmin})
+	assert.Empty(t, resp.Info["report"].(demand.Report).Demand)
+}
+
+func TestGetDemandReportInAtomicBatch(t *testing.T) {
+	customers = newCustomers(models.Customer{ID: "1", NumEmployees: 200, Address: models.Address{City: "Toronto", CountryCode: "CA"}})
+	salesStore, _ = sales.New("")
+	demandReports = map[string]demand.Report{}
+	router.RegisterRoutes("reports", router.Routes{
+		{Name: "Get Demand Report", Methods: []string{http.MethodGet}, Path: "/reports/demand", HandlerFunc: getDemandReport, Role: models.RoleViewer},
+	})
+
+	// The batch holds the mutation lock while the report is aggregated on a cache miss
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		body := `{"atomic": true, "operations": [{"method": "GET", "path": "/reports/demand"}]}`
+		router.NewRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(body)))
+		done <- rec
+	}()
+	select {
+	case rec := <-done:
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"status":200`)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Atomic batch deadlocked")
+	}
+}
@@ -0,0 +1,81 @@
+package reports
+
+import (
+	"net/http"
+	"sync"
+	"time"
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/components/sales"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// Config configures the report handlers
+type Config struct {
+	// DemandInterval is how often the demand forecast of every tenant is aggregated
+	DemandInterval time.Duration
+	// DefaultConversionRate is the share of quoted umbrellas expected to be ordered until there are enough decided quotes to compute it
+	DefaultConversionRate float64
+}
+
+// DefaultConfig aggregates demand hourly, and expects a tenth of quoted umbrellas to be ordered
+var DefaultConfig = Config{
+	DemandInterval:        time.Hour,
+	DefaultConversionRate: 0.1,
+}
+
+var (
+	configMu sync.RWMutex
+	config   = DefaultConfig
+)
+
+// Configure sets the configuration of the report handlers. It must be called before Init. Zero values keep their defaults
+func Configure(c Config) {
+	if c.DemandInterval <= 0 {
+		c.DemandInterval = DefaultConfig.DemandInterval
+	}
+	if c.DefaultConversionRate <= 0 {
+		c.DefaultConversionRate = DefaultConfig.DefaultConversionRate
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+}
+
+func currentConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+// Formats of reports
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+// customers are the customers whose forecasts are reported on, tests may override them
+var customers = customerstore.Default
+
+// salesStore holds the quotes and orders that conversion rates are computed from, tests may override it
+var salesStore = sales.Default
+
+// timeNow is used when aggregating reports, tests may override it
+var timeNow = time.Now
+
+// Init registers handlers with the router
+func Init() {
+	routes := router.Routes{
+		{
+			Name:        "Get Demand Report",
+			Methods:     []string{http.MethodGet},
+			Path:        "/reports/demand",
+			HandlerFunc: getDemandReport,
+			Role:        models.RoleAdmin,
+		},
+	}
+	router.RegisterRoutes("reports", routes)
+
+	go runDemandAggregator()
+}
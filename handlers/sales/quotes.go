@@ -0,0 +1,455 @@
+package sales
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"time"
+	"umbrellacorp/components/catalog"
+	"umbrellacorp/components/sales"
+	"umbrellacorp/handlers/scope"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// defaultQuoteValidity is how long quotes are valid for customers without rain in their forecast
+const defaultQuoteValidity = 14 * 24 * time.Hour
+
+// Formats of quote documents
+const (
+	formatHTML = "html"
+	formatJSON = "json"
+)
+
+// documentContentTypes maps each format to the Content-Type of quote documents
+var documentContentTypes = map[string]string{
+	formatHTML: "text/html; charset=UTF-8",
+	formatJSON: "application/json; charset=UTF-8",
+}
+
+// quoteRequest is the body of create quote requests
+type quoteRequest struct {
+	Items []quoteItemRequest `json:"items" api:"required"`
+}
+
+// quoteItemRequest is a product to quote. The quantity defaults to the suggested quantity, see suggestedQuantity
+type quoteItemRequest struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// suggestedQuantity returns the quantity of a product suggested for the customer, an umbrella for each of its employees
+func suggestedQuantity(customer models.Customer) int {
+	if customer.NumEmployees < 1 {
+		return 1
+	}
+	return customer.NumEmployees
+}
+
+// rainWindow returns the period from the first to the last rain forecast for the customer at or after now, or nil if no rain is forecast
+func rainWindow(customer models.Customer, now time.Time) *sales.RainWindow {
+	var window *sales.RainWindow
+	for _, weather := range customer.WeatherDetails {
+		if weather.Type != models.WeatherTypeRain || weather.Date.Before(now) {
+			continue
+		}
+		if window == nil {
+			window = &sales.RainWindow{Start: weather.Date, End: weather.Date}
+		}
+		if weather.Date.Before(window.Start) {
+			window.Start = weather.Date
+		}
+		if weather.Date.After(window.End) {
+			window.End = weather.Date
+		}
+	}
+	return window
+}
+
+// quoteValidUntil returns the end of the validity of a quote issued at now. Quotes are valid until the end of the last day of rain
+// forecast, since that's when the customer needs the umbrellas by, or for defaultQuoteValidity if no rain is forecast
+func quoteValidUntil(window *sales.RainWindow, now time.Time) time.Time {
+	if window == nil {
+		return now.Add(defaultQuoteValidity)
+	}
+	end := window.End.UTC()
+	return time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+}
+
+// createQuote issues a quote to the customer specified by the id path param for the requested catalog products, priced at the tier of
+// each item's quantity. Items without a quantity are quoted at the quantity suggested for the customer
+func createQuote(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	var request quoteRequest
+	if err := req.Parse(&request); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+	if len(request.Items) == 0 {
+		return resp, router.NewError(http.StatusBadRequest, "Quotes require at least one item")
+	}
+
+	customer, ok := scope.Customers(req, customers, territories).Get(req.Vars["id"])
+	if !ok {
+		return resp, customerNotFound(req.Vars["id"])
+	}
+
+	now := timeNow()
+	quote := sales.Quote{
+		Tenant:       scope.Tenant(req.Tenant),
+		CustomerID:   customer.ID,
+		CustomerName: customer.Name,
+		IssuedBy:     req.Actor,
+		LineItems:    []sales.LineItem{},
+		RainWindow:   rainWindow(customer, now),
+		ValidFrom:    now,
+		CreatedAt:    now,
+	}
+	quote.ValidUntil = quoteValidUntil(quote.RainWindow, now)
+
+	productCatalog := catalogs.Get(req.Tenant)
+	for _, item := range request.Items {
+		product, ok := productCatalog.Product(item.ProductID)
+		if !ok {
+			return resp, router.NewError(http.StatusBadRequest, "%s", catalog.ErrNotFound{Kind: "product", ID: item.ProductID}.Error())
+		}
+		if quote.Currency != "" && product.Currency != quote.Currency {
+			return resp, router.NewError(http.StatusBadRequest, "Quotes can't mix currencies: %s and %s", quote.Currency, product.Currency)
+		}
+		quote.Currency = product.Currency
+
+		quantity := item.Quantity
+		if quantity == 0 {
+			quantity = suggestedQuantity(customer)
+		}
+		if quantity < 0 {
+			return resp, router.NewError(http.StatusBadRequest, "Quoted quantities must be positive")
+		}
+
+		unitPrice := product.UnitPriceCents(quantity)
+		quote.LineItems = append(quote.LineItems, sales.LineItem{
+			ProductID:      product.ID,
+			SKU:            product.SKU,
+			Name:           product.Name,
+			Quantity:       quantity,
+			UnitPriceCents: unitPrice,
+			TotalCents:     unitPrice * int64(quantity),
+		})
+		quote.TotalCents += unitPrice * int64(quantity)
+	}
+
+	quote, err := salesStore.CreateQuote(quote)
+	if err != nil {
+		return resp, salesError(err)
+	}
+	resp.Info["quote"] = quote
+	return resp, nil
+}
+
+// getCustomerQuotes returns the quotes issued to the customer specified by the id path param, most recent first
+func getCustomerQuotes(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	if !isAccessible(scope.Customers(req, customers, territories), req.Vars["id"]) {
+		return resp, customerNotFound(req.Vars["id"])
+	}
+	resp.Info["quotes"] = salesStore.Quotes(scope.Tenant(req.Tenant), req.Vars["id"])
+	return resp, nil
+}
+
+// accessibleQuote returns the quote specified by the id path param if its customer is accessible to the caller
+func accessibleQuote(req router.Request) (sales.Quote, error) {
+	quote, ok := salesStore.Quote(scope.Tenant(req.Tenant), req.Vars["id"])
+	if !ok || !isAccessible(scope.Customers(req, customers, territories), quote.CustomerID) {
+		return quote, salesError(sales.ErrNotFound{Kind: "quote", ID: req.Vars["id"]})
+	}
+	return quote, nil
+}
+
+// getQuote returns the quote specified by the id path param
+func getQuote(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	quote, err := accessibleQuote(req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Info["quote"] = quote
+	return resp, nil
+}
+
+// cancelQuote cancels the open quote specified by the id path param, so that it can no longer be ordered
+func cancelQuote(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	quote, err := accessibleQuote(req)
+	if err != nil {
+		return resp, err
+	}
+	quote, err = salesStore.CancelQuote(quote.Tenant, quote.ID)
+	if err != nil {
+		return resp, salesError(err)
+	}
+	resp.Info["quote"] = quote
+	return resp, nil
+}
+
+// quoteDocument is the printable document of a quote, see getQuoteDocument
+var quoteDocument = template.Must(template.New("quote").Funcs(template.FuncMap{
+	"money": formatCents,
+	"date":  func(t time.Time) string { return t.UTC().Format("January 2, 2006") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Quote {{.ID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ccc; padding: 0.5em; text-align: left; }
+td.amount, th.amount { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Quote</h1>
+<p>Quote {{.ID}} for {{.CustomerName}}, issued by {{.IssuedBy}}</p>
+<p>Valid from {{date .ValidFrom}} until {{date .ValidUntil}}</p>
+{{with .RainWindow}}<p>Rain is forecast from {{date .Start}} to {{date .End}}</p>
+{{end}}<table>
+<tr><th>SKU</th><th>Product</th><th class="amount">Quantity</th><th class="amount">Unit price</th><th class="amount">Total</th></tr>
+{{range .LineItems}}<tr><td>{{.SKU}}</td><td>{{.Name}}</td><td class="amount">{{.Quantity}}</td><td class="amount">{{money .UnitPriceCents $.Currency}}</td><td class="amount">{{money .TotalCents $.Currency}}</td></tr>
+{{end}}<tr><th colspan="4">Total</th><th class="amount">{{money .TotalCents .Currency}}</th></tr>
+</table>
+</body>
+</html>
+`))
+
+// formatCents formats an amount in cents of the currency, e.g. 1,234.50 USD
+func formatCents(cents int64, currency string) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	units := fmt.Sprintf("%d", cents/100)
+	for i := len(units) - 3; i > 0; i -= 3 {
+		units = units[:i] + "," + units[i:]
+	}
+	return fmt.Sprintf("%s%s.%02d %s", sign, units, cents%100, currency)
+}
+
+// getQuoteDocument returns the printable document of the quote specified by the id path param. Supported query params:
+//   - format: html or json, defaults to html
+func getQuoteDocument(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	format := req.Query.Get("format")
+	if format == "" {
+		format = formatHTML
+	}
+	if _, ok := documentContentTypes[format]; !ok {
+		return resp, router.NewError(http.StatusBadRequest, "Unsupported format: %s", format)
+	}
+
+	quote, err := accessibleQuote(req)
+	if err != nil {
+		return resp, err
+	}
+
+	resp.ContentType = documentContentTypes[format]
+	resp.Stream = func(w io.Writer) error {
+		if format == formatJSON {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(quote)
+		}
+		return quoteDocument.Execute(w, quote)
+	}
+	return resp, nil
+}
+
+// placeOrder converts the quote specified by the id path param into an order. The order's stock is reserved at the first warehouse that
+// serves the customer and has every item available
+func placeOrder(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	quote, err := accessibleQuote(req)
+	if err != nil {
+		return resp, err
+	}
+	if quote, err = salesStore.CheckOrderable(quote.Tenant, quote.ID, timeNow()); err != nil {
+		return resp, salesError(err)
+	}
+	customer, ok := scope.Customers(req, customers, territories).Get(quote.CustomerID)
+	if !ok {
+		return resp, router.NewError(http.StatusConflict, "The customer of quote %s was deleted", quote.ID)
+	}
+
+	productCatalog := catalogs.Get(req.Tenant)
+	warehouse, ok := orderWarehouse(productCatalog, customer, quote.LineItems)
+	if !ok {
+		return resp, router.NewError(http.StatusConflict, "No warehouse serving %s has enough stock for quote %s", customer.Name, quote.ID)
+	}
+
+	order := sales.Order{Tenant: quote.Tenant, QuoteID: quote.ID, WarehouseID: warehouse.ID, ReservationIDs: []string{}}
+	for _, item := range quote.LineItems {
+		reservation, err := productCatalog.Reserve(catalog.Reservation{
+			ProductID:   item.ProductID,
+			WarehouseID: warehouse.ID,
+			Quantity:    item.Quantity,
+			Reference:   "quote " + quote.ID,
+		})
+		if err != nil {
+			// Stock was reserved concurrently since the warehouse was chosen
+			releaseReservations(productCatalog, order.ReservationIDs)
+			return resp, catalogError(err)
+		}
+		order.ReservationIDs = append(order.ReservationIDs, reservation.ID)
+	}
+
+	order, err = salesStore.PlaceOrder(order, req.Actor, timeNow())
+	if err != nil {
+		releaseReservations(productCatalog, order.ReservationIDs)
+		return resp, salesError(err)
+	}
+	eventBus.PublishTenant(req.Tenant, topicOrderPlaced, order)
+	resp.Info["order"] = order
+	return resp, nil
+}
+
+// orderWarehouse returns the first warehouse that serves the customer and has every item available
+func orderWarehouse(productCatalog *catalog.Catalog, customer models.Customer, items []sales.LineItem) (catalog.Warehouse, bool) {
+	available := map[string]int{}
+	for _, item := range items {
+		stock, err := productCatalog.Stock(item.ProductID)
+		if err != nil {
+			// The product was deleted since it was quoted
+			return catalog.Warehouse{}, false
+		}
+		for _, s := range stock {
+			available[s.WarehouseID+"|"+item.ProductID] = s.Available
+		}
+	}
+
+	for _, warehouse := range productCatalog.Warehouses() {
+		if !warehouse.Serves(customer.Address) {
+			continue
+		}
+		stocked := true
+		for _, item := range items {
+			stocked = stocked && available[warehouse.ID+"|"+item.ProductID] >= item.Quantity
+		}
+		if stocked {
+			return warehouse, true
+		}
+	}
+	return catalog.Warehouse{}, false
+}
+
+// releaseReservations releases the reservations, ignoring those that were already released
+func releaseReservations(productCatalog *catalog.Catalog, ids []string) {
+	for _, id := range ids {
+		productCatalog.Release(id)
+	}
+}
+
+// getOrders returns the orders of the customers accessible to the caller, most recent first. Supported query params:
+//   - customer_id: only returns the orders of the customer
+//   - status: only returns the orders with the status
+func getOrders(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	status := sales.OrderStatus(req.Query.Get("status"))
+	if status != "" {
+		if err := status.Validate(); err != nil {
+			return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+		}
+	}
+
+	accessible := map[string]bool{}
+	for _, customer := range scope.Customers(req, customers, territories).All() {
+		accessible[customer.ID] = true
+	}
+	orders := []sales.Order{}
+	for _, order := range salesStore.Orders(scope.Tenant(req.Tenant), req.Query.Get("customer_id"), status) {
+		// Orders of purged customers remain available to admins
+		if accessible[order.CustomerID] || req.Role.Allows(models.RoleAdmin) {
+			orders = append(orders, order)
+		}
+	}
+	resp.Info["orders"] = orders
+	return resp, nil
+}
+
+// accessibleOrder returns the order specified by the id path param if its customer is accessible to the caller
+func accessibleOrder(req router.Request) (sales.Order, error) {
+	order, ok := salesStore.Order(scope.Tenant(req.Tenant), req.Vars["id"])
+	if !ok || !(req.Role.Allows(models.RoleAdmin) || isAccessible(scope.Customers(req, customers, territories), order.CustomerID)) {
+		return order, salesError(sales.ErrNotFound{Kind: "order", ID: req.Vars["id"]})
+	}
+	return order, nil
+}
+
+// getOrder returns the order specified by the id path param
+func getOrder(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	order, err := accessibleOrder(req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Info["order"] = order
+	return resp, nil
+}
+
+// orderStatusRequest is the body of set order status requests
+type orderStatusRequest struct {
+	Status sales.OrderStatus `json:"status" api:"required"`
+}
+
+// setOrderStatus moves the order specified by the id path param to the requested status. The order's reserved stock is released when it's
+// cancelled, and removed from the stock on hand when it ships
+func setOrderStatus(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	var request orderStatusRequest
+	if err := req.Parse(&request); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+	order, err := accessibleOrder(req)
+	if err != nil {
+		return resp, err
+	}
+
+	order, err = salesStore.SetOrderStatus(order.Tenant, order.ID, request.Status, req.Actor, timeNow())
+	if err != nil {
+		return resp, salesError(err)
+	}
+
+	productCatalog := catalogs.Get(req.Tenant)
+	switch order.Status {
+	case sales.OrderStatusCancelled:
+		releaseReservations(productCatalog, order.ReservationIDs)
+	case sales.OrderStatusShipped:
+		for _, id := range order.ReservationIDs {
+			productCatalog.Fulfill(id)
+		}
+	}
+	eventBus.PublishTenant(req.Tenant, topicOrderUpdated, order)
+	resp.Info["order"] = order
+	return resp, nil
+}
+
+// salesError translates errors returned by the sales store into router errors with the appropriate status
+func salesError(err error) error {
+	switch err.(type) {
+	case sales.ErrNotFound:
+		return router.NewError(http.StatusNotFound, "%s", err.Error())
+	case sales.ErrConflict:
+		return router.NewError(http.StatusConflict, "%s", err.Error())
+	}
+	return router.NewError(http.StatusBadRequest, "%s", err.Error())
+}
+
+// catalogError translates errors returned by the catalog into router errors with the appropriate status
+func catalogError(err error) error {
+	switch err.(type) {
+	case catalog.ErrNotFound:
+		return router.NewError(http.StatusNotFound, "%s", err.Error())
+	case catalog.ErrConflict, catalog.ErrInsufficientStock:
+		return router.NewError(http.StatusConflict, "%s", err.Error())
+	}
+	return router.NewError(http.StatusBadRequest, "%s", err.Error())
+}
@@ -0,0 +1,225 @@
+package sales
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+	"umbrellacorp/components/catalog"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/components/sales"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteValidity(t *testing.T) {
+	now := time.Date(2017, 02, 16, 12, 0, 0, 0, time.UTC)
+	rain := func(day, hour int) models.Weather {
+		return models.Weather{Date: time.Date(2017, 02, day, hour, 0, 0, 0, time.UTC), Type: models.WeatherTypeRain}
+	}
+
+	tests := []struct {
+		name          string
+		weather       []models.Weather
+		expWindow     *sales.RainWindow
+		expValidUntil time.Time
+	}{
+		{
+			name:          "no rain",
+			expValidUntil: now.Add(defaultQuoteValidity),
+		},
+		{
+			name:          "past rain is ignored",
+			weather:       []models.Weather{rain(16, 9)},
+			expValidUntil: now.Add(defaultQuoteValidity),
+		},
+		{
+			name:          "valid through the last day of rain",
+			weather:       []models.Weather{rain(19, 6), rain(16, 9), rain(17, 15), rain(18, 0)},
+			expWindow:     &sales.RainWindow{Start: rain(17, 15).Date, End: rain(19, 6).Date},
+			expValidUntil: time.Date(2017, 02, 20, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			window := rainWindow(models.Customer{WeatherDetails: test.weather}, now)
+			assert.Equal(t, test.expWindow, window)
+			assert.Equal(t, test.expValidUntil, quoteValidUntil(window, now))
+		})
+	}
+}
+
+func TestFormatCents(t *testing.T) {
+	assert.Equal(t, "0.05 USD", formatCents(5, "USD"))
+	assert.Equal(t, "1,234,567.89 CAD", formatCents(123456789, "CAD"))
+	assert.Equal(t, "-150.00 USD", formatCents(-15000, "USD"))
+}
+
+func TestQuotesAndOrders(t *testing.T) {
+	toronto := models.Customer{
+		ID:             "1",
+		Name:           "Toronto Company",
+		NumEmployees:   120,
+		Address:        models.Address{City: "Toronto", Country: "Canada", CountryCode: "CA"},
+		WeatherDetails: []models.Weather{{Date: time.Date(2017, 02, 17, 6, 0, 0, 0, time.UTC), Type: models.WeatherTypeRain}},
+	}
+	customers = newCustomers(toronto)
+	salesStore, _ = sales.New("")
+	catalogs = catalog.NewTenants()
+	eventBus = eventbus.New(10)
+	defer func() { catalogs, eventBus = catalog.Default, eventbus.Default }()
+	subscription, _, _ := eventBus.Subscribe([]string{"order"}, 0, 10)
+
+	productCatalog := catalogs.Get(models.DefaultTenant)
+	product, err := productCatalog.CreateProduct(catalog.Product{SKU: "UMB-1", Name: "Compact", PriceTiers: []catalog.PriceTier{
+		{MinQuantity: 1, UnitPriceCents: 1500},
+		{MinQuantity: 100, UnitPriceCents: 1100},
+	}})
+	assert.NoError(t, err)
+	warehouse, err := productCatalog.CreateWarehouse(catalog.Warehouse{Name: "Toronto", Countries: []string{"CA"}})
+	assert.NoError(t, err)
+
+	admin := router.Request{Actor: "alice", Role: models.RoleAdmin, Vars: map[string]string{"id": toronto.ID}}
+	create := admin
+	create.Info = map[string]interface{}{"items": []map[string]interface{}{{"product_id": "missing"}}}
+	_, err = createQuote(create)
+	assert.Equal(t, router.NewError(http.StatusBadRequest, "Failed to locate product with id: missing"), err)
+
+	// The quantity is suggested from the number of employees, which reaches the bulk price tier
+	create.Info = map[string]interface{}{"items": []map[string]interface{}{{"product_id": product.ID}}}
+	resp, err := createQuote(create)
+	assert.NoError(t, err)
+	quote := resp.Info["quote"].(sales.Quote)
+	assert.Equal(t, []sales.LineItem{{ProductID: product.ID, SKU: "UMB-1", Name: "Compact", Quantity: 120, UnitPriceCents: 1100, TotalCents: 132000}}, quote.LineItems)
+	assert.Equal(t, int64(132000), quote.TotalCents)
+	assert.Equal(t, time.Date(2017, 02, 18, 0, 0, 0, 0, time.UTC), quote.ValidUntil)
+
+	resp, _ = getCustomerQuotes(admin)
+	assert.Equal(t, []sales.Quote{quote}, resp.Info["quotes"])
+
+	document := router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": quote.ID}}
+	resp, err = getQuoteDocument(document)
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, resp.Stream(&buf))
+	assert.Equal(t, "text/html; charset=UTF-8", resp.ContentType)
+	assert.Contains(t, buf.String(), "Toronto Company")
+	assert.Contains(t, buf.String(), "1,320.00 USD")
+	document.Query = url.Values{"format": {"pdf"}}
+	_, err = getQuoteDocument(document)
+	assert.Equal(t, router.NewError(http.StatusBadRequest, "Unsupported format: pdf"), err)
+
+	// Orders need stock at a warehouse serving the customer
+	order := router.Request{Actor: "alice", Role: models.RoleAdmin, Vars: map[string]string{"id": quote.ID}}
+	_, err = placeOrder(order)
+	assert.Equal(t, http.StatusConflict, router.StatusCode(err))
+	_, err = productCatalog.SetStock(product.ID, warehouse.ID, 150)
+	assert.NoError(t, err)
+	resp, err = placeOrder(order)
+	assert.NoError(t, err)
+	placed := resp.Info["order"].(sales.Order)
+	assert.Equal(t, sales.OrderStatusPending, placed.Status)
+	assert.Equal(t, warehouse.ID, placed.WarehouseID)
+	stock, _ := productCatalog.Stock(product.ID)
+	assert.Equal(t, 30, stock[0].Available)
+
+	_, err = placeOrder(order)
+	assert.Equal(t, router.NewError(http.StatusConflict, "Quote %s is ordered", quote.ID), err)
+
+	setStatus := func(status sales.OrderStatus) error {
+		_, err := setOrderStatus(router.Request{Actor: "alice", Role: models.RoleAdmin, Vars: map[string]string{"id": placed.ID}, Info: map[string]interface{}{"status": string(status)}})
+		return err
+	}
+	assert.Equal(t, router.NewError(http.StatusConflict, "Orders that are pending can't be delivered"), setStatus(sales.OrderStatusDelivered))
+	assert.NoError(t, setStatus(sales.OrderStatusConfirmed))
+	assert.NoError(t, setStatus(sales.OrderStatusShipped))
+
+	// Shipped orders take their stock with them
+	stock, _ = productCatalog.Stock(product.ID)
+	assert.Equal(t, catalog.Stock{ProductID: product.ID, WarehouseID: warehouse.ID, OnHand: 30, Available: 30}, stock[0])
+	assert.Empty(t, productCatalog.Reservations())
+
+	resp, err = getOrders(router.Request{Role: models.RoleAdmin, Query: url.Values{"status": {"shipped"}}})
+	assert.NoError(t, err)
+	orders := resp.Info["orders"].([]sales.Order)
+	assert.Len(t, orders, 1)
+	assert.Len(t, orders[0].History, 3)
+	_, err = getOrders(router.Request{Role: models.RoleAdmin, Query: url.Values{"status": {"lost"}}})
+	assert.Equal(t, router.NewError(http.StatusBadRequest, "Unsupported order status: lost"), err)
+
+	subscription.Close()
+	topics := []string{}
+	for event := range subscription.Events() {
+		topics = append(topics, event.Topic)
+	}
+	assert.Equal(t, []string{topicOrderPlaced, topicOrderUpdated, topicOrderUpdated}, topics)
+}
+
+func TestCancelledOrderReleasesStock(t *testing.T) {
+	toronto := models.Customer{ID: "1", Name: "Toronto Company", Address: models.Address{City: "Toronto", Country: "Canada", CountryCode: "CA"}}
+	customers = newCustomers(toronto)
+	salesStore, _ = sales.New("")
+	catalogs = catalog.NewTenants()
+	defer func() { catalogs = catalog.Default }()
+
+	productCatalog := catalogs.Get(models.DefaultTenant)
+	product, _ := productCatalog.CreateProduct(catalog.Product{SKU: "UMB-1", Name: "Compact", PriceTiers: []catalog.PriceTier{{MinQuantity: 1, UnitPriceCents: 1500}}})
+	warehouse, _ := productCatalog.CreateWarehouse(catalog.Warehouse{Name: "Toronto", Countries: []string{"CA"}})
+	_, err := productCatalog.SetStock(product.ID, warehouse.ID, 10)
+	assert.NoError(t, err)
+
+	resp, err := createQuote(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": toronto.ID}, Info: map[string]interface{}{"items": []map[string]interface{}{{"product_id": product.ID, "quantity": 4}}}})
+	assert.NoError(t, err)
+	quote := resp.Info["quote"].(sales.Quote)
+	resp, err = placeOrder(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": quote.ID}})
+	assert.NoError(t, err)
+	placed := resp.Info["order"].(sales.Order)
+	assert.Len(t, productCatalog.Reservations(), 1)
+
+	_, err = setOrderStatus(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": placed.ID}, Info: map[string]interface{}{"status": "cancelled"}})
+	assert.NoError(t, err)
+	stock, _ := productCatalog.Stock(product.ID)
+	assert.Equal(t, 10, stock[0].Available)
+}
+
+func TestQuoteScope(t *testing.T) {
+	toronto := models.Customer{ID: "1", Name: "Toronto Company", Address: models.Address{City: "Toronto", Country: "Canada", CountryCode: "CA"}}
+	chicago := models.Customer{ID: "2", Name: "Chicago Company", Address: models.Address{City: "Chicago", Country: "US", CountryCode: "US"}}
+	customers = newCustomers(toronto, chicago)
+	salesStore, _ = sales.New("")
+	territories = territory.NewTenants()
+	defer func() { territories = territory.Default }()
+	registry := territories.Get(models.DefaultTenant)
+	canada, err := registry.Create(territory.Territory{Name: "Canada", Countries: []string{"CA"}})
+	assert.NoError(t, err)
+	assert.NoError(t, registry.Assign("alice", []string{canada.ID}))
+
+	quote, err := salesStore.CreateQuote(sales.Quote{
+		Tenant:     models.DefaultTenant,
+		CustomerID: chicago.ID,
+		LineItems:  []sales.LineItem{{ProductID: "p1", Quantity: 1}},
+		ValidFrom:  timeNow(),
+		ValidUntil: timeNow().Add(defaultQuoteValidity),
+	})
+	assert.NoError(t, err)
+
+	rep := router.Request{Actor: "alice", Role: models.RoleRep, Vars: map[string]string{"id": quote.ID}}
+	_, err = getQuote(rep)
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate quote with id: %s", quote.ID), err)
+	_, err = placeOrder(rep)
+	assert.Equal(t, http.StatusNotFound, router.StatusCode(err))
+	_, err = createQuote(router.Request{Actor: "alice", Role: models.RoleRep, Vars: map[string]string{"id": chicago.ID}, Info: map[string]interface{}{"items": []map[string]interface{}{{"product_id": "p1"}}}})
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate existing customer with id: 2"), err)
+
+	// Other tenants have their own quotes
+	_, err = getQuote(router.Request{Tenant: "acme", Role: models.RoleAdmin, Vars: map[string]string{"id": quote.ID}})
+	assert.Equal(t, http.StatusNotFound, router.StatusCode(err))
+	resp, err := getQuote(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": quote.ID}})
+	assert.NoError(t, err)
+	assert.Equal(t, quote, resp.Info["quote"])
+}
@@ -0,0 +1,152 @@
+package sales
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"umbrellacorp/components/catalog"
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/components/sales"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// Config configures the sales handlers
+type Config struct {
+	// Path is the file that quotes and orders are persisted to. They're only kept in memory if it's empty
+	Path string
+}
+
+var (
+	configMu sync.RWMutex
+	config   Config
+)
+
+// Configure sets the configuration of the sales handlers. It must be called before Init
+func Configure(c Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+}
+
+// Topics of the events published when orders change
+const (
+	topicOrderPlaced  = "order.placed"
+	topicOrderUpdated = "order.updated"
+)
+
+// salesStore stores the quotes and orders of every tenant, tests may override it
+var salesStore = sales.Default
+
+// customers are the customers that quotes are issued to, tests may override them
+var customers = customerstore.Default
+
+// catalogs prices quotes and holds the stock of orders, tests may override it
+var catalogs = catalog.Default
+
+// territories defines the customers accessible to each sales rep, see scope.Customers
+var territories = territory.Default
+
+// eventBus is the bus that order changes are published to, tests may override it
+var eventBus = eventbus.Default
+
+// timeNow is used to date quotes and orders, tests may override it
+var timeNow = time.Now
+
+// Init loads the configured quotes and orders and registers handlers with the router
+func Init() {
+	configMu.RLock()
+	c := config
+	configMu.RUnlock()
+
+	if c.Path != "" {
+		if err := salesStore.Load(c.Path); err != nil {
+			log.Fatalf("Failed to initialize quotes and orders: %s", err.Error())
+		}
+	}
+
+	routes := router.Routes{
+		{
+			Name:        "Create Quote",
+			Methods:     []string{http.MethodPost},
+			Path:        "/customers/{id}/quotes",
+			HandlerFunc: createQuote,
+			Role:        models.RoleRep,
+		},
+		{
+			Name:        "Get Customer Quotes",
+			Methods:     []string{http.MethodGet},
+			Path:        "/customers/{id}/quotes",
+			HandlerFunc: getCustomerQuotes,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Get Quote",
+			Methods:     []string{http.MethodGet},
+			Path:        "/quotes/{id}",
+			HandlerFunc: getQuote,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Get Quote Document",
+			Methods:     []string{http.MethodGet},
+			Path:        "/quotes/{id}/document",
+			HandlerFunc: getQuoteDocument,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Cancel Quote",
+			Methods:     []string{http.MethodPost},
+			Path:        "/quotes/{id}/cancel",
+			HandlerFunc: cancelQuote,
+			Role:        models.RoleRep,
+		},
+		{
+			Name:        "Place Order",
+			Methods:     []string{http.MethodPost},
+			Path:        "/quotes/{id}/order",
+			HandlerFunc: placeOrder,
+			Role:        models.RoleRep,
+		},
+		{
+			Name:        "Get Orders",
+			Methods:     []string{http.MethodGet},
+			Path:        "/orders",
+			HandlerFunc: getOrders,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Get Order",
+			Methods:     []string{http.MethodGet},
+			Path:        "/orders/{id}",
+			HandlerFunc: getOrder,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Set Order Status",
+			Methods:     []string{http.MethodPut},
+			Path:        "/orders/{id}/status",
+			HandlerFunc: setOrderStatus,
+			Role:        models.RoleRep,
+		},
+	}
+	router.RegisterRoutes("sales", routes)
+}
+
+// isAccessible returns true if the view contains the customer with the specified id, including deleted customers
+func isAccessible(view customerstore.View, id string) bool {
+	for _, customer := range view.All() {
+		if customer.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// customerNotFound returns the error of customers that don't exist or aren't accessible to the caller
+func customerNotFound(id string) error {
+	return router.NewError(http.StatusNotFound, "%s", customerstore.ErrNotFound(id).Error())
+}
@@ -0,0 +1,24 @@
+package sales
+
+import (
+	"os"
+	"testing"
+	"time"
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/models"
+)
+
+func TestMain(t *testing.M) {
+	timeNow = func() time.Time { return time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC) }
+	os.Exit(t.Run())
+}
+
+// newCustomers returns customer stores whose default tenant's store contains the specified customers
+func newCustomers(existingCustomers ...models.Customer) *customerstore.Tenants {
+	return customerstore.NewTenants(func(tenantID string) *customerstore.Store {
+		if tenantID == models.DefaultTenant {
+			return customerstore.New(existingCustomers...)
+		}
+		return customerstore.New()
+	})
+}
@@ -0,0 +1,28 @@
+// Package scope resolves the data that the callers of handlers can access, so that every handler package scopes requests the same way
+package scope
+
+import (
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// Customers returns the view of the tenant's customers accessible to the caller. Admins can access every customer of the tenant, other
+// callers can only access the customers within their territories. The stores and territories are passed in so that tests can override
+// the handlers' own
+func Customers(req router.Request, stores *customerstore.Tenants, territories *territory.Tenants) customerstore.View {
+	store := stores.Get(req.Tenant)
+	if req.Role.Allows(models.RoleAdmin) {
+		return store.In(nil)
+	}
+	return store.In(territories.Get(req.Tenant).Scope(req.Actor))
+}
+
+// Tenant returns the id of the tenant. The empty tenant is the default tenant
+func Tenant(tenantID string) string {
+	if tenantID == "" {
+		return models.DefaultTenant
+	}
+	return tenantID
+}
@@ -0,0 +1,36 @@
+package scope
+
+import (
+	"testing"
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCustomers(t *testing.T) {
+	toronto := models.Customer{ID: "1", Name: "Toronto Company", Address: models.Address{City: "Toronto", CountryCode: "CA"}}
+	chicago := models.Customer{ID: "2", Name: "Chicago Company", Address: models.Address{City: "Chicago", CountryCode: "US"}}
+	stores := customerstore.NewTenants(func(tenantID string) *customerstore.Store {
+		if tenantID == models.DefaultTenant {
+			return customerstore.New(toronto, chicago)
+		}
+		return customerstore.New()
+	})
+	territories := territory.NewTenants()
+	canada, err := territories.Get(models.DefaultTenant).Create(territory.Territory{Name: "Canada", Countries: []string{"CA"}})
+	assert.NoError(t, err)
+	assert.NoError(t, territories.Get(models.DefaultTenant).Assign("rep", []string{canada.ID}))
+
+	assert.Len(t, Customers(router.Request{Role: models.RoleAdmin}, stores, territories).List(), 2)
+	assert.Equal(t, models.Customers{toronto}, Customers(router.Request{Role: models.RoleRep, Actor: "rep"}, stores, territories).List())
+	assert.Empty(t, Customers(router.Request{Role: models.RoleRep, Actor: "other"}, stores, territories).List())
+	assert.Empty(t, Customers(router.Request{Role: models.RoleAdmin, Tenant: "acme"}, stores, territories).List())
+}
+
+func TestTenant(t *testing.T) {
+	assert.Equal(t, models.DefaultTenant, Tenant(""))
+	assert.Equal(t, "acme", Tenant("acme"))
+}
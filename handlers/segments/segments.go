@@ -0,0 +1,209 @@
+package segments
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"umbrellacorp/components/customerlist"
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/components/segment"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/handlers/scope"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// Config configures the segment handlers
+type Config struct {
+	// Path is the file that saved segments are persisted to. They're only kept in memory if it's empty
+	Path string
+}
+
+var (
+	configMu sync.RWMutex
+	config   Config
+)
+
+// Configure sets the configuration of the segment handlers. It must be called before Init
+func Configure(c Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+}
+
+// segments stores the saved segments of every tenant. It's kept in memory until Init loads the configured file, tests may override it
+var segments, _ = segment.New("")
+
+// customers are the customers that segments are matched against, tests may override them
+var customers = customerstore.Default
+
+// territories defines the customers accessible to each sales rep, see scope.Customers
+var territories = territory.Default
+
+// timeNow is used to date segments and when matching customers, tests may override it
+var timeNow = time.Now
+
+// Init loads the configured segments and registers handlers with the router
+func Init() {
+	configMu.RLock()
+	c := config
+	configMu.RUnlock()
+
+	var err error
+	segments, err = segment.New(c.Path)
+	if err != nil {
+		log.Fatalf("Failed to initialize segments: %s", err.Error())
+	}
+
+	routes := router.Routes{
+		{
+			Name:        "Create Segment",
+			Methods:     []string{http.MethodPost},
+			Path:        "/segments",
+			HandlerFunc: createSegment,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Segments",
+			Methods:     []string{http.MethodGet},
+			Path:        "/segments",
+			HandlerFunc: getSegments,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Get Segment",
+			Methods:     []string{http.MethodGet},
+			Path:        "/segments/{id}",
+			HandlerFunc: getSegment,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Update Segment",
+			Methods:     []string{http.MethodPut},
+			Path:        "/segments/{id}",
+			HandlerFunc: updateSegment,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Delete Segment",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/segments/{id}",
+			HandlerFunc: deleteSegment,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Segment Customers",
+			Methods:     []string{http.MethodGet},
+			Path:        "/segments/{id}/customers",
+			HandlerFunc: getSegmentCustomers,
+			Role:        models.RoleViewer,
+		},
+	}
+	router.RegisterRoutes("segments", routes)
+}
+
+// createSegment saves a named segment expression
+func createSegment(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	var saved segment.Segment
+	if err := req.Parse(&saved); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+	saved.Tenant = scope.Tenant(req.Tenant)
+	saved.CreatedBy, saved.CreatedAt = req.Actor, timeNow()
+
+	saved, err := segments.Create(saved)
+	if err != nil {
+		return resp, segmentError(err)
+	}
+	resp.Info["segment"] = saved
+	return resp, nil
+}
+
+// updateSegment replaces the name, description and expression of the segment specified by the id path param
+func updateSegment(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	var saved segment.Segment
+	if err := req.Parse(&saved); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+	saved.ID, saved.Tenant, saved.UpdatedAt = req.Vars["id"], scope.Tenant(req.Tenant), timeNow()
+
+	saved, err := segments.Update(saved)
+	if err != nil {
+		return resp, segmentError(err)
+	}
+	resp.Info["segment"] = saved
+	return resp, nil
+}
+
+// deleteSegment deletes the segment specified by the id path param
+func deleteSegment(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	if err := segments.Delete(scope.Tenant(req.Tenant), req.Vars["id"]); err != nil {
+		return resp, segmentError(err)
+	}
+	resp.Info["id"] = req.Vars["id"]
+	return resp, nil
+}
+
+// getSegments returns the tenant's segments, in the order they were created
+func getSegments(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	resp.Info["segments"] = segments.Segments(scope.Tenant(req.Tenant))
+	return resp, nil
+}
+
+// getSegment returns the segment specified by the id path param
+func getSegment(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	saved, ok := segments.Segment(scope.Tenant(req.Tenant), req.Vars["id"])
+	if !ok {
+		return resp, segmentError(segment.ErrNotFound(req.Vars["id"]))
+	}
+	resp.Info["segment"] = saved
+	return resp, nil
+}
+
+// getSegmentCustomers returns a page of the customers accessible to the caller that are members of the segment specified by the id path
+// param. The filtering, sorting and pagination query params of customer listings are supported, see customerlist.ParseOptions
+func getSegmentCustomers(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	saved, ok := segments.Segment(scope.Tenant(req.Tenant), req.Vars["id"])
+	if !ok {
+		return resp, segmentError(segment.ErrNotFound(req.Vars["id"]))
+	}
+	expression, err := segment.Parse(saved.Expression)
+	if err != nil {
+		return resp, segmentError(err)
+	}
+
+	opts, err := customerlist.ParseOptions(req.Query)
+	if err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+	opts.Expressions = append(opts.Expressions, expression)
+
+	view := scope.Customers(req, customers, territories)
+	existingCustomers := view.List()
+	if opts.IncludeDeleted {
+		existingCustomers = view.All()
+	}
+	page, nextCursor, total := customerlist.List(existingCustomers, opts, timeNow())
+	resp.Info["customers"] = page
+	resp.Info["next_cursor"] = nextCursor
+	resp.Info["total_count"] = total
+	return resp, nil
+}
+
+// segmentError translates errors returned by the segment store into router errors with the appropriate status
+func segmentError(err error) error {
+	switch err.(type) {
+	case segment.ErrNotFound:
+		return router.NewError(http.StatusNotFound, "%s", err.Error())
+	case segment.ErrConflict:
+		return router.NewError(http.StatusConflict, "%s", err.Error())
+	}
+	return router.NewError(http.StatusBadRequest, "%s", err.Error())
+}
@@ -0,0 +1,99 @@
+package segments
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+	"umbrellacorp/components/customerstore"
+	"umbrellacorp/components/segment"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(t *testing.M) {
+	timeNow = func() time.Time { return time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC) }
+	os.Exit(t.Run())
+}
+
+// newCustomers returns customer stores whose default tenant's store contains the specified customers
+func newCustomers(existingCustomers ...models.Customer) *customerstore.Tenants {
+	return customerstore.NewTenants(func(tenantID string) *customerstore.Store {
+		if tenantID == models.DefaultTenant {
+			return customerstore.New(existingCustomers...)
+		}
+		return customerstore.New()
+	})
+}
+
+func TestSegments(t *testing.T) {
+	rain := []models.Weather{{Date: time.Date(2017, 02, 17, 6, 0, 0, 0, time.UTC), Type: models.WeatherTypeRain}}
+	seattle := models.Customer{ID: "1", Name: "Seattle Company", NumEmployees: 80, Address: models.Address{City: "Seattle", CountryCode: "US"}, WeatherDetails: rain}
+	portland := models.Customer{ID: "2", Name: "Portland Company", NumEmployees: 20, Address: models.Address{City: "Portland", CountryCode: "US"}, WeatherDetails: rain}
+	toronto := models.Customer{ID: "3", Name: "Toronto Company", NumEmployees: 120, Address: models.Address{City: "Toronto", CountryCode: "CA"}}
+	customers = newCustomers(seattle, portland, toronto)
+	segments, _ = segment.New("")
+
+	admin := router.Request{Actor: "marketing", Role: models.RoleAdmin, Info: map[string]interface{}{
+		"name":       "Large rainy",
+		"expression": "num_employees >= 50 and has_rain_within(",
+	}}
+	_, err := createSegment(admin)
+	assert.Equal(t, http.StatusBadRequest, router.StatusCode(err))
+	assert.Contains(t, err.Error(), "Parse error at column")
+
+	admin.Info["expression"] = "num_employees >= 50 and has_rain_within(48h)"
+	resp, err := createSegment(admin)
+	assert.NoError(t, err)
+	created := resp.Info["segment"].(segment.Segment)
+	assert.Equal(t, "marketing", created.CreatedBy)
+	_, err = createSegment(admin)
+	assert.Equal(t, http.StatusConflict, router.StatusCode(err))
+
+	members := router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": created.ID}, Query: url.Values{}}
+	resp, err = getSegmentCustomers(members)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Customers{seattle}, resp.Info["customers"])
+	assert.Equal(t, 1, resp.Info["total_count"])
+
+	update := router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": created.ID}, Info: map[string]interface{}{
+		"name":       "Large",
+		"expression": "num_employees >= 50",
+	}}
+	_, err = updateSegment(update)
+	assert.NoError(t, err)
+	members.Query = url.Values{"where": []string{`country = "CA"`}}
+	resp, err = getSegmentCustomers(members)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Customers{toronto}, resp.Info["customers"])
+
+	_, err = deleteSegment(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": created.ID}})
+	assert.NoError(t, err)
+	_, err = getSegmentCustomers(members)
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate segment with id: %s", created.ID), err)
+}
+
+func TestSegmentRainWithClock(t *testing.T) {
+	// The forecasts stored for customers only cover weatherforecaster.ForecastRange, so rain functions have to match regardless of the
+	// current time
+	timeNow = time.Now
+	defer func() { timeNow = func() time.Time { return time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC) } }()
+	rainy := models.Customer{ID: "1", Name: "Rainy Company", Address: models.Address{City: "Toronto", CountryCode: "CA"}, WeatherDetails: []models.Weather{
+		{Date: weatherforecaster.ForecastRange().Start.Add(6 * time.Hour), Type: models.WeatherTypeRain},
+	}}
+	customers = newCustomers(rainy, models.Customer{ID: "2", Name: "Dry Company", Address: models.Address{City: "Toronto", CountryCode: "CA"}})
+	segments, _ = segment.New("")
+
+	resp, err := createSegment(router.Request{Role: models.RoleAdmin, Info: map[string]interface{}{
+		"name":       "Rainy",
+		"expression": "has_rain_within(48h) and rain_hours_next(48h) >= 3",
+	}})
+	assert.NoError(t, err)
+	resp, err = getSegmentCustomers(router.Request{Role: models.RoleAdmin, Vars: map[string]string{"id": resp.Info["segment"].(segment.Segment).ID}, Query: url.Values{}})
+	assert.NoError(t, err)
+	assert.Equal(t, models.Customers{rainy}, resp.Info["customers"])
+}
@@ -0,0 +1,71 @@
+package tenants
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"umbrellacorp/components/tenant"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// Config configures tenants
+type Config struct {
+	// Path is the json file listing the tenants and their configuration. Only the default tenant exists if it's empty
+	Path string
+	// Domain is the domain whose subdomains identify tenants. Tenants are only resolved from the X-Tenant header and credentials if it's
+	// empty
+	Domain string
+}
+
+var (
+	configMu sync.RWMutex
+	config   Config
+)
+
+// Configure sets the configuration of tenants. It must be called before Init
+func Configure(c Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+}
+
+// registry contains the tenants that requests can be made to
+var registry = tenant.Default
+
+// Init loads the configured tenants, enables the resolution of each request's tenant and registers handlers with the router
+func Init() {
+	configMu.RLock()
+	c := config
+	configMu.RUnlock()
+
+	if c.Path != "" {
+		if err := registry.Load(c.Path); err != nil {
+			log.Fatalf("Failed to initialize tenants: %s", err.Error())
+		}
+	}
+	router.SetTenants(registry.Exists, c.Domain)
+
+	routes := router.Routes{
+		{
+			Name:        "Get Tenant",
+			Methods:     []string{http.MethodGet},
+			Path:        "/tenant",
+			HandlerFunc: getTenant,
+			Role:        models.RoleViewer,
+		},
+	}
+	router.RegisterRoutes("tenants", routes)
+}
+
+// getTenant returns the configuration of the request's tenant. The forecast provider's API key isn't returned
+func getTenant(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	t, ok := registry.Get(req.Tenant)
+	if !ok {
+		return resp, router.NewError(http.StatusNotFound, "Unknown tenant: %s", req.Tenant)
+	}
+	t.Forecaster.APIKey = ""
+	resp.Info["tenant"] = t
+	return resp, nil
+}
@@ -0,0 +1,35 @@
+package tenants
+
+import (
+	"net/http"
+	"testing"
+	"umbrellacorp/components/tenant"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTenant(t *testing.T) {
+	acme := tenant.Tenant{
+		ID:         "acme",
+		Name:       "Acme",
+		Forecaster: weatherforecaster.ProviderConfig{Provider: weatherforecaster.ProviderOpenWeatherMap, APIKey: "secret"},
+		AlertRules: tenant.AlertRules{WithinHours: 24},
+	}
+	registry = tenant.New(acme)
+
+	resp, err := getTenant(router.Request{Tenant: "acme"})
+	assert.NoError(t, err)
+	redacted := acme
+	redacted.Forecaster.APIKey = ""
+	assert.Equal(t, redacted, resp.Info["tenant"])
+
+	resp, err = getTenant(router.Request{Tenant: models.DefaultTenant})
+	assert.NoError(t, err)
+	assert.Equal(t, models.DefaultTenant, resp.Info["tenant"].(tenant.Tenant).ID)
+
+	_, err = getTenant(router.Request{Tenant: "globex"})
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Unknown tenant: globex"), err)
+}
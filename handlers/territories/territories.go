@@ -0,0 +1,176 @@
+package territories
+
+import (
+	"net/http"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// registries store each tenant's territories, which scope the customers accessible to sales reps
+var registries = territory.Default
+
+// Init registers handlers with the router
+func Init() {
+	routes := router.Routes{
+		{
+			Name:        "Get Territories",
+			Methods:     []string{http.MethodGet},
+			Path:        "/territories",
+			HandlerFunc: getTerritories,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Create Territory",
+			Methods:     []string{http.MethodPost},
+			Path:        "/territories",
+			HandlerFunc: createTerritory,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Territory Assignments",
+			Methods:     []string{http.MethodGet},
+			Path:        "/territories/assignments",
+			HandlerFunc: getAssignments,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Assign Territories",
+			Methods:     []string{http.MethodPut},
+			Path:        "/territories/assignments/{rep}",
+			HandlerFunc: assignTerritories,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Territory",
+			Methods:     []string{http.MethodGet},
+			Path:        "/territories/{id}",
+			HandlerFunc: getTerritory,
+			Role:        models.RoleViewer,
+		},
+		{
+			Name:        "Update Territory",
+			Methods:     []string{http.MethodPut},
+			Path:        "/territories/{id}",
+			HandlerFunc: updateTerritory,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Delete Territory",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/territories/{id}",
+			HandlerFunc: deleteTerritory,
+			Role:        models.RoleAdmin,
+		},
+	}
+	router.RegisterRoutes("territories", routes)
+}
+
+// assignmentRequest is the body of assignment requests
+type assignmentRequest struct {
+	TerritoryIDs []string `json:"territory_ids"`
+}
+
+// getTerritories returns every territory. Supported query params:
+//   - rep: only return the territories assigned to the specified rep
+func getTerritories(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	registry := registries.Get(req.Tenant)
+	if rep := req.Query.Get("rep"); rep != "" {
+		resp.Info["territories"] = registry.Territories(rep)
+		return resp, nil
+	}
+	resp.Info["territories"] = registry.List()
+	return resp, nil
+}
+
+// getTerritory returns the territory specified by the id path param
+func getTerritory(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	registry := registries.Get(req.Tenant)
+	t, ok := registry.Get(req.Vars["id"])
+	if !ok {
+		return resp, registryError(territory.ErrNotFound(req.Vars["id"]))
+	}
+	resp.Info["territory"] = t
+	return resp, nil
+}
+
+// createTerritory creates a territory from its countries, cities and polygons. Countries may be specified by name or ISO-3166 code
+func createTerritory(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	registry := registries.Get(req.Tenant)
+	var t territory.Territory
+	if err := req.Parse(&t); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+
+	t, err := registry.Create(t)
+	if err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+	resp.Info["territory"] = t
+	return resp, nil
+}
+
+// updateTerritory replaces the territory specified by the id path param. Reps assigned to the territory keep it
+func updateTerritory(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	registry := registries.Get(req.Tenant)
+	var t territory.Territory
+	if err := req.Parse(&t); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+
+	t.ID = req.Vars["id"]
+	t, err := registry.Update(t)
+	if err != nil {
+		return resp, registryError(err)
+	}
+	resp.Info["territory"] = t
+	return resp, nil
+}
+
+// deleteTerritory deletes the territory specified by the id path param, and unassigns it from every rep
+func deleteTerritory(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	registry := registries.Get(req.Tenant)
+	if err := registry.Delete(req.Vars["id"]); err != nil {
+		return resp, registryError(err)
+	}
+	return resp, nil
+}
+
+// getAssignments returns the ids of the territories assigned to each rep
+func getAssignments(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	registry := registries.Get(req.Tenant)
+	resp.Info["assignments"] = registry.Assignments()
+	return resp, nil
+}
+
+// assignTerritories replaces the territories assigned to the rep specified by the rep path param, which is the subject of the rep's API
+// key or JWT. Reps can only access the customers within their territories
+func assignTerritories(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	registry := registries.Get(req.Tenant)
+	var assignmentReq assignmentRequest
+	if err := req.Parse(&assignmentReq); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+
+	if err := registry.Assign(req.Vars["rep"], assignmentReq.TerritoryIDs); err != nil {
+		return resp, registryError(err)
+	}
+	resp.Info["territories"] = registry.Territories(req.Vars["rep"])
+	return resp, nil
+}
+
+// registryError translates errors returned by the territory registry into router errors with the appropriate status. Other errors are
+// validation failures
+func registryError(err error) error {
+	if _, ok := err.(territory.ErrNotFound); ok {
+		return router.NewError(http.StatusNotFound, "%s", err.Error())
+	}
+	return router.NewError(http.StatusBadRequest, "%s", err.Error())
+}
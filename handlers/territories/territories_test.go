@@ -0,0 +1,81 @@
+package territories
+
+import (
+	"net/http"
+	"testing"
+	"umbrellacorp/components/territory"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateTerritory(t *testing.T) {
+	registries = territory.NewTenants()
+
+	tests := []struct {
+		name         string
+		info         map[string]interface{}
+		expCountries []string
+		expError     error
+	}{
+		{
+			name:         "create",
+			info:         map[string]interface{}{"name": "North America", "countries": []string{"Canada", "US"}},
+			expCountries: []string{"CA", "US"},
+		},
+		{
+			name:     "missing name",
+			info:     map[string]interface{}{"countries": []string{"CA"}},
+			expError: router.NewError(http.StatusBadRequest, "Request validation failed: name required"),
+		},
+		{
+			name:     "unknown country",
+			info:     map[string]interface{}{"name": "Atlantis", "countries": []string{"Atlantis"}},
+			expError: router.NewError(http.StatusBadRequest, "Unknown country: Atlantis"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := createTerritory(router.Request{Info: test.info})
+			assert.Equal(t, test.expError, err)
+			if err != nil {
+				return
+			}
+
+			created := resp.Info["territory"].(territory.Territory)
+			assert.Equal(t, test.expCountries, created.Countries)
+			resp, err = getTerritory(router.Request{Vars: map[string]string{"id": created.ID}})
+			assert.NoError(t, err)
+			assert.Equal(t, created, resp.Info["territory"])
+		})
+	}
+}
+
+func TestAssignTerritories(t *testing.T) {
+	registries = territory.NewTenants()
+	canada, err := registries.Get(models.DefaultTenant).Create(territory.Territory{Name: "Canada", Countries: []string{"CA"}})
+	assert.NoError(t, err)
+
+	_, err = assignTerritories(router.Request{Vars: map[string]string{"rep": "alice"}, Info: map[string]interface{}{"territory_ids": []string{"missing"}}})
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate territory with id: missing"), err)
+
+	resp, err := assignTerritories(router.Request{Vars: map[string]string{"rep": "alice"}, Info: map[string]interface{}{"territory_ids": []string{canada.ID}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []territory.Territory{canada}, resp.Info["territories"])
+
+	resp, _ = getAssignments(router.Request{})
+	assert.Equal(t, map[string][]string{"alice": {canada.ID}}, resp.Info["assignments"])
+
+	// Other tenants have their own territories
+	_, err = getTerritory(router.Request{Tenant: "globex", Vars: map[string]string{"id": canada.ID}})
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate territory with id: %s", canada.ID), err)
+	resp, _ = getAssignments(router.Request{Tenant: "globex"})
+	assert.Equal(t, map[string][]string{}, resp.Info["assignments"])
+
+	_, err = deleteTerritory(router.Request{Vars: map[string]string{"id": canada.ID}})
+	assert.NoError(t, err)
+	resp, _ = getTerritories(router.Request{Query: map[string][]string{"rep": {"alice"}}})
+	assert.Equal(t, []territory.Territory{}, resp.Info["territories"])
+}
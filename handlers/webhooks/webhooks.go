@@ -0,0 +1,287 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/components/webhook"
+	"umbrellacorp/models"
+	"umbrellacorp/router"
+)
+
+// eventTypes are the event bus topics that can be subscribed to
+var eventTypes = []string{"customer.created", "customer.updated", "customer.deleted", "forecast.changed", "rain.alert", "stock.low", "order.placed", "order.updated", "notification.message"}
+
+// busBufferSize is the number of events that may be pending enqueueing before the subscription to the bus is dropped and resumed from
+// the bus's replay buffer
+const busBufferSize = 1000
+
+var (
+	configMu sync.RWMutex
+	config   = webhook.DefaultConfig
+)
+
+// Configure sets the configuration of the webhook queue. It must be called before Init. Zero values keep their defaults
+func Configure(c webhook.Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+}
+
+// manager delivers queued events to webhook subscriptions
+var manager *webhook.Manager
+
+// Init registers handlers with the router, and starts delivering events to webhook subscriptions
+func Init() {
+	configMu.RLock()
+	var err error
+	manager, err = webhook.New(config)
+	configMu.RUnlock()
+	if err != nil {
+		log.Fatalf("Failed to initialize webhooks: %s", err.Error())
+	}
+
+	routes := router.Routes{
+		{
+			Name:        "Get Webhooks",
+			Methods:     []string{http.MethodGet},
+			Path:        "/webhooks",
+			HandlerFunc: getWebhooks,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Create Webhook",
+			Methods:     []string{http.MethodPost},
+			Path:        "/webhooks",
+			HandlerFunc: createWebhook,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Webhook Deliveries",
+			Methods:     []string{http.MethodGet},
+			Path:        "/webhooks/deliveries",
+			HandlerFunc: getDeliveries,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Redeliver Webhook",
+			Methods:     []string{http.MethodPost},
+			Path:        "/webhooks/deliveries/{id}/redeliver",
+			HandlerFunc: redeliver,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Get Webhook",
+			Methods:     []string{http.MethodGet},
+			Path:        "/webhooks/{id}",
+			HandlerFunc: getWebhook,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Update Webhook",
+			Methods:     []string{http.MethodPut},
+			Path:        "/webhooks/{id}",
+			HandlerFunc: updateWebhook,
+			Role:        models.RoleAdmin,
+		},
+		{
+			Name:        "Delete Webhook",
+			Methods:     []string{http.MethodDelete},
+			Path:        "/webhooks/{id}",
+			HandlerFunc: deleteWebhook,
+			Role:        models.RoleAdmin,
+		},
+	}
+	router.RegisterRoutes("webhooks", routes)
+
+	go forwardEvents(eventbus.Default)
+	go manager.Run(nil)
+}
+
+// forwardEvents enqueues every event published to the bus for delivery. It never returns, so it should be run in its own goroutine
+func forwardEvents(bus *eventbus.Bus) {
+	var lastID uint64
+	for {
+		subscription, replay, complete := bus.Subscribe(eventTypes, lastID, busBufferSize)
+		if !complete {
+			log.Printf("Events published after event %d were evicted from the replay buffer before being queued for webhooks", lastID)
+		}
+		for _, event := range replay {
+			enqueue(event)
+			lastID = event.ID
+		}
+		for event := range subscription.Events() {
+			enqueue(event)
+			lastID = event.ID
+		}
+		// The bus dropped the subscription because enqueueing fell behind, resume from the last event
+	}
+}
+
+// enqueue queues the event for delivery to the matching subscriptions of the event's tenant. The payload is the event's json
+// representation
+func enqueue(event eventbus.Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal event %d for webhooks: %s", event.ID, err.Error())
+		return
+	}
+	if _, err = manager.Enqueue(event.Tenant, event.Topic, payload); err != nil {
+		log.Printf("Failed to queue event %d for webhooks: %s", event.ID, err.Error())
+	}
+}
+
+// webhookRequest is the body of create and update requests
+type webhookRequest struct {
+	URL    string   `json:"url" api:"required"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+	Active *bool    `json:"active"`
+}
+
+// subscription validates the request and converts it to a subscription. Subscriptions are active unless specified otherwise
+func (req webhookRequest) subscription() (webhook.Subscription, error) {
+	sub := webhook.Subscription{URL: req.URL, Events: []string{}, Secret: req.Secret, Active: req.Active == nil || *req.Active}
+	for _, event := range req.Events {
+		if !isEventType(event) {
+			return sub, router.NewError(http.StatusBadRequest, "Unsupported event type: %s. Supported types: %s", event, strings.Join(eventTypes, ", "))
+		}
+		sub.Events = append(sub.Events, event)
+	}
+	if err := sub.Validate(); err != nil {
+		return sub, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+	return sub, nil
+}
+
+// isEventType returns true if the event is a supported event type, or a prefix of one such as customer
+func isEventType(event string) bool {
+	for _, eventType := range eventTypes {
+		if eventType == event || strings.HasPrefix(eventType, event+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// redact hides the subscription's secret, which is only returned when the subscription is created
+func redact(sub webhook.Subscription) webhook.Subscription {
+	sub.Secret = ""
+	return sub
+}
+
+// getWebhooks returns every webhook subscription of the tenant
+func getWebhooks(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	subs := []webhook.Subscription{}
+	for _, sub := range manager.Subscriptions(req.Tenant) {
+		subs = append(subs, redact(sub))
+	}
+	resp.Info["webhooks"] = subs
+	return resp, nil
+}
+
+// getWebhook returns the webhook subscription specified by the id path param
+func getWebhook(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	sub, ok := manager.Subscription(req.Tenant, req.Vars["id"])
+	if !ok {
+		return resp, router.NewError(http.StatusNotFound, "%s", webhook.ErrNotFound{Entity: "webhook subscription", ID: req.Vars["id"]}.Error())
+	}
+	resp.Info["webhook"] = redact(sub)
+	return resp, nil
+}
+
+// createWebhook creates a webhook subscription to the tenant's events. The response contains the secret used to sign payloads, generated
+// unless specified
+func createWebhook(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	var webhookReq webhookRequest
+	if err := req.Parse(&webhookReq); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+	sub, err := webhookReq.subscription()
+	if err != nil {
+		return resp, err
+	}
+
+	sub.Tenant = req.Tenant
+	sub, err = manager.CreateSubscription(sub)
+	if err != nil {
+		return resp, err
+	}
+	resp.Info["webhook"] = sub
+	return resp, nil
+}
+
+// updateWebhook replaces the webhook subscription specified by the id path param. The secret is kept unless specified
+func updateWebhook(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	var webhookReq webhookRequest
+	if err := req.Parse(&webhookReq); err != nil {
+		return resp, router.NewError(http.StatusBadRequest, "%s", err.Error())
+	}
+	sub, err := webhookReq.subscription()
+	if err != nil {
+		return resp, err
+	}
+
+	sub.ID, sub.Tenant = req.Vars["id"], req.Tenant
+	sub, err = manager.UpdateSubscription(sub)
+	if err != nil {
+		return resp, managerError(err)
+	}
+	resp.Info["webhook"] = redact(sub)
+	return resp, nil
+}
+
+// deleteWebhook deletes the webhook subscription specified by the id path param
+func deleteWebhook(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	if err := manager.DeleteSubscription(req.Tenant, req.Vars["id"]); err != nil {
+		return resp, managerError(err)
+	}
+	return resp, nil
+}
+
+// getDeliveries returns the tenant's delivery log, oldest first. Supported query params:
+//   - webhook_id: only return deliveries to the specified subscription
+//   - status: pending, delivered or dead
+func getDeliveries(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+
+	status := webhook.DeliveryStatus(req.Query.Get("status"))
+	switch status {
+	case "", webhook.StatusPending, webhook.StatusDelivered, webhook.StatusDead:
+	default:
+		return resp, router.NewError(http.StatusBadRequest, "status must be %s, %s or %s", webhook.StatusPending, webhook.StatusDelivered, webhook.StatusDead)
+	}
+
+	resp.Info["deliveries"] = manager.Deliveries(req.Tenant, req.Query.Get("webhook_id"), status)
+	return resp, nil
+}
+
+// redeliver queues the dead lettered delivery specified by the id path param to be attempted again
+func redeliver(req router.Request) (router.Response, error) {
+	resp := router.Response{Info: map[string]interface{}{}}
+	delivery, err := manager.Redeliver(req.Tenant, req.Vars["id"])
+	if err != nil {
+		if _, ok := err.(webhook.ErrNotFound); ok {
+			return resp, managerError(err)
+		}
+		return resp, router.NewError(http.StatusConflict, "%s", err.Error())
+	}
+	resp.Info["delivery"] = delivery
+	return resp, nil
+}
+
+// managerError translates errors returned by the webhook manager into router errors with the appropriate status
+func managerError(err error) error {
+	if _, ok := err.(webhook.ErrNotFound); ok {
+		return router.NewError(http.StatusNotFound, "%s", err.Error())
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+	"umbrellacorp/components/eventbus"
+	"umbrellacorp/components/webhook"
+	"umbrellacorp/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(t *testing.M) {
+	// The receivers of the tests run on the same host
+	webhook.AllowLoopback = true
+	os.Exit(t.Run())
+}
+
+func TestCreateWebhook(t *testing.T) {
+	manager, _ = webhook.New(webhook.Config{})
+
+	tests := []struct {
+		name      string
+		info      map[string]interface{}
+		expEvents []string
+		expActive bool
+		expError  error
+	}{
+		{
+			name:      "every event",
+			info:      map[string]interface{}{"url": "https://crm.example.com/hooks"},
+			expEvents: []string{},
+			expActive: true,
+		},
+		{
+			name:      "event filters",
+			info:      map[string]interface{}{"url": "https://crm.example.com/hooks", "events": []string{"customer", "rain.alert"}, "active": false},
+			expEvents: []string{"customer", "rain.alert"},
+			expActive: false,
+		},
+		{
+			name:     "unsupported event type",
+			info:     map[string]interface{}{"url": "https://crm.example.com/hooks", "events": []string{"rain.stopped"}},
+			expError: router.NewError(http.StatusBadRequest, "Unsupported event type: rain.stopped. Supported types: customer.created, customer.updated, customer.deleted, forecast.changed, rain.alert, stock.low, order.placed, order.updated, notification.message"),
+		},
+		{
+			name:     "invalid url",
+			info:     map[string]interface{}{"url": "ftp://crm.example.com"},
+			expError: router.NewError(http.StatusBadRequest, "url must be an absolute http or https URL"),
+		},
+		{
+			name:     "missing url",
+			info:     map[string]interface{}{},
+			expError: router.NewError(http.StatusBadRequest, "Request validation failed: url required"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := createWebhook(router.Request{Info: test.info})
+			assert.Equal(t, test.expError, err)
+			if err != nil {
+				return
+			}
+
+			sub := resp.Info["webhook"].(webhook.Subscription)
+			assert.Equal(t, test.expEvents, sub.Events)
+			assert.Equal(t, test.expActive, sub.Active)
+			assert.NotEmpty(t, sub.Secret)
+
+			// The secret is only returned on creation
+			resp, err = getWebhook(router.Request{Vars: map[string]string{"id": sub.ID}})
+			assert.NoError(t, err)
+			assert.Empty(t, resp.Info["webhook"].(webhook.Subscription).Secret)
+		})
+	}
+
+	_, err := updateWebhook(router.Request{Vars: map[string]string{"id": "unknown"}, Info: map[string]interface{}{"url": "https://crm.example.com"}})
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate webhook subscription with id: unknown"), err)
+	_, err = deleteWebhook(router.Request{Vars: map[string]string{"id": "unknown"}})
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate webhook subscription with id: unknown"), err)
+}
+
+func TestDeliveries(t *testing.T) {
+	manager, _ = webhook.New(webhook.Config{MaxAttempts: 1})
+
+	var received []eventbus.Event
+	var secret string
+	status := http.StatusOK
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		assert.NoError(t, webhook.Verify(secret, req.Header.Get(webhook.SignatureHeader), body, time.Minute, time.Now()))
+
+		var event eventbus.Event
+		assert.NoError(t, json.Unmarshal(body, &event))
+		received = append(received, event)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	resp, err := createWebhook(router.Request{Info: map[string]interface{}{"url": receiver.URL, "events": []string{"rain.alert"}}})
+	assert.NoError(t, err)
+	sub := resp.Info["webhook"].(webhook.Subscription)
+	secret = sub.Secret
+
+	bus := eventbus.New(10)
+	enqueue(bus.Publish("customer.created", map[string]string{"id": "1"}))
+	enqueue(bus.Publish("rain.alert", map[string]string{"customer_id": "1"}))
+	// Other tenants' events aren't delivered to the subscription
+	enqueue(bus.PublishTenant("globex", "rain.alert", map[string]string{"customer_id": "3"}))
+	manager.DeliverDue()
+
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, "rain.alert", received[0].Topic)
+		assert.Equal(t, map[string]interface{}{"customer_id": "1"}, received[0].Data)
+	}
+
+	status = http.StatusGone
+	enqueue(bus.Publish("rain.alert", map[string]string{"customer_id": "2"}))
+	manager.DeliverDue()
+
+	resp, err = getDeliveries(router.Request{Query: url.Values{"webhook_id": {sub.ID}, "status": {"dead"}}})
+	assert.NoError(t, err)
+	dead := resp.Info["deliveries"].([]webhook.Delivery)
+	assert.Len(t, dead, 1)
+
+	status = http.StatusOK
+	_, err = redeliver(router.Request{Vars: map[string]string{"id": dead[0].ID}})
+	assert.NoError(t, err)
+	_, err = redeliver(router.Request{Vars: map[string]string{"id": dead[0].ID}})
+	assert.Equal(t, router.NewError(http.StatusConflict, "Only dead deliveries can be redelivered, delivery %s is pending", dead[0].ID), err)
+	manager.DeliverDue()
+	assert.Len(t, received, 3)
+
+	resp, _ = getDeliveries(router.Request{Tenant: "globex"})
+	assert.Equal(t, []webhook.Delivery{}, resp.Info["deliveries"])
+	_, err = redeliver(router.Request{Tenant: "globex", Vars: map[string]string{"id": dead[0].ID}})
+	assert.Equal(t, router.NewError(http.StatusNotFound, "Failed to locate webhook delivery with id: %s", dead[0].ID), err)
+
+	_, err = getDeliveries(router.Request{Query: url.Values{"status": {"lost"}}})
+	assert.Equal(t, router.NewError(http.StatusBadRequest, "status must be pending, delivered or dead"), err)
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// ForecastChange describes a change to a customer's weather details
+type ForecastChange struct {
+	CustomerID     string    `json:"customer_id"`
+	City           string    `json:"city"`
+	CountryCode    string    `json:"country_code"`
+	WeatherDetails []Weather `json:"weather"`
+}
+
+// RainAlert notifies that rain has newly been forecast for a customer's location
+type RainAlert struct {
+	CustomerID   string    `json:"customer_id"`
+	CustomerName string    `json:"customer_name"`
+	City         string    `json:"city"`
+	CountryCode  string    `json:"country_code"`
+	Date         time.Time `json:"date"`
+}
+
+// NewRainAlerts returns an alert for each rain period in the customer's weather details that isn't in the previous weather details
+func NewRainAlerts(customer Customer, previous []Weather) []RainAlert {
+	forecast := map[time.Time]bool{}
+	for _, weather := range previous {
+		if weather.Type == WeatherTypeRain {
+			forecast[weather.Date.UTC()] = true
+		}
+	}
+
+	alerts := []RainAlert{}
+	for _, weather := range customer.WeatherDetails {
+		if weather.Type != WeatherTypeRain || forecast[weather.Date.UTC()] {
+			continue
+		}
+		forecast[weather.Date.UTC()] = true
+		alerts = append(alerts, RainAlert{
+			CustomerID:   customer.ID,
+			CustomerName: customer.Name,
+			City:         customer.Address.City,
+			CountryCode:  customer.Address.CountryCode,
+			Date:         weather.Date,
+		})
+	}
+	return alerts
+}
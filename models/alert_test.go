@@ -0,0 +1,55 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRainAlerts(t *testing.T) {
+	now := time.Date(2017, 02, 16, 0, 0, 0, 0, time.UTC)
+	customer := Customer{
+		ID:      "1",
+		Name:    "Awesome Company",
+		Address: Address{City: "Toronto", Country: "Canada", CountryCode: "CA"},
+		WeatherDetails: []Weather{
+			{Date: now, Type: WeatherTypeRain},
+			{Date: now.Add(3 * time.Hour), Type: WeatherType("Clear")},
+			{Date: now.Add(6 * time.Hour), Type: WeatherTypeRain},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		previous  []Weather
+		expAlerts []time.Time
+	}{
+		{
+			name:      "no previous forecast",
+			expAlerts: []time.Time{now, now.Add(6 * time.Hour)},
+		},
+		{
+			name:      "rain already forecast",
+			previous:  []Weather{{Date: now.In(time.FixedZone("EST", -5*60*60)), Type: WeatherTypeRain}},
+			expAlerts: []time.Time{now.Add(6 * time.Hour)},
+		},
+		{
+			name:      "previously clear",
+			previous:  []Weather{{Date: now, Type: WeatherType("Clear")}, {Date: now.Add(6 * time.Hour), Type: WeatherTypeRain}},
+			expAlerts: []time.Time{now},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var dates []time.Time
+			for _, alert := range NewRainAlerts(customer, test.previous) {
+				assert.Equal(t, "Awesome Company", alert.CustomerName)
+				assert.Equal(t, "CA", alert.CountryCode)
+				dates = append(dates, alert.Date)
+			}
+			assert.Equal(t, test.expAlerts, dates)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package models
+
+import "fmt"
+
+// Role grants access to API routes. Each role is granted the access of the roles below it
+type Role string
+
+// Supported Role values, from least to most privileged
+const (
+	RoleViewer = Role("viewer")
+	RoleRep    = Role("rep")
+	RoleAdmin  = Role("admin")
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleRep:    2,
+	RoleAdmin:  3,
+}
+
+// Validate verifies that the role is supported
+func (role Role) Validate() error {
+	if _, ok := roleRanks[role]; !ok {
+		return fmt.Errorf("Unknown role: %s", role)
+	}
+	return nil
+}
+
+// Allows returns true if the role grants access to routes requiring the specified role
+func (role Role) Allows(required Role) bool {
+	rank, ok := roleRanks[role]
+	return ok && rank >= roleRanks[required]
+}
+
+// Principal is the authenticated identity making a request
+type Principal struct {
+	// Subject identifies who made the request, e.g. the name of an API key or the sub claim of a JWT
+	Subject string `json:"subject"`
+	Role    Role   `json:"role"`
+	// Tenant restricts the principal to the data of a tenant. Principals without a tenant can act on behalf of any tenant
+	Tenant string `json:"tenant,omitempty"`
+	// Client identifies the credential used to make the request, e.g. the id of an API key, so that requests can be rate limited per
+	// credential
+	Client string `json:"-"`
+}
+
+// DefaultTenant is the tenant of requests that don't specify one
+const DefaultTenant = "default"
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// PipelineStatus outlines where a customer is in the sales pipeline
+type PipelineStatus string
+
+// Supported PipelineStatus values. An empty status is treated as PipelineStatusProspect
+const (
+	PipelineStatusProspect    = PipelineStatus("prospect")
+	PipelineStatusContacted   = PipelineStatus("contacted")
+	PipelineStatusNegotiating = PipelineStatus("negotiating")
+	PipelineStatusWon         = PipelineStatus("won")
+	PipelineStatusLost        = PipelineStatus("lost")
+)
+
+var pipelineStatuses = []PipelineStatus{
+	PipelineStatusProspect,
+	PipelineStatusContacted,
+	PipelineStatusNegotiating,
+	PipelineStatusWon,
+	PipelineStatusLost,
+}
+
+// Validate verifies that the status is empty or one of the supported PipelineStatus values
+func (status PipelineStatus) Validate() error {
+	if status == "" {
+		return nil
+	}
+	for _, supported := range pipelineStatuses {
+		if status == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown pipeline status: %s", status)
+}
+
+// LeadScore ranks how promising a customer is to call, along with the factors that contributed to the ranking
+type LeadScore struct {
+	Total     float64       `json:"total"`
+	Breakdown []ScoreFactor `json:"breakdown"`
+	ScoredAt  time.Time     `json:"scored_at"`
+}
+
+// ScoreFactor is a single weighted input of a LeadScore
+type ScoreFactor struct {
+	Name string `json:"name"`
+	// Value is the factor normalized to the range [0, 1]
+	Value  float64 `json:"value"`
+	Weight float64 `json:"weight"`
+	// Points is the factor's contribution to LeadScore.Total
+	Points      float64 `json:"points"`
+	Explanation string  `json:"explanation"`
+}
@@ -2,19 +2,28 @@ package models
 
 import (
 	"fmt"
+	"time"
+	"umbrellacorp/util"
 
 	countryCodes "github.com/launchdarkly/go-country-codes"
 )
 
 // Customer represents a customer and provides validation functionality
 type Customer struct {
-	ID             string    `json:"id"`
-	Name           string    `json:"name" api:"required"`
-	Contact        string    `json:"contact"` // optional field
-	ContactNumber  string    `json:"contact_number" api:"required"`
-	Address        Address   `json:"address" api:"required"`
-	NumEmployees   int       `json:"num_employees" api_required`
-	WeatherDetails []Weather `json:"weather"`
+	ID             string         `json:"id"`
+	Name           string         `json:"name" api:"required"`
+	Contact        string         `json:"contact"` // optional field
+	ContactNumber  string         `json:"contact_number" api:"required"`
+	Address        Address        `json:"address" api:"required"`
+	NumEmployees   int            `json:"num_employees"`
+	WeatherDetails []Weather      `json:"weather"`
+	PipelineStatus PipelineStatus `json:"pipeline_status"` // optional field, defaults to PipelineStatusProspect
+	LastContacted  *time.Time     `json:"last_contacted"`  // optional field, nil if the customer has never been contacted
+	Lead           *LeadScore     `json:"lead,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	Notes          string         `json:"notes"` // optional field
+	DeletedAt      *time.Time     `json:"deleted_at,omitempty"`
+	DeletedBy      string         `json:"deleted_by,omitempty"`
 }
 
 // Validate verifies data about the Customer. It does not duplicate verification of properties annotated with `api:"required"` tags
@@ -23,12 +32,38 @@ func (customer Customer) Validate() error {
 	if len(customer.ContactNumber) < contactNumberMinLength {
 		return fmt.Errorf("The contact number must be a minimum of %d digits", contactNumberMinLength)
 	}
+
+	if err := customer.PipelineStatus.Validate(); err != nil {
+		return err
+	}
 	return customer.Address.Validate()
 }
 
 // Customers is a list of Customer objects
 type Customers []Customer
 
+// RainDays returns the number of distinct days, in UTC, with rain in the customer's weather details
+func (customer Customer) RainDays() int {
+	days := map[string]bool{}
+	for _, weather := range customer.WeatherDetails {
+		if weather.Type == WeatherTypeRain {
+			days[weather.Date.UTC().Format("2006-01-02")] = true
+		}
+	}
+	return len(days)
+}
+
+// HasRainWithin returns true if rain is forecast for the customer between start and start + duration
+func (customer Customer) HasRainWithin(start time.Time, duration time.Duration) bool {
+	dateRange := util.DateRange{Start: start, End: start.Add(duration)}
+	for _, weather := range customer.WeatherDetails {
+		if weather.Type == WeatherTypeRain && dateRange.Contains(weather.Date) {
+			return true
+		}
+	}
+	return false
+}
+
 // Address represents a physical address
 type Address struct {
 	City        string `json:"city"`
@@ -39,9 +74,26 @@ type Address struct {
 	// Address2        string          `json:"address_2"`
 	// ZipCode         string          `json:"zip_code"`
 
-	// For future we should support translating address fields to geo coordinates leveraging a geo API to be more flexible when obtaining address info from
-	// the client
-	// GeoCooordinates geo.Coordinates `json:"geo_coordinates"`
+	// Coordinates are optional. They allow the address to be matched against territories defined by geographic areas
+	// For future we should support translating address fields to geo coordinates leveraging a geo API, rather than relying on the client
+	Coordinates *Coordinates `json:"coordinates,omitempty"`
+}
+
+// Coordinates are a geographic location in decimal degrees
+type Coordinates struct {
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lng"`
+}
+
+// Validate verifies that the coordinates are within the valid ranges of latitudes and longitudes
+func (coordinates Coordinates) Validate() error {
+	if coordinates.Latitude < -90 || coordinates.Latitude > 90 {
+		return fmt.Errorf("Latitude must be between -90 and 90")
+	}
+	if coordinates.Longitude < -180 || coordinates.Longitude > 180 {
+		return fmt.Errorf("Longitude must be between -180 and 180")
+	}
+	return nil
 }
 
 var errAddressValidationFailure = fmt.Errorf(`Please ensure city and country are provided`)
@@ -52,6 +104,12 @@ func (address Address) Validate() error {
 		return errAddressValidationFailure
 	}
 
+	if address.Coordinates != nil {
+		if err := address.Coordinates.Validate(); err != nil {
+			return err
+		}
+	}
+
 	_, err := address.SetCountryCode()
 	return err
 }
@@ -102,6 +102,54 @@ func TestValidateCustomer(t *testing.T) {
 			},
 			expErr: nil,
 		},
+		{
+			name: "Unknown pipeline status",
+			input: Customer{
+				ContactNumber:  "4165555555",
+				PipelineStatus: PipelineStatus("ghosted"),
+				Address: Address{
+					City:    "Chicago",
+					Country: "US",
+				},
+			},
+			expErr: fmt.Errorf("Unknown pipeline status: ghosted"),
+		},
+		{
+			name: "Known pipeline status",
+			input: Customer{
+				ContactNumber:  "4165555555",
+				PipelineStatus: PipelineStatusNegotiating,
+				Address: Address{
+					City:    "Chicago",
+					Country: "US",
+				},
+			},
+			expErr: nil,
+		},
+		{
+			name: "Invalid coordinates",
+			input: Customer{
+				ContactNumber: "4165555555",
+				Address: Address{
+					City:        "Chicago",
+					Country:     "US",
+					Coordinates: &Coordinates{Latitude: 91, Longitude: -87.6},
+				},
+			},
+			expErr: fmt.Errorf("Latitude must be between -90 and 90"),
+		},
+		{
+			name: "Valid coordinates",
+			input: Customer{
+				ContactNumber: "4165555555",
+				Address: Address{
+					City:        "Chicago",
+					Country:     "US",
+					Coordinates: &Coordinates{Latitude: 41.9, Longitude: -87.6},
+				},
+			},
+			expErr: nil,
+		},
 	}
 
 	for _, test := range tests {
@@ -111,3 +159,23 @@ func TestValidateCustomer(t *testing.T) {
 		})
 	}
 }
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     Role
+		required Role
+		expAllow bool
+	}{
+		{name: "same role", role: RoleRep, required: RoleRep, expAllow: true},
+		{name: "higher role", role: RoleAdmin, required: RoleViewer, expAllow: true},
+		{name: "lower role", role: RoleViewer, required: RoleRep, expAllow: false},
+		{name: "unknown role", role: Role("root"), required: RoleViewer, expAllow: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expAllow, test.role.Allows(test.required))
+		})
+	}
+}
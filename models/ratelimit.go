@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// RateLimit is the state of a client's rate limit after a request was counted against it
+type RateLimit struct {
+	// Allowed is false if the client exceeded the limit, in which case the request must be rejected
+	Allowed bool
+	// Limit is the number of requests the client can make in a burst
+	Limit int
+	// Remaining is the number of requests the client can make before being limited
+	Remaining int
+	// Reset is how long until the client's full burst is available again
+	Reset time.Duration
+	// RetryAfter is how long until the client can make another request. It's only set if the request wasn't allowed
+	RetryAfter time.Duration
+}
@@ -0,0 +1,40 @@
+package router
+
+import (
+	"net/http"
+	"umbrellacorp/models"
+)
+
+// Authenticator identifies the principal making a request from its http headers
+type Authenticator interface {
+	Authenticate(header http.Header) (models.Principal, error)
+}
+
+var authenticator Authenticator
+
+// SetAuthenticator enables authentication of every request. It must be called before serving requests. Until it's called, requests
+// aren't authenticated and are made with the admin role on behalf of the X-Actor header, which is only intended for tests
+func SetAuthenticator(a Authenticator) {
+	authenticator = a
+}
+
+// authenticate returns the principal making the http request. The returned error is a router Error with status 401
+func authenticate(header http.Header) (models.Principal, error) {
+	if authenticator == nil {
+		return models.Principal{Subject: requestActor(header), Role: models.RoleAdmin}, nil
+	}
+
+	principal, err := authenticator.Authenticate(header)
+	if err != nil {
+		return principal, NewError(http.StatusUnauthorized, "%s", err.Error())
+	}
+	return principal, nil
+}
+
+// authorize verifies that the principal's role grants access to the route. The returned error is a router Error with status 403
+func authorize(principal models.Principal, route Route) error {
+	if !principal.Role.Allows(route.requiredRole()) {
+		return NewError(http.StatusForbidden, "The %s role can't access %s, it requires the %s role", principal.Role, route.Name, route.requiredRole())
+	}
+	return nil
+}
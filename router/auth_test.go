@@ -0,0 +1,131 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tokenAuthenticator authenticates requests whose Authorization header is the name of a role
+type tokenAuthenticator struct{}
+
+func (tokenAuthenticator) Authenticate(header http.Header) (models.Principal, error) {
+	role := models.Role(header.Get("Authorization"))
+	if role.Validate() != nil {
+		return models.Principal{}, fmt.Errorf("Invalid credentials")
+	}
+	return models.Principal{Subject: string(role) + "@umbrellacorp.com", Role: role}, nil
+}
+
+func TestAuthorization(t *testing.T) {
+	prevRegistry := routesRegistry
+	SetAuthenticator(tokenAuthenticator{})
+	defer func() {
+		routesRegistry = prevRegistry
+		SetAuthenticator(nil)
+	}()
+
+	whoAmI := func(req Request) (Response, error) {
+		return Response{Info: map[string]interface{}{"actor": req.Actor, "role": req.Role}}, nil
+	}
+	routesRegistry = nil
+	RegisterRoutes("test", Routes{
+		{Name: "View", Methods: []string{http.MethodGet}, Path: "/view", HandlerFunc: whoAmI, Role: models.RoleViewer},
+		{Name: "Edit", Methods: []string{http.MethodPost}, Path: "/edit", HandlerFunc: whoAmI, Role: models.RoleRep},
+		{Name: "Unspecified", Methods: []string{http.MethodDelete}, Path: "/unspecified", HandlerFunc: whoAmI},
+	})
+	router := NewRouter()
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		token     string
+		body      string
+		expStatus int
+		expBody   string
+	}{
+		{
+			name:      "unauthenticated",
+			method:    http.MethodGet,
+			path:      "/view",
+			expStatus: http.StatusUnauthorized,
+			expBody:   "Invalid credentials\n",
+		},
+		{
+			name:      "authorized",
+			method:    http.MethodGet,
+			path:      "/view",
+			token:     "viewer",
+			expStatus: http.StatusOK,
+			expBody:   `{"actor":"viewer@umbrellacorp.com","role":"viewer"}` + "\n",
+		},
+		{
+			name:      "higher role",
+			method:    http.MethodPost,
+			path:      "/edit",
+			token:     "admin",
+			expStatus: http.StatusOK,
+		},
+		{
+			name:      "forbidden",
+			method:    http.MethodPost,
+			path:      "/edit",
+			token:     "viewer",
+			expStatus: http.StatusForbidden,
+			expBody:   "The viewer role can't access Edit, it requires the rep role\n",
+		},
+		{
+			name:      "routes without a role require admin",
+			method:    http.MethodDelete,
+			path:      "/unspecified",
+			token:     "rep",
+			expStatus: http.StatusForbidden,
+		},
+		{
+			name:   "batch operations are authorized individually",
+			method: http.MethodPost,
+			path:   "/batch",
+			token:  "viewer",
+			body: `{"operations": [
+				{"method": "GET", "path": "/view"},
+				{"method": "POST", "path": "/edit"}
+			]}`,
+			expStatus: http.StatusOK,
+			expBody: `{"results": [
+				{"status": 200, "body": {"actor": "viewer@umbrellacorp.com", "role": "viewer"}},
+				{"status": 403, "error": "The viewer role can't access Edit, it requires the rep role"}
+			]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			req.Header.Set("Authorization", test.token)
+			// X-Actor is ignored once requests are authenticated
+			req.Header.Set(actorHeader, "spoofed")
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.expStatus, rec.Code)
+			if rec.Code == http.StatusUnauthorized {
+				assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+			}
+			switch {
+			case test.expBody == "":
+			case json.Valid([]byte(test.expBody)) && rec.Code == http.StatusOK:
+				assert.JSONEq(t, test.expBody, rec.Body.String())
+			default:
+				assert.Equal(t, test.expBody, rec.Body.String())
+			}
+		})
+	}
+}
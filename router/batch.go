@@ -0,0 +1,276 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"umbrellacorp/models"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	batchRouteName = "Batch"
+	batchPath      = "/batch"
+	// maxBatchOperations caps the number of operations of a single batch
+	maxBatchOperations = 100
+)
+
+// Transaction is the changes made to a Transactor's state by an atomic batch
+type Transaction struct {
+	// Commit is called once every operation of the batch succeeded, e.g. to publish events that were held back. It may be nil
+	Commit func()
+	// Rollback restores the state captured when the batch began
+	Rollback func()
+}
+
+// Transactor is application state, such as a store, that handlers modify and that can be rolled back when an atomic batch fails
+type Transactor interface {
+	// Begin captures the current state before the operations of an atomic batch are executed
+	Begin() Transaction
+}
+
+var transactors []Transactor
+
+// RegisterTransactor registers state to be rolled back when an atomic batch fails
+func RegisterTransactor(transactor Transactor) {
+	transactors = append(transactors, transactor)
+}
+
+// mutationLock is held for reading by every request and background job that may mutate state, and for writing by atomic batches so that
+// their rollback can't undo changes made concurrently
+var mutationLock sync.RWMutex
+
+// WithMutationLock calls fn while holding mutationLock for reading, like requests that may mutate state. Background jobs mutate state
+// through it so that atomic batches can't roll back their changes, and read state through it so that they don't act on the changes of a
+// batch that may yet be rolled back. It must not be called by handlers, which already hold the lock
+func WithMutationLock(fn func()) {
+	mutationLock.RLock()
+	defer mutationLock.RUnlock()
+	fn()
+}
+
+// isMutation returns true if requests with the http method may mutate state
+func isMutation(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// batchOperation is a single request within a batch
+type batchOperation struct {
+	Method string                 `json:"method" api:"required"`
+	Path   string                 `json:"path" api:"required"`
+	Body   map[string]interface{} `json:"body"`
+}
+
+// batchRequest is the body of a batch. Operations are executed in order. If Atomic is set, the batch stops at the first failed operation
+// and the changes made by the preceding operations are rolled back. Atomic batches can only mutate state through Transactional routes
+type batchRequest struct {
+	Operations []batchOperation `json:"operations" api:"required"`
+	Atomic     bool             `json:"atomic"`
+}
+
+// batchResult is the outcome of a single operation of a batch
+type batchResult struct {
+	Status int                    `json:"status"`
+	Body   map[string]interface{} `json:"body,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+	// RolledBack is set on operations that succeeded but were undone because a later operation of an atomic batch failed
+	RolledBack bool `json:"rolled_back,omitempty"`
+}
+
+// handleBatch serves batches by dispatching each operation to the registered route that matches its method and path
+func handleBatch(router *mux.Router, muxRoutes map[*mux.Route]Route) http.Handler {
+	return handle(Route{
+		Name:  batchRouteName,
+		Role:  models.RoleViewer,
+		batch: true,
+		HandlerFunc: func(req Request) (Response, error) {
+			resp := Response{Info: map[string]interface{}{}}
+
+			var batch batchRequest
+			if err := req.Parse(&batch); err != nil {
+				return resp, NewError(http.StatusBadRequest, "%s", err.Error())
+			}
+			if len(batch.Operations) > maxBatchOperations {
+				return resp, NewError(http.StatusBadRequest, "A batch can't contain more than %d operations", maxBatchOperations)
+			}
+
+			var results []batchResult
+			if batch.Atomic {
+				var err error
+				if results, err = executeAtomic(router, muxRoutes, req, batch.Operations); err != nil {
+					return resp, err
+				}
+			} else {
+				results = make([]batchResult, len(batch.Operations))
+				for i, op := range batch.Operations {
+					results[i] = dispatch(router, muxRoutes, req, op, true)
+				}
+			}
+
+			resp.Info["results"] = results
+			return resp, nil
+		},
+	})
+}
+
+// executeAtomic executes the operations while no other request can mutate state. Routes with a Prepare fn are prepared before the lock is
+// acquired. If an operation fails, every registered Transactor is rolled back and the remaining operations are skipped. The batch is
+// rejected with a 400 error before any operation is executed if it contains a mutation of a route that isn't Transactional
+func executeAtomic(router *mux.Router, muxRoutes map[*mux.Route]Route, batchReq Request, ops []batchOperation) ([]batchResult, error) {
+	calls := make([]batchCall, len(ops))
+	failures := make([]*batchResult, len(ops))
+	for i, op := range ops {
+		calls[i], failures[i] = resolve(router, muxRoutes, batchReq, op)
+		if failures[i] != nil {
+			// The batch fails at this operation, so the rest don't need to be prepared
+			break
+		}
+		if isMutation(op.Method) && !calls[i].route.Transactional {
+			return nil, NewError(http.StatusBadRequest, "%s can't be used within an atomic batch because its changes can't be rolled back", calls[i].route.Name)
+		}
+	}
+	for i := range ops {
+		if failures[i] != nil {
+			break
+		}
+		if calls[i].route.Prepare != nil {
+			calls[i].req.Context = calls[i].route.Prepare(calls[i].req)
+		}
+	}
+
+	mutationLock.Lock()
+	defer mutationLock.Unlock()
+
+	var transactions []Transaction
+	for _, transactor := range transactors {
+		transactions = append(transactions, transactor.Begin())
+	}
+
+	results := make([]batchResult, len(ops))
+	for i := range ops {
+		if failures[i] != nil {
+			results[i] = *failures[i]
+		} else {
+			results[i] = calls[i].execute(false)
+		}
+		if results[i].Status < http.StatusBadRequest {
+			continue
+		}
+
+		for _, transaction := range transactions {
+			transaction.Rollback()
+		}
+		for j := 0; j < i; j++ {
+			results[j].RolledBack = true
+		}
+		for j := i + 1; j < len(ops); j++ {
+			results[j] = batchResult{Status: http.StatusFailedDependency, Error: "Skipped because a previous operation of the atomic batch failed"}
+		}
+		return results, nil
+	}
+
+	for _, transaction := range transactions {
+		if transaction.Commit != nil {
+			transaction.Commit()
+		}
+	}
+	return results, nil
+}
+
+// batchCall is an operation of a batch that was matched to its route and that the batch's caller is allowed to call
+type batchCall struct {
+	route Route
+	op    batchOperation
+	req   Request
+}
+
+// dispatch executes a single operation of a batch on behalf of the batch's request. lock specifies whether the operation needs to acquire
+// mutationLock, i.e. whether it isn't already held by an atomic batch
+func dispatch(router *mux.Router, muxRoutes map[*mux.Route]Route, batchReq Request, op batchOperation, lock bool) batchResult {
+	call, failure := resolve(router, muxRoutes, batchReq, op)
+	if failure != nil {
+		return *failure
+	}
+	return call.execute(lock)
+}
+
+// resolve matches the operation to its route and verifies that the batch's caller may call it. The result of the operation is returned
+// instead if it can't be called
+func resolve(router *mux.Router, muxRoutes map[*mux.Route]Route, batchReq Request, op batchOperation) (batchCall, *batchResult) {
+	httpReq, err := http.NewRequest(op.Method, op.Path, nil)
+	if err != nil {
+		return batchCall{}, &batchResult{Status: http.StatusBadRequest, Error: err.Error()}
+	}
+
+	var match mux.RouteMatch
+	if !router.Match(httpReq, &match) || match.Route == nil {
+		if match.MatchErr == mux.ErrMethodMismatch {
+			return batchCall{}, &batchResult{Status: http.StatusMethodNotAllowed, Error: "Method not allowed"}
+		}
+		return batchCall{}, &batchResult{Status: http.StatusNotFound, Error: "Route not found"}
+	}
+
+	route, ok := muxRoutes[match.Route]
+	if !ok {
+		// e.g. a nested batch
+		return batchCall{}, &batchResult{Status: http.StatusBadRequest, Error: "Route can't be used within a batch"}
+	}
+	if route.RawBody {
+		return batchCall{}, &batchResult{Status: http.StatusBadRequest, Error: "Route doesn't accept json bodies"}
+	}
+	// Each operation requires the same role as calling its route directly
+	if err = authorize(models.Principal{Subject: batchReq.Actor, Role: batchReq.Role}, route); err != nil {
+		return batchCall{}, &batchResult{Status: StatusCode(err), Error: err.Error()}
+	}
+
+	// Each operation counts against the rate limit of its route, so that batches can't be used to get around stricter limits
+	if _, err = takeRateLimit(batchReq.Client, route.Name); err != nil {
+		return batchCall{}, &batchResult{Status: StatusCode(err), Error: err.Error()}
+	}
+
+	return batchCall{route: route, op: op, req: Request{
+		Info:    op.Body,
+		Query:   httpReq.URL.Query(),
+		Vars:    match.Vars,
+		Actor:   batchReq.Actor,
+		Role:    batchReq.Role,
+		Tenant:  batchReq.Tenant,
+		Client:  batchReq.Client,
+		Route:   route.Name,
+		Header:  batchReq.Header,
+		Context: batchReq.Context,
+	}}, nil
+}
+
+// execute calls the operation's handler. lock specifies whether the operation needs to acquire mutationLock, i.e. whether it isn't
+// already held by an atomic batch
+func (call batchCall) execute(lock bool) batchResult {
+	if lock && isMutation(call.op.Method) {
+		mutationLock.RLock()
+		defer mutationLock.RUnlock()
+	}
+
+	resp, err := call.route.HandlerFunc(call.req)
+	if err != nil {
+		return batchResult{Status: StatusCode(err), Error: err.Error()}
+	}
+	if resp.Stream != nil || resp.Upgrade != nil {
+		return batchResult{Status: http.StatusBadRequest, Error: "Streamed responses aren't supported within a batch"}
+	}
+
+	// Round trip the response through json so that the result matches what the route would have sent over http
+	body := map[string]interface{}{}
+	buf, err := json.Marshal(resp.Info)
+	if err == nil {
+		err = json.Unmarshal(buf, &body)
+	}
+	if err != nil {
+		return batchResult{Status: http.StatusInternalServerError, Error: err.Error()}
+	}
+	return batchResult{Status: http.StatusOK, Body: body}
+}
@@ -0,0 +1,216 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testState is a Transactor holding a list of names. Names added by atomic batches are only announced once the batch commits
+type testState struct {
+	names     []string
+	announced []string
+	holding   bool
+	held      []string
+}
+
+func (state *testState) add(name string) {
+	state.names = append(state.names, name)
+	if state.holding {
+		state.held = append(state.held, name)
+		return
+	}
+	state.announced = append(state.announced, name)
+}
+
+func (state *testState) Begin() Transaction {
+	names := append([]string{}, state.names...)
+	state.holding = true
+	return Transaction{
+		Commit: func() {
+			state.announced = append(state.announced, state.held...)
+			state.holding, state.held = false, nil
+		},
+		Rollback: func() {
+			state.names = names
+			state.holding, state.held = false, nil
+		},
+	}
+}
+
+// preparedKey is the context key of whether an operation was prepared without the mutation lock held
+type preparedKey struct{}
+
+func TestBatch(t *testing.T) {
+	prevRegistry, prevTransactors := routesRegistry, transactors
+	defer func() {
+		routesRegistry, transactors = prevRegistry, prevTransactors
+	}()
+
+	state := &testState{}
+	routesRegistry = nil
+	transactors = nil
+	RegisterTransactor(state)
+	RegisterRoutes("name", Routes{
+		{
+			Name:    "Add Name",
+			Methods: []string{http.MethodPost},
+			Path:    "/names",
+			HandlerFunc: func(req Request) (Response, error) {
+				var body struct {
+					Name string `json:"name" api:"required"`
+				}
+				if err := req.Parse(&body); err != nil {
+					return Response{}, NewError(http.StatusBadRequest, "%s", err.Error())
+				}
+				if prepared, ok := req.Context.Value(preparedKey{}).(bool); ok && !prepared {
+					return Response{}, NewError(http.StatusInternalServerError, "Prepared while holding the mutation lock")
+				}
+				state.add(body.Name)
+				return Response{Info: map[string]interface{}{"count": len(state.names)}}, nil
+			},
+			Prepare: func(req Request) context.Context {
+				// Atomic batches acquire the mutation lock after preparing their operations
+				unlocked := mutationLock.TryLock()
+				if unlocked {
+					mutationLock.Unlock()
+				}
+				return context.WithValue(req.Context, preparedKey{}, unlocked)
+			},
+			Transactional: true,
+		},
+		{
+			Name:        "Log Name",
+			Methods:     []string{http.MethodPost},
+			Path:        "/names/log",
+			HandlerFunc: func(req Request) (Response, error) { return Response{}, nil },
+		},
+		{
+			Name:    "Get Name",
+			Methods: []string{http.MethodGet},
+			Path:    "/names/{index}",
+			HandlerFunc: func(req Request) (Response, error) {
+				return Response{Info: map[string]interface{}{"index": req.Vars["index"], "upper": req.Query.Get("upper")}}, nil
+			},
+		},
+		{
+			Name:        "Import Names",
+			Methods:     []string{http.MethodPost},
+			Path:        "/names/import",
+			HandlerFunc: func(req Request) (Response, error) { return Response{}, nil },
+			RawBody:     true,
+		},
+	})
+	router := NewRouter()
+
+	tests := []struct {
+		name         string
+		body         string
+		expStatus    int
+		expResults   string
+		expNames     []string
+		expAnnounced []string
+	}{
+		{
+			name: "non atomic batch continues past failures",
+			body: `{"operations": [
+				{"method": "POST", "path": "/names", "body": {"name": "Umbrella"}},
+				{"method": "POST", "path": "/names", "body": {}},
+				{"method": "GET", "path": "/names/1?upper=true"},
+				{"method": "DELETE", "path": "/names/1"},
+				{"method": "GET", "path": "/unknown"},
+				{"method": "POST", "path": "/batch", "body": {"operations": []}},
+				{"method": "POST", "path": "/names/import"}
+			]}`,
+			expStatus: http.StatusOK,
+			expResults: `[
+				{"status": 200, "body": {"count": 1}},
+				{"status": 400, "error": "Request validation failed: name required"},
+				{"status": 200, "body": {"index": "1", "upper": "true"}},
+				{"status": 405, "error": "Method not allowed"},
+				{"status": 404, "error": "Route not found"},
+				{"status": 400, "error": "Route can't be used within a batch"},
+				{"status": 400, "error": "Route doesn't accept json bodies"}
+			]`,
+			expNames:     []string{"Umbrella"},
+			expAnnounced: []string{"Umbrella"},
+		},
+		{
+			name: "atomic batch rolls back on failure",
+			body: `{"atomic": true, "operations": [
+				{"method": "POST", "path": "/names", "body": {"name": "Umbrella"}},
+				{"method": "POST", "path": "/names", "body": {}},
+				{"method": "POST", "path": "/names", "body": {"name": "Corp"}}
+			]}`,
+			expStatus: http.StatusOK,
+			expResults: `[
+				{"status": 200, "body": {"count": 1}, "rolled_back": true},
+				{"status": 400, "error": "Request validation failed: name required"},
+				{"status": 424, "error": "Skipped because a previous operation of the atomic batch failed"}
+			]`,
+			expNames:     []string{},
+			expAnnounced: []string{},
+		},
+		{
+			name: "atomic batch succeeds",
+			body: `{"atomic": true, "operations": [
+				{"method": "POST", "path": "/names", "body": {"name": "Umbrella"}},
+				{"method": "POST", "path": "/names", "body": {"name": "Corp"}}
+			]}`,
+			expStatus: http.StatusOK,
+			expResults: `[
+				{"status": 200, "body": {"count": 1}},
+				{"status": 200, "body": {"count": 2}}
+			]`,
+			expNames:     []string{"Umbrella", "Corp"},
+			expAnnounced: []string{"Umbrella", "Corp"},
+		},
+		{
+			name: "atomic batch rejects mutations that can't be rolled back",
+			body: `{"atomic": true, "operations": [
+				{"method": "POST", "path": "/names", "body": {"name": "Umbrella"}},
+				{"method": "POST", "path": "/names/log"},
+				{"method": "POST", "path": "/names", "body": {}}
+			]}`,
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "operations required",
+			body:      `{}`,
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "too many operations",
+			body:      fmt.Sprintf(`{"operations": [%s{}]}`, strings.Repeat("{},", maxBatchOperations)),
+			expStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state.names, state.announced = []string{}, []string{}
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(test.body)))
+			assert.Equal(t, test.expStatus, rec.Code)
+			if test.expStatus != http.StatusOK {
+				assert.Empty(t, state.names)
+				return
+			}
+
+			var resp struct {
+				Results json.RawMessage `json:"results"`
+			}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.JSONEq(t, test.expResults, string(resp.Results))
+			assert.Equal(t, test.expNames, state.names)
+			assert.Equal(t, test.expAnnounced, state.announced)
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Error is an error associated with an http status code. Handlers return it when a failure shouldn't be reported to the client as an
+// internal server error
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (err Error) Error() string {
+	return err.Message
+}
+
+// NewError returns an Error with the specified http status code and formatted message
+func NewError(status int, format string, args ...interface{}) error {
+	return Error{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// StatusCode returns the http status code associated with err. Errors that weren't created by NewError are treated as internal server
+// errors
+func StatusCode(err error) int {
+	if routerErr, ok := err.(Error); ok {
+		return routerErr.Status
+	}
+	return http.StatusInternalServerError
+}
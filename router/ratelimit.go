@@ -0,0 +1,94 @@
+package router
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"umbrellacorp/models"
+)
+
+// RateLimiter limits how often clients can call routes
+type RateLimiter interface {
+	// Take counts a request by the client to the route, and returns the client's rate limit afterwards. limited is false if the route
+	// isn't rate limited
+	Take(client, route string) (rateLimit models.RateLimit, limited bool)
+}
+
+var rateLimiter RateLimiter
+
+// SetRateLimiter enables rate limiting of every request. It must be called before serving requests
+func SetRateLimiter(limiter RateLimiter) {
+	rateLimiter = limiter
+}
+
+// Headers describing a client's rate limit, as proposed by the IETF RateLimit header fields draft
+const (
+	rateLimitLimitHeader     = "RateLimit-Limit"
+	rateLimitRemainingHeader = "RateLimit-Remaining"
+	rateLimitResetHeader     = "RateLimit-Reset"
+	retryAfterHeader         = "Retry-After"
+)
+
+// rateLimitError is the json body of responses to rate limited requests
+type rateLimitError struct {
+	Error      string `json:"error"`
+	RetryAfter int    `json:"retry_after"`
+}
+
+// clientID identifies the client making the http request for rate limiting. Clients are identified by their credential if they're
+// authenticated, and by their IP address otherwise
+func clientID(req *http.Request, principal models.Principal) string {
+	if principal.Client != "" {
+		return principal.Client
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// takeRateLimit counts a request by the client to the route. It returns nil if the route isn't rate limited. The returned error is a
+// router Error with status 429 if the client exceeded the limit
+func takeRateLimit(client, route string) (*models.RateLimit, error) {
+	if rateLimiter == nil {
+		return nil, nil
+	}
+	rateLimit, limited := rateLimiter.Take(client, route)
+	if !limited {
+		return nil, nil
+	}
+	if !rateLimit.Allowed {
+		return &rateLimit, NewError(http.StatusTooManyRequests, "Rate limit of %s exceeded, retry in %d seconds", route, seconds(rateLimit.RetryAfter))
+	}
+	return &rateLimit, nil
+}
+
+// limitRate counts the http request against the client's rate limit for the route, and describes the limit in the response headers. It
+// responds with status 429 and returns false if the client exceeded the limit
+func limitRate(w http.ResponseWriter, client, route string) bool {
+	rateLimit, err := takeRateLimit(client, route)
+	if rateLimit == nil {
+		return true
+	}
+
+	w.Header().Set(rateLimitLimitHeader, strconv.Itoa(rateLimit.Limit))
+	w.Header().Set(rateLimitRemainingHeader, strconv.Itoa(rateLimit.Remaining))
+	w.Header().Set(rateLimitResetHeader, strconv.Itoa(seconds(rateLimit.Reset)))
+	if err == nil {
+		return true
+	}
+
+	w.Header().Set(retryAfterHeader, strconv.Itoa(seconds(rateLimit.RetryAfter)))
+	w.WriteHeader(StatusCode(err))
+	json.NewEncoder(w).Encode(rateLimitError{Error: err.Error(), RetryAfter: seconds(rateLimit.RetryAfter)})
+	return false
+}
+
+// seconds rounds the duration up to whole seconds, so that clients waiting that long aren't limited again
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
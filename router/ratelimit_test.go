@@ -0,0 +1,124 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingLimiter allows each client a fixed number of requests per route
+type countingLimiter struct {
+	limit int
+	taken map[string]int
+}
+
+func (limiter *countingLimiter) Take(client, route string) (models.RateLimit, bool) {
+	if route == "Unlimited" {
+		return models.RateLimit{Allowed: true}, false
+	}
+	limiter.taken[client+" "+route]++
+	remaining := limiter.limit - limiter.taken[client+" "+route]
+	if remaining < 0 {
+		return models.RateLimit{Limit: limiter.limit, Reset: time.Minute, RetryAfter: 1500 * time.Millisecond}, true
+	}
+	return models.RateLimit{Allowed: true, Limit: limiter.limit, Remaining: remaining, Reset: time.Minute}, true
+}
+
+func TestRateLimit(t *testing.T) {
+	prevRegistry := routesRegistry
+	SetRateLimiter(&countingLimiter{limit: 1, taken: map[string]int{}})
+	defer func() {
+		routesRegistry = prevRegistry
+		SetRateLimiter(nil)
+	}()
+
+	ok := func(req Request) (Response, error) {
+		return Response{Info: map[string]interface{}{"client": req.Client}}, nil
+	}
+	routesRegistry = nil
+	RegisterRoutes("test", Routes{
+		{Name: "Upsert", Methods: []string{http.MethodPost}, Path: "/upsert", HandlerFunc: ok},
+		{Name: "Unlimited", Methods: []string{http.MethodGet}, Path: "/unlimited", HandlerFunc: ok},
+	})
+	router := NewRouter()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		remoteAddr string
+		body       string
+		expStatus  int
+		expHeader  http.Header
+		expBody    string
+	}{
+		{
+			name:       "allowed",
+			method:     http.MethodPost,
+			path:       "/upsert",
+			remoteAddr: "10.0.0.1:1234",
+			expStatus:  http.StatusOK,
+			expHeader:  http.Header{"Ratelimit-Limit": {"1"}, "Ratelimit-Remaining": {"0"}, "Ratelimit-Reset": {"60"}},
+			expBody:    `{"client":"ip:10.0.0.1"}`,
+		},
+		{
+			name:       "limited",
+			method:     http.MethodPost,
+			path:       "/upsert",
+			remoteAddr: "10.0.0.1:5678",
+			expStatus:  http.StatusTooManyRequests,
+			expHeader:  http.Header{"Ratelimit-Remaining": {"0"}, "Retry-After": {"2"}},
+			expBody:    `{"error":"Rate limit of Upsert exceeded, retry in 2 seconds","retry_after":2}`,
+		},
+		{
+			name:       "other client",
+			method:     http.MethodPost,
+			path:       "/upsert",
+			remoteAddr: "10.0.0.2:1234",
+			expStatus:  http.StatusOK,
+		},
+		{
+			name:       "unlimited route",
+			method:     http.MethodGet,
+			path:       "/unlimited",
+			remoteAddr: "10.0.0.1:1234",
+			expStatus:  http.StatusOK,
+			expHeader:  http.Header{"Ratelimit-Limit": nil},
+		},
+		{
+			name:       "batch operations are limited individually",
+			method:     http.MethodPost,
+			path:       "/batch",
+			remoteAddr: "10.0.0.3:1234",
+			body:       `{"operations": [{"method": "POST", "path": "/upsert"}, {"method": "POST", "path": "/upsert"}]}`,
+			expStatus:  http.StatusOK,
+			expBody: `{"results": [
+				{"status": 200, "body": {"client": "ip:10.0.0.3"}},
+				{"status": 429, "error": "Rate limit of Upsert exceeded, retry in 2 seconds"}
+			]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			req.RemoteAddr = test.remoteAddr
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.expStatus, rec.Code)
+			for key, values := range test.expHeader {
+				assert.Equal(t, values, rec.Header()[key], key)
+			}
+			if test.expBody != "" {
+				assert.JSONEq(t, test.expBody, rec.Body.String())
+			}
+		})
+	}
+}
@@ -1,15 +1,56 @@
 package router
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"reflect"
+	"umbrellacorp/models"
 )
 
 // Request represents the data associated with a handler
 type Request struct {
 	// Info represents the json query or method parameters associated with a handler
 	Info map[string]interface{} `json:"info"`
+	// Query represents the url query parameters of the http request
+	Query url.Values `json:"-"`
+	// Vars represents the variables in the route's path, e.g. {id} in /customers/{id}
+	Vars map[string]string `json:"-"`
+	// Body is the raw request body. It's only populated for routes with RawBody set, in which case Info is empty
+	Body []byte `json:"-"`
+	// ContentType is the Content-Type header of the request. It's only populated for routes with RawBody set
+	ContentType string `json:"-"`
+	// Actor identifies who made the request, for recording who made changes. It's the subject of the authenticated principal
+	Actor string `json:"-"`
+	// Role is the role of the authenticated principal
+	Role models.Role `json:"-"`
+	// Tenant is the tenant whose data the request can access, see resolveTenant
+	Tenant string `json:"-"`
+	// Client identifies the client making the request for rate limiting, see clientID
+	Client string `json:"-"`
+	// Route is the Name of the Route that the request was dispatched to
+	Route string `json:"-"`
+	// Header represents the http headers of the request
+	Header http.Header `json:"-"`
+	// Context is cancelled when the client disconnects, so that long running handlers such as streams can stop early
+	Context context.Context `json:"-"`
+}
+
+// anonymousActor is the Actor of requests that don't identify who made them
+const anonymousActor = "anonymous"
+
+// actorHeader is the http header specifying the Actor of a request
+const actorHeader = "X-Actor"
+
+// requestActor returns the actor specified by the http request's headers. It's only trusted while authentication is disabled, see
+// SetAuthenticator
+func requestActor(header http.Header) string {
+	if actor := header.Get(actorHeader); actor != "" {
+		return actor
+	}
+	return anonymousActor
 }
 
 // Parse deserializes the request object into the output param. It provides validation of the request based on "api" annotated properties
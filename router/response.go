@@ -1,6 +1,41 @@
 package router
 
+import (
+	"io"
+	"net/http"
+)
+
 // Response represents the data to be sent back in the http response body
 type Response struct {
 	Info map[string]interface{} `json:"info"`
+	// Stream optionally writes the response body directly, instead of Info being sent as json. It allows large responses, such as exports,
+	// to be written without buffering them in memory
+	Stream func(w io.Writer) error `json:"-"`
+	// ContentType is the Content-Type header sent along with a Stream
+	ContentType string `json:"-"`
+	// Header optionally specifies additional http headers sent along with a Stream, e.g. Cache-Control
+	Header http.Header `json:"-"`
+	// Upgrade optionally takes over the http connection instead of a response being sent, e.g. to upgrade it to a WebSocket. Handlers
+	// still validate the request first, so that invalid requests are rejected with an ordinary error response
+	Upgrade func(w http.ResponseWriter, req *http.Request) `json:"-"`
+}
+
+// flushWriter flushes every write to the client, so that streamed data such as events is delivered as soon as it's written rather than
+// when the server's buffer fills up
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func newFlushWriter(w http.ResponseWriter) io.Writer {
+	if flusher, ok := w.(http.Flusher); ok {
+		return flushWriter{w: w, flusher: flusher}
+	}
+	return w
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.flusher.Flush()
+	return n, err
 }
@@ -1,11 +1,14 @@
 package router
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"umbrellacorp/models"
 
 	"github.com/gorilla/mux"
 )
@@ -13,23 +16,47 @@ import (
 // NewRouter returns a configured gorilla mux router
 func NewRouter() *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
+	muxRoutes := map[*mux.Route]Route{}
 	for _, routes := range routesRegistry {
 		for _, route := range routes {
-			router.Name(route.Name).Methods(route.Methods...).Path(route.Path).Handler(handle(route.HandlerFunc))
+			muxRoute := router.Name(route.Name).Methods(route.Methods...).Path(route.Path).Handler(handle(route))
+			muxRoutes[muxRoute] = route
 		}
 	}
+
+	router.Name(batchRouteName).Methods(http.MethodPost).Path(batchPath).Handler(handleBatch(router, muxRoutes))
 	return router
 }
 
 // HandlerFunc is umbrellaCorp's handler fn signature that is decorated with http.HandlerFunc
 type HandlerFunc func(Request) (Response, error)
 
-// Route defines details to associate a handler with an http router. See router.handle(HandlerFunc) fn for more details
+// Route defines details to associate a handler with an http router. See router.handle(Route) fn for more details
 type Route struct {
 	Name        string
 	Methods     []string
 	Path        string
 	HandlerFunc HandlerFunc
+	// RawBody skips unmarshalling the request body as json. The handler reads the body from Request.Body instead
+	RawBody bool
+	// Role is the minimum role required to call the route. Routes that don't specify a role require the admin role
+	Role models.Role
+	// Prepare is called for the route's operations within atomic batches before the batch acquires the mutation lock, so that slow calls
+	// such as fetching forecasts don't block every other mutation. The handler is called with the returned context. It may be nil
+	Prepare func(req Request) context.Context
+	// Transactional is set on routes whose changes are rolled back by a registered Transactor. Atomic batches reject routes that may
+	// mutate state without it, since their changes couldn't be undone if the batch failed
+	Transactional bool
+
+	// batch is set on the batch route, which manages mutationLock itself
+	batch bool
+}
+
+func (route Route) requiredRole() models.Role {
+	if route.Role == "" {
+		return models.RoleAdmin
+	}
+	return route.Role
 }
 
 // Routes is a list of Route objects
@@ -65,23 +92,64 @@ func RegisterRoutes(entity string, routes Routes) error {
 	return nil
 }
 
-// handle decorates our HandlerFunc with http.HandlerFunc so that we may centralize reading request body details and send results back
-// to the client
-func handle(handlerFn HandlerFunc) http.Handler {
+// maxBodySize is the largest request body accepted, in bytes
+const maxBodySize = 1000000
+
+// handle decorates the route's HandlerFunc with http.HandlerFunc so that we may centralize reading request body details and send results
+// back to the client
+func handle(route Route) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-		// Read up to 1 MB of data from the client
-		body, err := ioutil.ReadAll(io.LimitReader(req.Body, 1000000))
+		principal, err := authenticate(req.Header)
+		// Requests are limited before authentication fails, so that clients can't guess credentials without being limited
+		if !limitRate(w, clientID(req, principal), route.Name) {
+			return
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="umbrellacorp"`)
+			http.Error(w, err.Error(), StatusCode(err))
+			return
+		}
+		if err = authorize(principal, route); err != nil {
+			http.Error(w, err.Error(), StatusCode(err))
+			return
+		}
+		tenant, err := resolveTenant(req, principal)
+		if err != nil {
+			http.Error(w, err.Error(), StatusCode(err))
+			return
+		}
+
+		// Read up to maxBodySize bytes of data from the client. One more byte is read so that larger bodies are rejected rather than
+		// truncated, which could otherwise import a partial row
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBodySize+1))
 		if err != nil {
 			err = fmt.Errorf("Failed to read request body. Err: %v", err.Error())
 			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 			return
 		}
 		defer req.Body.Close()
+		if len(body) > maxBodySize {
+			http.Error(w, fmt.Sprintf("Request body exceeds the limit of %d bytes", maxBodySize), http.StatusRequestEntityTooLarge)
+			return
+		}
 
-		request := Request{}
-		if len(body) > 0 {
+		request := Request{
+			Query:   req.URL.Query(),
+			Vars:    mux.Vars(req),
+			Actor:   principal.Subject,
+			Role:    principal.Role,
+			Tenant:  tenant,
+			Client:  clientID(req, principal),
+			Route:   route.Name,
+			Header:  req.Header,
+			Context: req.Context(),
+		}
+		if route.RawBody {
+			request.Body = body
+			request.ContentType = req.Header.Get("Content-Type")
+		} else if len(body) > 0 {
 			err = json.Unmarshal(body, &request.Info)
 			if err != nil {
 				err = fmt.Errorf("Failed to unmarshal request body. Err: %v", err.Error())
@@ -90,9 +158,33 @@ func handle(handlerFn HandlerFunc) http.Handler {
 			}
 		}
 
-		resp, err := handlerFn(request)
+		if isMutation(req.Method) && !route.batch {
+			// Wait for any atomic batch in progress, see handleBatch
+			mutationLock.RLock()
+			defer mutationLock.RUnlock()
+		}
+
+		resp, err := route.HandlerFunc(request)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), StatusCode(err))
+			return
+		}
+
+		if resp.Upgrade != nil {
+			w.Header().Del("Content-Type")
+			resp.Upgrade(w, req)
+			return
+		}
+
+		if resp.Stream != nil {
+			for key, values := range resp.Header {
+				w.Header()[key] = values
+			}
+			w.Header().Set("Content-Type", resp.ContentType)
+			// The status has already been sent once the stream starts, so failures can only be logged
+			if err = resp.Stream(newFlushWriter(w)); err != nil {
+				log.Printf("Failed to stream response for route %s. Err: %v", route.Name, err.Error())
+			}
 			return
 		}
 
@@ -0,0 +1,152 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandle(t *testing.T) {
+	echo := func(req Request) (Response, error) {
+		return Response{Info: map[string]interface{}{"info": req.Info, "body": string(req.Body), "content_type": req.ContentType}}, nil
+	}
+
+	tests := []struct {
+		name           string
+		route          Route
+		contentType    string
+		body           string
+		expStatus      int
+		expContentType string
+		expHeader      http.Header
+		expBody        string
+		expFlushed     bool
+	}{
+		{
+			name:           "json body",
+			route:          Route{HandlerFunc: echo},
+			body:           `{"name": "Awesome Company"}`,
+			expStatus:      http.StatusOK,
+			expContentType: "application/json; charset=UTF-8",
+			expBody:        `{"body":"","content_type":"","info":{"name":"Awesome Company"}}` + "\n",
+		},
+		{
+			name:      "invalid json body",
+			route:     Route{HandlerFunc: echo},
+			body:      `name,contact_number`,
+			expStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "raw body",
+			route:          Route{HandlerFunc: echo, RawBody: true},
+			contentType:    "text/csv",
+			body:           `name,contact_number`,
+			expStatus:      http.StatusOK,
+			expContentType: "application/json; charset=UTF-8",
+			expBody:        `{"body":"name,contact_number","content_type":"text/csv","info":null}` + "\n",
+		},
+		{
+			name:        "oversized raw body",
+			route:       Route{HandlerFunc: echo, RawBody: true},
+			contentType: "text/csv",
+			body:        "name,contact_number\n" + strings.Repeat("Awesome Company,4165555555\n", maxBodySize/27+1),
+			expStatus:   http.StatusRequestEntityTooLarge,
+			expBody:     "Request body exceeds the limit of 1000000 bytes\n",
+		},
+		{
+			name:      "body at the limit",
+			route:     Route{HandlerFunc: echo, RawBody: true},
+			body:      strings.Repeat("a", maxBodySize),
+			expStatus: http.StatusOK,
+		},
+		{
+			name: "router error",
+			route: Route{HandlerFunc: func(req Request) (Response, error) {
+				return Response{}, NewError(http.StatusNotFound, "Not here")
+			}},
+			expStatus: http.StatusNotFound,
+			expBody:   "Not here\n",
+		},
+		{
+			name: "other errors",
+			route: Route{HandlerFunc: func(req Request) (Response, error) {
+				return Response{}, fmt.Errorf("Something broke")
+			}},
+			expStatus: http.StatusInternalServerError,
+			expBody:   "Something broke\n",
+		},
+		{
+			name: "stream",
+			route: Route{HandlerFunc: func(req Request) (Response, error) {
+				return Response{
+					ContentType: "text/csv",
+					Stream: func(w io.Writer) error {
+						_, err := io.WriteString(w, "name\nAwesome Company\n")
+						return err
+					},
+				}, nil
+			}},
+			expStatus:      http.StatusOK,
+			expContentType: "text/csv",
+			expBody:        "name\nAwesome Company\n",
+			expFlushed:     true,
+		},
+		{
+			name: "stream with headers",
+			route: Route{HandlerFunc: func(req Request) (Response, error) {
+				return Response{
+					ContentType: "text/event-stream",
+					Header:      http.Header{"Cache-Control": {"no-cache"}},
+					Stream: func(w io.Writer) error {
+						_, err := io.WriteString(w, ": connected\n\n")
+						return err
+					},
+				}, nil
+			}},
+			expStatus:      http.StatusOK,
+			expContentType: "text/event-stream",
+			expHeader:      http.Header{"Cache-Control": {"no-cache"}},
+			expBody:        ": connected\n\n",
+			expFlushed:     true,
+		},
+		{
+			name: "upgrade",
+			route: Route{HandlerFunc: func(req Request) (Response, error) {
+				return Response{
+					Upgrade: func(w http.ResponseWriter, req *http.Request) {
+						w.WriteHeader(http.StatusSwitchingProtocols)
+					},
+				}, nil
+			}},
+			expStatus: http.StatusSwitchingProtocols,
+			expHeader: http.Header{"Content-Type": nil},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/customers", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", test.contentType)
+			rec := httptest.NewRecorder()
+
+			handle(test.route).ServeHTTP(rec, req)
+
+			assert.Equal(t, test.expStatus, rec.Code)
+			if test.expContentType != "" {
+				assert.Equal(t, test.expContentType, rec.Header().Get("Content-Type"))
+			}
+			for key := range test.expHeader {
+				assert.Equal(t, test.expHeader.Get(key), rec.Header().Get(key))
+			}
+			if test.expBody != "" {
+				assert.Equal(t, test.expBody, rec.Body.String())
+			}
+			assert.Equal(t, test.expFlushed, rec.Flushed)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"umbrellacorp/models"
+)
+
+// TenantHeader is the http header specifying the tenant of a request
+const TenantHeader = "X-Tenant"
+
+var (
+	// tenantExists returns true if the tenant exists. Every tenant is accepted if it's nil
+	tenantExists func(tenant string) bool
+	// tenantDomain is the domain whose subdomains identify tenants, e.g. acme.umbrellacorp.com identifies the acme tenant of
+	// umbrellacorp.com. Tenants aren't resolved from the host if it's empty
+	tenantDomain string
+)
+
+// SetTenants enables the resolution of tenants from the subdomains of domain, and restricts requests to the tenants for which exists
+// returns true. It must be called before serving requests
+func SetTenants(exists func(tenant string) bool, domain string) {
+	tenantExists = exists
+	tenantDomain = strings.ToLower(domain)
+}
+
+// resolveTenant returns the tenant of the http request made by the principal. The tenant is resolved from, in order of precedence, the
+// principal's tenant, the X-Tenant header and the subdomain of the request's host. Requests that don't specify a tenant belong to the
+// default tenant. Principals restricted to a tenant can't specify another tenant. The returned error is a router Error
+func resolveTenant(req *http.Request, principal models.Principal) (string, error) {
+	requested := req.Header.Get(TenantHeader)
+	if requested == "" {
+		requested = subdomainTenant(req.Host)
+	}
+
+	tenant := requested
+	if principal.Tenant != "" {
+		if requested != "" && requested != principal.Tenant {
+			return "", NewError(http.StatusForbidden, "%s can't access tenant %s", principal.Subject, requested)
+		}
+		tenant = principal.Tenant
+	}
+	if tenant == "" {
+		tenant = models.DefaultTenant
+	}
+
+	if tenantExists != nil && !tenantExists(tenant) {
+		return "", NewError(http.StatusNotFound, "Unknown tenant: %s", tenant)
+	}
+	return tenant, nil
+}
+
+// subdomainTenant returns the tenant identified by the host's subdomain of tenantDomain, or an empty string
+func subdomainTenant(host string) string {
+	if tenantDomain == "" {
+		return ""
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, "."+tenantDomain) {
+		return ""
+	}
+
+	subdomain := strings.TrimSuffix(host, "."+tenantDomain)
+	if strings.Contains(subdomain, ".") {
+		// Only the subdomain directly under the domain identifies a tenant
+		return ""
+	}
+	return subdomain
+}
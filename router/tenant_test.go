@@ -0,0 +1,98 @@
+package router
+
+import (
+	"net/http"
+	"testing"
+	"umbrellacorp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveTenant(t *testing.T) {
+	SetTenants(func(tenant string) bool { return tenant != "unknown" }, "umbrellacorp.com")
+	defer SetTenants(nil, "")
+
+	unrestricted := models.Principal{Subject: "admin@umbrellacorp.com", Role: models.RoleAdmin}
+	acmeRep := models.Principal{Subject: "rep@acme.com", Role: models.RoleRep, Tenant: "acme"}
+
+	tests := []struct {
+		name      string
+		host      string
+		header    string
+		principal models.Principal
+		expTenant string
+		expError  error
+	}{
+		{
+			name:      "default tenant",
+			host:      "localhost:8080",
+			principal: unrestricted,
+			expTenant: models.DefaultTenant,
+		},
+		{
+			name:      "header",
+			host:      "localhost:8080",
+			header:    "globex",
+			principal: unrestricted,
+			expTenant: "globex",
+		},
+		{
+			name:      "subdomain",
+			host:      "Globex.umbrellacorp.com:8080",
+			principal: unrestricted,
+			expTenant: "globex",
+		},
+		{
+			name:      "header takes precedence over subdomain",
+			host:      "globex.umbrellacorp.com",
+			header:    "initech",
+			principal: unrestricted,
+			expTenant: "initech",
+		},
+		{
+			name:      "nested subdomain",
+			host:      "api.globex.umbrellacorp.com",
+			principal: unrestricted,
+			expTenant: models.DefaultTenant,
+		},
+		{
+			name:      "principal's tenant",
+			host:      "localhost:8080",
+			principal: acmeRep,
+			expTenant: "acme",
+		},
+		{
+			name:      "principal's tenant requested",
+			host:      "acme.umbrellacorp.com",
+			principal: acmeRep,
+			expTenant: "acme",
+		},
+		{
+			name:      "another tenant than the principal's",
+			host:      "localhost:8080",
+			header:    "globex",
+			principal: acmeRep,
+			expError:  NewError(http.StatusForbidden, "rep@acme.com can't access tenant globex"),
+		},
+		{
+			name:      "unknown tenant",
+			host:      "unknown.umbrellacorp.com",
+			principal: unrestricted,
+			expError:  NewError(http.StatusNotFound, "Unknown tenant: unknown"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "/customers", nil)
+			req.Host = test.host
+			if test.header != "" {
+				req.Header.Set(TenantHeader, test.header)
+			}
+
+			tenant, err := resolveTenant(req, test.principal)
+			assert.Equal(t, test.expError, err)
+			assert.Equal(t, test.expTenant, tenant)
+		})
+	}
+}
@@ -1,19 +1,124 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"time"
+	"umbrellacorp/components/leadscorer"
+	"umbrellacorp/components/quota"
+	"umbrellacorp/components/ratelimit"
+	"umbrellacorp/components/weatherforecaster"
+	"umbrellacorp/components/webhook"
 	"umbrellacorp/handlers"
+	"umbrellacorp/handlers/apikeys"
+	"umbrellacorp/handlers/campaigns"
+	"umbrellacorp/handlers/catalog"
+	"umbrellacorp/handlers/customer"
+	"umbrellacorp/handlers/providers"
+	"umbrellacorp/handlers/reports"
+	"umbrellacorp/handlers/sales"
+	"umbrellacorp/handlers/segments"
+	"umbrellacorp/handlers/tenants"
+	"umbrellacorp/handlers/webhooks"
 	"umbrellacorp/router"
 )
 
+var (
+	leadWeightRain    = flag.Float64("lead-weight-rain", leadscorer.DefaultWeights.Rain, "Weight of the upcoming rain when scoring leads, relative to the other lead weights")
+	leadWeightSize    = flag.Float64("lead-weight-company-size", leadscorer.DefaultWeights.CompanySize, "Weight of the company size when scoring leads, relative to the other lead weights")
+	leadWeightRecency = flag.Float64("lead-weight-recency", leadscorer.DefaultWeights.Recency, "Weight of the days since a lead was last contacted when scoring leads, relative to the other lead weights")
+	leadWeightStatus  = flag.Float64("lead-weight-pipeline", leadscorer.DefaultWeights.Pipeline, "Weight of the pipeline status when scoring leads, relative to the other lead weights")
+	deletedRetention  = flag.Duration("deleted-retention", customer.DefaultConfig.DeletedRetention, "How long deleted customers can be restored before they're purged")
+	auditFile         = flag.String("audit-file", "audit.jsonl", "File that the audit log of customer changes is appended to")
+	webhooksFile      = flag.String("webhooks-file", "webhooks.json", "File that webhook subscriptions and pending deliveries are persisted to")
+	demandInterval    = flag.Duration("demand-interval", reports.DefaultConfig.DemandInterval, "How often the demand forecast of every tenant is aggregated")
+	conversionRate    = flag.Float64("default-conversion-rate", reports.DefaultConfig.DefaultConversionRate, "Share of quoted umbrellas expected to be ordered until enough quotes were decided to compute it")
+	salesFile         = flag.String("sales-file", "sales.json", "File that quotes and orders are persisted to")
+	campaignsFile     = flag.String("campaigns-file", "campaigns.json", "File that campaigns and the customers that opted out of them are persisted to")
+	segmentsFile      = flag.String("segments-file", "segments.json", "File that saved customer segments are persisted to")
+	smsGatewayURL     = flag.String("sms-gateway-url", "", "URL that campaign text messages are posted to as JSON with to and body fields. Campaigns can't text customers if it's empty")
+	apiKeysFile       = flag.String("api-keys-file", "api_keys.json", "File that the hashes of API keys are persisted to")
+	bootstrapKeyFile  = flag.String("bootstrap-key-file", "bootstrap_api_key.txt", "File that a generated admin API key is written to when no API keys exist and "+bootstrapKeyEnv+" isn't set")
+	tenantsFile       = flag.String("tenants-file", "", "File listing the tenants and their forecast providers and alert rules. Only the default tenant exists if it's empty")
+	tenantDomain      = flag.String("tenant-domain", "", "Domain whose subdomains identify tenants, e.g. umbrellacorp.com")
+	rateLimit         = flag.Int("rate-limit", 600, "Requests per minute allowed per API key, JWT subject or IP address. 0 disables rate limiting")
+	upsertRateLimit   = flag.Int("upsert-rate-limit", 60, "Requests per minute allowed per client to routes that fetch forecasts from the weather provider")
+	owmPerMinute      = flag.Int("openweathermap-calls-per-minute", 60, "Calls per minute allowed to OpenWeatherMap across every tenant. 0 doesn't cap calls")
+	owmPerDay         = flag.Int("openweathermap-calls-per-day", 1000, "Calls per UTC day allowed to OpenWeatherMap across every tenant. 0 doesn't cap calls")
+	forecastProvider  = flag.String("forecast-provider", "", "Forecast provider used instead of every tenant's, e.g. replay to return recorded forecasts or record to save OpenWeatherMap's responses")
+	lowStockCustomers = flag.Int("low-stock-rainy-customers", catalog.DefaultConfig.MinRainyCustomers, "Customers served by a warehouse that must have rain forecast before its low stock is alerted")
+	forecastFixtures  = flag.String("forecast-fixtures", "", "Directory that the replay and record forecast providers read and save forecasts to")
+)
+
+// jwtKeyEnv is the environment variable specifying the key that JWTs are signed with. It's read from the environment rather than a flag
+// so that it isn't visible in the process list
+const jwtKeyEnv = "UMBRELLACORP_JWT_KEY"
+
+// bootstrapKeyEnv is the environment variable specifying the admin API key created when no API keys exist yet
+const bootstrapKeyEnv = "UMBRELLACORP_BOOTSTRAP_API_KEY"
+
 func main() {
+	flag.Parse()
 	initialize()
 	fmt.Printf("\nStarting Server\n")
 	log.Fatal(http.ListenAndServe(":8080", router.NewRouter()))
 }
 
 func initialize() {
+	forecaster := weatherforecaster.ProviderConfig{Provider: *forecastProvider, Fixtures: *forecastFixtures}
+	if err := forecaster.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	weatherforecaster.Configure(forecaster)
+	weights := leadscorer.Weights{Rain: *leadWeightRain, CompanySize: *leadWeightSize, Recency: *leadWeightRecency, Pipeline: *leadWeightStatus}
+	if err := leadscorer.Configure(weights); err != nil {
+		log.Fatal(err)
+	}
+	if *conversionRate < 0 || *conversionRate > 1 {
+		log.Fatalf("The default conversion rate must be between 0 and 1: %g", *conversionRate)
+	}
+	customer.Configure(customer.Config{
+		DeletedRetention: *deletedRetention,
+		AuditPath:        *auditFile,
+	})
+	sales.Configure(sales.Config{Path: *salesFile})
+	segments.Configure(segments.Config{Path: *segmentsFile})
+	campaigns.Configure(campaigns.Config{Path: *campaignsFile, SMSGatewayURL: *smsGatewayURL})
+	reports.Configure(reports.Config{DemandInterval: *demandInterval, DefaultConversionRate: *conversionRate})
+	catalog.Configure(catalog.Config{MinRainyCustomers: *lowStockCustomers})
+	webhooks.Configure(webhook.Config{Path: *webhooksFile})
+	providers.Configure(providers.Config{Budgets: map[string]quota.Budget{
+		weatherforecaster.ProviderOpenWeatherMap: {PerMinute: *owmPerMinute, PerDay: *owmPerDay},
+	}})
+	tenants.Configure(tenants.Config{Path: *tenantsFile, Domain: *tenantDomain})
+	apikeys.Configure(apikeys.Config{
+		KeysPath:         *apiKeysFile,
+		JWTKey:           []byte(os.Getenv(jwtKeyEnv)),
+		BootstrapKey:     os.Getenv(bootstrapKeyEnv),
+		BootstrapKeyPath: *bootstrapKeyFile,
+	})
 	handlers.Init()
+	initRateLimiter()
+}
+
+// forecastRoutes are the routes that fetch forecasts from the weather provider, which are limited by upsertRateLimit
+var forecastRoutes = []string{"Set Customer", "Import Customers"}
+
+func initRateLimiter() {
+	config := ratelimit.Config{
+		Default: ratelimit.Limit{Requests: *rateLimit, Per: time.Minute},
+		Routes:  map[string]ratelimit.Limit{},
+	}
+	for _, route := range forecastRoutes {
+		config.Routes[route] = ratelimit.Limit{Requests: *upsertRateLimit, Per: time.Minute}
+	}
+
+	limiter, err := ratelimit.New(config, ratelimit.NewMemory())
+	if err != nil {
+		log.Fatalf("Failed to initialize rate limiting: %s", err.Error())
+	}
+	router.SetRateLimiter(limiter)
 }